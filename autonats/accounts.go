@@ -0,0 +1,133 @@
+package autonats
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	natssrv "get.pme.sh/pnats/server"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	SystemAccountName  = "$SYS"
+	DefaultAccountName = "$G"
+	tenantUserPrefix   = "tenant."
+)
+
+// Returns the name of the NATS account isolating the tenant, the case is kept since tenant names are
+// case sensitive.
+func TenantAccountName(tenant string) string {
+	return "T_" + tenant
+}
+
+// Returns the password of the tenant user, derived from the secret so that every node agrees on it.
+func tenantPassword(secret, tenant string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("nats-tenant:" + tenant))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WithTenant authenticates the connection as the tenant, binding it to the tenant's account.
+func WithTenant(secret, tenant string) nats.Option {
+	return nats.UserInfo(tenantUserPrefix+tenant, tenantPassword(secret, tenant))
+}
+
+// accountAuth binds the clients to their accounts, tenants are provisioned at runtime which the
+// static user list of the server does not allow.
+type accountAuth struct {
+	srv     *natssrv.Server
+	secret  string
+	tenants sync.Map // tenant -> account name
+}
+
+func (a *accountAuth) Check(c natssrv.ClientAuthentication) bool {
+	opts := c.GetOpts()
+	var account string
+	switch opts.Username {
+	case "sys":
+		if opts.Password != "sys" {
+			return false
+		}
+		account = SystemAccountName
+	case "":
+		account = DefaultAccountName
+	case "usr":
+		if opts.Password != "usr" {
+			return false
+		}
+		account = DefaultAccountName
+	default:
+		tenant, ok := strings.CutPrefix(opts.Username, tenantUserPrefix)
+		if !ok {
+			return false
+		}
+		name, ok := a.tenants.Load(tenant)
+		if !ok || !hmac.Equal([]byte(opts.Password), []byte(tenantPassword(a.secret, tenant))) {
+			return false
+		}
+		account = name.(string)
+	}
+	acc, err := a.srv.LookupAccount(account)
+	if err != nil {
+		return false
+	}
+	c.RegisterUser(&natssrv.User{Username: opts.Username, Account: acc})
+	return true
+}
+
+// ProvisionTenant creates the JetStream enabled account of the tenant if it does not exist yet.
+func (s *Server) ProvisionTenant(tenant string) error {
+	srv := s.Server()
+	if srv == nil || s.auth == nil {
+		return fmt.Errorf("nats server not running")
+	}
+	name := TenantAccountName(tenant)
+	acc, isNew := srv.LookupOrRegisterAccount(name)
+	if isNew || !acc.JetStreamEnabled() {
+		if err := acc.EnableJetStream(nil); err != nil {
+			return fmt.Errorf("failed to enable jetstream for tenant %q: %w", tenant, err)
+		}
+	}
+	s.auth.tenants.Store(tenant, name)
+	return nil
+}
+
+// DeprovisionTenant revokes the access to the account of the tenant and disconnects its clients. Its
+// streams are stopped but their data is kept, provisioning the tenant again brings them back.
+func (s *Server) DeprovisionTenant(tenant string) error {
+	srv := s.Server()
+	if srv == nil || s.auth == nil {
+		return fmt.Errorf("nats server not running")
+	}
+	if _, ok := s.auth.tenants.LoadAndDelete(tenant); !ok {
+		return nil
+	}
+	acc, err := srv.LookupAccount(TenantAccountName(tenant))
+	if err != nil {
+		return nil
+	}
+	if n := acc.NumLocalConnections(); n != 0 {
+		connz, err := srv.Connz(&natssrv.ConnzOptions{Username: true, Account: acc.Name, Limit: n})
+		if err != nil {
+			return err
+		}
+		for _, c := range connz.Conns {
+			srv.DisconnectClientByID(c.Cid)
+		}
+	}
+	if acc.JetStreamEnabled() {
+		if err := acc.DisableJetStream(); err != nil {
+			return fmt.Errorf("failed to disable jetstream for tenant %q: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+// ConnectTenant connects in-process to the account of a provisioned tenant.
+func (s *Server) ConnectTenant(tenant string, opts ...nats.Option) (*nats.Conn, error) {
+	return s.Connect(append(opts, WithTenant(s.auth.secret, tenant))...)
+}
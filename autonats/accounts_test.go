@@ -0,0 +1,125 @@
+package autonats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	natssrv "get.pme.sh/pnats/server"
+	"github.com/nats-io/nats.go"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	*config.EnvName = t.TempDir()
+	opts := Options{Embedded: true, StoreDir: t.TempDir(), Secret: "secret", ServerName: "test", LocalPort: -1}
+	opts.SetDefaults()
+	srv, err := StartServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	select {
+	case <-srv.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("server not ready")
+	}
+	return srv
+}
+
+// A client presenting credentials, records the user it is registered as.
+type testClient struct {
+	natssrv.ClientAuthentication
+	opts natssrv.ClientOpts
+	user *natssrv.User
+}
+
+func (c *testClient) GetOpts() *natssrv.ClientOpts { return &c.opts }
+func (c *testClient) RegisterUser(u *natssrv.User) { c.user = u }
+
+// Returns the account the credentials are bound to, empty if rejected.
+func testAuth(srv *Server, user, pass string) string {
+	c := &testClient{opts: natssrv.ClientOpts{Username: user, Password: pass}}
+	if !srv.auth.Check(c) {
+		return ""
+	}
+	return c.user.Account.Name
+}
+
+func TestAccountAuth(t *testing.T) {
+	srv := testServer(t)
+	if err := srv.ProvisionTenant("a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		user, pass, account string
+	}{
+		{"", "", DefaultAccountName},
+		{"usr", "usr", DefaultAccountName},
+		{"usr", "x", ""},
+		{"usr", "", ""},
+		{"sys", "sys", SystemAccountName},
+		{"sys", "x", ""},
+		{"tenant.a", tenantPassword("secret", "a"), TenantAccountName("a")},
+		{"tenant.a", tenantPassword("other", "a"), ""},
+		{"tenant.b", tenantPassword("secret", "b"), ""},
+		{"x", "x", ""},
+	} {
+		if got := testAuth(srv, tc.user, tc.pass); got != tc.account {
+			t.Errorf("%s:%s: bound to %q, want %q", tc.user, tc.pass, got, tc.account)
+		}
+	}
+}
+
+func TestDeprovisionTenant(t *testing.T) {
+	srv := testServer(t)
+	if err := srv.ProvisionTenant("a"); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	conn, err := srv.ConnectTenant("a", nats.NoReconnect(), nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := srv.DeprovisionTenant("a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tenant client not disconnected")
+	}
+	if acc := testAuth(srv, "tenant.a", tenantPassword("secret", "a")); acc != "" {
+		t.Fatalf("deprovisioned tenant bound to %q", acc)
+	}
+	acc, err := srv.Server().LookupAccount(TenantAccountName("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.JetStreamEnabled() {
+		t.Error("jetstream still enabled")
+	}
+	if err := srv.DeprovisionTenant("a"); err != nil {
+		t.Errorf("deprovisioned twice: %v", err)
+	}
+
+	// Provisioned again.
+	if err := srv.ProvisionTenant("a"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err = srv.ConnectTenant("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.AccountInfo(nats.Context(context.Background())); err != nil {
+		t.Errorf("jetstream not enabled again: %v", err)
+	}
+}
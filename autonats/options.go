@@ -3,8 +3,11 @@ package autonats
 import (
 	"cmp"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"net"
 	"strings"
+	"time"
 
 	"get.pme.sh/pmesh/netx"
 	"get.pme.sh/pmesh/security"
@@ -21,8 +24,9 @@ type Options struct {
 	ClusterName string // Name of the cluster
 	StoreDir    string // Directory to store data
 
-	LocalAddr string // Address we bind to for strictly local connections
-	LocalPort int    // Port to bind to for strictly local connections
+	LocalAddr  string        // Address we bind to for strictly local connections
+	LocalPort  int           // Port to bind to for strictly local connections
+	LocalDrain time.Duration // Time given to the local connections to close on shutdown, default = 3s
 
 	Addr      string // Address we bind to
 	Advertise string // Address we advertise as
@@ -33,6 +37,13 @@ type Options struct {
 	TLSConfig *tls.Config  // TLS configuration
 
 	Topology Topology // Topology to use for bootstrapping
+
+	MaxMemory int64 // JetStream memory limit in bytes, sized from the system memory if not positive
+	MaxStore  int64 // JetStream storage limit in bytes, sized from the available disk if not positive
+
+	// Embedded runs a lone server that only accepts in-process and local connections, without
+	// cluster routes, gateways or leaf remotes. The topology is ignored.
+	Embedded bool
 }
 
 func NewTLSConfig(secret string) (tlsc *tls.Config) {
@@ -50,10 +61,51 @@ func NewTLSConfig(secret string) (tlsc *tls.Config) {
 	}
 }
 
+// NewRotationTLSConfig returns the configuration a node uses while the cluster moves from the previous
+// secret to the new one. It presents the certificate of either secret depending on what the peer asks
+// for and accepts the peers of both, so the nodes can rotate one at a time, see Server.RotateTLS.
+func NewRotationTLSConfig(secret, previous string) *tls.Config {
+	trusted := []*tls.Config{NewTLSConfig(secret), NewTLSConfig(previous)}
+	verify := func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no peer certificate")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		var err error
+		for _, t := range trusted {
+			_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       t.ServerName,
+				Roots:         t.RootCAs,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+
+	cfg := trusted[0].Clone()
+	cfg.Certificates = append(cfg.Certificates, trusted[1].Certificates...)
+	cfg.ClientCAs = x509.NewCertPool()
+	for _, s := range []string{secret, previous} {
+		cfg.ClientCAs.AddCert(security.GetSelfSignedRootCA(s + "-n").X509)
+	}
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	cfg.InsecureSkipVerify = true // Verified against both secrets instead.
+	cfg.VerifyConnection = verify
+	return cfg
+}
+
 func (opts *Options) SetDefaults() {
 	opts.Addr = cmp.Or(opts.Addr, "0.0.0.0")
 	opts.Port = cmp.Or(opts.Port, 8443)
 	opts.LocalAddr = cmp.Or(opts.LocalAddr, "127.0.0.1")
+	opts.LocalDrain = cmp.Or(opts.LocalDrain, 3*time.Second)
 	if opts.TLSConfig == nil {
 		opts.TLSConfig = NewTLSConfig(opts.Secret)
 	}
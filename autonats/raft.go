@@ -0,0 +1,88 @@
+package autonats
+
+import (
+	"errors"
+
+	natssrv "get.pme.sh/pnats/server"
+)
+
+// ReadinessPhase is the step of the startup probe the server is at.
+type ReadinessPhase = string
+
+const (
+	PhaseStarting    ReadinessPhase = "starting"     // Waiting for the server to accept connections.
+	PhaseConnecting  ReadinessPhase = "connecting"   // Connecting to the server.
+	PhaseJetStream   ReadinessPhase = "jetstream"    // Waiting for the JetStream API.
+	PhaseRaftPropose ReadinessPhase = "raft-propose" // Waiting for the RAFT log to accept a proposal.
+	PhaseRaftWrite   ReadinessPhase = "raft-write"   // Waiting for a write to the RAFT log.
+	PhaseReady       ReadinessPhase = "ready"        // Ready.
+	PhaseDraining    ReadinessPhase = "draining"     // Shutting down, waiting for the local connections to close.
+	PhaseStopped     ReadinessPhase = "stopped"      // Shut down or failed to start.
+)
+
+// Phase returns the readiness phase of the server.
+func (s *Server) Phase() ReadinessPhase {
+	if p, ok := s.phase.Load().(ReadinessPhase); ok {
+		return p
+	}
+	return PhaseStarting
+}
+
+// StreamLeader is the RAFT state of a stream.
+type StreamLeader struct {
+	Leader  string `json:"leader,omitempty"`  // Name of the leader, empty while leaderless.
+	Lagging int    `json:"lagging,omitempty"` // Number of replicas behind the leader.
+	Offline int    `json:"offline,omitempty"` // Number of replicas offline.
+}
+
+// RaftState is the JetStream RAFT state as seen by the server.
+type RaftState struct {
+	Phase       ReadinessPhase          `json:"phase"`
+	Clustered   bool                    `json:"clustered"`
+	MetaLeader  string                  `json:"meta_leader,omitempty"` // Name of the meta leader, empty while leaderless.
+	IsLeader    bool                    `json:"is_leader"`             // Whether the server is the meta leader.
+	Current     bool                    `json:"current"`               // Whether the server is caught up with the meta leader.
+	ClusterSize int                     `json:"cluster_size,omitempty"`
+	Streams     map[string]StreamLeader `json:"streams,omitempty"` // Streams of the default account by name.
+}
+
+func (s *Server) RaftState() (st RaftState, err error) {
+	st.Phase = s.Phase()
+	srv := s.Server()
+	if srv == nil {
+		return st, errors.New("nats server not running")
+	}
+	st.Clustered = srv.JetStreamIsClustered()
+	st.IsLeader = srv.JetStreamIsLeader()
+	st.Current = srv.JetStreamIsCurrent()
+
+	info, err := srv.Jsz(&natssrv.JSzOptions{Accounts: true, Streams: true})
+	if err != nil {
+		return
+	}
+	if info.Meta != nil {
+		st.MetaLeader = info.Meta.Leader
+		st.ClusterSize = info.Meta.Size
+	}
+	st.Streams = make(map[string]StreamLeader)
+	for _, acc := range info.AccountDetails {
+		if acc.Name != DefaultAccountName {
+			continue
+		}
+		for _, stream := range acc.Streams {
+			var sl StreamLeader
+			if c := stream.Cluster; c != nil {
+				sl.Leader = c.Leader
+				for _, r := range c.Replicas {
+					if r.Offline {
+						sl.Offline++
+					} else if !r.Current {
+						sl.Lagging++
+					}
+				}
+			}
+			st.Streams[stream.Name] = sl
+		}
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package autonats
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pause between two connections closed by a rotation, so that a node never loses all of its routes at once.
+const rotateCycleInterval = 2 * time.Second
+
+// A connection made through the network intercept, tracked until closed so that a rotation can cycle it.
+type interceptConn struct {
+	net.Conn
+	cause string
+	once  sync.Once
+	owner *natsNetworkIntercept
+}
+
+func (c *interceptConn) Close() error {
+	c.once.Do(func() {
+		c.owner.mu.Lock()
+		delete(c.owner.conns, c)
+		c.owner.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+type interceptListener struct {
+	net.Listener
+	owner *natsNetworkIntercept
+	cause string
+}
+
+func (l *interceptListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.owner.track(conn, l.cause), nil
+}
+
+func (i *natsNetworkIntercept) track(conn net.Conn, cause string) net.Conn {
+	c := &interceptConn{Conn: conn, cause: cause, owner: i}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.conns == nil {
+		i.conns = make(map[*interceptConn]struct{})
+	}
+	i.conns[c] = struct{}{}
+	return c
+}
+
+// Returns the connections between the nodes, leaving out the clients.
+func (i *natsNetworkIntercept) nodeConns() (conns []*interceptConn) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for c := range i.conns {
+		if c.cause != "client" {
+			conns = append(conns, c)
+		}
+	}
+	return
+}
+
+// RotateTLS replaces the TLS configuration of the connections between the nodes. New connections use it
+// right away, and the open ones are closed one at a time so that the server reconnects them with it
+// without a restart. Until every node has rotated, the configuration must trust both the old and the
+// new certificates, see NewRotationTLSConfig. Clients keep their connections and pick up the configuration
+// when they reconnect. The session rotates on SIGHUP when the secret in the configuration changed.
+func (s *Server) RotateTLS(ctx context.Context, cfg *tls.Config) error {
+	s.intercept.cfg.Store(cfg)
+	conns := s.intercept.nodeConns()
+	if len(conns) == 0 {
+		return nil
+	}
+	s.logger.Info().Int("conns", len(conns)).Msg("Cycling the connections between the nodes to rotate the certificates")
+	for n, c := range conns {
+		if n != 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.donech:
+				return nil
+			case <-time.After(rotateCycleInterval):
+			}
+		}
+		c.Close()
+	}
+	return nil
+}
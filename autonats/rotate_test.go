@@ -0,0 +1,177 @@
+package autonats
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/xlog"
+)
+
+// Starts an echo listener behind an intercept using the configuration, returns its address.
+func testListen(t *testing.T, cfg *tls.Config, cause string) string {
+	t.Helper()
+	i := &natsNetworkIntercept{}
+	i.cfg.Store(cfg)
+	ln, err := i.ListenCause("tcp", "127.0.0.1:0", cause)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Dials the address through the intercept and checks that the peer accepted the connection.
+func testDial(i *natsNetworkIntercept, addr, cause string) (net.Conn, error) {
+	conn, err := i.DialTimeoutCause("tcp", addr, time.Second, cause)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write([]byte("x")); err == nil {
+		_, err = io.ReadFull(conn, make([]byte, 1))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func TestRotationTLSConfig(t *testing.T) {
+	*config.EnvName = t.TempDir()
+	prev, next := NewTLSConfig("old"), NewTLSConfig("new")
+	rotation := NewRotationTLSConfig("new", "old")
+
+	for _, tc := range []struct {
+		name         string
+		dial, listen *tls.Config
+		ok           bool
+	}{
+		{"rotated to old", rotation, prev, true},
+		{"old to rotated", prev, rotation, true},
+		{"rotated to new", rotation, next, true},
+		{"new to rotated", next, rotation, true},
+		{"rotated to rotated", rotation, rotation, true},
+		{"new to old", next, prev, false},
+		{"old to new", prev, next, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			i := &natsNetworkIntercept{}
+			i.cfg.Store(tc.dial)
+			conn, err := testDial(i, testListen(t, tc.listen, "route"), "route")
+			if err == nil {
+				conn.Close()
+			}
+			if ok := err == nil; ok != tc.ok {
+				t.Fatalf("connected = %v, want %v (%v)", ok, tc.ok, err)
+			}
+		})
+	}
+}
+
+func TestRotateTLS(t *testing.T) {
+	*config.EnvName = t.TempDir()
+	rotation := NewRotationTLSConfig("new", "old")
+	addr := testListen(t, rotation, "route")
+
+	i := &natsNetworkIntercept{}
+	i.cfg.Store(NewTLSConfig("old"))
+	route, err := testDial(i, addr, "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer route.Close()
+	client, err := testDial(i, testListen(t, rotation, "client"), "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := &Server{intercept: i, logger: xlog.NewDomain("nats"), donech: make(chan struct{})}
+	cfg := NewTLSConfig("new")
+	if err := s.RotateTLS(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if i.cfg.Load() != cfg {
+		t.Fatal("configuration not replaced")
+	}
+	if conns := i.nodeConns(); len(conns) != 0 {
+		t.Fatalf("%d node connections left open", len(conns))
+	}
+	if _, err := route.Write([]byte("x")); err == nil {
+		t.Fatal("route connection not closed")
+	}
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatalf("client connection closed: %v", err)
+	}
+
+	// New connections use the new certificates.
+	conn, err := testDial(i, addr, "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestRotateTLSInterrupted(t *testing.T) {
+	*config.EnvName = t.TempDir()
+	rotation := NewRotationTLSConfig("new", "old")
+	addr := testListen(t, rotation, "route")
+	setup := func() (*Server, []net.Conn) {
+		i := &natsNetworkIntercept{}
+		i.cfg.Store(NewTLSConfig("old"))
+		var routes []net.Conn
+		for range 2 {
+			conn, err := testDial(i, addr, "route")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { conn.Close() })
+			routes = append(routes, conn)
+		}
+		return &Server{intercept: i, logger: xlog.NewDomain("nats"), donech: make(chan struct{})}, routes
+	}
+
+	// Canceled between two connections, the rest is left open.
+	s, _ := setup()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.RotateTLS(ctx, NewTLSConfig("new")); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if conns := s.intercept.nodeConns(); len(conns) != 1 {
+		t.Fatalf("%d node connections open, want 1", len(conns))
+	}
+
+	// Stops quietly when the server shuts down.
+	s, _ = setup()
+	time.AfterFunc(100*time.Millisecond, func() { close(s.donech) })
+	start := time.Now()
+	if err := s.RotateTLS(context.Background(), NewTLSConfig("new")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d >= rotateCycleInterval {
+		t.Errorf("returned after %v", d)
+	}
+	if conns := s.intercept.nodeConns(); len(conns) != 1 {
+		t.Fatalf("%d node connections open, want 1", len(conns))
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"get.pme.sh/pmesh/tlsmux"
+	"get.pme.sh/pmesh/util"
 	"get.pme.sh/pmesh/xlog"
 
 	natssrv "get.pme.sh/pnats/server"
@@ -33,6 +34,26 @@ type Server struct {
 	ready, done  bool
 	shuttingDown atomic.Bool
 	mu           sync.Mutex
+	auth         *accountAuth
+	phase        atomic.Value // ReadinessPhase
+
+	logger     *xlog.Logger
+	localln    net.Listener
+	localConns atomic.Int32
+	localDrain time.Duration
+	intercept  *natsNetworkIntercept
+}
+
+// A connection accepted by the local listener, counted until closed.
+type localConn struct {
+	net.Conn
+	once  sync.Once
+	count *atomic.Int32
+}
+
+func (c *localConn) Close() error {
+	c.once.Do(func() { c.count.Add(-1) })
+	return c.Conn.Close()
 }
 
 func (s *Server) Ready() <-chan struct{} { return s.readych }
@@ -49,6 +70,7 @@ func (s *Server) markDead() {
 	}
 	s.done = true
 	s.ready = true
+	s.phase.Store(PhaseStopped)
 }
 func (s *Server) markAlive() {
 	s.mu.Lock()
@@ -58,6 +80,7 @@ func (s *Server) markAlive() {
 	}
 	close(s.readych)
 	s.ready = true
+	s.phase.Store(PhaseReady)
 }
 
 func (s *Server) ClientURL() string {
@@ -91,6 +114,29 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.mux.ServeHTTP(w, r)
 	}
 }
+
+// JetStreamUsage is the JetStream resource usage of the server against its limits.
+type JetStreamUsage struct {
+	Memory    uint64 `json:"memory"`     // Memory used in bytes.
+	Store     uint64 `json:"store"`      // Storage used in bytes.
+	MaxMemory int64  `json:"max_memory"` // Memory limit in bytes.
+	MaxStore  int64  `json:"max_store"`  // Storage limit in bytes.
+}
+
+func (s *Server) JetStreamUsage() (u JetStreamUsage, err error) {
+	srv := s.Server()
+	if srv == nil {
+		return u, errors.New("nats server not running")
+	}
+	info, err := srv.Jsz(nil)
+	if err != nil {
+		return
+	}
+	u.Memory, u.Store = info.Memory, info.Store
+	u.MaxMemory, u.MaxStore = info.Config.MaxMemory, info.Config.MaxStore
+	return
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	sv := s.Server()
 	if sv == nil {
@@ -98,6 +144,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	}
 
 	if !s.shuttingDown.Swap(true) {
+		s.drainLocal(ctx)
 		sv.Shutdown()
 	}
 
@@ -109,6 +156,33 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		return nil
 	}
 }
+
+// Stops accepting local connections and gives the open ones, such as the CLI, some time to finish
+// before the server shuts down.
+func (s *Server) drainLocal(ctx context.Context) {
+	if s.localln == nil {
+		return
+	}
+	s.localln.Close()
+	if s.localConns.Load() == 0 {
+		return
+	}
+	s.phase.Store(PhaseDraining)
+	s.logger.Info().Int32("conns", s.localConns.Load()).Msg("Waiting for the local connections to close")
+
+	ctx, cancel := context.WithTimeout(ctx, s.localDrain)
+	defer cancel()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for s.localConns.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			s.logger.Warn().Int32("conns", s.localConns.Load()).Msg("Closing the local connections still open")
+			return
+		case <-tick.C:
+		}
+	}
+}
 func (s *Server) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -118,10 +192,12 @@ func (s *Server) Close() error {
 const ServerStartTimeout = 5 * time.Minute
 
 type natsNetworkIntercept struct {
-	cfg *tls.Config
+	cfg   atomic.Pointer[tls.Config]
+	mu    sync.Mutex
+	conns map[*interceptConn]struct{}
 }
 
-func (i natsNetworkIntercept) DialTimeoutCause(network, address string, timeout time.Duration, cause string) (net.Conn, error) {
+func (i *natsNetworkIntercept) DialTimeoutCause(network, address string, timeout time.Duration, cause string) (net.Conn, error) {
 	d := net.Dialer{
 		Timeout:   timeout,
 		KeepAlive: -1,
@@ -130,24 +206,43 @@ func (i natsNetworkIntercept) DialTimeoutCause(network, address string, timeout
 	if err != nil {
 		return nil, err
 	}
-	cli := tlsmux.Client(netconn, i.cfg, "nats-"+cause)
+	cli := tlsmux.Client(netconn, i.cfg.Load(), "nats-"+cause)
 	if err := cli.Handshake(); err != nil {
 		netconn.Close()
 		return nil, err
 	}
-	return cli, nil
+	return i.track(cli, cause), nil
 }
-func (i natsNetworkIntercept) ListenCause(network, address, cause string) (net.Listener, error) {
-	return tlsmux.Listen(network, address, i.cfg, "nats-"+cause)
+func (i *natsNetworkIntercept) ListenCause(network, address, cause string) (net.Listener, error) {
+	// Resolve the configuration on every handshake so that a rotation applies to the next connection.
+	proto := "nats-" + cause
+	cfg := &tls.Config{
+		NextProtos: []string{proto},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := i.cfg.Load().Clone()
+			cfg.NextProtos = []string{proto}
+			return cfg, nil
+		},
+	}
+	ln, err := tlsmux.Listen(network, address, cfg, proto)
+	if err != nil {
+		return nil, err
+	}
+	return &interceptListener{ln, i, cause}, nil
 }
 
 func StartServer(opts Options) (srv *Server, err error) {
 	opts.SetDefaults()
 	logger := opts.Logger
 
+	// NATS only takes the limits as given if both are set.
+	if opts.MaxStore > 0 && opts.MaxMemory <= 0 {
+		opts.MaxMemory = util.GetTotalMemory() / 4 * 3
+	}
+
 	// Create the base options
-	systemAccount := natssrv.NewAccount("$SYS")
-	defaultAccount := natssrv.NewAccount("$G")
+	systemAccount := natssrv.NewAccount(SystemAccountName)
+	defaultAccount := natssrv.NewAccount(DefaultAccountName)
 	defaultAccount.EnableJetStream(nil)
 	base := &natssrv.Options{
 		Trace:                  false,
@@ -160,8 +255,8 @@ func StartServer(opts Options) (srv *Server, err error) {
 		StoreDir:               opts.StoreDir,
 		DisableJetStreamBanner: true,
 		JetStream:              true,
-		JetStreamMaxMemory:     -1,
-		JetStreamMaxStore:      -1,
+		JetStreamMaxMemory:     opts.MaxMemory,
+		JetStreamMaxStore:      opts.MaxStore,
 		Cluster: natssrv.ClusterOpts{
 			Name:      opts.ClusterName,
 			Host:      opts.Addr,
@@ -196,13 +291,22 @@ func StartServer(opts Options) (srv *Server, err error) {
 			defaultAccount,
 		},
 		NoAuthUser:    "usr",
-		SystemAccount: "$SYS",
-	}
-	base.NetworkIntercept = natsNetworkIntercept{
-		cfg: opts.TLSConfig,
+		SystemAccount: SystemAccountName,
 	}
+	auth := &accountAuth{secret: opts.Secret}
+	base.CustomClientAuthentication = auth
+	intercept := &natsNetworkIntercept{}
+	intercept.cfg.Store(opts.TLSConfig)
+	base.NetworkIntercept = intercept
 
-	if opts.ClusterName == "" {
+	if opts.Embedded {
+		logger.Info().Msg("Starting embedded node")
+		base.DontListen = true
+		base.Routes = nil
+		base.Gateway = natssrv.GatewayOpts{}
+		base.Cluster = natssrv.ClusterOpts{}
+		base.LeafNode = natssrv.LeafNodeOpts{}
+	} else if opts.ClusterName == "" {
 		logger.Info().Msg("Starting leaf node")
 		for clusterName, servers := range opts.Topology {
 			// skip seeding partition.
@@ -255,6 +359,7 @@ func StartServer(opts Options) (srv *Server, err error) {
 	if err != nil {
 		return
 	}
+	auth.srv = natss
 	natsLogger := Logger{Logger: logger}
 	if !opts.Debug {
 		newLogger := logger.Level(xlog.LevelInfo)
@@ -268,8 +373,12 @@ func StartServer(opts Options) (srv *Server, err error) {
 	srv = &Server{
 		readych: make(chan struct{}),
 		donech:  make(chan struct{}),
+		auth:    auth,
 	}
+	srv.logger, srv.localDrain = logger, opts.LocalDrain
+	srv.intercept = intercept
 	srv.s.Store(natss)
+	srv.phase.Store(PhaseStarting)
 	srv.mux = http.NewServeMux()
 	srv.mux.HandleFunc(natssrv.RootPath, natss.HandleRoot)
 	srv.mux.HandleFunc(natssrv.VarzPath, natss.HandleVarz)
@@ -305,12 +414,15 @@ func StartServer(opts Options) (srv *Server, err error) {
 			logger.Error().Err(lnErr).Msg("Failed to start local listener")
 		} else {
 			srv.cliurl = fmt.Sprintf("nats://%s", localListener.Addr())
+			srv.localln = localListener
 			logger.Info().Msgf("Listening for client connections on %s", srv.cliurl)
 			go func() {
 				defer localListener.Close()
 				for {
 					conn, err := localListener.Accept()
-					if err != nil {
+					if errors.Is(err, net.ErrClosed) {
+						return
+					} else if err != nil {
 						logger.Error().Err(err).Msg("Failed to accept local connection")
 						select {
 						case <-srv.donech:
@@ -319,6 +431,8 @@ func StartServer(opts Options) (srv *Server, err error) {
 							continue
 						}
 					}
+					srv.localConns.Add(1)
+					conn = &localConn{Conn: conn, count: &srv.localConns}
 					go func() {
 						err := natss.RegisterExternalConn(conn)
 						if err != nil {
@@ -356,6 +470,7 @@ func StartServer(opts Options) (srv *Server, err error) {
 
 		for i := 0; ; i++ {
 			// Wait for the server to be ready
+			srv.phase.Store(PhaseStarting)
 			if !natss.ReadyForConnections(5 * time.Second) {
 				logger.Info().Err(err).Msg("Init: Waiting for NATS server to accept connections")
 				if time.Now().After(deadline) {
@@ -369,6 +484,7 @@ func StartServer(opts Options) (srv *Server, err error) {
 
 			// Connect to the server
 			if conn == nil {
+				srv.phase.Store(PhaseConnecting)
 				var err error
 				conn, err = srv.Connect(nats.Timeout(time.Until(deadline)))
 				if err != nil {
@@ -378,6 +494,7 @@ func StartServer(opts Options) (srv *Server, err error) {
 			}
 
 			// Try messaging the jetstream API up to 5 times
+			srv.phase.Store(PhaseJetStream)
 			var jserr error
 			for i := 1; i <= 10; i++ {
 				_, jserr = conn.Request("$JS.API.INFO", nil, time.Duration(i*100)*time.Millisecond)
@@ -390,12 +507,20 @@ func StartServer(opts Options) (srv *Server, err error) {
 				continue
 			}
 
+			// A lone node has no RAFT log to wait for.
+			if opts.Embedded {
+				logger.Info().Msg("Init: NATS server ready")
+				srv.markAlive()
+				return
+			}
+
 			// Test jetstream node placement
 			jsc := lo.Must(jetstream.New(conn)) // There's nothing to fail here
 			if _, err := jsc.AccountInfo(ctx); err != nil {
 				logger.Info().Err(err).Msg("Init: Waiting for Jetstream to become ready")
 				continue
 			}
+			srv.phase.Store(PhaseRaftPropose)
 			kv, err := jsc.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
 				Bucket:      "pmesh-probe",
 				Description: "pmesh-probe",
@@ -408,6 +533,7 @@ func StartServer(opts Options) (srv *Server, err error) {
 				continue
 			}
 
+			srv.phase.Store(PhaseRaftWrite)
 			if _, err := kv.Put(ctx, "test", []byte{0x1}); err != nil {
 				logger.Info().Err(err).Msg("Init: Waiting for Jetstream RAFT log to become ready (write)")
 				time.Sleep(1 * time.Second)
@@ -0,0 +1,93 @@
+package autonats
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// Starts a server with only a local listener, returns it with n accepted local connections.
+func testLocalServer(t *testing.T, n int, drain time.Duration) (*Server, []net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{logger: xlog.NewDomain("nats"), localln: ln, localDrain: drain}
+	var conns []net.Conn
+	for range n {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.localConns.Add(1)
+		conn = &localConn{Conn: conn, count: &s.localConns}
+		t.Cleanup(func() { conn.Close() })
+		conns = append(conns, conn)
+	}
+	return s, conns
+}
+
+func TestDrainLocal(t *testing.T) {
+	s, conns := testLocalServer(t, 2, 5*time.Second)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conns[0].Close()
+		conns[0].Close() // Counted once.
+		time.Sleep(100 * time.Millisecond)
+		conns[1].Close()
+	}()
+	start := time.Now()
+	s.drainLocal(context.Background())
+	if d := time.Since(start); d < 200*time.Millisecond || d > 2*time.Second {
+		t.Errorf("returned after %v", d)
+	}
+	if n := s.localConns.Load(); n != 0 {
+		t.Errorf("%d connections left", n)
+	}
+	if s.Phase() != PhaseDraining {
+		t.Errorf("phase = %v, want %v", s.Phase(), PhaseDraining)
+	}
+	if _, err := net.Dial("tcp", s.localln.Addr().String()); err == nil {
+		t.Error("local listener still accepting")
+	}
+}
+
+func TestDrainLocalTimeout(t *testing.T) {
+	s, _ := testLocalServer(t, 1, 100*time.Millisecond)
+	start := time.Now()
+	s.drainLocal(context.Background())
+	if d := time.Since(start); d < 100*time.Millisecond || d > 2*time.Second {
+		t.Errorf("returned after %v", d)
+	}
+
+	// The context deadline wins if it is shorter.
+	s, _ = testLocalServer(t, 1, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	s.drainLocal(ctx)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("returned after %v", d)
+	}
+}
+
+func TestDrainLocalIdle(t *testing.T) {
+	s, _ := testLocalServer(t, 0, time.Minute)
+	start := time.Now()
+	s.drainLocal(context.Background())
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("returned after %v", d)
+	}
+	if s.Phase() == PhaseDraining {
+		t.Error("draining without connections")
+	}
+}
@@ -19,6 +19,14 @@ func (c Client) PeersAlive() (res []xpost.Peer, err error) {
 	err = c.Call("/peers/alive", nil, &res)
 	return
 }
+func (c Client) PeerData(peer string) (res session.PeerData, err error) {
+	err = c.Call("/peers/data/"+peer, nil, &res)
+	return
+}
+func (c Client) UpdatePeerUD(u session.PeerUDUpdate) (res map[string]any, err error) {
+	err = c.Call("/peers/ud", u, &res)
+	return
+}
 func (c Client) Publish(topic string, p any) (ack jetstream.PubAck, err error) {
 	err = c.Call("/publish/"+topic, p, &ack)
 	return
@@ -27,3 +35,35 @@ func (c Client) Reload(invalidate bool) (err error) {
 	err = c.Call("/reload", session.ServiceInvalidate{Invalidate: invalidate}, nil)
 	return
 }
+func (c Client) ReloadStatus() (res session.ReloadStatus, err error) {
+	err = c.Call("/reload/status", nil, &res)
+	return
+}
+func (c Client) ReloadPlan() (res session.ReloadPlan, err error) {
+	err = c.Call("/reload/plan", nil, &res)
+	return
+}
+func (c Client) ConfigDiff() (res []session.ConfigDrift, err error) {
+	err = c.Call("/config/diff", nil, &res)
+	return
+}
+func (c Client) Runners() (res map[string]session.RunnerState, err error) {
+	err = c.Call("/runner", nil, &res)
+	return
+}
+func (c Client) RunnerPause(name string) (res session.RunnerCommandResult, err error) {
+	err = c.Call("/runner/pause/"+name, nil, &res)
+	return
+}
+func (c Client) RunnerResume(name string) (res session.RunnerCommandResult, err error) {
+	err = c.Call("/runner/resume/"+name, nil, &res)
+	return
+}
+func (c Client) Cordon() (res session.CordonResult, err error) {
+	err = c.Call("/cordon", nil, &res)
+	return
+}
+func (c Client) Uncordon() (res session.CordonResult, err error) {
+	err = c.Call("/uncordon", nil, &res)
+	return
+}
@@ -9,7 +9,6 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"sync"
 	"time"
 
 	"get.pme.sh/pmesh/pmtp"
@@ -80,23 +79,17 @@ func (c Client) tailBroadcast(ctx context.Context, dialer *pmtp.Dialer, u *pmtp.
 		return nil
 	}
 
-	wg := &sync.WaitGroup{}
-	errs = make([]error, len(peers))
-	for i, peer := range peers {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			host := peer.IP
-			if peer.Me {
-				host = u.Host
-			}
-			err := tail(ctx, dialer, true, host, to, out.SubWriter(peer.Host))
-			if err != nil {
-				errs[i] = fmt.Errorf("host %s: %w", peer.Host, err)
-			}
-		}()
+	// Streams until the context is done or the logs are read, no deadline per peer.
+	_, failed := xpost.FanOut(ctx, peers, -1, func(ctx context.Context, peer *xpost.Peer) (struct{}, error) {
+		host := peer.IP
+		if peer.Me {
+			host = u.Host
+		}
+		return struct{}{}, tail(ctx, dialer, true, host, to, out.SubWriter(peer.Host))
+	})
+	for _, f := range failed {
+		errs = append(errs, f)
 	}
-	wg.Wait()
 	return
 }
 
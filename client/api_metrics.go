@@ -10,3 +10,15 @@ func (c Client) SessionMetrics() (m session.SessionMetrics, err error) {
 	err = c.Call("/session", nil, &m)
 	return
 }
+func (c Client) ClearClientSession(ip string) (res session.SessionClearResult, err error) {
+	err = c.Call("/session/clear/"+ip, nil, &res)
+	return
+}
+func (c Client) JetStreamMetrics() (m session.JetStreamMetrics, err error) {
+	err = c.Call("/jetstream", nil, &m)
+	return
+}
+func (c Client) RaftStatus() (m session.RaftStatus, err error) {
+	err = c.Call("/raft", nil, &m)
+	return
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"get.pme.sh/pmesh/service"
 	"get.pme.sh/pmesh/session"
 	"get.pme.sh/pmesh/snowflake"
 )
@@ -29,6 +30,10 @@ func (c Client) ServiceRestartAll(invalidate bool) (res session.ServiceCommandRe
 	err = c.Call("/service/restart", session.ServiceInvalidate{Invalidate: invalidate}, &res)
 	return
 }
+func (c Client) ServiceRollingRestart(name string, invalidate bool) (res session.ServiceCommandResult, err error) {
+	err = c.Call("/service/restart/"+name, session.ServiceInvalidate{Invalidate: invalidate, Rolling: true}, &res)
+	return
+}
 func (c Client) ServiceStop(name string) (res session.ServiceCommandResult, err error) {
 	err = c.Call("/service/stop/"+name, nil, &res)
 	return
@@ -41,3 +46,11 @@ func (c Client) Services() (res map[string]snowflake.ID, err error) {
 	err = c.Call("/service", nil, &res)
 	return
 }
+func (c Client) ServiceExport(name string) (res session.ServiceExport, err error) {
+	err = c.Call("/service/export/"+name, nil, &res)
+	return
+}
+func (c Client) ServiceBuild(name string) (res service.BuildProgress, err error) {
+	err = c.Call("/service/build/"+name, nil, &res)
+	return
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"get.pme.sh/pmesh/pmtp"
+	"get.pme.sh/pmesh/vhttp"
+)
+
+// Streams the metadata of the requests matching the options served by the node, as JSON lines.
+func (c Client) TapContext(ctx context.Context, opts vhttp.TapOptions, out io.Writer) error {
+	u, err := pmtp.ParseURL(c.URL)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if u.TLS {
+		scheme = "https"
+	}
+	req := &http.Request{
+		Method: "POST",
+		URL: &url.URL{
+			Scheme: scheme,
+			Host:   u.Host,
+			Path:   "/tap",
+		},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	req = req.WithContext(ctx)
+	conn, resp, err := u.Dialer().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		response, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status: %s: %s", resp.Status, response)
+	}
+	context.AfterFunc(ctx, func() { conn.Close() })
+	_, err = io.Copy(out, resp.Body)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
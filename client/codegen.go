@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+
+	"get.pme.sh/pmesh/schema"
+	"get.pme.sh/pmesh/session"
+)
+
+// Endpoint is a topic of the manifest that can be called through the publish API.
+type Endpoint struct {
+	Method   string         // Name of the generated method.
+	Topic    string         // Topic as declared in the manifest.
+	Params   []string       // Names of the parameters substituted for the wildcards, in order.
+	Doc      string         // Description of the endpoint.
+	Request  *schema.Schema // Schema of the payload, if declared.
+	Response *schema.Schema // Schema of the result, if declared.
+}
+
+// Returns the endpoint for the topic, wildcard tokens become parameters.
+func makeEndpoint(method, topic, doc string) Endpoint {
+	ep := Endpoint{Method: method, Topic: topic, Doc: doc}
+	tokens := strings.Split(topic, ".")
+	for i, tok := range tokens {
+		switch {
+		case tok == "*":
+			ep.Params = append(ep.Params, fmt.Sprintf("p%d", len(ep.Params)+1))
+		case tok == ">" || (tok == "" && i == len(tokens)-1):
+			ep.Params = append(ep.Params, "rest")
+		}
+	}
+	return ep
+}
+
+// Endpoints returns the callable endpoints of the manifest, the runners followed by the services.
+func Endpoints(manifest *session.Manifest) (res []Endpoint, err error) {
+	runners := make([]string, 0, len(manifest.Runners))
+	for topic := range manifest.Runners {
+		runners = append(runners, topic)
+	}
+	slices.Sort(runners)
+	for _, topic := range runners {
+		ep := makeEndpoint(schema.GoIdent(topic, "T"), topic, fmt.Sprintf("runner %q", topic))
+		if r := manifest.Runners[topic]; r != nil {
+			ep.Request, ep.Response = r.Schema.Request, r.Schema.Response
+		}
+		res = append(res, ep)
+	}
+	for _, kv := range manifest.Services {
+		ep := makeEndpoint("Svc"+schema.GoIdent(kv.A, "T"), "svc."+kv.A+".", fmt.Sprintf("service %q, rest is the path with dots as separators", kv.A))
+		res = append(res, ep)
+	}
+
+	seen := make(map[string]string, len(res))
+	for _, ep := range res {
+		if prev, ok := seen[ep.Method]; ok {
+			return nil, fmt.Errorf("topics %q and %q map to the same method %s", prev, ep.Topic, ep.Method)
+		}
+		seen[ep.Method] = ep.Topic
+	}
+	return
+}
+
+// Returns the Go expression building the topic from the parameters, which are escaped so that they
+// stay within the path segment of the topic.
+func (ep Endpoint) topicExpr() string {
+	tokens := strings.Split(ep.Topic, ".")
+	var parts []string
+	lit := ""
+	param := 0
+	for i, tok := range tokens {
+		if i != 0 {
+			lit += "."
+		}
+		if tok == "*" || tok == ">" || (tok == "" && i == len(tokens)-1) {
+			if lit != "" {
+				parts = append(parts, strconv.Quote(lit))
+				lit = ""
+			}
+			parts = append(parts, "url.PathEscape("+ep.Params[param]+")")
+			param++
+			continue
+		}
+		lit += tok
+	}
+	if lit != "" {
+		parts = append(parts, strconv.Quote(lit))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// GenerateGo emits the source of a Go package with a method for each endpoint of the manifest,
+// wrapping the publish API. The payload and the result of the runners declaring a schema are typed
+// after it, the others are left untyped.
+func GenerateGo(pkg string, manifest *session.Manifest) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("invalid package name %q", pkg)
+	}
+	endpoints, err := Endpoints(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the methods first, collecting the types they use.
+	types := &bytes.Buffer{}
+	methods := &bytes.Buffer{}
+	declared := make(map[string]string)
+	declare := func(name, def string) {
+		if prev, ok := declared[name]; ok {
+			if prev != def {
+				err = fmt.Errorf("schemas declare different types named %s", name)
+			}
+			return
+		}
+		declared[name] = def
+		fmt.Fprintf(types, "\ntype %s %s\n", name, def)
+	}
+	for _, ep := range endpoints {
+		req, res := "any", "json.RawMessage"
+		if ep.Request != nil {
+			req = ep.Request.GoType(ep.Method+"Request", declare)
+		}
+		if ep.Response != nil {
+			res = ep.Response.GoType(ep.Method+"Response", declare)
+		}
+		args := make([]string, 0, len(ep.Params)+1)
+		for _, p := range ep.Params {
+			args = append(args, p+" string")
+		}
+		args = append(args, "payload "+req)
+		fmt.Fprintf(methods, "\n// %s calls the %s.\n", ep.Method, ep.Doc)
+		fmt.Fprintf(methods, "func (c Client) %s(%s) (%s, error) {\n", ep.Method, strings.Join(args, ", "), res)
+		fmt.Fprintf(methods, "\treturn call[%s](c, %s, payload)\n}\n", res, ep.topicExpr())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by pmesh gen-client. DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %s\n\n", pkg)
+	fmt.Fprintf(buf, "import (\n")
+	if bytes.Contains(types.Bytes(), []byte("json.")) || bytes.Contains(methods.Bytes(), []byte("json.")) {
+		fmt.Fprintf(buf, "\t\"encoding/json\"\n")
+	}
+	if bytes.Contains(methods.Bytes(), []byte("url.PathEscape")) {
+		fmt.Fprintf(buf, "\t\"net/url\"\n")
+	}
+	fmt.Fprintf(buf, "\n\t\"get.pme.sh/pmesh/client\"\n)\n\n")
+	fmt.Fprintf(buf, "// Client calls the runners and services of the manifest through the publish API.\n")
+	fmt.Fprintf(buf, "type Client struct {\n\tclient.Client\n}\n\n")
+	fmt.Fprintf(buf, "func call[T any](c Client, topic string, payload any) (res T, err error) {\n")
+	fmt.Fprintf(buf, "\terr = c.Call(\"/publish/\"+topic, payload, &res)\n\treturn\n}\n")
+	buf.Write(types.Bytes())
+	buf.Write(methods.Bytes())
+	return format.Source(buf.Bytes())
+}
@@ -1,12 +1,19 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
 
 	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/session"
 	"get.pme.sh/pmesh/ui"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 func init() {
@@ -30,12 +37,225 @@ func init() {
 		GroupID: refGroup("svct", "Management"),
 	}
 	inval := reloadcmd.PersistentFlags().BoolP("invalidate", "i", false, "Invalidates cached builds")
+	diff := reloadcmd.Flags().Bool("diff", false, "Prints what the reload would change as JSON, without applying it")
+	status := reloadcmd.Flags().Bool("status", false, "Prints the progress of the reloads as JSON, without reloading")
 	reloadcmd.Run = func(_ *cobra.Command, args []string) {
 		cli := getClient()
+		if *status {
+			res, err := cli.ReloadStatus()
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			data, _ := json.MarshalIndent(res, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		if *diff {
+			plan, err := cli.ReloadPlan()
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			data, _ := json.MarshalIndent(plan, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
 		res := ui.SpinnyWait("Reloading...", func() (string, error) {
 			return "Done", cli.Reload(*inval)
 		})
 		fmt.Println(ui.RenderOkLine(res))
 	}
 	config.RootCommand.AddCommand(reloadcmd)
+
+	configcmd := &cobra.Command{
+		Use:     "config",
+		Short:   "Inspects the running configuration",
+		GroupID: refGroup("svct", "Management"),
+	}
+	jsonOut := false
+	diffcmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Shows what a reload would change or revert, comparing the running configuration to the manifest on disk",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, args []string) {
+			drift, err := getClient().ConfigDiff()
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			if jsonOut {
+				data, _ := json.MarshalIndent(drift, "", "  ")
+				fmt.Println(string(data))
+				return
+			}
+			if len(drift) == 0 {
+				fmt.Println(ui.RenderOkLine("No drift, the running configuration matches the manifest"))
+				return
+			}
+			value := func(v any) string {
+				data, _ := json.Marshal(v)
+				return string(data)
+			}
+			for _, d := range drift {
+				switch d.Kind {
+				case session.DriftAdded:
+					fmt.Println(ui.OkStyle.Render("+ "+d.Path+": ") + value(d.Disk))
+				case session.DriftRemoved:
+					fmt.Println(ui.ErrStyle.Render("- "+d.Path+": ") + value(d.Running))
+				case session.DriftChanged:
+					fmt.Println(ui.BrownStyle.Render("~ "+d.Path+": ") + value(d.Running) + " -> " + value(d.Disk))
+				default:
+					fmt.Println(ui.FaintStyle.Render("! "+d.Path+": ") + value(d.Running) + ui.FaintStyle.Render(" ("+d.Note+")"))
+				}
+			}
+		},
+	}
+	diffcmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	configcmd.AddCommand(diffcmd)
+	config.RootCommand.AddCommand(configcmd)
+
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "runners",
+		Short:   "Lists the runners and the nodes consuming them",
+		Args:    cobra.NoArgs,
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			runners, err := getClient().Runners()
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			names := make([]string, 0, len(runners))
+			for name := range runners {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "RUNNER\tSTATE\tINFLIGHT\tNODES")
+			for _, name := range names {
+				r := runners[name]
+				state := "idle"
+				if r.Paused {
+					state = "paused"
+				} else if r.Consuming {
+					state = "consuming"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", name, state, r.Inflight, strings.Join(r.Nodes, ", "))
+			}
+			tw.Flush()
+		},
+	})
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "pause [runner]",
+		Short:   "Pauses a runner, it stops taking new messages until resumed",
+		Args:    cobra.ExactArgs(1),
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			cli := getClient()
+			res := ui.SpinnyWait("Pausing...", func() (string, error) {
+				r, err := cli.RunnerPause(args[0])
+				if err == nil && !r.Changed {
+					return "Already paused", nil
+				}
+				return "Paused", err
+			})
+			fmt.Println(ui.RenderOkLine(res))
+		},
+	})
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "resume [runner]",
+		Short:   "Resumes a paused runner",
+		Args:    cobra.ExactArgs(1),
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			cli := getClient()
+			res := ui.SpinnyWait("Resuming...", func() (string, error) {
+				r, err := cli.RunnerResume(args[0])
+				if err == nil && !r.Changed {
+					return "Not paused", nil
+				}
+				return "Resumed", err
+			})
+			fmt.Println(ui.RenderOkLine(res))
+		},
+	})
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "cordon",
+		Short:   "Takes the node out of rotation, new external traffic is shed while the requests in flight drain",
+		Args:    cobra.NoArgs,
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			cli := getClient()
+			res := ui.SpinnyWait("Cordoning...", func() (string, error) {
+				r, err := cli.Cordon()
+				if err == nil && !r.Changed {
+					return "Already cordoned", nil
+				}
+				return "Cordoned", err
+			})
+			fmt.Println(ui.RenderOkLine(res))
+		},
+	})
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "uncordon",
+		Short:   "Puts a cordoned node back into rotation",
+		Args:    cobra.NoArgs,
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			cli := getClient()
+			res := ui.SpinnyWait("Uncordoning...", func() (string, error) {
+				r, err := cli.Uncordon()
+				if err == nil && !r.Changed {
+					return "Not cordoned", nil
+				}
+				return "Uncordoned", err
+			})
+			fmt.Println(ui.RenderOkLine(res))
+		},
+	})
+
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "peer [machine-id|host]",
+		Short:   "Prints the user and system data advertised by a peer, the local node by default",
+		Args:    cobra.MaximumNArgs(1),
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			peer := config.Get().Host
+			if len(args) != 0 {
+				peer = args[0]
+			}
+			res, err := getClient().PeerData(peer)
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			data, _ := json.MarshalIndent(res, "", "  ")
+			fmt.Println(string(data))
+		},
+	})
+	var unset []string
+	udcmd := &cobra.Command{
+		Use:     "ud [field=value]...",
+		Short:   "Updates the user data advertised by the node to its peers, without a restart",
+		GroupID: refGroup("svct", "Management"),
+		Run: func(_ *cobra.Command, args []string) {
+			update := session.PeerUDUpdate{Set: map[string]any{}, Unset: unset}
+			for _, arg := range args {
+				field, valueStr, ok := strings.Cut(arg, "=")
+				if !ok {
+					ui.ExitWithError(fmt.Errorf("expected field=value, got %q", arg))
+				}
+				var value any
+				if err := yaml.Unmarshal([]byte(valueStr), &value); err != nil {
+					ui.ExitWithError(err)
+				}
+				update.Set[field] = value
+			}
+			res, err := getClient().UpdatePeerUD(update)
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			data, _ := json.MarshalIndent(res, "", "  ")
+			fmt.Println(string(data))
+		},
+	}
+	udcmd.Flags().StringSliceVar(&unset, "unset", nil, "Fields to remove")
+	config.RootCommand.AddCommand(udcmd)
 }
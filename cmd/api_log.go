@@ -2,13 +2,21 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/rundown"
+	"get.pme.sh/pmesh/snowflake"
+	"get.pme.sh/pmesh/ui"
+	"get.pme.sh/pmesh/vhttp"
 	"get.pme.sh/pmesh/xlog"
 
 	"github.com/spf13/cobra"
@@ -76,6 +84,47 @@ var raytraceCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	GroupID: refGroup("log", "Logs"),
 }
+var tapCmd = &cobra.Command{
+	Use:     "tap",
+	Short:   "Stream request metadata as it is served",
+	Args:    cobra.NoArgs,
+	GroupID: refGroup("log", "Logs"),
+}
+var logsCmd = &cobra.Command{
+	Use:     "logs [domain]",
+	Short:   "Show the logs of a domain, following the log file across rotation",
+	Args:    cobra.ExactArgs(1),
+	GroupID: refGroup("log", "Logs"),
+}
+var decodeIDCmd = &cobra.Command{
+	Use:     "decode-id [id]",
+	Short:   "Decode a session, service or lambda ID into the time it was created at",
+	Args:    cobra.ExactArgs(1),
+	GroupID: refGroup("log", "Logs"),
+}
+var decodeIDJson = decodeIDCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
+var logsFollow = logsCmd.Flags().BoolP("follow", "f", false, "Follow the log file")
+var logsLevel = logsCmd.Flags().StringP("min-level", "l", "info", "Minimum log level")
+var logsLines = logsCmd.Flags().Int64P("lines", "n", 100, "Max lines to emit from history")
+var logsJson = logsCmd.Flags().BoolP("json", "j", config.IsTermDumb(), "Output logs in JSON format")
+var logsFile = logsCmd.Flags().String("file", "", "Log file to follow, defaults to the domain's own file or the session log")
+var logsLive = logsCmd.Flags().Bool("live", false, "Follow the logs collected by the running daemon instead of the log file")
+
+// Returns the log file the domain is written to, services log to their own file while the rest
+// goes to the session log.
+func domainLogFile(domain string) string {
+	root, _, _ := strings.Cut(domain, ".")
+	if root != "" {
+		if _, err := os.Stat(config.LogDir.File(root + ".log")); err == nil {
+			return root + ".log"
+		}
+	}
+	return "session.log"
+}
+
+var tapHost = tapCmd.Flags().String("host", "", "Host to match, empty for any")
+var tapPrefix = tapCmd.Flags().StringP("prefix", "p", "", "Path prefix to match, empty for any")
+var tapRate = tapCmd.Flags().IntP("rate", "r", vhttp.TapDefaultRate, "Maximum number of events per second")
 var tailOptions = tailOptionsP(tailCmd.PersistentFlags())
 var raytraceOptions = tailOptionsP(raytraceCmd.PersistentFlags())
 
@@ -130,5 +179,94 @@ func init() {
 			}
 		}
 	}
-	config.RootCommand.AddCommand(tailCmd, raytraceCmd)
+	tapCmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx, cancel := rundown.WithContext(context.Background())
+		defer cancel()
+
+		opt := vhttp.TapOptions{
+			Host:   *tapHost,
+			Prefix: *tapPrefix,
+			Rate:   *tapRate,
+		}
+		if err := getClient().TapContext(ctx, opt, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	logsCmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx, cancel := rundown.WithContext(context.Background())
+		defer cancel()
+
+		opt := xlog.TailOptions{
+			Domain:    args[0],
+			LineLimit: *logsLines,
+			IoLimit:   1000 * 1024 * 1024,
+		}
+		if err := opt.MinLevel.UnmarshalText([]byte(*logsLevel)); err != nil {
+			log.Fatal(err)
+		}
+		var wr io.Writer = os.Stdout
+		if !*logsJson {
+			wr = xlog.StdoutWriter()
+		}
+		mw := xlog.ToMuxWriter(wr)
+		defer mw.Flush()
+
+		// When following the file, it picks up from where the history stops: the history ends with the
+		// lines logged before the file was measured, and following starts with the lines logged after.
+		file := *logsFile
+		if file == "" {
+			file = domainLogFile(opt.Domain)
+		}
+		start := int64(0)
+		followFile := *logsFollow && !*logsLive
+		if followFile {
+			opt.Before = time.Now()
+			path := file
+			if !filepath.IsAbs(path) {
+				path = config.LogDir.File(path)
+			}
+			if st, err := os.Stat(path); err == nil {
+				start = st.Size()
+			}
+		}
+
+		if err := xlog.TailContext(ctx, opt, mw); err != nil {
+			log.Fatal(err)
+		}
+		if !*logsFollow {
+			return
+		}
+		mw.Flush()
+
+		var err error
+		if followFile {
+			opt.After, opt.Before = opt.Before, time.Time{}
+			err = xlog.FollowFile(ctx, file, start, opt.Filter(), mw)
+		} else {
+			opt.Follow = true
+			opt.IoLimit = -1
+			opt.Viral = false
+			err = getClient().TailContext(ctx, opt, mw)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+	}
+	decodeIDCmd.Run = func(cmd *cobra.Command, args []string) {
+		id, err := snowflake.Parse(args[0])
+		if err != nil {
+			ui.ExitWithError(err)
+		}
+		parts := id.Decode()
+		if *decodeIDJson {
+			json.NewEncoder(os.Stdout).Encode(parts)
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Timestamp\t%s (%s ago)\n", parts.Timestamp.Format(time.RFC3339Nano), time.Since(parts.Timestamp).Round(time.Second))
+		fmt.Fprintf(tw, "Machine\t%d\n", parts.MachineID)
+		fmt.Fprintf(tw, "Sequence\t%d\n", parts.Sequence)
+		tw.Flush()
+	}
+	config.RootCommand.AddCommand(tailCmd, raytraceCmd, tapCmd, logsCmd, decodeIDCmd)
 }
@@ -1,11 +1,21 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"get.pme.sh/pmesh/client"
 	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/service"
+	"get.pme.sh/pmesh/session"
 	"get.pme.sh/pmesh/ui"
+	"get.pme.sh/pmesh/variant"
 
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 )
 
@@ -40,6 +50,151 @@ func init() {
 		},
 	})
 
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "export [service]",
+		Short:   "Print the resolved definition of a service as YAML",
+		Args:    cobra.MaximumNArgs(1),
+		GroupID: refGroup("ctrl", "Service"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli := getClient()
+			var svc string
+			if len(args) == 0 {
+				svc = ui.PromptSelectService(cli)
+			} else {
+				svc = args[0]
+			}
+			res, err := cli.ServiceExport(svc)
+			if err != nil {
+				return err
+			}
+			fmt.Print(res.YAML)
+			return nil
+		},
+	})
+	config.RootCommand.AddCommand(&cobra.Command{
+		Use:     "build-log [service]",
+		Short:   "Print the status and output of the last build of a service",
+		Args:    cobra.MaximumNArgs(1),
+		GroupID: refGroup("ctrl", "Service"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli := getClient()
+			var svc string
+			if len(args) == 0 {
+				svc = ui.PromptSelectService(cli)
+			} else {
+				svc = args[0]
+			}
+			res, err := cli.ServiceBuild(svc)
+			if err != nil {
+				return err
+			}
+			for _, line := range res.Output {
+				fmt.Println(line.Line)
+			}
+			switch res.Status {
+			case service.BuildFailed:
+				fmt.Println(ui.ErrStyle.Render("Build failed: " + res.Error))
+			case service.BuildDone:
+				fmt.Println(ui.RenderOkLine("Build finished in " + res.Finished.Sub(res.Started).Round(time.Millisecond).String()))
+			default:
+				fmt.Println(ui.FaintStyle.Render("Building since " + res.Started.Format(time.TimeOnly)))
+			}
+			return nil
+		},
+	})
+	importCmd := &cobra.Command{
+		Use:     "import [file] [manifest]",
+		Short:   "Add exported service definitions to a manifest",
+		Args:    cobra.RangeArgs(1, 2),
+		GroupID: refGroup("ctrl", "Service"),
+	}
+	importReplace := importCmd.Flags().Bool("replace", false, "Replace existing services with the same name")
+	importReload := importCmd.Flags().Bool("reload", false, "Reload the running node after importing")
+	importCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		manifestPath := session.GetManifestPathFromArgs(args[1:])
+		names, err := session.ImportServices(manifestPath, data, *importReplace)
+		if err != nil {
+			return err
+		}
+		fmt.Println(ui.RenderOkLine(fmt.Sprintf("Imported %s into %s", strings.Join(names, ", "), manifestPath)))
+		if *importReload {
+			return getClient().Reload(false)
+		}
+		return nil
+	}
+	config.RootCommand.AddCommand(importCmd)
+
+	genCmd := &cobra.Command{
+		Use:     "gen-client [manifest]",
+		Short:   "Generate a Go client calling the runners and services of a manifest",
+		Args:    cobra.MaximumNArgs(1),
+		GroupID: refGroup("ctrl", "Service"),
+	}
+	genPackage := genCmd.Flags().StringP("package", "p", "pmclient", "Name of the generated package")
+	genOut := genCmd.Flags().StringP("out", "o", "", "Output file, stdout if empty")
+	genCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		manifest, err := session.LoadManifest(session.GetManifestPathFromArgs(args))
+		if err != nil {
+			return err
+		}
+		src, err := client.GenerateGo(*genPackage, manifest)
+		if err != nil {
+			return err
+		}
+		if *genOut == "" {
+			_, err = os.Stdout.Write(src)
+			return err
+		}
+		return os.WriteFile(*genOut, src, 0644)
+	}
+	config.RootCommand.AddCommand(genCmd)
+
+	typesCmd := &cobra.Command{
+		Use:     "types [kind]",
+		Short:   "List the service types, route handlers and health checks with their fields",
+		Args:    cobra.MaximumNArgs(1),
+		GroupID: refGroup("ctrl", "Service"),
+	}
+	typesJson := typesCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
+	typesCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		registries := session.Registries()
+		if len(args) != 0 {
+			descs, ok := registries[args[0]]
+			if !ok {
+				return fmt.Errorf("unknown kind %q, expected one of services, handlers or checks", args[0])
+			}
+			registries = map[string][]variant.Description{args[0]: descs}
+		}
+		if *typesJson {
+			return json.NewEncoder(os.Stdout).Encode(registries)
+		}
+		kinds := lo.Keys(registries)
+		slices.Sort(kinds)
+		for _, kind := range kinds {
+			fmt.Println(ui.BrownStyle.Render(kind))
+			for _, d := range registries[kind] {
+				line := "  " + d.Tag
+				if d.Usage != "" {
+					line += " " + ui.FaintStyle.Render(d.Usage)
+				}
+				fmt.Println(line)
+				for _, f := range d.Fields {
+					field := fmt.Sprintf("    %s %s", f.Name, ui.FaintStyle.Render(f.Type))
+					if f.Default != nil {
+						field += fmt.Sprintf(" = %v", f.Default)
+					}
+					fmt.Println(field)
+				}
+			}
+		}
+		return nil
+	}
+	config.RootCommand.AddCommand(typesCmd)
+
 	for _, cmd := range ui.ServiceControls {
 		config.RootCommand.AddCommand(&cobra.Command{
 			Use:     cmd.Use,
@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"cmp"
 	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
+	"get.pme.sh/pmesh/client"
 	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/pmtp"
 
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
@@ -81,6 +86,64 @@ func init() {
 	strAccessor("cluster", func(ss *config.Config) *string { return &ss.Cluster })
 	strAccessor("remote", func(ss *config.Config) *string { return &ss.Remote })
 	strAccessor("advertised", func(ss *config.Config) *string { return &ss.Advertised })
+	getset(
+		"standalone",
+		func(s *config.Config) any {
+			if s.Standalone == nil {
+				return "auto"
+			}
+			return *s.Standalone
+		},
+		func(s *config.Config, v string) error {
+			if v == "auto" {
+				s.Standalone = nil
+				return nil
+			}
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid standalone setting %q, expected true, false or auto", v)
+			}
+			s.Standalone = &b
+			return nil
+		},
+	)
+	getset(
+		"machine-id",
+		func(s *config.Config) any {
+			// Shows the configured ID, flagged when the running node has yet to restart to take it.
+			id, err := config.ConfiguredMachineID(s)
+			res := id.String()
+			if s.MachineID == "" {
+				res += " (auto)"
+			}
+			if err != nil {
+				res += " (" + err.Error() + ")"
+			}
+			if cli, e := client.ConnectTo(cmp.Or(*optURL, pmtp.DefaultURL)); e == nil {
+				if m, e := cli.SystemMetrics(); e == nil && m.MachineID != id.String() {
+					res += " (pending restart, running as " + m.MachineID + ")"
+				}
+			}
+			return res
+		},
+		func(s *config.Config, v string) error {
+			// Takes effect on restart, "random" breaks a collision between cloned machines and "auto"
+			// goes back to deriving it from the host.
+			switch v {
+			case "auto":
+				s.MachineID = ""
+			case "random":
+				s.MachineID = config.RandomMachineID().String()
+			default:
+				id, err := config.ParseMachineID(v)
+				if err != nil {
+					return err
+				}
+				s.MachineID = id.String()
+			}
+			return nil
+		},
+	)
 
 	// Add the arbitrary PeerUD/LocalUD command
 	//
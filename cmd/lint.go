@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/session"
+	"get.pme.sh/pmesh/ui"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts session.LintOptions
+	jsonOut := false
+	strict := false
+	listRules := false
+	lintcmd := &cobra.Command{
+		Use:     "lint [manifest]",
+		Short:   "Checks the manifest for anti-patterns, exits with an error if any error is found",
+		Args:    cobra.MaximumNArgs(1),
+		GroupID: refGroup("daemon", "Daemon"),
+		Run: func(_ *cobra.Command, args []string) {
+			if listRules {
+				for _, rule := range session.LintRules {
+					fmt.Println(ui.BrownStyle.Render(rule.Name) + " " + ui.FaintStyle.Render(rule.Description))
+				}
+				return
+			}
+
+			findings, err := session.LintManifest(session.GetManifestPathFromArgs(args), opts)
+			if err != nil {
+				ui.ExitWithError(err)
+			}
+			failed := false
+			for _, f := range findings {
+				failed = failed || f.Severity == session.LintError || (strict && f.Severity == session.LintWarning)
+			}
+
+			if jsonOut {
+				data, _ := json.MarshalIndent(findings, "", "  ")
+				fmt.Println(string(data))
+			} else if len(findings) == 0 {
+				fmt.Println(ui.RenderOkLine("No issues found"))
+			} else {
+				for _, f := range findings {
+					var sev string
+					switch f.Severity {
+					case session.LintError:
+						sev = ui.ErrStyle.Render("error  ")
+					case session.LintWarning:
+						sev = ui.BrownStyle.Render("warning")
+					default:
+						sev = ui.FaintStyle.Render("info   ")
+					}
+					fmt.Println(sev + " " + f.Location + ": " + f.Message + ui.FaintStyle.Render(" ("+f.ID()+")"))
+				}
+			}
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+	lintcmd.Flags().StringSliceVar(&opts.Disable, "disable", nil, "Rules to disable")
+	lintcmd.Flags().StringSliceVar(&opts.Ignore, "ignore", nil, "Findings to suppress, by ID with glob patterns")
+	lintcmd.Flags().BoolVar(&strict, "strict", false, "Exit with an error on warnings as well")
+	lintcmd.Flags().BoolVar(&jsonOut, "json", false, "Output in JSON format")
+	lintcmd.Flags().BoolVar(&listRules, "rules", false, "List the rules and exit")
+	config.RootCommand.AddCommand(lintcmd)
+}
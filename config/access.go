@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/samber/lo"
+)
+
+// Access is the level of access granted to a caller of the management API.
+type Access int
+
+const (
+	AccessNone     Access = iota
+	AccessViewer          // Read-only access to metrics, health and logs.
+	AccessOperator        // Viewer, and may restart/stop services and reload the manifest.
+	AccessAdmin           // Unrestricted.
+)
+
+var accessStr = map[string]Access{
+	"":         AccessNone,
+	"viewer":   AccessViewer,
+	"operator": AccessOperator,
+	"admin":    AccessAdmin,
+}
+var accessInt = lo.Invert(accessStr)
+
+func (k Access) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+func (k *Access) UnmarshalText(text []byte) error {
+	v, ok := accessStr[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown access level: %q", text)
+	}
+	*k = v
+	return nil
+}
+func (k Access) String() string {
+	return accessInt[k]
+}
+
+// User is a set of credentials for the management API, authenticated using basic auth.
+type User struct {
+	Password string `json:"password"` // Password
+	Access   Access `json:"access"`   // Access level granted
+}
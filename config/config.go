@@ -14,15 +14,19 @@ import (
 )
 
 type Config struct {
-	Role       Role                `json:"role"`       // Role of this server
-	Remote     string              `json:"remote"`     // URL of the PNATS/NATS server if we're a regular client
-	Host       string              `json:"host"`       // Hostname of this server
-	Cluster    string              `json:"cluster"`    // Cluster name
-	Secret     string              `json:"secret"`     // Secret key used for all encryption
-	Topology   map[string][]string `json:"topology"`   // Topology of the mesh [Hostname -> Cluster]
-	Advertised string              `json:"advertised"` // Advertised hostname of this server
-	PeerUD     map[string]any      `json:"peerud"`     // Arbitrary data to be sent to peers
-	LocalUD    map[string]any      `json:"localud"`    // Arbitrary data used for parsing yaml
+	Role       Role                `json:"role"`                 // Role of this server
+	Remote     string              `json:"remote"`               // URL of the PNATS/NATS server if we're a regular client
+	Host       string              `json:"host"`                 // Hostname of this server
+	Cluster    string              `json:"cluster"`              // Cluster name
+	Secret     string              `json:"secret"`               // Secret key used for all encryption
+	Topology   map[string][]string `json:"topology"`             // Topology of the mesh [Hostname -> Cluster]
+	Advertised string              `json:"advertised"`           // Advertised hostname of this server
+	PeerUD     map[string]any      `json:"peerud"`               // Arbitrary data to be sent to peers
+	LocalUD    map[string]any      `json:"localud"`              // Arbitrary data used for parsing yaml
+	RayFormat  string              `json:"rayformat"`            // Format of the request IDs, either "ray" (default) or "snowflake"
+	Users      map[string]User     `json:"users"`                // Additional management API users [Username -> User]
+	MachineID  string              `json:"machineid"`            // Overrides the machine ID derived from the host, in hexadecimal
+	Standalone *bool               `json:"standalone,omitempty"` // Runs NATS embedded if no topology is configured, by default if there is no advertised hostname either, overridden by --standalone
 }
 
 func (c *Config) SetDefaults() {
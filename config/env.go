@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
@@ -23,6 +24,15 @@ var InternalPort = GInt("internal-port", "", 8443, "Internal port")
 var ServiceSubnet = GString("subnet-service", "", "127.1.0.0/16", "Service subnet")
 var DialerSubnet = GString("subnet-dialer", "", "127.2.0.0/16", "Dialer subnet")
 var _ = GString("cwd", "C", "", "Sets the working directory before running the command")
+var Standalone = GBool("standalone", "", false, "Run NATS embedded without accepting cluster connections if no topology is configured, overrides the standalone setting of the node")
+var JetStreamMaxMemory = GString("js-max-memory", "", "", "JetStream memory limit, e.g. 2g or 25%, sized from the system memory if empty")
+var JetStreamMaxStore = GString("js-max-store", "", "", "JetStream storage limit, e.g. 50g, sized from the available disk if empty")
+var MaxClientSessions = GInt("max-sessions", "", 100000, "Maximum number of client sessions kept in memory, the least recently used are evicted past it")
+var MuxBacklog = GInt("mux-backlog", "", 8, "Connections per protocol waiting to be accepted on the internal port before the handshakes block")
+var MuxHandshakeTimeout = GDuration("mux-handshake-timeout", "", 10*time.Second, "Connections to the internal port not done with the TLS handshake within it are closed, disabled if zero")
+var MuxFallback = GString("mux-fallback", "", "", "Protocol serving the connections to the internal port that negotiate none of those listened to, rejected if empty")
+var Takeover = GBool("takeover", "", false, "Take over the listeners of the running node instead of failing to start, it drains and exits once they are handed off")
+var AllowDegraded = GBool("allow-degraded", "", false, "Keep serving HTTP if NATS is unavailable, runners and clustering are disabled")
 
 var cache = sync.Map{}
 
@@ -52,10 +62,11 @@ func Home() (home string) {
 type Subdir string
 
 const (
-	LogDir   Subdir = "log"
-	AsnDir   Subdir = "asn"
-	StoreDir Subdir = "store"
-	CertDir  Subdir = "certs"
+	LogDir      Subdir = "log"
+	AsnDir      Subdir = "asn"
+	StoreDir    Subdir = "store"
+	CertDir     Subdir = "certs"
+	ManifestDir Subdir = "manifests" // Local copies of the manifests loaded from a remote source
 )
 
 func NatsDir(serverName string) string {
@@ -88,6 +99,15 @@ func getenv(name string) (string, bool) {
 	name = strings.ReplaceAll(name, "-", "_")
 	return os.LookupEnv("PM3_" + name)
 }
+
+// IsSet returns true if the global was given either in the environment or on the command line.
+func IsSet(name string) bool {
+	if _, ok := getenv(name); ok {
+		return true
+	}
+	f := RootCommand.PersistentFlags().Lookup(name)
+	return f != nil && f.Changed
+}
 func GInt(name, shorthand string, value int, usage string) *int {
 	flags := RootCommand.PersistentFlags()
 	if env, ok := getenv(name); ok {
@@ -106,6 +126,16 @@ func GString(name, shorthand string, value string, usage string) *string {
 	flags.StringVarP(&value, name, shorthand, value, usage)
 	return &value
 }
+func GDuration(name, shorthand string, value time.Duration, usage string) *time.Duration {
+	flags := RootCommand.PersistentFlags()
+	if env, ok := getenv(name); ok {
+		if v, e := time.ParseDuration(env); e == nil {
+			value = v
+		}
+	}
+	flags.DurationVarP(&value, name, shorthand, value, usage)
+	return &value
+}
 func GBool(name, shorthand string, value bool, usage string) *bool {
 	flags := RootCommand.PersistentFlags()
 	if env, ok := getenv(name); ok {
@@ -1,10 +1,12 @@
 package config
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/binary"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -25,7 +27,47 @@ func (m MachineID) ToSlice() []byte {
 	return b[:]
 }
 
+// ParseMachineID parses the hexadecimal form of a machine ID.
+func ParseMachineID(s string) (MachineID, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 16, 32)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid machine ID %q, expected 8 hexadecimal digits", s)
+	}
+	return MachineID(v), nil
+}
+
+// RandomMachineID generates a new machine ID, used to tell apart machines cloned from the same image.
+func RandomMachineID() MachineID {
+	var s [4]byte
+	if _, err := rand.Read(s[:]); err != nil {
+		panic(err)
+	}
+	s[0] |= 2
+	return MachineID(binary.LittleEndian.Uint32(s[:]))
+}
+
+// GetMachineID returns the ID of the machine, set in the configuration or derived from the hostname and
+// the host ID otherwise.
 var GetMachineID = sync.OnceValue(func() MachineID {
+	id, _ := ConfiguredMachineID(Get())
+	return id
+})
+
+// ConfiguredMachineID returns the machine ID the configuration resolves to, which the node takes on its
+// next start. An override that does not parse falls back to the host-derived ID, reported by the error.
+func ConfiguredMachineID(c *Config) (MachineID, error) {
+	if c.MachineID == "" {
+		return HostMachineID(), nil
+	}
+	id, err := ParseMachineID(c.MachineID)
+	if err != nil {
+		return HostMachineID(), fmt.Errorf("ignoring the machine-id override: %w", err)
+	}
+	return id, nil
+}
+
+// HostMachineID derives the ID of the machine from the hostname and the host ID.
+func HostMachineID() MachineID {
 	hash := sha1.New()
 	hn, _ := os.Hostname()
 	hash.Write([]byte(hn + "---pmesh"))
@@ -35,4 +77,4 @@ var GetMachineID = sync.OnceValue(func() MachineID {
 	s := hash.Sum(nil)
 	s[0] |= 2
 	return MachineID(binary.LittleEndian.Uint32(s[:4]))
-})
+}
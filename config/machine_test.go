@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestConfiguredMachineID(t *testing.T) {
+	tests := []struct {
+		override string
+		want     MachineID
+		err      bool
+	}{
+		{"", HostMachineID(), false},
+		{"0a0b0c0d", 0x0a0b0c0d, false},
+		{" 0A0B0C0D\n", 0x0a0b0c0d, false},
+		{"00000000", HostMachineID(), true},
+		{"not-hex", HostMachineID(), true},
+		{"100000000", HostMachineID(), true},
+	}
+	for _, tt := range tests {
+		got, err := ConfiguredMachineID(&Config{MachineID: tt.override})
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("%q: got %v, %v, want %v", tt.override, got, err, tt.want)
+		}
+	}
+	for range 10 {
+		id := RandomMachineID()
+		if parsed, err := ParseMachineID(id.String()); err != nil || parsed != id {
+			t.Fatalf("%v: parsed as %v, %v", id, parsed, err)
+		}
+	}
+}
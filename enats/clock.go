@@ -0,0 +1,98 @@
+package enats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/util"
+	"get.pme.sh/pmesh/xlog"
+)
+
+// ClockOptions configures the reference clock of the distributed scheduler.
+//
+// The scheduler stores the next run of a schedule as wall-clock time and every node compares it to
+// its own clock, so the nodes are assumed to be synchronized within the skew tolerance. A node only
+// fires once the next run is past by the tolerance, which keeps a node ahead of the others by less
+// than it from firing early, at the cost of delaying the runs by up to twice the tolerance. With the
+// server clock, the time of the NATS server is used instead, estimated from the timestamps
+// JetStream assigns to the writes, and only the one-way latency to it adds to the skew.
+type ClockOptions struct {
+	Skew   util.Duration `yaml:"skew,omitempty"`   // Tolerated clock difference between the nodes, defaults to 1s, capped to half of the interval
+	Server bool          `yaml:"server,omitempty"` // Use the time of the NATS server as the reference instead of the local clock
+}
+
+const (
+	defaultClockSkew  = time.Second
+	clockSyncInterval = time.Minute
+)
+
+type serverClock struct {
+	mu     sync.Mutex
+	opts   ClockOptions
+	offset time.Duration // Server time minus local time.
+	synced time.Time     // Local time of the last synchronization, zero if never.
+}
+
+// SetClock sets the reference clock of the scheduler.
+func (r *Gateway) SetClock(opts ClockOptions) {
+	r.clock.mu.Lock()
+	defer r.clock.mu.Unlock()
+	if !opts.Server {
+		r.clock.offset, r.clock.synced = 0, time.Time{}
+	}
+	r.clock.opts = opts
+}
+
+// ClockSkew returns the clock difference tolerated by the scheduler for the given interval.
+func (r *Gateway) ClockSkew(interval time.Duration) time.Duration {
+	r.clock.mu.Lock()
+	skew := r.clock.opts.Skew.Duration()
+	r.clock.mu.Unlock()
+	if skew <= 0 {
+		skew = defaultClockSkew
+	}
+	return min(skew, interval/2)
+}
+
+// Now returns the time of the reference clock of the scheduler, the local time unless configured
+// to follow the server. Falls back to the last known offset if the server cannot be reached.
+func (r *Gateway) Now(ctx context.Context) time.Time {
+	r.clock.mu.Lock()
+	defer r.clock.mu.Unlock()
+	if !r.clock.opts.Server {
+		return time.Now()
+	}
+	if time.Since(r.clock.synced) >= clockSyncInterval {
+		if offset, err := r.measureClockOffset(ctx); err != nil {
+			xlog.Warn().Err(err).Msg("Failed to synchronize with the server clock")
+		} else {
+			if d := offset - r.clock.offset; d > defaultClockSkew || d < -defaultClockSkew {
+				xlog.Info().Dur("offset", offset).Msg("Local clock differs from the server")
+			}
+			r.clock.offset = offset
+		}
+		r.clock.synced = time.Now()
+	}
+	return time.Now().Add(r.clock.offset)
+}
+
+// Measures the offset of the server clock by writing to the scheduler bucket and reading back the
+// timestamp assigned to the write, assuming it was assigned halfway through the round trip.
+func (r *Gateway) measureClockOffset(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	key := "_clock." + config.GetMachineID().String()
+	t0 := time.Now()
+	rev, err := r.SchedulerKV.Put(ctx, key, nil)
+	t1 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	entry, err := r.SchedulerKV.GetRevision(ctx, key, rev)
+	if err != nil {
+		return 0, err
+	}
+	return entry.Created().Sub(t0.Add(t1.Sub(t0) / 2)), nil
+}
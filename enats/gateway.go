@@ -3,13 +3,18 @@ package enats
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"get.pme.sh/pmesh/autonats"
 	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/util"
+	"get.pme.sh/pmesh/xlog"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
@@ -32,17 +37,74 @@ type Gateway struct {
 	DefaultKV, ResultKV jetstream.KeyValue
 
 	EventStream jetstream.Stream
+
+	quotas   atomic.Pointer[publishQuotas]
+	clock    serverClock
+	startErr error
+	tenants  map[string]*Gateway
+	limits   PublishLimits // Publish limits given to the tenants.
+	tenantMu sync.Mutex
 }
 
 const EventStreamPrefix = "ev."
 
+var ErrUnavailable = errors.New("nats is not available")
+
+// Parses a size limit, empty is zero.
+func parseLimit(s string) (int64, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	var size util.Size
+	if err := size.UnmarshalText([]byte(s)); err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// Returns true if the topology lists any server to connect to.
+func hasTopology(t map[string][]string) bool {
+	for _, hosts := range t {
+		if len(hosts) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func New() (r *Gateway) {
 	r = &Gateway{}
 
 	if config.Get().Role == config.RoleClient {
 		r.url = config.Get().Remote
 	} else {
-		r.Server = lo.Must(autonats.StartServer(autonats.Options{
+		// A node with nothing to connect to and nothing to be reached at runs embedded unless told otherwise.
+		topology := hasTopology(config.Get().Topology)
+		standalone, explicit := !topology && config.Get().Advertised == "", true
+		if config.IsSet("standalone") {
+			standalone = *config.Standalone
+		} else if cfg := config.Get().Standalone; cfg != nil {
+			standalone = *cfg
+		} else {
+			explicit = false
+		}
+		embedded := standalone && !topology
+		if explicit && standalone && topology {
+			xlog.Warn().Msg("Topology is configured, ignoring standalone mode")
+		} else if !explicit && embedded {
+			xlog.Info().Msg("No topology configured, running NATS standalone, use 'pmesh set standalone false' to accept cluster connections")
+		}
+		maxMemory, err := parseLimit(*config.JetStreamMaxMemory)
+		if err != nil {
+			r.startErr = fmt.Errorf("invalid jetstream memory limit: %w", err)
+			return
+		}
+		maxStore, err := parseLimit(*config.JetStreamMaxStore)
+		if err != nil {
+			r.startErr = fmt.Errorf("invalid jetstream storage limit: %w", err)
+			return
+		}
+		sv, err := autonats.StartServer(autonats.Options{
 			ServerName:  config.Get().Host,
 			ClusterName: config.Get().Cluster,
 			Secret:      config.Get().Secret,
@@ -52,7 +114,15 @@ func New() (r *Gateway) {
 			StoreDir:    config.NatsDir(config.Get().Host),
 			Advertise:   config.Get().Advertised,
 			Topology:    config.Get().Topology,
-		}))
+			Embedded:    embedded,
+			MaxMemory:   maxMemory,
+			MaxStore:    maxStore,
+		})
+		if err != nil {
+			r.startErr = fmt.Errorf("failed to start server: %w", err)
+			return
+		}
+		r.Server = sv
 		r.url = r.Server.ClientURL()
 	}
 
@@ -60,6 +130,9 @@ func New() (r *Gateway) {
 	return
 }
 func (r *Gateway) Open(ctx context.Context) (err error) {
+	if r.startErr != nil {
+		return r.startErr
+	}
 	if r.Server == nil {
 		if strings.HasPrefix(r.url, "nats://") {
 			r.Client.Conn, err = nats.Connect(r.url)
@@ -88,18 +161,21 @@ func (r *Gateway) Open(ctx context.Context) (err error) {
 		r.Client.Close()
 		return
 	}
+	r.PeerKV, err = r.KVStore(ctx, jetstream.KeyValueConfig{
+		Bucket:       "peers",
+		Description:  "PMesh peer discovery",
+		MaxValueSize: -1,
+		Storage:      jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return
+	}
+	return r.createResources(ctx)
+}
 
-	// Create the resources.
+// Creates the resources shared by the default account and the tenants.
+func (r *Gateway) createResources(ctx context.Context) (err error) {
 	{
-		r.PeerKV, err = r.KVStore(ctx, jetstream.KeyValueConfig{
-			Bucket:       "peers",
-			Description:  "PMesh peer discovery",
-			MaxValueSize: -1,
-			Storage:      jetstream.MemoryStorage,
-		})
-		if err != nil {
-			return
-		}
 		r.SchedulerKV, err = r.KVStore(ctx, jetstream.KeyValueConfig{
 			Bucket:       "sched",
 			Description:  "PMesh scheduler locks",
@@ -145,9 +221,95 @@ func (r *Gateway) Open(ctx context.Context) (err error) {
 		}
 	}
 	return nil
+}
+
+// SetEventQuota limits the storage of the event stream, the oldest events are discarded past it.
+func (r *Gateway) SetEventQuota(ctx context.Context, maxBytes int64) error {
+	cfg := r.EventStream.CachedInfo().Config
+	if maxBytes <= 0 {
+		maxBytes = -1
+	}
+	if cfg.MaxBytes == maxBytes {
+		return nil
+	}
+	cfg.MaxBytes = maxBytes
+	stream, err := r.Stream(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	r.EventStream = stream
+	return nil
+}
+
+// Available returns true if the gateway is connected.
+func (r *Gateway) Available() bool {
+	return r.Client.Conn != nil
+}
+
+// Tenant returns the gateway of the tenant's isolated account, provisioning it on first use.
+func (r *Gateway) Tenant(ctx context.Context, name string) (*Gateway, error) {
+	r.tenantMu.Lock()
+	defer r.tenantMu.Unlock()
+	if t, ok := r.tenants[name]; ok {
+		return t, nil
+	}
+	if r.Server == nil {
+		return nil, errors.New("tenants require a local nats server")
+	}
+	if err := r.Server.ProvisionTenant(name); err != nil {
+		return nil, err
+	}
 
+	t := &Gateway{url: r.url}
+	t.setPublishLimits(r.limits)
+	conn, err := r.Server.ConnectTenant(name)
+	if err != nil {
+		return nil, err
+	}
+	t.Client.Conn = conn
+	if t.Client.Jet, err = jetstream.New(conn); err == nil {
+		err = t.createResources(ctx)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open tenant %q: %w", name, err)
+	}
+	if r.tenants == nil {
+		r.tenants = make(map[string]*Gateway)
+	}
+	r.tenants[name] = t
+	return t, nil
+}
+
+// Tenants returns the names of the tenants opened.
+func (r *Gateway) Tenants() []string {
+	r.tenantMu.Lock()
+	defer r.tenantMu.Unlock()
+	return lo.Keys(r.tenants)
 }
+
+// RemoveTenant closes the gateway of the tenant and deprovisions its account, see
+// autonats.Server.DeprovisionTenant.
+func (r *Gateway) RemoveTenant(ctx context.Context, name string) (err error) {
+	r.tenantMu.Lock()
+	defer r.tenantMu.Unlock()
+	if t, ok := r.tenants[name]; ok {
+		err = t.Close(ctx)
+		delete(r.tenants, name)
+	}
+	if r.Server != nil {
+		err = errors.Join(err, r.Server.DeprovisionTenant(name))
+	}
+	return
+}
+
 func (r *Gateway) Close(ctx context.Context) (err error) {
+	r.tenantMu.Lock()
+	for name, t := range r.tenants {
+		err = errors.Join(err, t.Close(ctx))
+		delete(r.tenants, name)
+	}
+	r.tenantMu.Unlock()
 	if cli := r.Client; cli.Conn != nil {
 		r.Client.Conn = nil
 		select {
@@ -158,6 +320,7 @@ func (r *Gateway) Close(ctx context.Context) (err error) {
 	}
 	if r.Server != nil {
 		err = errors.Join(err, r.Server.Shutdown(ctx))
+		r.Server = nil
 	}
 	return
 }
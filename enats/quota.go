@@ -0,0 +1,131 @@
+package enats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"get.pme.sh/pmesh/rate"
+	"get.pme.sh/pmesh/util"
+
+	"github.com/nats-io/nats.go"
+)
+
+// PublishLimits maps a topic pattern to the rate messages may be published at, e.g.
+//
+//	jobs.: 100/s burst=20
+//
+// Patterns are topics as used by runners, the first matching pattern applies and its
+// quota is shared by all the subjects it matches.
+type PublishLimits = util.OrderedMap[string, rate.Limit]
+
+var ErrPublishLimit = errors.New("publish rate limit exceeded")
+
+type publishQuota struct {
+	pattern string
+	tokens  []string
+	limiter rate.Limiter
+}
+
+// Matches the subject against the pattern, following the NATS wildcard rules.
+func (q *publishQuota) match(subject string) bool {
+	tokens := strings.Split(subject, ".")
+	for i, t := range q.tokens {
+		switch {
+		case t == ">":
+			return len(tokens) > i
+		case i >= len(tokens):
+			return false
+		case t != "*" && t != tokens[i]:
+			return false
+		}
+	}
+	return len(tokens) == len(q.tokens)
+}
+
+type publishQuotas []*publishQuota
+
+// SetPublishLimits replaces the publish limits, counters are kept for the patterns with the same limit.
+// The limits apply to the tenants as well, each with its own counters.
+func (r *Gateway) SetPublishLimits(limits PublishLimits) {
+	r.setPublishLimits(limits)
+	r.tenantMu.Lock()
+	defer r.tenantMu.Unlock()
+	r.limits = limits
+	for _, t := range r.tenants {
+		t.setPublishLimits(limits)
+	}
+}
+func (r *Gateway) setPublishLimits(limits PublishLimits) {
+	var prev publishQuotas
+	if p := r.quotas.Load(); p != nil {
+		prev = *p
+	}
+
+	quotas := make(publishQuotas, 0, len(limits))
+	for _, kv := range limits {
+		if kv.B.IsZero() {
+			continue
+		}
+		subject := ToSubject(kv.A)
+		q := &publishQuota{
+			pattern: subject,
+			tokens:  strings.Split(subject, "."),
+			limiter: rate.LocalLimiter(kv.B.Options),
+		}
+		for _, p := range prev {
+			if p.pattern == subject && p.limiter.Options == kv.B.Options {
+				q.limiter.Counter = p.limiter.Counter
+				break
+			}
+		}
+		quotas = append(quotas, q)
+	}
+	r.quotas.Store(&quotas)
+}
+
+// CheckPublish consumes a message from the quota of the subject, waiting for the burst
+// queue if configured, and returns ErrPublishLimit if it is exceeded.
+func (r *Gateway) CheckPublish(ctx context.Context, subject string) error {
+	p := r.quotas.Load()
+	if p == nil {
+		return nil
+	}
+	for _, q := range *p {
+		if !q.match(subject) {
+			continue
+		}
+		if err := q.limiter.Enforce(ctx); err != nil {
+			return fmt.Errorf("%w for %q (%s): %w", ErrPublishLimit, subject, q.limiter.Rate, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// Publish publishes the message after checking the quota of the subject.
+func (r *Gateway) Publish(subject string, data []byte) error {
+	if !r.Available() {
+		return ErrUnavailable
+	}
+	if err := r.CheckPublish(context.Background(), subject); err != nil {
+		return err
+	}
+	return r.Client.Publish(subject, data)
+}
+
+// RequestMsg sends the request after checking the quota of the subject.
+func (r *Gateway) RequestMsg(msg *nats.Msg, timeout time.Duration) (*nats.Msg, error) {
+	if !r.Available() {
+		return nil, ErrUnavailable
+	}
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := r.CheckPublish(ctx, msg.Subject); err != nil {
+		return nil, err
+	}
+	return r.Client.RequestMsg(msg, time.Until(deadline))
+}
@@ -0,0 +1,91 @@
+package enats
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"get.pme.sh/pmesh/retry"
+	"get.pme.sh/pmesh/util"
+	"get.pme.sh/pmesh/xlog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Server side error codes returned while a RAFT group has no leader.
+const (
+	jsErrCodeClusterNotAvail  jetstream.ErrorCode = 10008
+	jsErrCodeClusterNotLeader jetstream.ErrorCode = 10009
+	jsErrCodeStreamOffline    jetstream.ErrorCode = 10118
+)
+
+// IsTransient returns true if the error is caused by a transient unavailability of JetStream,
+// such as a RAFT leader election, after which the operation may succeed.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, jetstream.ErrNoStreamResponse) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *jetstream.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode {
+		case jsErrCodeClusterNotAvail, jsErrCodeClusterNotLeader, jsErrCodeStreamOffline:
+			return true
+		}
+	}
+	return false
+}
+
+// Policy of the retries over a leaderless window, elections normally settle within a few seconds.
+var leaderRetryPolicy = retry.Policy{
+	Attempts: 6,
+	Backoff:  util.Duration(250 * time.Millisecond),
+	Timeout:  util.Duration(15 * time.Second),
+}
+
+// Number of operations retried after a transient JetStream failure.
+var transientRetries atomic.Uint64
+
+// TransientRetries returns the number of operations retried after a transient JetStream failure.
+func TransientRetries() uint64 { return transientRetries.Load() }
+
+// RetryTransient runs the operation, retrying it while it fails with a transient error. Other errors are
+// returned as is so the caller can still compare them against the sentinels.
+func RetryTransient(ctx context.Context, op string, f func(ctx context.Context) error) error {
+	attempt := 0
+	var final error
+	err := leaderRetryPolicy.RunContext(ctx, func() error {
+		err := f(ctx)
+		if !IsTransient(err) {
+			final = err
+			if retry.Retryable(err) {
+				return retry.Disable(err)
+			}
+			return err
+		}
+		attempt++
+		transientRetries.Add(1)
+		xlog.Debug().Err(err).Str("op", op).Int("attempt", attempt).Msg("JetStream unavailable, retrying")
+		return err
+	})
+	if final != nil {
+		return final
+	}
+	return err
+}
+
+// RetryTransientValue is RetryTransient for an operation returning a value.
+func RetryTransientValue[T any](ctx context.Context, op string, f func(ctx context.Context) (T, error)) (res T, err error) {
+	err = RetryTransient(ctx, op, func(ctx context.Context) (e error) {
+		res, e = f(ctx)
+		return
+	})
+	return
+}
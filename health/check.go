@@ -2,6 +2,7 @@ package health
 
 import (
 	"context"
+	"fmt"
 
 	"get.pme.sh/pmesh/variant"
 
@@ -26,3 +27,18 @@ func (t *Checker) UnmarshalYAML(node *yaml.Node) (e error) {
 	t.checker, e = Registry.Unmarshal(node)
 	return
 }
+func (t Checker) MarshalYAML() (any, error) {
+	if t.checker == nil {
+		return nil, nil
+	}
+	tag, ok := Registry.TagOf(t.checker)
+	if !ok {
+		return nil, fmt.Errorf("unregistered check type %T", t.checker)
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(t.checker); err != nil {
+		return nil, err
+	}
+	node.Tag = "!" + tag
+	return node, nil
+}
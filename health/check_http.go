@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"time"
@@ -61,6 +62,18 @@ func (t *HttpCheck) Perform(ctx context.Context, addr string) error {
 	if method == "" {
 		method = "GET"
 	}
+	// New connections report their connect latency, reused ones are not measured.
+	if d := DialerFromContext(ctx); d != nil {
+		var t0 time.Time
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			ConnectStart: func(_, _ string) { t0 = time.Now() },
+			ConnectDone: func(_, _ string, err error) {
+				if err == nil && !t0.IsZero() {
+					d.RecordLatency(time.Since(t0))
+				}
+			},
+		})
+	}
 	req, err := http.NewRequestWithContext(ctx, method, url.String(), nil)
 	if err != nil {
 		return err
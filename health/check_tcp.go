@@ -18,6 +18,15 @@ func (t *TcpCheck) UnmarshalInline(text string) error {
 }
 
 func (t *TcpCheck) Perform(ctx context.Context, addr string) error {
+	if d := DialerFromContext(ctx); d != nil {
+		conn, err := d.Dial(ctx, addr)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	}
+
 	var dialer netx.LocalDialer
 	dialer.Timeout = 15 * time.Second
 	if deadline, ok := ctx.Deadline(); ok {
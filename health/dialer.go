@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/netx"
+)
+
+// LatencyObserver is implemented by the observers interested in the connect latency measured by the checks.
+type LatencyObserver interface {
+	ObserveLatency(d time.Duration)
+}
+
+type observerWithLatency struct {
+	Observer
+	LatencyObserver
+}
+
+// WithLatency returns an observer forwarding the health to o and the connect latency to l.
+func WithLatency(o Observer, l LatencyObserver) Observer {
+	return observerWithLatency{o, l}
+}
+
+// Dialer connects the checks of a monitor to the upstream, recording the connect latency and
+// optionally keeping the connection open between the checks.
+type Dialer struct {
+	Reuse bool
+
+	mu      sync.Mutex
+	conn    net.Conn
+	latency time.Duration // Last sample not yet taken, zero if none.
+}
+
+type dialerKey struct{}
+
+func WithDialer(ctx context.Context, d *Dialer) context.Context {
+	return context.WithValue(ctx, dialerKey{}, d)
+}
+func DialerFromContext(ctx context.Context) *Dialer {
+	d, _ := ctx.Value(dialerKey{}).(*Dialer)
+	return d
+}
+
+// Records a connect latency sample.
+func (d *Dialer) RecordLatency(latency time.Duration) {
+	d.mu.Lock()
+	d.latency = latency
+	d.mu.Unlock()
+}
+
+// Take returns the latency recorded since the last call, if any.
+func (d *Dialer) Take() (latency time.Duration, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	latency, d.latency = d.latency, 0
+	return latency, latency != 0
+}
+
+// Returns true if the idle connection was not closed by the peer.
+func connAlive(conn net.Conn) bool {
+	var buf [1]byte
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	_, err := conn.Read(buf[:])
+	return err == nil || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+type sharedConn struct {
+	net.Conn
+}
+
+func (sharedConn) Close() error { return nil }
+
+// Dial returns a connection to the address, closing it releases it to the dialer if reused.
+func (d *Dialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		if connAlive(d.conn) {
+			return sharedConn{d.conn}, nil
+		}
+		d.conn.Close()
+		d.conn = nil
+	}
+
+	var dialer netx.LocalDialer
+	dialer.Timeout = 15 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Timeout = min(dialer.Timeout, time.Until(deadline))
+	}
+	t0 := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	d.latency = time.Since(t0)
+	if d.Reuse {
+		d.conn = conn
+		return sharedConn{conn}, nil
+	}
+	return conn, nil
+}
+
+// Close closes the reused connection, if any.
+func (d *Dialer) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
@@ -26,6 +26,7 @@ type Monitor struct {
 	Healthy   MonitorLoop        `yaml:"healthy"`   // The loop for healthy checks.
 	Unhealthy MonitorLoop        `yaml:"unhealthy"` // The loop for unhealthy checks.
 	Timeout   util.Duration      `yaml:"timeout"`   // The timeout for each check.
+	Reuse     bool               `yaml:"reuse"`     // Keep the connection of the TCP checks open between the checks.
 	Checks    map[string]Checker `yaml:"test"`      // The checks to perform.
 }
 
@@ -114,6 +115,12 @@ func (m *Monitor) observe(ctx context.Context, logger *xlog.Logger, address stri
 		}
 	}
 
+	// Share a dialer between the checks to measure the connect latency.
+	dialer := &Dialer{Reuse: m.Reuse}
+	defer dialer.Close()
+	ctx = WithDialer(ctx, dialer)
+	latencyObserver, _ := observer.(LatencyObserver)
+
 	// Get the adjustedthresholds and intervals
 	lunhealthy := m.Unhealthy.Or(5*time.Second, 3)
 	lhealthy := m.Healthy.Or(5*lunhealthy.Interval.Duration(), 1)
@@ -133,6 +140,9 @@ func (m *Monitor) observe(ctx context.Context, logger *xlog.Logger, address stri
 		if ctx.Err() != nil {
 			return
 		}
+		if latency, ok := dialer.Take(); ok && latencyObserver != nil {
+			latencyObserver.ObserveLatency(latency)
+		}
 
 		// Update the health state
 		newHealthy := Unknown
@@ -1,13 +1,51 @@
 package hosts
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
 	"strings"
 )
 
+// WildcardPrefix marks a pattern that matches any subdomain of the root, but not the root itself.
+const WildcardPrefix = "*."
+
+var ErrInvalidWildcard = errors.New("wildcard is only allowed as the first label")
+
+// Validates a hostname pattern.
+func ValidatePattern(pattern string) error {
+	if strings.Contains(strings.TrimPrefix(pattern, WildcardPrefix), "*") {
+		return fmt.Errorf("%q: %w", pattern, ErrInvalidWildcard)
+	}
+	return nil
+}
+
+// Compares hostname patterns by specificity, more specific patterns come first.
+// Patterns with more labels are more specific, exact patterns are more specific than wildcards with the same number of labels.
+func Compare(a, b string) int {
+	if c := cmp.Compare(strings.Count(b, "."), strings.Count(a, ".")); c != 0 {
+		return c
+	}
+	wa, wb := strings.HasPrefix(a, WildcardPrefix), strings.HasPrefix(b, WildcardPrefix)
+	if wa != wb {
+		if wa {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
 // google.com, www.google.com -> www., true
 // google.com, google.com -> "", true
 // google.com, yahoo.com -> "", false
+// *.google.com, www.google.com -> www., true
+// *.google.com, google.com -> "", false
 func Match(root, needle string) (sub string, ok bool) {
+	if base, wildcard := strings.CutPrefix(root, WildcardPrefix); wildcard {
+		sub, ok = Match(base, needle)
+		return sub, ok && sub != ""
+	}
 	at := len(needle) - len(root)
 	if at >= 0 && needle[at:] == root {
 		if at == 0 {
@@ -47,6 +85,13 @@ func MatchMap[V any](m map[string]V, needle string) (root, sub string, val V) {
 	}
 	Range(needle, func(k, s string) bool {
 		var ok bool
+		// Exact match beats the wildcard, which beats the implicit subdomain match.
+		if s != "" {
+			if val, ok = m[WildcardPrefix+k]; ok {
+				root, sub = WildcardPrefix+k, s
+				return false
+			}
+		}
 		if val, ok = m[k]; ok {
 			root, sub = k, s
 			return false
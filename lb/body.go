@@ -0,0 +1,69 @@
+package lb
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Buffers the body of the request so that it can be replayed on retry, if it is no larger than
+// BufferBody. The requests expecting 100-continue are left as is, as reading their body would
+// make the client send it before the app accepts it. Returns nil if not buffered.
+func (lb *LoadBalancer) bufferBody(r *http.Request) []byte {
+	limit := int64(lb.BufferBody)
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody || r.ContentLength > limit {
+		return nil
+	}
+	// The trailers of the requests are only sent with a chunked body.
+	if !lb.isRetriableMethod(r) || r.Header.Get("Expect") != "" || len(r.Trailer) != 0 {
+		return nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(buf)) > limit {
+		// Too large or failed, pass on what was read followed by the rest.
+		r.Body = multiReadCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil
+	}
+	r.Body.Close()
+	r.ContentLength, r.TransferEncoding = int64(len(buf)), nil
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return buf
+}
+
+// Copies the trailers received at the end of the body to the outgoing request.
+type trailerBody struct {
+	io.ReadCloser
+	src, dst http.Header
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		for k, v := range b.src {
+			b.dst[k] = v
+		}
+	}
+	return n, err
+}
+
+// The proxy clones the trailers of the request before they are received, leaving their values
+// empty, forwards them once the body is read instead.
+func forwardRequestTrailers(out *http.Request) {
+	if len(out.Trailer) == 0 || out.Body == nil {
+		return
+	}
+	ctx, ok := out.Context().Value(requestContextKey{}).(*requestContext)
+	if !ok || ctx.Request == nil {
+		return
+	}
+	out.Body = &trailerBody{ReadCloser: out.Body, src: ctx.Request.Trailer, dst: out.Trailer}
+}
@@ -0,0 +1,131 @@
+package lb
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/retry"
+	"get.pme.sh/pmesh/util"
+)
+
+func TestTrailerBody(t *testing.T) {
+	src, dst := http.Header{}, http.Header{"X-Checksum": nil}
+	b := &trailerBody{ReadCloser: io.NopCloser(strings.NewReader("data")), src: src, dst: dst}
+	src.Set("X-Checksum", "abc") // Received along with the end of the body.
+	if data, err := io.ReadAll(b); err != nil || string(data) != "data" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+	if got := dst.Get("X-Checksum"); got != "abc" {
+		t.Errorf("trailer %q, want %q", got, "abc")
+	}
+}
+
+// Echoes the request trailer, answering with a declared and an undeclared trailer like gRPC.
+var trailerApp = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.Header().Set("Trailer", "Grpc-Status")
+	io.WriteString(w, r.Trailer.Get("X-Checksum"))
+	w.Header().Set("Grpc-Status", "0")
+	w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+})
+
+func TestTrailersProxied(t *testing.T) {
+	for _, buffer := range []bool{false, true} {
+		lb, front := testProxy(t, trailerApp)
+		if buffer {
+			lb.BufferBody, lb.RetryIdempotent = 1<<20, true
+		}
+
+		// A body of unknown length is chunked, carrying the trailers.
+		req, err := http.NewRequest(http.MethodPost, front.URL, io.MultiReader(strings.NewReader("data")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Trailer = http.Header{"X-Checksum": {"abc"}}
+		req.Header.Set("Idempotency-Key", "1") // Retriable, buffered if enabled.
+		res, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "abc" {
+			t.Errorf("buffer=%v: app got request trailer %q, want %q", buffer, body, "abc")
+		}
+		if got := res.Trailer.Get("Grpc-Status"); got != "0" {
+			t.Errorf("buffer=%v: Grpc-Status trailer %q, want %q", buffer, got, "0")
+		}
+		if got := res.Trailer.Get("Grpc-Message"); got != "ok" {
+			t.Errorf("buffer=%v: Grpc-Message trailer %q, want %q", buffer, got, "ok")
+		}
+	}
+}
+
+func TestBufferedBodyRetried(t *testing.T) {
+	body := strings.Repeat("0123456789", 1000)
+	tests := []struct {
+		name    string
+		limit   util.Size
+		chunked bool
+		calls   int32 // Attempts expected
+		status  int
+	}{
+		{"buffered", 1 << 20, false, 2, http.StatusOK},
+		{"buffered chunked", 1 << 20, true, 2, http.StatusOK},
+		{"exact limit", util.Size(len(body)), false, 2, http.StatusOK},
+		{"too large", util.Size(len(body) - 1), false, 1, http.StatusServiceUnavailable},
+		{"too large chunked", util.Size(len(body) - 1), true, 1, http.StatusServiceUnavailable},
+		{"not buffered", 0, false, 1, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			lb, front := testProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					// Fails halfway through the body.
+					io.CopyN(io.Discard, r.Body, int64(len(body)/2))
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				b, _ := io.ReadAll(r.Body) // Not io.Copy, reading after the response started fails.
+				w.Write(b)
+			}))
+			lb.BufferBody, lb.RetryIdempotent = tt.limit, true
+			lb.Retry = retry.Policy{Attempts: 3, Backoff: util.Duration(time.Millisecond), Timeout: util.Duration(time.Second)}
+
+			var rd io.Reader = strings.NewReader(body)
+			if tt.chunked {
+				rd = io.MultiReader(rd) // Unknown length.
+			}
+			req, err := http.NewRequest(http.MethodPut, front.URL, rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := front.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", res.StatusCode, tt.status)
+			}
+			if n := calls.Load(); n != tt.calls {
+				t.Errorf("app called %d times, want %d", n, tt.calls)
+			}
+			if tt.status == http.StatusOK && string(got) != body {
+				t.Errorf("retry got %d bytes of the body, want %d", len(got), len(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package lb
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Bucketing of the latency histograms: the bounds grow by 2^(1/4) from 50µs, covering up to ~3
+// minutes with a relative error under 10%.
+const (
+	latencyMin     = 50 * time.Microsecond
+	latencyBuckets = 90
+	latencyWindow  = time.Minute
+)
+
+var latencyGrowth = math.Log(math.Pow(2, 0.25))
+
+// Returns the bucket of the duration, bucket 0 being everything under the minimum.
+func latencyBucket(d time.Duration) int {
+	if d < latencyMin {
+		return 0
+	}
+	i := int(math.Log(float64(d)/float64(latencyMin))/latencyGrowth) + 1
+	return min(i, latencyBuckets-1)
+}
+
+// Returns the geometric middle of the bucket.
+func latencyMid(i int) time.Duration {
+	if i == 0 {
+		return latencyMin / 2
+	}
+	return time.Duration(float64(latencyMin) * math.Exp((float64(i)-0.5)*latencyGrowth))
+}
+
+// LatencyHistogram is a bucketed histogram of the latencies observed over the last one to two
+// minutes, cheap enough to be updated on every request.
+type LatencyHistogram struct {
+	windows [2][latencyBuckets]atomic.Uint64
+	current atomic.Int32
+	rotated atomic.Int64 // Time of the last rotation of the windows, in nanoseconds.
+}
+
+// Rotates the windows if the current one is older than the window duration, both are cleared if
+// it is older than two so that no sample outlives them.
+func (h *LatencyHistogram) rotate(now int64) int {
+	cur := int(h.current.Load())
+	last := h.rotated.Load()
+	if now-last < int64(latencyWindow) || !h.rotated.CompareAndSwap(last, now) {
+		return cur
+	}
+	next := 1 - cur
+	for i := range h.windows[next] {
+		h.windows[next][i].Store(0)
+	}
+	if now-last >= 2*int64(latencyWindow) {
+		for i := range h.windows[cur] {
+			h.windows[cur][i].Store(0)
+		}
+	}
+	h.current.Store(int32(next))
+	return next
+}
+
+// Observe records a latency sample.
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	cur := h.rotate(time.Now().UnixNano())
+	h.windows[cur][latencyBucket(d)].Add(1)
+}
+
+// LatencyMetrics is a summary of a latency histogram, in milliseconds.
+type LatencyMetrics struct {
+	Count uint64  `json:"count,omitempty"` // Number of samples.
+	P50   float64 `json:"p50,omitempty"`
+	P95   float64 `json:"p95,omitempty"`
+	P99   float64 `json:"p99,omitempty"`
+}
+
+// Metrics returns the percentiles of the recent samples.
+func (h *LatencyHistogram) Metrics() (m LatencyMetrics) {
+	h.rotate(time.Now().UnixNano())
+	var counts [latencyBuckets]uint64
+	for w := range h.windows {
+		for i := range h.windows[w] {
+			n := h.windows[w][i].Load()
+			counts[i] += n
+			m.Count += n
+		}
+	}
+	if m.Count == 0 {
+		return
+	}
+	percentile := func(p float64) float64 {
+		rank := uint64(math.Ceil(p * float64(m.Count)))
+		var seen uint64
+		for i, n := range counts {
+			if seen += n; seen >= rank {
+				return float64(latencyMid(i)) / float64(time.Millisecond)
+			}
+		}
+		return float64(latencyMid(latencyBuckets-1)) / float64(time.Millisecond)
+	}
+	m.P50, m.P95, m.P99 = percentile(0.50), percentile(0.95), percentile(0.99)
+	return
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"get.pme.sh/pmesh/retry"
 	"get.pme.sh/pmesh/vhttp"
@@ -21,6 +22,24 @@ type requestContext struct {
 	Upstream     *Upstream
 	Retrier      retry.Retrier
 	Session      *vhttp.ClientSession
+	Timing       *vhttp.RequestTiming
+	sent         time.Time
+	body         []byte // Buffered request body, replayed on each attempt.
+}
+
+// Records the time spent on the current upstream in the latency histograms and the timing if
+// enabled.
+func (ctx *requestContext) recordUpstream() {
+	if ctx.sent.IsZero() {
+		return
+	}
+	d := time.Since(ctx.sent)
+	ctx.sent = time.Time{}
+	ctx.Upstream.Latency.Observe(d)
+	ctx.LoadBalancer.latency.Observe(d)
+	if ctx.Timing != nil {
+		ctx.Timing.RecordUpstream(ctx.Upstream.String(), d)
+	}
 }
 
 type requestContextKey struct{}
@@ -36,7 +55,15 @@ func (ctx *requestContext) stickyLoad() *Upstream {
 }
 func (ctx *requestContext) stickyCompareAndSwap(old, new *Upstream) bool {
 	key := stickySessionKey{ctx.LoadBalancer}
-	return ctx.Session.Values.CompareAndSwap(key, old, new)
+	// A nil *Upstream is not a nil interface, convert it so that it stands for no value.
+	var o, n any
+	if old != nil {
+		o = old
+	}
+	if new != nil {
+		n = new
+	}
+	return ctx.Session.Values.CompareAndSwap(key, o, n)
 }
 
 type LoadBalancer struct {
@@ -45,10 +72,16 @@ type LoadBalancer struct {
 	upstreams []*Upstream
 	mu        sync.RWMutex
 	counter   atomic.Uint32
+	latency   LatencyHistogram
+	bytesIn   atomic.Uint64
+	bytesOut  atomic.Uint64
 }
 
 type LoadBalancerMetrics struct {
 	Upstreams []UpstreamMetrics `json:"upstreams,omitempty"`
+	Latency   LatencyMetrics    `json:"latency"`   // Over all the upstreams.
+	BytesIn   uint64            `json:"bytes_in"`  // Bytes of request bodies sent to the upstreams.
+	BytesOut  uint64            `json:"bytes_out"` // Bytes of response bodies received from the upstreams.
 }
 
 func (lb *LoadBalancer) Healthy() bool {
@@ -70,6 +103,9 @@ func (lb *LoadBalancer) Metrics() LoadBalancerMetrics {
 	}
 	return LoadBalancerMetrics{
 		Upstreams: upstreams,
+		Latency:   lb.latency.Metrics(),
+		BytesIn:   lb.bytesIn.Load(),
+		BytesOut:  lb.bytesOut.Load(),
 	}
 }
 
@@ -166,7 +202,7 @@ func (lb *LoadBalancer) NextUpstream(ctx *requestContext) (result *Upstream, err
 	// If least-conn:
 	if strat == StrategyLeastConn {
 		result = lb.upstreams[0]
-		best := result.LoadFactor.Load()
+		best := result.weightedLoad()
 		if !result.Healthy.Load() {
 			best = 0x7fffffff
 		}
@@ -174,7 +210,7 @@ func (lb *LoadBalancer) NextUpstream(ctx *requestContext) (result *Upstream, err
 			if !upstream.Healthy.Load() {
 				continue
 			}
-			conns := upstream.LoadFactor.Load()
+			conns := upstream.weightedLoad()
 			if conns < best {
 				result, best = upstream, conns
 			}
@@ -199,8 +235,26 @@ func (lb *LoadBalancer) NextUpstream(ctx *requestContext) (result *Upstream, err
 		return first, nil
 	}
 
-	// Pick the entry given the entropy.
+	// Pick the entry given the entropy, if it has a reduced share of the traffic, only keep it
+	// proportionally to its weight.
 	result = lb.getHealthyIdxLocked(entropy%healthyN, bad)
+	if w := result.Weight.Load(); w < 100 && rand.Int31n(100) >= w {
+		result = lb.getFullWeightLocked(result)
+	}
+	return
+}
+
+// Returns a random healthy upstream with the full share of the traffic, or the fallback if none.
+func (lb *LoadBalancer) getFullWeightLocked(fallback *Upstream) (res *Upstream) {
+	res = fallback
+	n := 0
+	for _, u := range lb.upstreams {
+		if u.Healthy.Load() && u.Weight.Load() >= 100 {
+			if n++; rand.Intn(n) == 0 {
+				res = u
+			}
+		}
+	}
 	return
 }
 func (lb *LoadBalancer) PickUpstream(ctx *requestContext) (result *Upstream, err error) {
@@ -245,13 +299,12 @@ func (h lbRetryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (lb *LoadBalancer) OnErrorResponse(ctx *requestContext, r *http.Response) http.Handler {
 	// Determine traits.
-	retriable := false
+	retriable := lb.IsRetriable(ctx.Request, r.StatusCode)
 	var opt *ErrorOptions
 	switch {
 	// 5xx
 	case 500 <= r.StatusCode && r.StatusCode <= 599:
 		opt = lb.Error5xx
-		retriable = ctx.Request.Method == http.MethodGet
 	// 4xx
 	case r.StatusCode == 404:
 		opt = lb.Error404
@@ -288,8 +341,9 @@ func (lb *LoadBalancer) OnErrorResponse(ctx *requestContext, r *http.Response) h
 		}
 	}
 
-	// If bad request, we will never retry and it's not worth logging since it's the client's fault.
-	if 400 <= r.StatusCode && r.StatusCode <= 499 {
+	// If bad request and not explicitly retriable (e.g. 429), we will never retry and it's not worth
+	// logging since it's the client's fault.
+	if !retriable && 400 <= r.StatusCode && r.StatusCode <= 499 {
 		return nil
 	}
 
@@ -300,7 +354,7 @@ func (lb *LoadBalancer) OnErrorResponse(ctx *requestContext, r *http.Response) h
 			// Log and retry.
 			lb.getLogger().Warn().Str("status", r.Status).Stringer("upstream", ctx.Upstream).Msg("retriable server error")
 			return lbRetryHandler{ctx}
-		} else {
+		} else if ctx.Request.Context().Err() == nil {
 			// Log.
 			lb.getLogger().Error().Str("status", r.Status).Err(retryError).Stringer("upstream", ctx.Upstream).Msg("fatal server error")
 		}
@@ -317,6 +371,9 @@ func (lb *LoadBalancer) OnError(ctx *requestContext, w http.ResponseWriter, r *h
 		lb.getLogger().Warn().Err(err).Stringer("upstream", ctx.Upstream).Msg("retriable upstream error")
 		lb.serveHTTP(ctx, w, ctx.Request)
 		return
+	} else if r.Context().Err() != nil {
+		// Client went away while we were backing off, nobody to respond to.
+		return
 	} else {
 		lb.getLogger().Error().Err(err).Stringer("upstream", ctx.Upstream).Msg("fatal upstream error")
 	}
@@ -324,11 +381,15 @@ func (lb *LoadBalancer) OnError(ctx *requestContext, w http.ResponseWriter, r *h
 }
 
 func (lb *LoadBalancer) serveHTTP(ctx *requestContext, w http.ResponseWriter, r *http.Request) {
+	if ctx.body != nil {
+		r.Body, _ = r.GetBody()
+	}
 	us, err := lb.PickUpstream(ctx)
 	if err != nil {
 		lb.OnError(ctx, w, r, err)
 	} else {
 		ctx.Upstream = us
+		ctx.sent = time.Now()
 		us.ServeHTTP(w, r)
 	}
 }
@@ -339,7 +400,9 @@ func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ctx.Request = nil
 		ctx.Upstream = nil
 		ctx.Session = nil
+		ctx.Timing = nil
 		ctx.LoadBalancer = nil
+		ctx.body = nil
 	}()
 
 	cctx := context.WithValue(r.Context(), requestContextKey{}, ctx)
@@ -347,7 +410,9 @@ func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx.LoadBalancer = lb
 	ctx.Retrier = lb.Retry.RetrierContext(cctx)
 	ctx.Session = vhttp.ClientSessionFromContext(cctx)
+	ctx.Timing = vhttp.RequestTimingFromContext(cctx)
 	ctx.Upstream = nil
 	ctx.Request = r
+	ctx.body = lb.bufferBody(r)
 	lb.serveHTTP(ctx, w, r)
 }
@@ -0,0 +1,50 @@
+package lb
+
+import (
+	"testing"
+
+	"get.pme.sh/pmesh/vhttp"
+)
+
+func TestStickyUpstream(t *testing.T) {
+	lb := &LoadBalancer{Options: Options{State: StateSticky, Strategy: StrategyRoundRobin}}
+	a, b := NewHttpUpstream("127.0.0.1:1"), NewHttpUpstream("127.0.0.1:2")
+	lb.AddUpstream(a)
+	lb.AddUpstream(b)
+	ctx := &requestContext{LoadBalancer: lb, Session: &vhttp.ClientSession{}}
+
+	first, err := lb.PickUpstream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.stickyLoad() != first {
+		t.Fatal("upstream not stored in the session")
+	}
+	for range 4 {
+		if us, _ := lb.PickUpstream(ctx); us != first {
+			t.Fatalf("picked %v, want the sticky %v", us, first)
+		}
+	}
+
+	// Moves to the other upstream once the sticky one is unhealthy, and sticks to it.
+	first.Healthy.Store(false)
+	other, _ := lb.PickUpstream(ctx)
+	if other == first || other == nil {
+		t.Fatalf("picked %v after %v went unhealthy", other, first)
+	}
+	if ctx.stickyLoad() != other {
+		t.Error("session not moved to the healthy upstream")
+	}
+	first.Healthy.Store(true)
+	if us, _ := lb.PickUpstream(ctx); us != other {
+		t.Errorf("picked %v, want the sticky %v", us, other)
+	}
+
+	// Cleared without a value to store.
+	if !ctx.stickyCompareAndSwap(other, nil) || ctx.stickyLoad() != nil {
+		t.Error("sticky upstream not cleared")
+	}
+	if !ctx.stickyCompareAndSwap(nil, first) || ctx.stickyLoad() != first {
+		t.Error("sticky upstream not stored in an empty session")
+	}
+}
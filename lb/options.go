@@ -1,6 +1,9 @@
 package lb
 
 import (
+	"net/http"
+	"slices"
+
 	"get.pme.sh/pmesh/rate"
 	"get.pme.sh/pmesh/retry"
 	"get.pme.sh/pmesh/util"
@@ -49,10 +52,42 @@ type ErrorOptions struct {
 }
 
 type Options struct {
-	Retry    retry.Policy  `yaml:",inline"`         // The retry policy.
-	Strategy Strategy      `yaml:"strat,omitempty"` // The load balancing strategy.
-	State    StateType     `yaml:"state,omitempty"` // The session kind.
-	Error4xx *ErrorOptions `yaml:"4xx,omitempty"`   // The error handler for 4xx responses.
-	Error5xx *ErrorOptions `yaml:"5xx,omitempty"`   // The error handler for 5xx responses.
-	Error404 *ErrorOptions `yaml:"404,omitempty"`   // The error handler for 404 responses.
+	Retry           retry.Policy  `yaml:",inline"`                    // The retry policy.
+	RetryOn         []int         `yaml:"retry_on,omitempty"`         // The status codes that are retriable, defaults to all 5xx.
+	RetryIdempotent bool          `yaml:"retry_idempotent,omitempty"` // Whether to also retry idempotent methods (PUT, DELETE, HEAD, OPTIONS) and the POST/PATCH with an Idempotency-Key.
+	BufferBody      util.Size     `yaml:"buffer_body,omitempty"`      // The maximum size of the request bodies buffered to be replayed on retry, larger ones are not retried.
+	Strategy        Strategy      `yaml:"strat,omitempty"`            // The load balancing strategy.
+	State           StateType     `yaml:"state,omitempty"`            // The session kind.
+	Error4xx        *ErrorOptions `yaml:"4xx,omitempty"`              // The error handler for 4xx responses.
+	Error5xx        *ErrorOptions `yaml:"5xx,omitempty"`              // The error handler for 5xx responses.
+	Error404        *ErrorOptions `yaml:"404,omitempty"`              // The error handler for 404 responses.
+}
+
+// Returns true if the requests with the method of the given request may be retried.
+func (o *Options) isRetriableMethod(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet:
+		return true
+	case http.MethodPut, http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return o.RetryIdempotent
+	case http.MethodPost, http.MethodPatch:
+		return o.RetryIdempotent && r.Header.Get("Idempotency-Key") != ""
+	default:
+		return false
+	}
+}
+
+// Returns true if a response with the given status code to the given request may be retried.
+func (o *Options) IsRetriable(r *http.Request, status int) bool {
+	if !o.isRetriableMethod(r) {
+		return false
+	}
+	// The body is consumed by the first attempt, so we can only replay it if buffered.
+	if r.Method != http.MethodGet && r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	if len(o.RetryOn) == 0 {
+		return 500 <= status && status <= 599
+	}
+	return slices.Contains(o.RetryOn, status)
 }
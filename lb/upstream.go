@@ -6,15 +6,18 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"get.pme.sh/pmesh/netx"
+	"get.pme.sh/pmesh/vhttp"
 )
 
 type Upstream struct {
 	Address      string
 	Healthy      atomic.Bool
 	LoadFactor   atomic.Int32
+	Weight       atomic.Int32 // Share of the traffic relative to the other upstreams in percent, 100 = full share.
 	ReverseProxy httputil.ReverseProxy
 
 	// Metrics
@@ -22,6 +25,10 @@ type Upstream struct {
 	ErrorCount       atomic.Uint32
 	ServerErrorCount atomic.Uint32
 	ClientErrorCount atomic.Uint32
+	BytesIn          atomic.Uint64    // Bytes of request bodies sent to the upstream.
+	BytesOut         atomic.Uint64    // Bytes of response bodies received from the upstream.
+	ConnectLatency   atomic.Int64     // Moving average of the connect latency of the health checks, in nanoseconds.
+	Latency          LatencyHistogram // Time to the response headers of the requests.
 }
 
 func (u *Upstream) String() string {
@@ -32,13 +39,18 @@ func (u *Upstream) String() string {
 }
 
 type UpstreamMetrics struct {
-	Address          string `json:"address,omitempty"`
-	Healthy          bool   `json:"healthy,omitempty"`
-	LoadFactor       int32  `json:"load_factor,omitempty"`
-	RequestCount     uint32 `json:"request_count,omitempty"`
-	ErrorCount       uint32 `json:"error_count,omitempty"`
-	ServerErrorCount uint32 `json:"server_error_count,omitempty"`
-	ClientErrorCount uint32 `json:"client_error_count,omitempty"`
+	Address          string         `json:"address,omitempty"`
+	Healthy          bool           `json:"healthy,omitempty"`
+	LoadFactor       int32          `json:"load_factor,omitempty"`
+	Weight           int32          `json:"weight,omitempty"`
+	RequestCount     uint32         `json:"request_count,omitempty"`
+	ErrorCount       uint32         `json:"error_count,omitempty"`
+	ServerErrorCount uint32         `json:"server_error_count,omitempty"`
+	ClientErrorCount uint32         `json:"client_error_count,omitempty"`
+	BytesIn          uint64         `json:"bytes_in,omitempty"`
+	BytesOut         uint64         `json:"bytes_out,omitempty"`
+	ConnectLatency   float64        `json:"connect_latency,omitempty"` // Milliseconds.
+	Latency          LatencyMetrics `json:"latency"`
 }
 
 func (u *Upstream) Metrics() UpstreamMetrics {
@@ -46,10 +58,15 @@ func (u *Upstream) Metrics() UpstreamMetrics {
 		Address:          u.Address,
 		Healthy:          u.Healthy.Load(),
 		LoadFactor:       u.LoadFactor.Load(),
+		Weight:           u.Weight.Load(),
 		RequestCount:     u.RequestCount.Load(),
 		ErrorCount:       u.ErrorCount.Load(),
 		ServerErrorCount: u.ServerErrorCount.Load(),
 		ClientErrorCount: u.ClientErrorCount.Load(),
+		BytesIn:          u.BytesIn.Load(),
+		BytesOut:         u.BytesOut.Load(),
+		ConnectLatency:   float64(u.ConnectLatency.Load()) / float64(time.Millisecond),
+		Latency:          u.Latency.Metrics(),
 	}
 }
 
@@ -57,6 +74,31 @@ func (u *Upstream) SetHealthy(healthy bool) {
 	u.Healthy.Store(healthy)
 }
 
+// SetWeight sets the share of the traffic of the upstream, clamped to 1-100 percent.
+func (u *Upstream) SetWeight(percent int32) {
+	u.Weight.Store(min(max(percent, 1), 100))
+}
+
+// Returns the load factor scaled by the weight, so that the reduced share upstreams appear busier.
+func (u *Upstream) weightedLoad() int32 {
+	w := max(u.Weight.Load(), 1)
+	return (u.LoadFactor.Load()+1)*100/w - 1
+}
+
+// ObserveLatency adds a connect latency sample to the moving average.
+func (u *Upstream) ObserveLatency(d time.Duration) {
+	for {
+		prev := u.ConnectLatency.Load()
+		next := int64(d)
+		if prev != 0 {
+			next = (prev*7 + next) / 8
+		}
+		if u.ConnectLatency.CompareAndSwap(prev, next) {
+			return
+		}
+	}
+}
+
 type SuppressedHttpError struct {
 	http.Handler
 }
@@ -91,9 +133,13 @@ var globalBufferPool = &bufferPool{
 func NewHttpUpstreamTransport(address string, director func(r *http.Request), transport http.RoundTripper) (u *Upstream) {
 	u = &Upstream{Address: address}
 	u.Healthy.Store(true)
+	u.Weight.Store(100)
 
 	u.ReverseProxy = httputil.ReverseProxy{
-		Director:  director,
+		Director: func(r *http.Request) {
+			director(r)
+			forwardRequestTrailers(r)
+		},
 		Transport: transport,
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			if err == http.ErrAbortHandler {
@@ -102,12 +148,17 @@ func NewHttpUpstreamTransport(address string, director func(r *http.Request), tr
 				s.ServeHTTP(w, r)
 			} else {
 				rctx := r.Context().Value(requestContextKey{}).(*requestContext)
-				u.ErrorCount.Add(1)
+				rctx.recordUpstream()
+				// Client disconnects cancel the upstream request, they are not upstream errors.
+				if r.Context().Err() == nil {
+					u.ErrorCount.Add(1)
+				}
 				rctx.LoadBalancer.OnError(rctx, w, r, err)
 			}
 		},
 		ModifyResponse: func(r *http.Response) error {
 			ctx := r.Request.Context().Value(requestContextKey{}).(*requestContext)
+			ctx.recordUpstream()
 
 			// Fast path for non-error responses.
 			if !(400 <= r.StatusCode && r.StatusCode <= 599) {
@@ -132,7 +183,8 @@ func NewHttpUpstreamTransport(address string, director func(r *http.Request), tr
 				ctx.Upstream.ClientErrorCount.Add(1)
 			}
 
-			// Ask load balancer to handle the error.
+			// Ask load balancer to handle the error, the response it replaces is discarded along with
+			// its trailers. The responses passed through keep their trailers, such as the status of gRPC.
 			if hnd := ctx.LoadBalancer.OnErrorResponse(ctx, r); hnd != nil {
 				r.Body.Close()
 				return SuppressedHttpError{hnd}
@@ -147,7 +199,19 @@ func (p *Upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.LoadFactor.Add(1)
 	p.RequestCount.Add(1)
 	defer p.LoadFactor.Add(-1)
-	p.ReverseProxy.ServeHTTP(w, r)
+
+	cw := vhttp.NewConditionalResponse(w)
+	body := vhttp.NewCountingBody(r)
+	defer func() {
+		in, out := uint64(body.Count()), uint64(cw.Written)
+		p.BytesIn.Add(in)
+		p.BytesOut.Add(out)
+		if ctx, ok := r.Context().Value(requestContextKey{}).(*requestContext); ok && ctx.LoadBalancer != nil {
+			ctx.LoadBalancer.bytesIn.Add(in)
+			ctx.LoadBalancer.bytesOut.Add(out)
+		}
+	}()
+	p.ReverseProxy.ServeHTTP(cw, r)
 }
 
 func NewHttpUpstream(address string) (u *Upstream) {
@@ -0,0 +1,149 @@
+package lb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/retry"
+	"get.pme.sh/pmesh/util"
+)
+
+// Starts the app and a load balancer proxying to it, served by the returned server.
+func testProxy(t *testing.T, app http.Handler) (*LoadBalancer, *httptest.Server) {
+	t.Helper()
+	upstream := httptest.NewServer(app)
+	t.Cleanup(upstream.Close)
+	lb := &LoadBalancer{Options: Options{State: StateNone}}
+	lb.AddUpstream(NewHttpUpstream(upstream.Listener.Addr().String()))
+	front := httptest.NewServer(lb)
+	t.Cleanup(front.Close)
+	return lb, front
+}
+
+// Sends the head of a request expecting 100-continue, the body is left to the caller.
+func dialExpectContinue(t *testing.T, addr string, size int) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", size)
+	return conn, bufio.NewReader(conn)
+}
+
+func TestExpectContinueRejected(t *testing.T) {
+	for _, status := range []int{http.StatusExpectationFailed, http.StatusRequestEntityTooLarge} {
+		lb, front := testProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond) // Checks the request, the body would be on its way if sent early.
+			w.WriteHeader(status)
+		}))
+		conn, rd := dialExpectContinue(t, front.Listener.Addr().String(), 1<<20)
+
+		res, err := http.ReadResponse(rd, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != status {
+			t.Fatalf("got %d, want %d without a 100 Continue", res.StatusCode, status)
+		}
+		conn.Close()
+		front.Close() // Waits for the request to complete, counting the bytes sent.
+		if n := lb.Metrics().BytesIn; n != 0 {
+			t.Errorf("%d: %d body bytes sent upstream, want none", status, n)
+		}
+	}
+}
+
+func TestExpectContinueAccepted(t *testing.T) {
+	_, front := testProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		io.WriteString(w, strconv.FormatInt(n, 10))
+	}))
+	const size = 1 << 16
+	conn, rd := dialExpectContinue(t, front.Listener.Addr().String(), size)
+
+	res, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusContinue {
+		t.Fatalf("got %d, want 100", res.StatusCode)
+	}
+	if _, err := io.WriteString(conn, strings.Repeat("x", size)); err != nil {
+		t.Fatal(err)
+	}
+	res, err = http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != strconv.Itoa(size) {
+		t.Errorf("got %d %q, want 200 %q", res.StatusCode, body, strconv.Itoa(size))
+	}
+}
+
+func TestClientDisconnectCancelsUpstream(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		var calls atomic.Int32
+		started, cancelled := make(chan struct{}), make(chan struct{})
+		lb, front := testProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) != 1 {
+				return
+			}
+			// Either still working on the response or in the middle of streaming it.
+			if streaming {
+				io.WriteString(w, "partial")
+				w.(http.Flusher).Flush()
+			}
+			close(started)
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+			case <-time.After(10 * time.Second):
+			}
+		}))
+		lb.Retry = retry.Policy{Attempts: 3, Backoff: util.Duration(time.Millisecond), Timeout: util.Duration(time.Second)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, front.URL, nil)
+		go func() {
+			if res, err := front.Client().Do(req); err == nil {
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+		}()
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not proxied")
+		}
+		cancel()
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("streaming=%v: upstream request not cancelled", streaming)
+		}
+
+		front.Close() // Waits for the proxy to give up on the request.
+		if n := calls.Load(); n != 1 {
+			t.Errorf("streaming=%v: app called %d times, want no retry", streaming, n)
+		}
+		if n := lb.Upstreams()[0].ErrorCount.Load(); n != 0 {
+			t.Errorf("streaming=%v: %d upstream errors, want none", streaming, n)
+		}
+	}
+}
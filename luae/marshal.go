@@ -187,8 +187,20 @@ func toGoFunc(vm *lua.LState, fn *lua.LFunction, ty reflect.Type) reflect.Value
 
 // TODO: Userdata, proper tokenization
 
+var ErrCycle = errors.New("cyclic value")
+
+// A map, slice or pointer being marshaled, to detect the cycles.
+type marshalVisit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
 // MarshalLua converts a Go value to a Lua value.
 func MarshalLua(value any) (lua.LValue, error) {
+	return marshalLua(value, nil)
+}
+func marshalLua(value any, visiting map[marshalVisit]struct{}) (lua.LValue, error) {
 	switch value := value.(type) {
 	case nil:
 		return lua.LNil, nil
@@ -274,6 +286,27 @@ func MarshalLua(value any) (lua.LValue, error) {
 	}
 
 	v := reflect.ValueOf(value)
+
+	// Keep track of the references being walked, a reference to one of them is a cycle.
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			break
+		}
+		visit := marshalVisit{v.Pointer(), v.Type(), 0}
+		if v.Kind() == reflect.Slice {
+			visit.len = v.Len()
+		}
+		if _, ok := visiting[visit]; ok {
+			return lua.LNil, fmt.Errorf("%w of type %s", ErrCycle, v.Type())
+		}
+		if visiting == nil {
+			visiting = map[marshalVisit]struct{}{}
+		}
+		visiting[visit] = struct{}{}
+		defer delete(visiting, visit)
+	}
+
 	switch v.Kind() {
 	case reflect.Func:
 		return toLuaFunc(v), nil
@@ -281,11 +314,11 @@ func MarshalLua(value any) (lua.LValue, error) {
 		if v.IsNil() {
 			return lua.LNil, nil
 		}
-		return MarshalLua(v.Elem().Interface())
+		return marshalLua(v.Elem().Interface(), visiting)
 	case reflect.Array, reflect.Slice:
 		tbl := &lua.LTable{}
 		for i := 0; i < v.Len(); i++ {
-			v, e := MarshalLua(v.Index(i).Interface())
+			v, e := marshalLua(v.Index(i).Interface(), visiting)
 			if e != nil {
 				return nil, e
 			}
@@ -295,7 +328,7 @@ func MarshalLua(value any) (lua.LValue, error) {
 	case reflect.Map:
 		tbl := &lua.LTable{}
 		for _, k := range v.MapKeys() {
-			v, e := MarshalLua(v.MapIndex(k).Interface())
+			v, e := marshalLua(v.MapIndex(k).Interface(), visiting)
 			if e != nil {
 				return nil, e
 			}
@@ -347,48 +380,7 @@ func UnmarshalLua(vm *lua.LState, value lua.LValue, target any) (err error) {
 		*v = value.(*lua.LFunction)
 		return nil
 	case *any:
-		switch value.Type() {
-		case lua.LTString:
-			*v = string(value.(lua.LString))
-			return nil
-		case lua.LTNumber:
-			*v = float64(value.(lua.LNumber))
-			return nil
-		case lua.LTBool:
-			*v = value == lua.LTrue
-			return nil
-		case lua.LTNil:
-			*v = nil
-			return nil
-		case lua.LTTable:
-			arr, mv, e := unpackTable(vm, value.(*lua.LTable), false)
-			if e != nil {
-				return e
-			}
-			if len(arr) != 0 {
-				aarr := make([]any, len(arr))
-				for i, v := range arr {
-					if e := UnmarshalLua(vm, v, &aarr[i]); e != nil {
-						return e
-					}
-				}
-				*v = aarr
-			} else {
-				amap := make(map[string]any, len(mv))
-				for k, v := range mv {
-					var av any
-					if e := UnmarshalLua(vm, v, &av); e != nil {
-						continue
-					}
-					amap[k] = av
-				}
-				*v = amap
-			}
-			return nil
-		default:
-			*v = value.String()
-			return nil
-		}
+		return unmarshalAny(vm, value, v, &jsonBudget{}, 0)
 	case Unmarshaler:
 		return v.UnmarshalLua(vm, value)
 	case *bool:
@@ -484,54 +476,7 @@ func UnmarshalLua(vm *lua.LState, value lua.LValue, target any) (err error) {
 			return nil
 		}
 	case *json.RawMessage:
-		switch value.Type() {
-		case lua.LTString:
-			*v, err = json.Marshal(value.(lua.LString))
-			return
-		case lua.LTNumber:
-			fp := float64(value.(lua.LNumber))
-			var j any
-			if float64(int64(fp)) == fp {
-				j = int64(fp)
-			} else {
-				j = fp
-			}
-			*v, err = json.Marshal(j)
-			return
-		case lua.LTBool:
-			*v, err = json.Marshal(value == lua.LTrue)
-			return
-		case lua.LTNil:
-			*v = []byte("null")
-			return nil
-		case lua.LTTable:
-			arr, mv, e := unpackTable(vm, value.(*lua.LTable), false)
-			if e != nil {
-				return e
-			}
-			if len(arr) != 0 {
-				j := make([]json.RawMessage, len(arr))
-				for i, v := range arr {
-					if e := UnmarshalLua(vm, v, &j[i]); e != nil {
-						return e
-					}
-				}
-				*v, err = json.Marshal(j)
-			} else {
-				j := make(map[string]json.RawMessage, len(mv))
-				for k, v := range mv {
-					var jv json.RawMessage
-					if e := UnmarshalLua(vm, v, &jv); e != nil {
-						continue
-					}
-					j[k] = jv
-				}
-				*v, err = json.Marshal(j)
-			}
-			return
-		default:
-			return fmt.Errorf("cannot convert %s to json", value.Type())
-		}
+		return unmarshalJSON(vm, value, v, &jsonBudget{}, 0)
 	case *yaml.Node:
 		var js json.RawMessage
 		if e := UnmarshalLua(vm, value, &js); e != nil {
@@ -642,6 +587,138 @@ func UnmarshalLua(vm *lua.LState, value lua.LValue, target any) (err error) {
 	return fmt.Errorf("cannot unmarshal to %T", target)
 }
 
+func unmarshalAny(vm *lua.LState, value lua.LValue, v *any, b *jsonBudget, depth int) error {
+	switch value.Type() {
+	case lua.LTString:
+		*v = string(value.(lua.LString))
+		return nil
+	case lua.LTNumber:
+		*v = float64(value.(lua.LNumber))
+		return nil
+	case lua.LTBool:
+		*v = value == lua.LTrue
+		return nil
+	case lua.LTNil:
+		*v = nil
+		return nil
+	case lua.LTTable:
+		if depth >= MaxTableDepth {
+			return fmt.Errorf("%w: more than %d levels", ErrTableTooDeep, MaxTableDepth)
+		}
+		arr, mv, e := unpackTable(vm, value.(*lua.LTable), false)
+		if e != nil {
+			return e
+		}
+		if b.entries += len(arr) + len(mv); b.entries > MaxTableEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrTableTooLarge, MaxTableEntries)
+		}
+		if len(arr) != 0 {
+			aarr := make([]any, len(arr))
+			for i, v := range arr {
+				if e := unmarshalAny(vm, v, &aarr[i], b, depth+1); e != nil {
+					return e
+				}
+			}
+			*v = aarr
+		} else {
+			amap := make(map[string]any, len(mv))
+			for k, v := range mv {
+				var av any
+				if e := unmarshalAny(vm, v, &av, b, depth+1); e != nil {
+					if errors.Is(e, ErrTableTooDeep) || errors.Is(e, ErrTableTooLarge) {
+						return e
+					}
+					continue
+				}
+				amap[k] = av
+			}
+			*v = amap
+		}
+		return nil
+	default:
+		*v = value.String()
+		return nil
+	}
+}
+
+// Limits of the conversion of the Lua tables to Go values and JSON, protecting against pathological
+// inputs such as self-referencing tables.
+const (
+	MaxTableDepth   = 100     // Levels of nested tables.
+	MaxTableEntries = 1 << 20 // Entries of all the tables converted.
+)
+
+var (
+	ErrTableTooDeep  = errors.New("table nested too deep")
+	ErrTableTooLarge = errors.New("table too large")
+)
+
+// Entries counted by the conversion of a value to JSON or to a Go value, a table shared by several
+// parents counts at each of them.
+type jsonBudget struct {
+	entries int
+}
+
+func unmarshalJSON(vm *lua.LState, value lua.LValue, v *json.RawMessage, b *jsonBudget, depth int) (err error) {
+	switch value.Type() {
+	case lua.LTString:
+		*v, err = json.Marshal(value.(lua.LString))
+		return
+	case lua.LTNumber:
+		fp := float64(value.(lua.LNumber))
+		var j any
+		if float64(int64(fp)) == fp {
+			j = int64(fp)
+		} else {
+			j = fp
+		}
+		*v, err = json.Marshal(j)
+		return
+	case lua.LTBool:
+		*v, err = json.Marshal(value == lua.LTrue)
+		return
+	case lua.LTNil:
+		*v = []byte("null")
+		return nil
+	case lua.LTTable:
+		if depth >= MaxTableDepth {
+			return fmt.Errorf("%w: more than %d levels", ErrTableTooDeep, MaxTableDepth)
+		}
+		arr, mv, e := unpackTable(vm, value.(*lua.LTable), false)
+		if e != nil {
+			return e
+		}
+		if b.entries += len(arr) + len(mv); b.entries > MaxTableEntries {
+			return fmt.Errorf("%w: more than %d entries", ErrTableTooLarge, MaxTableEntries)
+		}
+		if len(arr) != 0 {
+			j := make([]json.RawMessage, len(arr))
+			for i, v := range arr {
+				if e := unmarshalJSON(vm, v, &j[i], b, depth+1); e != nil {
+					return e
+				}
+			}
+			*v, err = json.Marshal(j)
+		} else {
+			j := make(map[string]json.RawMessage, len(mv))
+			for k, v := range mv {
+				var jv json.RawMessage
+				if e := unmarshalJSON(vm, v, &jv, b, depth+1); e != nil {
+					if errors.Is(e, ErrTableTooDeep) || errors.Is(e, ErrTableTooLarge) {
+						return e
+					}
+					continue
+				}
+				j[k] = jv
+			}
+			*v, err = json.Marshal(j)
+		}
+		return
+	default:
+		return fmt.Errorf("cannot convert %s to json", value.Type())
+	}
+}
+
 // EvalLua evaluates Lua code and unmashals the result to a Go value.
 func addReturn(s *string) {
 	if strings.Contains(*s, "return ") {
@@ -0,0 +1,128 @@
+package luae
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestMarshalLuaCycles(t *testing.T) {
+	selfMap := map[string]any{}
+	selfMap["self"] = selfMap
+
+	selfSlice := []any{nil}
+	selfSlice[0] = selfSlice
+
+	selfPtr := new(any)
+	*selfPtr = selfPtr
+
+	a := map[string]any{}
+	b := []any{map[string]any{"a": a}}
+	a["b"] = b
+
+	tests := map[string]any{
+		"direct map":     selfMap,
+		"direct slice":   selfSlice,
+		"direct pointer": selfPtr,
+		"indirect":       a,
+	}
+	for name, value := range tests {
+		if _, err := MarshalLua(value); !errors.Is(err, ErrCycle) {
+			t.Errorf("%s: got %v, want ErrCycle", name, err)
+		}
+	}
+}
+
+func TestMarshalLuaDiamond(t *testing.T) {
+	shared := map[string]any{"x": 1}
+	list := []any{1, 2}
+	prefix := []any{1, nil}
+	prefix[1] = prefix[:1] // Same address as its parent, but shorter.
+	value := map[string]any{
+		"a":      shared,
+		"b":      []any{shared, shared},
+		"c":      &shared,
+		"list":   []any{list, list},
+		"prefix": prefix,
+	}
+	lv, err := MarshalLua(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := lv.(*lua.LTable)
+	for _, x := range []lua.LValue{
+		tbl.RawGetString("a").(*lua.LTable).RawGetString("x"),
+		tbl.RawGetString("b").(*lua.LTable).RawGetInt(2).(*lua.LTable).RawGetString("x"),
+		tbl.RawGetString("c").(*lua.LTable).RawGetString("x"),
+	} {
+		if x != lua.LNumber(1) {
+			t.Errorf("shared value marshaled as %v, want 1", x)
+		}
+	}
+	if n := tbl.RawGetString("prefix").(*lua.LTable).RawGetInt(2).(*lua.LTable).Len(); n != 1 {
+		t.Errorf("prefix has %d items, want 1", n)
+	}
+}
+
+func TestUnmarshalLuaSelfReferencingTable(t *testing.T) {
+	vm := lua.NewState()
+	defer vm.Close()
+	tbl := vm.NewTable()
+	tbl.RawSetString("self", tbl)
+	var v any
+	if err := UnmarshalLua(vm, tbl, &v); !errors.Is(err, ErrTableTooDeep) {
+		t.Fatalf("got %v, want ErrTableTooDeep", err)
+	}
+}
+
+func TestUnmarshalLuaSharedTableBomb(t *testing.T) {
+	vm := lua.NewState()
+	defer vm.Close()
+
+	// Each level references the one below twice, expanding to 2^40 leaves within the depth limit.
+	tbl := vm.NewTable()
+	tbl.RawSetString("leaf", lua.LTrue)
+	for i := 0; i < 40; i++ {
+		parent := vm.NewTable()
+		parent.RawSetString("a", tbl)
+		parent.RawSetString("b", tbl)
+		tbl = parent
+	}
+	var v any
+	if err := UnmarshalLua(vm, tbl, &v); !errors.Is(err, ErrTableTooLarge) {
+		t.Fatalf("any: got %v, want ErrTableTooLarge", err)
+	}
+	var js json.RawMessage
+	if err := UnmarshalLua(vm, tbl, &js); !errors.Is(err, ErrTableTooLarge) {
+		t.Fatalf("json: got %v, want ErrTableTooLarge", err)
+	}
+}
+
+func TestUnmarshalLuaDepth(t *testing.T) {
+	vm := lua.NewState()
+	defer vm.Close()
+	nest := func(levels int) lua.LValue {
+		var v lua.LValue = lua.LTrue
+		for range levels {
+			tbl := vm.NewTable()
+			tbl.RawSetString("x", v)
+			v = tbl
+		}
+		return v
+	}
+	var v any
+	if err := UnmarshalLua(vm, nest(MaxTableDepth/2), &v); err != nil {
+		t.Fatal(err)
+	}
+	for range MaxTableDepth / 2 {
+		v = v.(map[string]any)["x"]
+	}
+	if v != true {
+		t.Errorf("innermost value %v, want true", v)
+	}
+	if err := UnmarshalLua(vm, nest(MaxTableDepth+1), &v); !errors.Is(err, ErrTableTooDeep) {
+		t.Fatalf("got %v, want ErrTableTooDeep", err)
+	}
+}
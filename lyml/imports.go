@@ -0,0 +1,109 @@
+package lyml
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Default limits of the files read while evaluating a single document, protecting manifest loading
+// against recursive or fanned out imports.
+const (
+	DefaultMaxImportDepth = 32
+	DefaultMaxImportFiles = 1024
+)
+
+var ErrImportLimit = errors.New("import limit exceeded")
+
+// ImportTracker accounts for the files read while evaluating a document, including the nested imports
+// and the files read by the parse function.
+type ImportTracker struct {
+	MaxDepth int // Levels of nested imports allowed, DefaultMaxImportDepth if zero
+	MaxFiles int // Files allowed, DefaultMaxImportFiles if zero
+
+	mu       sync.Mutex
+	files    []string
+	maxDepth int
+}
+
+// Files returns the files read so far, in the order they were read.
+func (t *ImportTracker) Files() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.files...)
+}
+
+// Depth returns the deepest level of nested imports reached.
+func (t *ImportTracker) Depth() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.maxDepth
+}
+
+func (t *ImportTracker) add(file string, depth int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	maxDepth, maxFiles := cmp.Or(t.MaxDepth, DefaultMaxImportDepth), cmp.Or(t.MaxFiles, DefaultMaxImportFiles)
+	if depth > maxDepth {
+		return fmt.Errorf("%w: %q is nested more than %d levels deep", ErrImportLimit, file, maxDepth)
+	}
+	if len(t.files) >= maxFiles {
+		return fmt.Errorf("%w: %q exceeds the limit of %d files", ErrImportLimit, file, maxFiles)
+	}
+	t.files = append(t.files, file)
+	t.maxDepth = max(t.maxDepth, depth)
+	return nil
+}
+
+// Attaches an import tracker to the context, the caller can inspect it once the evaluation is done.
+type importTrackerKey struct{}
+type importDepthKey struct{}
+
+func WithImportTracker(ctx context.Context, t *ImportTracker) context.Context {
+	return context.WithValue(ctx, importTrackerKey{}, t)
+}
+func GetImportTracker(ctx context.Context) *ImportTracker {
+	t, _ := ctx.Value(importTrackerKey{}).(*ImportTracker)
+	return t
+}
+
+// Attaches a new import tracker to the context if it has none, called once per document so that all
+// its imports are accounted for together.
+func ensureImportTracker(ctx context.Context) context.Context {
+	if GetImportTracker(ctx) == nil {
+		ctx = WithImportTracker(ctx, &ImportTracker{})
+	}
+	return ctx
+}
+
+// Accounts for the file about to be read and returns the context to evaluate it with.
+func enterFile(ctx context.Context, file string) (context.Context, error) {
+	ctx = ensureImportTracker(ctx)
+	t := GetImportTracker(ctx)
+	depth, nested := ctx.Value(importDepthKey{}).(int)
+	if nested {
+		depth++
+	}
+	if err := t.add(file, depth); err != nil {
+		return ctx, err
+	}
+	ctx = context.WithValue(ctx, importDepthKey{}, depth)
+	return WithBaseDir(ctx, filepath.Dir(file)), nil
+}
+
+// Runs the function with the VM evaluating the given file.
+func withFile(vm *lua.LState, file string, f func() error) error {
+	prev := vm.Context()
+	ctx, err := enterFile(prev, file)
+	if err != nil {
+		return err
+	}
+	vm.SetContext(ctx)
+	defer vm.SetContext(prev)
+	return f()
+}
@@ -0,0 +1,77 @@
+package lyml
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeImportChain(t *testing.T, n int) string {
+	dir := t.TempDir()
+	for i := range n {
+		data := "k" + string(rune('a'+i)) + ": v\n"
+		if i+1 < n {
+			data += "$import: ./" + string(rune('a'+i+1)) + ".yml\n"
+		}
+		if err := os.WriteFile(filepath.Join(dir, string(rune('a'+i))+".yml"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "a.yml")
+}
+
+func TestImportTrackerRecordsFiles(t *testing.T) {
+	path := writeImportChain(t, 3)
+	tracker := &ImportTracker{}
+	var res map[string]string
+	if err := LoadContext(WithImportTracker(context.Background(), tracker), path, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Errorf("got %v, want the keys of the 3 files", res)
+	}
+	files := tracker.Files()
+	if len(files) != 3 {
+		t.Fatalf("files = %v, want 3", files)
+	}
+	for i, f := range files {
+		if want := string(rune('a'+i)) + ".yml"; filepath.Base(f) != want {
+			t.Errorf("files[%d] = %q, want %q", i, f, want)
+		}
+	}
+	if d := tracker.Depth(); d != 2 {
+		t.Errorf("depth = %d, want 2", d)
+	}
+}
+
+func TestImportTrackerLimits(t *testing.T) {
+	path := writeImportChain(t, 4)
+	tests := []struct {
+		name    string
+		tracker *ImportTracker
+		fail    string
+	}{
+		{"defaults", &ImportTracker{}, ""},
+		{"depth", &ImportTracker{MaxDepth: 2}, "levels deep"},
+		{"files", &ImportTracker{MaxFiles: 3}, "limit of 3 files"},
+		{"within", &ImportTracker{MaxDepth: 3, MaxFiles: 4}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res map[string]string
+			err := LoadContext(WithImportTracker(context.Background(), tt.tracker), path, &res)
+			if tt.fail == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrImportLimit) || !strings.Contains(err.Error(), tt.fail) {
+				t.Fatalf("got %v, want %v mentioning %q", err, ErrImportLimit, tt.fail)
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@ package lyml
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"get.pme.sh/pmesh/luae"
@@ -15,15 +17,34 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func withWd(wd string, f func()) {
-	cwd, _ := os.Getwd()
-	defer os.Chdir(cwd)
-	os.Chdir(wd)
-	f()
+// Base directory the relative paths of a document are resolved against, tracked in the context
+// rather than by changing the working directory so that documents can be rendered concurrently.
+type baseDirKey struct{}
+
+func WithBaseDir(ctx context.Context, dir string) context.Context {
+	if abs, err := filepath.Abs(resolvePath(ctx, dir)); err == nil {
+		dir = abs
+	}
+	return context.WithValue(ctx, baseDirKey{}, dir)
+}
+func GetBaseDir(ctx context.Context) string {
+	dir, _ := ctx.Value(baseDirKey{}).(string)
+	return dir
+}
+
+// Resolves a path relative to the base directory of the context.
+func resolvePath(ctx context.Context, path string) string {
+	base := GetBaseDir(ctx)
+	if base == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
 }
 
-type specialNode interface {
-	eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error
+// SpecialNode is a directive evaluated in place of the pair or the sequence item it was parsed from,
+// appending its result to the content of the parent node of the given kind.
+type SpecialNode interface {
+	Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error
 }
 
 type mismatchErr struct {
@@ -106,7 +127,7 @@ type conditionalNode struct {
 	Node *yaml.Node
 }
 
-func (c conditionalNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error {
+func (c conditionalNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error {
 	var cond bool
 	if err := luae.EvalLua(vm, c.Cond, &cond); err != nil {
 		return err
@@ -132,7 +153,7 @@ type matchNode struct {
 	Node *yaml.Node
 }
 
-func (c matchNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error {
+func (c matchNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error {
 	if c.Node.Kind != yaml.MappingNode {
 		return newMismatchErr(c.Node, yaml.MappingNode)
 	}
@@ -168,7 +189,7 @@ type eachNode struct {
 	Node      *yaml.Node
 }
 
-func (c eachNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (err error) {
+func (c eachNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (err error) {
 	var tname, kname, vname string
 	if before, after, ok := strings.Cut(c.Directive, " as "); ok {
 		tname = strings.TrimSpace(before)
@@ -232,7 +253,7 @@ type localsNode struct {
 	Node *yaml.Node
 }
 
-func (c localsNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (err error) {
+func (c localsNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (err error) {
 	if c.Node.Kind == yaml.MappingNode {
 		for i := 0; i < len(c.Node.Content); i += 2 {
 			// Decode the key and value as generic type.
@@ -307,15 +328,25 @@ func (c localsNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (
 >     three: 3
 >   $import: ./a.yml
 >   $import: ./*.yml
+>   $import!: ./conf.d/*.yml #fails if a pattern matches no files
 >   $import:
   - ./a.yml
   - ./b.yml
+
+Files matching a pattern are imported in lexical order.
 */
 type importNode struct {
-	Path *yaml.Node
+	Path     *yaml.Node
+	Required bool
 }
 
-func (c importNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (err error) {
+var ErrImportNoMatch = errors.New("no files match")
+
+func (c importNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (err error) {
+	if IsSafeMode(vm.Context()) {
+		return fmt.Errorf("$import: %w", ErrSafeMode)
+	}
+
 	// Decode the path.
 	//
 	node, err := evaluate(vm, c.Path)
@@ -348,19 +379,22 @@ func (c importNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (
 	// Mixin each file.
 	//
 	for _, path := range paths {
-		files, _ := filepath.Glob(path)
+		files, _ := filepath.Glob(resolvePath(vm.Context(), path))
+		if len(files) == 0 && c.Required {
+			return fmt.Errorf("$import!: %w %q", ErrImportNoMatch, path)
+		}
+		sort.Strings(files)
 		for _, file := range files {
-			var data []byte
-			if data, err = os.ReadFile(file); err != nil {
-				return
-			}
-
-			withWd(filepath.Dir(file), func() {
-				var node *yaml.Node
-				node, err = unmarshal(vm, data)
-				if err == nil {
-					err = mixin(result, node, kind)
+			err = withFile(vm, file, func() error {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return err
 				}
+				node, err := unmarshal(vm, data)
+				if err != nil {
+					return err
+				}
+				return mixin(result, node, kind)
 			})
 			if err != nil {
 				return
@@ -371,15 +405,15 @@ func (c importNode) eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) (
 }
 
 // Parse a special node from a key-value pair.
-func parseSpecialPair(k, v *yaml.Node) specialNode {
+func parseSpecialPair(k, v *yaml.Node) SpecialNode {
 	if k.Kind != yaml.ScalarNode {
 		return nil
 	}
 	if k.Value == "$" {
 		return localsNode{Node: v}
 	}
-	if k.Value == "$import" {
-		return importNode{Path: v}
+	if k.Value == "$import" || k.Value == "$import!" {
+		return importNode{Path: v, Required: k.Value == "$import!"}
 	}
 	if strings.HasPrefix(k.Value, "${") && strings.HasSuffix(k.Value, "}") {
 		act := k.Value[2 : len(k.Value)-1]
@@ -393,12 +427,16 @@ func parseSpecialPair(k, v *yaml.Node) specialNode {
 		case "each":
 			return eachNode{Directive: rest, Node: v}
 		}
+		return customSpecialNode(verb, rest, v)
+	}
+	if verb, ok := strings.CutPrefix(k.Value, "$"); ok {
+		return customSpecialNode(verb, "", v)
 	}
 	return nil
 }
 
 // Parse a special node from a node.
-func parseSpecialNode(e *yaml.Node) specialNode {
+func parseSpecialNode(e *yaml.Node) SpecialNode {
 	if e.Kind != yaml.MappingNode {
 		return nil
 	}
@@ -463,7 +501,7 @@ func evaluate(vm *lua.LState, node *yaml.Node) (result *yaml.Node, err error) {
 			s := parseSpecialNode(child)
 			if s != nil {
 				scope.Enter()
-				if err = s.eval(vm, &res, yaml.SequenceNode); err != nil {
+				if err = s.Eval(vm, &res, yaml.SequenceNode); err != nil {
 					return
 				}
 				continue
@@ -478,7 +516,7 @@ func evaluate(vm *lua.LState, node *yaml.Node) (result *yaml.Node, err error) {
 			s := parseSpecialPair(k, v)
 			if s != nil {
 				scope.Enter()
-				if err = s.eval(vm, &res, yaml.MappingNode); err != nil {
+				if err = s.Eval(vm, &res, yaml.MappingNode); err != nil {
 					return
 				}
 				continue
@@ -509,9 +547,10 @@ func unmarshal(vm *lua.LState, data []byte) (res *yaml.Node, err error) {
 
 // Transform takes a lyml document and returns the parsed yaml.Node.
 func TransformContext(ctx context.Context, lyml []byte) (res *yaml.Node, err error) {
-	vm := NewContextVM(ctx)
-	defer vm.Close()
-	return unmarshal(vm, lyml)
+	ctx = ensureImportTracker(ctx)
+	p := acquireVM(ctx)
+	defer func() { p.release(err == nil) }()
+	return unmarshal(p.vm, lyml)
 }
 func Transform(lyml []byte) (res *yaml.Node, err error) {
 	return TransformContext(context.Background(), lyml)
@@ -546,17 +585,21 @@ func Unmarshal(data []byte, v any) error {
 	return UnmarshalContext(context.Background(), data, v)
 }
 
-// Load is unmarshal with file path.
+// Load is unmarshal with file path, relative paths in the document are resolved against its directory.
 func LoadContext(ctx context.Context, path string, v any) error {
+	path = resolvePath(ctx, path)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	ctx, err := enterFile(ctx, path)
+	if err != nil {
+		return err
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-
-	withWd(filepath.Dir(path), func() {
-		err = UnmarshalContext(ctx, data, v)
-	})
-	return err
+	return UnmarshalContext(ctx, data, v)
 }
 func Load(path string, v any) error {
 	return LoadContext(context.Background(), path, v)
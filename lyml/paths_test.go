@@ -0,0 +1,54 @@
+package lyml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLuaPathsResolveAgainstDocument(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"data.txt":        "from io",
+		"mod.lua":         "return { v = 'from require' }",
+		"lib/init.lua":    "return { v = 'from init' }",
+		"script.lua":      "return 'from dofile'",
+		"conf/nested.yml": "n: $(io.open('data.txt'):read('*a'))\n",
+		"conf/data.txt":   "from nested",
+	}
+	files["pm3.yml"] = `io: $(io.open('data.txt'):read('*a'))
+lines: $(io.lines('data.txt')())
+require: $(require('mod').v)
+init: $(require('lib').v)
+dofile: $(dofile('script.lua'))
+loadfile: $(loadfile('script.lua')())
+$import: conf/nested.yml
+`
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var res map[string]string
+	if err := Load(filepath.Join(dir, "pm3.yml"), &res); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"io":       "from io",
+		"lines":    "from io",
+		"require":  "from require",
+		"init":     "from init",
+		"dofile":   "from dofile",
+		"loadfile": "from dofile",
+		"n":        "from nested",
+	}
+	for k, v := range want {
+		if res[k] != v {
+			t.Errorf("%s = %q, want %q", k, res[k], v)
+		}
+	}
+}
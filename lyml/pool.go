@@ -0,0 +1,127 @@
+package lyml
+
+import (
+	"context"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Pool of VMs used for rendering, creating a VM and opening its libraries costs far more than most
+// documents take to evaluate.
+// Safe mode VMs are kept apart as they are opened with a different set of libraries.
+var vmPool, safeVMPool = &sync.Pool{}, &sync.Pool{}
+
+// A pooled VM, along with a snapshot of its globals, registry and libraries taken when it was created.
+type pooledVM struct {
+	vm       *lua.LState
+	pool     *sync.Pool
+	meta     lua.LValue
+	builtin  []builtinMeta
+	snapshot map[*lua.LTable]map[lua.LValue]lua.LValue
+}
+
+// Values standing for the types sharing a single metatable per VM, set by setmetatable("", mt) and
+// the like.
+var builtinSamples = []lua.LValue{
+	lua.LNil,
+	lua.LFalse,
+	lua.LNumber(0),
+	lua.LString(""),
+	&lua.LFunction{},
+	&lua.LState{},
+	lua.LChannel(nil),
+}
+
+// Metatable of a builtin type, the raw __metatable field tells a replacement apart from a
+// metatable protected with the original one.
+type builtinMeta struct {
+	meta, protect lua.LValue
+}
+
+func snapshotBuiltins(vm *lua.LState) []builtinMeta {
+	res := make([]builtinMeta, len(builtinSamples))
+	for i, v := range builtinSamples {
+		res[i] = builtinMeta{vm.GetMetatable(v), vm.GetMetaField(v, "__metatable")}
+	}
+	return res
+}
+
+// Takes a snapshot of the table and the tables under it up to the given depth.
+func snapshotTable(tbl *lua.LTable, depth int, into map[*lua.LTable]map[lua.LValue]lua.LValue) {
+	if _, ok := into[tbl]; ok {
+		return
+	}
+	entries := map[lua.LValue]lua.LValue{}
+	into[tbl] = entries
+	tbl.ForEach(func(k, v lua.LValue) {
+		entries[k] = v
+	})
+	if depth > 0 {
+		for _, v := range entries {
+			if sub, ok := v.(*lua.LTable); ok {
+				snapshotTable(sub, depth-1, into)
+			}
+		}
+	}
+}
+
+// Returns true if the tables still match the snapshot.
+func (p *pooledVM) pristine() bool {
+	if p.vm.G.Global.Metatable != p.meta {
+		return false
+	}
+	for i, b := range snapshotBuiltins(p.vm) {
+		if b != p.builtin[i] {
+			return false
+		}
+	}
+	for tbl, entries := range p.snapshot {
+		n := 0
+		same := true
+		tbl.ForEach(func(k, v lua.LValue) {
+			n++
+			if same {
+				if prev, ok := entries[k]; !ok || prev != v {
+					same = false
+				}
+			}
+		})
+		if !same || n != len(entries) {
+			return false
+		}
+	}
+	return true
+}
+
+// Acquires a VM from the pool, or creates a new one.
+func acquireVM(ctx context.Context) *pooledVM {
+	pool := vmPool
+	if IsSafeMode(ctx) {
+		pool = safeVMPool
+	}
+	p, _ := pool.Get().(*pooledVM)
+	if p == nil {
+		p = &pooledVM{vm: NewContextVM(ctx), pool: pool}
+		p.snapshot = map[*lua.LTable]map[lua.LValue]lua.LValue{}
+		p.meta = p.vm.G.Global.Metatable
+		p.builtin = snapshotBuiltins(p.vm)
+		snapshotTable(p.vm.G.Global, 2, p.snapshot)
+		snapshotTable(p.vm.G.Registry, 2, p.snapshot)
+	} else {
+		p.vm.SetContext(ctx)
+	}
+	return p
+}
+
+// Returns the VM to the pool, if it is still in its initial state it is reset and reused, otherwise
+// it is closed so that no state leaks from one render to the next.
+func (p *pooledVM) release(ok bool) {
+	vm := p.vm
+	if !ok || vm.Env != vm.G.Global || vm.GetTop() != 0 || !p.pristine() {
+		vm.Close()
+		return
+	}
+	vm.RemoveContext()
+	p.pool.Put(p)
+}
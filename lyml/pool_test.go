@@ -0,0 +1,78 @@
+package lyml
+
+import (
+	"context"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestPoolDiscardsModifiedVMs(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		reused bool
+	}{
+		{"locals", "local x = 1", true},
+		{"string calls", "local s = ('x'):upper()", true},
+		{"global", "leak = 1", false},
+		{"global removed", "tostring = nil", false},
+		{"library field", "string.upper = string.lower", false},
+		{"global metatable", "setmetatable(_G, {__index = function() return 1 end})", false},
+		{"string metatable", "getmetatable('').__index = {}", false},
+		{"builtin metatable", "debug.setmetatable(0, {__index = math})", false},
+		{"nil metatable", "debug.setmetatable(nil, {__index = function() return 1 end})", false},
+		{"loaded module", "package.loaded.leak = {}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := acquireVM(context.Background())
+			if !p.pristine() {
+				t.Fatal("acquired a modified VM")
+			}
+			if err := p.vm.DoString(tt.script); err != nil {
+				t.Fatal(err)
+			}
+			p.release(true)
+			if closed := p.vm.IsClosed(); closed == tt.reused {
+				t.Errorf("closed = %v, want %v", closed, !tt.reused)
+			}
+		})
+	}
+}
+
+func TestPoolDiscardsRegistryChanges(t *testing.T) {
+	p := acquireVM(context.Background())
+	p.vm.G.Registry.RawSetString("leak", lua.LTrue)
+	p.release(true)
+	if !p.vm.IsClosed() {
+		t.Error("VM with a modified registry not closed")
+	}
+}
+
+func TestPoolDiscardsFailedVMs(t *testing.T) {
+	p := acquireVM(context.Background())
+	p.release(false)
+	if !p.vm.IsClosed() {
+		t.Error("VM of a failed render not closed")
+	}
+	p = acquireVM(context.Background())
+	p.vm.Push(p.vm.GetGlobal("tostring"))
+	p.release(true)
+	if !p.vm.IsClosed() {
+		t.Error("VM with values left on the stack not closed")
+	}
+}
+
+func TestPoolIsolatesRenders(t *testing.T) {
+	for range 3 {
+		var res map[string]string
+		doc := "a: $(type(leak))\nb: $(leak = 1; 'set')\nc: $(type(('').leak))\nd: $(string.leak = 1; 'set')\n"
+		if err := Unmarshal([]byte(doc), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res["a"] != "nil" || res["c"] != "nil" || res["b"] != "set" || res["d"] != "set" {
+			t.Fatalf("state leaked from a previous render: %v", res)
+		}
+	}
+}
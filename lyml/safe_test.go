@@ -0,0 +1,73 @@
+package lyml
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSafeModeGlobals(t *testing.T) {
+	unsafe := []string{"io", "os", "env", "fetch", "glob", "parse", "args", "dofile", "require", "module", "load", "loadfile", "loadstring"}
+	for _, safe := range []bool{false, true} {
+		ctx := context.Background()
+		if safe {
+			ctx = WithSafeMode(ctx)
+		}
+		for _, name := range unsafe {
+			var res map[string]string
+			if err := UnmarshalContext(ctx, []byte("v: $(type("+name+"))"), &res); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got := res["v"] == "nil"; got != safe {
+				t.Errorf("safe mode %v: %s is %s", safe, name, res["v"])
+			}
+		}
+	}
+}
+
+func TestSafeModeImport(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.yml"), []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithBaseDir(context.Background(), dir)
+	if _, err := RenderContext(ctx, []byte("$import: ./a.yml\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []string{"$import: ./a.yml\n", "$import!: ./a.yml\n"} {
+		if _, err := RenderContext(WithSafeMode(ctx), []byte(doc)); !errors.Is(err, ErrSafeMode) {
+			t.Errorf("%q: got %v, want the safe mode error", doc, err)
+		}
+	}
+	if _, err := RenderContext(WithSafeMode(ctx), []byte("b: $(parse('./a.yml').a)\n")); err == nil {
+		t.Error("parse succeeded in safe mode")
+	}
+}
+
+type constNode struct{ value string }
+
+func (c constNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error {
+	return Mixin(result, &yaml.Node{Kind: yaml.ScalarNode, Value: c.value}, kind)
+}
+
+func TestSafeModeCustomNode(t *testing.T) {
+	err := RegisterSpecialNode("safetest", func(arg string, value *yaml.Node) SpecialNode {
+		return constNode{value.Value}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { customNodes.Delete("safetest") })
+	doc := []byte("- $safetest: x\n")
+	if res, err := Render(doc); err != nil || string(res) != "- x\n" {
+		t.Fatalf("rendered %q, %v", res, err)
+	}
+	if _, err := RenderContext(WithSafeMode(context.Background()), doc); !errors.Is(err, ErrSafeMode) {
+		t.Errorf("got %v, want the safe mode error", err)
+	}
+}
@@ -0,0 +1,78 @@
+package lyml
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v3"
+)
+
+// SpecialNodeFactory creates the special node for a custom directive, written either as `$verb: value`
+// where the argument is empty, or as `${verb argument}: value`. Returning nil leaves the pair as is.
+type SpecialNodeFactory func(arg string, value *yaml.Node) SpecialNode
+
+// Custom directives.
+var customNodes = sync.Map{} // string -> SpecialNodeFactory
+
+// Verbs handled by lyml itself.
+var builtinVerbs = map[string]bool{
+	"":        true,
+	"import":  true,
+	"import!": true,
+	"if":      true,
+	"match":   true,
+	"each":    true,
+}
+
+// RegisterSpecialNode adds a custom directive, for instance:
+//
+//	lyml.RegisterSpecialNode("secret", func(arg string, value *yaml.Node) lyml.SpecialNode {
+//		return secretNode{Name: value}
+//	})
+//
+// makes `$secret: name` and `${secret ...}: name` evaluate through secretNode. Custom directives may
+// access the system, so they fail in safe mode like $import.
+func RegisterSpecialNode(verb string, factory SpecialNodeFactory) error {
+	if builtinVerbs[verb] || strings.ContainsAny(verb, " ${}") {
+		return fmt.Errorf("invalid special node verb %q", verb)
+	}
+	if _, loaded := customNodes.LoadOrStore(verb, factory); loaded {
+		return fmt.Errorf("special node %q is already registered", verb)
+	}
+	return nil
+}
+
+func customSpecialNode(verb, arg string, v *yaml.Node) SpecialNode {
+	if f, ok := customNodes.Load(verb); ok {
+		if node := f.(SpecialNodeFactory)(arg, v); node != nil {
+			return customNode{verb, node}
+		}
+	}
+	return nil
+}
+
+// Custom directive, not evaluated in safe mode.
+type customNode struct {
+	verb string
+	SpecialNode
+}
+
+func (c customNode) Eval(vm *lua.LState, result *[]*yaml.Node, kind yaml.Kind) error {
+	if IsSafeMode(vm.Context()) {
+		return fmt.Errorf("$%s: %w", c.verb, ErrSafeMode)
+	}
+	return c.SpecialNode.Eval(vm, result, kind)
+}
+
+// Evaluate evaluates the special nodes within the node, for use by custom special nodes.
+func Evaluate(vm *lua.LState, node *yaml.Node) (*yaml.Node, error) {
+	return evaluate(vm, node)
+}
+
+// Mixin appends the content of the node to the result of a special node, for use by custom special
+// nodes. Null is ignored and a single node can be mixed into a sequence.
+func Mixin(result *[]*yaml.Node, node *yaml.Node, kind yaml.Kind) error {
+	return mixin(result, node, kind)
+}
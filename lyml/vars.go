@@ -3,6 +3,7 @@ package lyml
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -18,7 +19,6 @@ import (
 
 	"github.com/samber/lo"
 	lua "github.com/yuin/gopher-lua"
-	"gopkg.in/yaml.v3"
 )
 
 // Global variables.
@@ -105,25 +105,38 @@ var initGlobals = sync.OnceFunc(func() {
 			}
 			return expr.MatchString(str), nil
 		},
-		"glob": func(pattern string) ([]string, error) {
-			return filepath.Glob(pattern)
+		"glob": func(vm *lua.LState, pattern string) ([]string, error) {
+			// Match relative to the base directory, but keep the results relative to it as well.
+			//
+			base := GetBaseDir(vm.Context())
+			matches, err := filepath.Glob(resolvePath(vm.Context(), pattern))
+			if err != nil || base == "" || filepath.IsAbs(pattern) {
+				return matches, err
+			}
+			for i, m := range matches {
+				if rel, err := filepath.Rel(base, m); err == nil {
+					matches[i] = rel
+				}
+			}
+			return matches, nil
 		},
 		"parse": func(vm *lua.LState, path string) (res any, err error) {
 			// Read the file and parse it.
 			//
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return nil, err
-			}
+			path = resolvePath(vm.Context(), path)
+			err = withFile(vm, path, func() error {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
 
-			// Evaluate the node.
-			//
-			withWd(filepath.Dir(path), func() {
-				var node *yaml.Node
-				node, err = unmarshal(vm, data)
-				if err == nil {
-					err = node.Decode(&res)
+				// Evaluate the node.
+				//
+				node, err := unmarshal(vm, data)
+				if err != nil {
+					return err
 				}
+				return node.Decode(&res)
 			})
 			return
 		},
@@ -150,9 +163,48 @@ func GetVar(ctx context.Context, key string) lua.LValue {
 	return v.(lua.LValue)
 }
 
+// Safe mode restricts the evaluation to pure computation so that untrusted documents can be rendered,
+// imports, the working directory changes and the globals that access the system are disabled.
+type safeModeKey struct{}
+
+var ErrSafeMode = errors.New("not allowed in safe mode")
+
+func WithSafeMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, safeModeKey{}, true)
+}
+func IsSafeMode(ctx context.Context) bool {
+	v, _ := ctx.Value(safeModeKey{}).(bool)
+	return v
+}
+
+// Globals hidden in safe mode as they access the filesystem, the environment or the network.
+var unsafeGlobals = map[string]bool{
+	"env":   true,
+	"fetch": true,
+	"glob":  true,
+	"parse": true,
+	"args":  true,
+}
+
+// Functions of the base library removed in safe mode as they load files or code.
+var unsafeBaseFuncs = []string{"dofile", "loadfile", "load", "loadstring", "require", "module"}
+
+// Libraries opened in safe mode.
+var safeLibs = map[string]lua.LGFunction{
+	lua.BaseLibName:      lua.OpenBase,
+	lua.TabLibName:       lua.OpenTable,
+	lua.StringLibName:    lua.OpenString,
+	lua.MathLibName:      lua.OpenMath,
+	lua.CoroutineLibName: lua.OpenCoroutine,
+}
+
 // Creates a metatable that indexes globals and contextual variables.
 var contextualLookup = luae.NewFunc(func(l *lua.LState) int {
-	if v := GetVar(l.Context(), l.ToString(2)); v != nil {
+	key := l.ToString(2)
+	if unsafeGlobals[key] && IsSafeMode(l.Context()) {
+		return 0
+	}
+	if v := GetVar(l.Context(), key); v != nil {
 		l.Push(v)
 		return 1
 	}
@@ -166,12 +218,76 @@ func NewContextMetatable() *lua.LTable {
 	return meta
 }
 
-// Creates a new VM with a context.
+// Creates a new VM with a context, if the context is in safe mode only the libraries without
+// access to the system are opened.
 func NewContextVM(ctx context.Context) *lua.LState {
+	safe := IsSafeMode(ctx)
 	vm := lua.NewState(lua.Options{
 		IncludeGoStackTrace: true,
+		SkipOpenLibs:        safe,
 	})
+	if safe {
+		for name, open := range safeLibs {
+			vm.Push(vm.NewFunction(open))
+			vm.Push(lua.LString(name))
+			vm.Call(1, 0)
+		}
+		for _, name := range unsafeBaseFuncs {
+			vm.G.Global.RawSetString(name, lua.LNil)
+		}
+	} else {
+		resolveLuaPaths(vm)
+	}
 	vm.SetContext(ctx)
 	vm.G.Global.Metatable = NewContextMetatable()
 	return vm
 }
+
+// Resolves the paths given to the functions of the libraries that load files against the base directory
+// of the document, as the paths of lyml itself, rather than against the working directory of the process.
+// The other functions of io and os are left as is.
+func resolveLuaPaths(vm *lua.LState) {
+	wrap := func(tbl lua.LValue, name string) {
+		t, ok := tbl.(*lua.LTable)
+		if !ok {
+			return
+		}
+		fn, ok := t.RawGetString(name).(*lua.LFunction)
+		if !ok {
+			return
+		}
+		t.RawSetString(name, vm.NewFunction(func(l *lua.LState) int {
+			if path, ok := l.Get(1).(lua.LString); ok {
+				l.Replace(1, lua.LString(resolvePath(l.Context(), string(path))))
+			}
+			l.Insert(fn, 1)
+			l.Call(l.GetTop()-1, lua.MultRet)
+			return l.GetTop()
+		}))
+	}
+	wrap(vm.G.Global, "dofile")
+	wrap(vm.G.Global, "loadfile")
+	wrap(vm.GetGlobal(lua.IoLibName), "open")
+	wrap(vm.GetGlobal(lua.IoLibName), "lines")
+
+	// Modules are searched next to the document first.
+	pkg, ok := vm.GetGlobal(lua.LoadLibName).(*lua.LTable)
+	if !ok {
+		return
+	}
+	require, ok := vm.GetGlobal("require").(*lua.LFunction)
+	if !ok {
+		return
+	}
+	vm.SetGlobal("require", vm.NewFunction(func(l *lua.LState) int {
+		prev := pkg.RawGetString("path")
+		if base := GetBaseDir(l.Context()); base != "" {
+			local := filepath.Join(base, "?.lua") + ";" + filepath.Join(base, "?", "init.lua")
+			pkg.RawSetString("path", lua.LString(local+";"+lua.LVAsString(prev)))
+			defer pkg.RawSetString("path", prev)
+		}
+		l.Insert(require, 1)
+		l.Call(l.GetTop()-1, lua.MultRet)
+		return l.GetTop()
+	}))
+}
@@ -0,0 +1,108 @@
+package netx
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// First file descriptor passed by socket activation.
+const listenFdsStart = 3
+
+// Names of the listeners pmesh takes, given with FileDescriptorName= in the socket unit. Other names,
+// such as the name of the socket unit systemd gives by default, are matched by port.
+const (
+	ListenerHTTP  = "http"
+	ListenerHTTPS = "https"
+)
+
+func isListenerName(name string) bool {
+	return name == ListenerHTTP || name == ListenerHTTPS
+}
+
+// InheritedListener is a listener passed by the service manager.
+type InheritedListener struct {
+	net.Listener
+	Name string // Name given in LISTEN_FDNAMES, if any.
+}
+
+var inherited struct {
+	once      sync.Once
+	mu        sync.Mutex
+	listeners []*InheritedListener
+}
+
+// Collects the listeners passed with the systemd socket activation protocol, which keeps the
+// sockets open across a restart so that the connections queue in the backlog instead of being
+// refused. The variables are cleared so that the child processes do not inherit them.
+func collectInherited() {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(listenFdsStart+i), "listen-fd-"+strconv.Itoa(i))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			xlog.Warn().Err(err).Int("fd", listenFdsStart+i).Msg("Ignoring inherited file descriptor")
+			continue
+		}
+		il := &InheritedListener{Listener: l}
+		if i < len(names) {
+			il.Name = names[i]
+		}
+		inherited.listeners = append(inherited.listeners, il)
+	}
+}
+
+// TakeInheritedListener returns the inherited listener with the given name, or failing that, the one
+// bound to the given port whose name is not one pmesh takes, removing it from the list. Returns nil if
+// there is no match.
+func TakeInheritedListener(name string, port int) net.Listener {
+	inherited.once.Do(collectInherited)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	byName := func(l *InheritedListener) bool {
+		return l.Name == name
+	}
+	byPort := func(l *InheritedListener) bool {
+		if isListenerName(l.Name) {
+			return false
+		}
+		addr, ok := l.Addr().(*net.TCPAddr)
+		return ok && addr.Port == port
+	}
+	for _, match := range []func(*InheritedListener) bool{byName, byPort} {
+		for i, l := range inherited.listeners {
+			if match(l) {
+				inherited.listeners = append(inherited.listeners[:i], inherited.listeners[i+1:]...)
+				return l.Listener
+			}
+		}
+	}
+	return nil
+}
+
+// Listen returns the inherited listener matching the name or port if any, otherwise listens on the
+// address.
+func Listen(name, host string, port int) (net.Listener, error) {
+	if l := TakeInheritedListener(name, port); l != nil {
+		xlog.Info().Str("name", name).Stringer("addr", l.Addr()).Msg("Using inherited listener")
+		return l, nil
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
@@ -0,0 +1,88 @@
+package netx
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"get.pme.sh/pmesh/xlog"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Minimum interval between two warnings about file descriptor exhaustion.
+const fdWarnInterval = time.Minute
+
+var lastFDWarning atomic.Int64
+
+// IsFDExhausted returns true if the error is caused by the process or the system running out of file descriptors.
+func IsFDExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// FDUsage is the number of file descriptors opened by the process against its limit.
+type FDUsage struct {
+	Open  int32  `json:"open"`
+	Limit uint64 `json:"limit"` // Soft limit, zero if unknown.
+}
+
+func GetFDUsage() (u FDUsage, err error) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return
+	}
+	if u.Open, err = proc.NumFDs(); err != nil {
+		return
+	}
+	if limits, e := proc.Rlimit(); e == nil {
+		for _, l := range limits {
+			if l.Resource == process.RLIMIT_NOFILE {
+				u.Limit = l.Soft
+			}
+		}
+	}
+	return
+}
+
+// CheckFDExhausted returns true if the error is caused by file descriptor exhaustion, logging a warning
+// with the current usage at most once a minute so that the cause is not lost among the failures.
+func CheckFDExhausted(err error, op string) bool {
+	if !IsFDExhausted(err) {
+		return false
+	}
+	now := time.Now().UnixNano()
+	last := lastFDWarning.Load()
+	if now-last < int64(fdWarnInterval) || !lastFDWarning.CompareAndSwap(last, now) {
+		return true
+	}
+	ev := xlog.Warn().Err(err).Str("op", op)
+	if u, e := GetFDUsage(); e == nil {
+		ev = ev.Int32("open", u.Open).Uint64("limit", u.Limit)
+	}
+	if errors.Is(err, syscall.ENFILE) {
+		ev.Msg("System file table is full, raise fs.file-max or reduce the number of open files")
+	} else {
+		ev.Msg("Out of file descriptors, raise the limit with `ulimit -n` or LimitNOFILE in the service unit")
+	}
+	return true
+}
+
+type fdGuardListener struct {
+	net.Listener
+}
+
+func (l fdGuardListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		CheckFDExhausted(err, "accept")
+	}
+	return conn, err
+}
+
+// GuardListener wraps the listener to report the accept errors caused by file descriptor exhaustion.
+func GuardListener(l net.Listener) net.Listener {
+	return fdGuardListener{l}
+}
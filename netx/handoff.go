@@ -0,0 +1,192 @@
+//go:build !windows
+
+package netx
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// The listeners are handed off between two processes on the same host so that the binary can be
+// upgraded without refusing connections: the running node serves a unix socket, the new process
+// connects and sends the hello, receives the listeners along with their names (SCM_RIGHTS) and
+// acknowledges them, after which the running node stops accepting and drains. Connections arriving
+// in between wait in the backlog of the shared sockets. Not supported on Windows, where the new
+// process has to wait for the ports to be released and bind them again.
+const (
+	handoffHello   = "pmesh-handoff/1\n"
+	handoffAck     = "ok\n"
+	handoffTimeout = 10 * time.Second
+)
+
+type syscallListener interface {
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// Duplicates the descriptor of the listener without going through File, whose Fd switches the
+// socket of the running node to blocking mode.
+func dupListener(l net.Listener) (int, error) {
+	sl, ok := l.(syscallListener)
+	if !ok {
+		return -1, errors.New("no file descriptor")
+	}
+	rc, err := sl.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	dup := -1
+	cerr := rc.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if dup, err = syscall.Dup(int(fd)); err == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	return dup, cmp.Or(cerr, err)
+}
+
+// ServeHandoff serves the handoff of the named listeners on the unix socket at the path until the
+// context is done. Once they are passed and acknowledged, handoff is called and the socket closed.
+func ServeHandoff(ctx context.Context, path string, listeners map[string]net.Listener, handoff func()) error {
+	os.Remove(path) // Left over by a node that did not exit cleanly.
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	go func() {
+		defer stop()
+		defer ln.Close()
+		for {
+			conn, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			err = sendListeners(conn, listeners)
+			conn.Close()
+			if err != nil {
+				xlog.Warn().Err(err).Msg("Listener handoff failed")
+				continue
+			}
+			xlog.Info().Msg("Listeners handed off")
+			handoff()
+			return
+		}
+	}()
+	return nil
+}
+
+func sendListeners(conn *net.UnixConn, listeners map[string]net.Listener) error {
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	hello := make([]byte, len(handoffHello))
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return err
+	}
+	if string(hello) != handoffHello {
+		return errors.New("handoff: unexpected hello")
+	}
+
+	var names []string
+	var fds []int
+	for name, l := range listeners {
+		fd, err := dupListener(l)
+		if err != nil {
+			return fmt.Errorf("handoff: listener %q: %w", name, err)
+		}
+		defer syscall.Close(fd)
+		names = append(names, name)
+		fds = append(fds, fd)
+	}
+	if _, _, err := conn.WriteMsgUnix([]byte(strings.Join(names, ":")+"\n"), syscall.UnixRights(fds...), nil); err != nil {
+		return err
+	}
+
+	ack := make([]byte, len(handoffAck))
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return err
+	}
+	if string(ack) != handoffAck {
+		return errors.New("handoff: not acknowledged")
+	}
+	return nil
+}
+
+// RequestHandoff takes over the listeners of the node serving the handoff on the unix socket at the
+// path, Listen then uses them in place of binding.
+func RequestHandoff(path string) error {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	if _, err := io.WriteString(conn, handoffHello); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(64*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return err
+	}
+	var fds []int
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	var names []string
+	if line := strings.TrimSuffix(string(buf[:n]), "\n"); line != "" {
+		names = strings.Split(line, ":")
+	}
+
+	var received []*InheritedListener
+	if len(names) != len(fds) {
+		err = fmt.Errorf("handoff: got %d names for %d listeners", len(names), len(fds))
+	}
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "handoff-fd-"+fmt.Sprint(i))
+		if err == nil {
+			var l net.Listener
+			if l, err = net.FileListener(f); err == nil {
+				received = append(received, &InheritedListener{Listener: l, Name: names[i]})
+			}
+		}
+		f.Close()
+	}
+	if err == nil {
+		_, err = io.WriteString(conn, handoffAck)
+	}
+	if err != nil {
+		for _, l := range received {
+			l.Close()
+		}
+		return err
+	}
+
+	inherited.once.Do(collectInherited)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+	inherited.listeners = append(inherited.listeners, received...)
+	return nil
+}
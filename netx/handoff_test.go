@@ -0,0 +1,85 @@
+//go:build !windows
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "handoff.sock")
+	done := make(chan struct{})
+	err = ServeHandoff(ctx, path, map[string]net.Listener{ListenerHTTP: l}, func() { close(done) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RequestHandoff(path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handoff not signaled")
+	}
+
+	// The socket of the previous node is left non-blocking, its deadlines still apply.
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(50 * time.Millisecond))
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("accept: got %v, want a deadline error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept ignored its deadline")
+	}
+
+	// The previous node stops accepting, the connections are served by the new one.
+	l.Close()
+	taken := TakeInheritedListener(ListenerHTTP, 0)
+	if taken == nil {
+		t.Fatal("listener not inherited")
+	}
+	defer taken.Close()
+	go func() {
+		if conn, err := taken.Accept(); err == nil {
+			io.WriteString(conn, "new")
+			conn.Close()
+		}
+	}()
+	conn, err := net.DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if b, _ := io.ReadAll(conn); string(b) != "new" {
+		t.Errorf("got %q from port %d, want the new listener", b, port)
+	}
+
+	// Only handed off once.
+	if err := RequestHandoff(path); err == nil {
+		t.Error("second handoff succeeded")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ServeHandoff is not supported on Windows, see handoff.go.
+func ServeHandoff(ctx context.Context, path string, listeners map[string]net.Listener, handoff func()) error {
+	return errors.ErrUnsupported
+}
+
+// RequestHandoff is not supported on Windows, see handoff.go.
+func RequestHandoff(path string) error {
+	return errors.ErrUnsupported
+}
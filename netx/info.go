@@ -90,6 +90,25 @@ func (NullIPInfo) Country() CountryISO { return CountryISO{} }
 func (NullIPInfo) Flags() Flags        { return 0 }
 func (NullIPInfo) Unwrap(any) bool     { return false }
 
+// LocalIPInfo is the info of a loopback or private address, matching LocalIPInfoHeaders.
+type LocalIPInfo struct{ NullIPInfo }
+
+func (LocalIPInfo) Desc() string { return "Local" }
+
+// LocalIPInfoProvider is implemented by the providers holding info about loopback and private
+// addresses, which are otherwise not looked up.
+type LocalIPInfoProvider interface {
+	LookupLocal(IP) (IPInfo, bool)
+}
+
+// LookupLocal returns the info the provider holds about the local address, if any.
+func LookupLocal(p IPInfoProvider, ip IP) (IPInfo, bool) {
+	if l, ok := p.(LocalIPInfoProvider); ok {
+		return l.LookupLocal(ip)
+	}
+	return nil, false
+}
+
 func NormalizeOrg(k string) string {
 	k = strings.ToUpper(k)
 	k = strings.Map(func(r rune) rune {
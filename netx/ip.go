@@ -2,6 +2,7 @@ package netx
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -131,6 +132,12 @@ func (i IP) IsZero() bool {
 func (i IP) IsV4() bool {
 	return i.High == 0 && ((i.Low>>32) == 0xffff || i.Low == 0)
 }
+
+// Unlike IsV4, does not take the zero address for an IPv4 one, the prefix of a network needs it to tell
+// 0.0.0.0/0 and ::/0 apart.
+func (i IP) isMapped() bool {
+	return i.High == 0 && (i.Low>>32) == 0xffff
+}
 func (i IP) String() string {
 	return i.ToAddr().String()
 }
@@ -163,10 +170,11 @@ func (i IP) ToIP() net.IP {
 	return net.IP(i.ToSlice())
 }
 func (s IP) Compare(o IP) int64 {
+	// Not the difference, it overflows when the addresses are far apart.
 	if s.High != o.High {
-		return int64(s.High - o.High)
+		return int64(cmp.Compare(s.High, o.High))
 	} else {
-		return int64(s.Low - o.Low)
+		return int64(cmp.Compare(s.Low, o.Low))
 	}
 }
 func (s IP) Equal(o IP) bool {
@@ -201,6 +209,37 @@ func (ip IP) IsPrivate() bool {
 	return ip0&0xfe == 0xfc
 }
 
+// Prefixes of the transition addresses embedding an IPv4 address.
+const (
+	nat64Prefix  = 0x0064_ff9b_0000_0000 // 64:ff9b::/96, RFC 6052
+	teredoPrefix = 0x2001_0000           // 2001::/32, RFC 4380
+	sixToFour    = 0x2002                // 2002::/16, RFC 3056
+)
+
+// Unmap returns the IPv4 address embedded in a NAT64, 6to4 or Teredo address so that a client is treated
+// the same whichever way it reached us, other addresses are returned as is. IPv4-mapped addresses need
+// no unmapping, they are stored in their IPv4 form. Embedded addresses that are not public are ignored,
+// such an address can only be forged and must not pass for a local one.
+func (ip IP) Unmap() IP {
+	var v4 IP
+	switch {
+	case ip.IsV4():
+		return ip
+	case ip.High == nat64Prefix && ip.Low>>32 == 0:
+		v4 = IPv4Uint32(uint32(ip.Low))
+	case ip.High>>32 == teredoPrefix:
+		v4 = IPv4Uint32(^uint32(ip.Low)) // Obfuscated client address
+	case ip.High>>48 == sixToFour:
+		v4 = IPv4Uint32(uint32(ip.High >> 16))
+	default:
+		return ip
+	}
+	if !v4.IsPublic() {
+		return ip
+	}
+	return v4
+}
+
 type IPPort struct {
 	IP   IP
 	Port uint16
@@ -257,8 +296,11 @@ func ParseIPNet(ip string) (s IPNet) {
 	return
 }
 func FromIPNet(a *net.IPNet) IPNet {
-	ones, _ := a.Mask.Size()
-	if v4 := a.IP.To4(); v4 != nil {
+	ones, bits := a.Mask.Size()
+	if v4 := a.IP.To4(); v4 != nil && (bits == 32 || ones >= 96) {
+		if bits == 128 {
+			ones -= 96 // IPv4-mapped network with an IPv6 mask.
+		}
 		return IPNet{
 			IP:    FromIP(v4),
 			Shift: uint8(32 - ones),
@@ -277,7 +319,7 @@ func (i IPNet) ToIPNet() *net.IPNet {
 	}
 }
 func (i IPNet) Size() (ones, bits int) {
-	if i.IP.IsV4() {
+	if i.IP.isMapped() {
 		return 32 - int(i.Shift), 32
 	} else {
 		return 128 - int(i.Shift), 128
@@ -291,7 +333,11 @@ func (i IPNet) Contains(o IP) bool {
 }
 func (i IPNet) String() string {
 	ones, _ := i.Size()
-	return i.IP.String() + "/" + strconv.Itoa(ones)
+	ip := i.IP.String()
+	if i.IP.IsZero() {
+		ip = "::" // Not 0.0.0.0, see isMapped.
+	}
+	return ip + "/" + strconv.Itoa(ones)
 }
 func (i IPNet) MarshalText() ([]byte, error) {
 	return []byte(i.String()), nil
@@ -304,14 +350,21 @@ func (i *IPNet) UnmarshalText(b []byte) (e error) {
 	e = i.IP.UnmarshalText(b[:idx])
 	if e == nil {
 		v, ok := parseUint8(b[idx+1:])
-		if !ok {
-			return errors.New("invalid CIDR")
+		bits := uint8(128)
+		if i.IP.isMapped() {
+			bits = 32
+			if bytes.IndexByte(b[:idx], ':') >= 0 {
+				// Written in IPv6 form, e.g. ::ffff:10.0.0.0/104.
+				if v < 96 {
+					return errors.New("invalid CIDR")
+				}
+				v -= 96
+			}
 		}
-		if i.IP.IsV4() {
-			i.Shift = uint8(32 - v)
-		} else {
-			i.Shift = uint8(128 - v)
+		if !ok || v > bits {
+			return errors.New("invalid CIDR")
 		}
+		i.Shift = bits - v
 	}
 	return
 }
@@ -0,0 +1,25 @@
+package netx
+
+import "testing"
+
+func TestUnmap(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":                          "203.0.113.7",
+		"::ffff:203.0.113.7":                   "203.0.113.7",
+		"64:ff9b::cb00:7107":                   "203.0.113.7",
+		"64:ff9b::1:cb00:7107":                 "64:ff9b::1:cb00:7107", // Outside of the /96
+		"64:ff9b::a00:1":                       "64:ff9b::a00:1",       // Private
+		"2002:cb00:7107::1":                    "203.0.113.7",
+		"2002:c0a8:101::1":                     "2002:c0a8:101::1", // Private
+		"2002:7f00:1::1":                       "2002:7f00:1::1",   // Loopback
+		"2001:0:4136:e378:8000:63bf:34ff:8ef8": "203.0.113.7",
+		"2001:0:4136:e378:8000:63bf:f5ff:fefe": "2001:0:4136:e378:8000:63bf:f5ff:fefe", // 10.0.1.1
+		"2001:db8::cb00:7107":                  "2001:db8::cb00:7107",
+		"::1":                                  "::1",
+	}
+	for in, want := range tests {
+		if got := ParseIP(in).Unmap().String(); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package netx
 
-import "sort"
+import (
+	"slices"
+	"sort"
+)
 
 type ip6info[Info any] struct {
 	beg, end IP
@@ -27,7 +30,7 @@ func (s *IPMap[Info]) Sort() {
 }
 
 func (s *IPMap[Info]) Add(info *Info, min IP, max IP) {
-	if min.IsV4() {
+	if min.isMapped() {
 		imin := uint32(min.Low)
 		imax := uint32(max.Low)
 		wrapped := ip4info[Info]{imin, imax, info}
@@ -71,3 +74,68 @@ func (i2 *IPMap[Info]) Find(ip IP) (v *Info) {
 	}
 	return
 }
+
+// AddNet adds a network to the map, see Build for the networks that overlap.
+func (s *IPMap[Info]) AddNet(info *Info, n IPNet) {
+	beg, end := n.Range()
+	s.Add(info, beg, end)
+}
+
+// Build sorts the map, resolving the ranges that overlap so that the narrowest one wins, which is the
+// longest prefix match for networks. The ranges must be either nested or disjoint, as networks are.
+// Find requires it if the ranges overlap, Sort is enough otherwise.
+func (s *IPMap[Info]) Build() {
+	v4 := make([]ip6info[Info], len(s.v4))
+	for i, r := range s.v4 {
+		v4[i] = ip6info[Info]{IPv4Uint32(r.beg), IPv4Uint32(r.end), r.info}
+	}
+	v4 = flattenRanges(v4)
+	s.v4 = s.v4[:0]
+	for _, r := range v4 {
+		s.v4 = append(s.v4, ip4info[Info]{uint32(r.beg.Low), uint32(r.end.Low), r.info})
+	}
+	s.v6 = flattenRanges(s.v6)
+}
+
+// Splits the nested ranges into disjoint ones, each part belonging to the innermost range covering it.
+func flattenRanges[Info any](ranges []ip6info[Info]) []ip6info[Info] {
+	slices.SortStableFunc(ranges, func(a, b ip6info[Info]) int {
+		if c := a.beg.Compare(b.beg); c != 0 {
+			return int(c)
+		}
+		return int(b.end.Compare(a.end)) // Outermost first
+	})
+
+	var (
+		out    []ip6info[Info]
+		stack  []ip6info[Info]
+		cursor IP   // First address not yet assigned
+		done   bool // Assigned up to the last address
+	)
+	emit := func(end IP, info *Info) {
+		if done || end.Compare(cursor) < 0 {
+			return
+		}
+		out = append(out, ip6info[Info]{cursor, end, info})
+		cursor, done = end.next()
+	}
+	for _, r := range ranges {
+		for len(stack) > 0 && stack[len(stack)-1].end.Compare(r.beg) < 0 {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			emit(top.end, top.info)
+		}
+		if len(stack) > 0 && r.beg.Compare(cursor) > 0 {
+			top := stack[len(stack)-1]
+			emit(r.beg.prev(), top.info)
+		}
+		stack = append(stack, r)
+		cursor, done = r.beg, false
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		emit(top.end, top.info)
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package netx
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// Returns the first and last address of the network.
+func (i IPNet) Range() (beg, end IP) {
+	mask := IPFromMask(i.Shift)
+	beg = IP{Low: i.IP.Low &^ mask.Low, High: i.IP.High &^ mask.High}
+	return beg, beg.BitOr(mask)
+}
+
+// Returns the next address, wrapping around to zero past the last one.
+func (i IP) next() (IP, bool) {
+	i.Low++
+	if i.Low == 0 {
+		i.High++
+		return i, i.High == 0
+	}
+	return i, false
+}
+func (i IP) prev() IP {
+	if i.Low == 0 {
+		i.High--
+	}
+	i.Low--
+	return i
+}
+func (i IP) trailingZeros() int {
+	if i.Low != 0 {
+		return bits.TrailingZeros64(i.Low)
+	}
+	return 64 + bits.TrailingZeros64(i.High)
+}
+
+type ipRange struct {
+	beg, end IP
+}
+
+// Sorts and merges the overlapping or adjacent ranges.
+func mergeRanges(ranges []ipRange) []ipRange {
+	slices.SortFunc(ranges, func(a, b ipRange) int {
+		return int(a.beg.Compare(b.beg))
+	})
+	out := ranges[:0]
+	for _, r := range ranges {
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			if next, wrapped := last.end.next(); wrapped || r.beg.Compare(next) <= 0 {
+				if r.end.Compare(last.end) > 0 {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Returns the minimal list of networks covering the range, maxShift is the size of the address space.
+func (r ipRange) appendNets(nets []IPNet, maxShift int) []IPNet {
+	beg := r.beg
+	for {
+		shift := min(beg.trailingZeros(), maxShift)
+		for shift > 0 {
+			if last := beg.BitOr(IPFromMask(uint8(shift))); last.Compare(r.end) <= 0 {
+				break
+			}
+			shift--
+		}
+		nets = append(nets, IPNet{IP: beg, Shift: uint8(shift)})
+		last := beg.BitOr(IPFromMask(uint8(shift)))
+		if last == r.end {
+			return nets
+		}
+		beg, _ = last.next()
+	}
+}
+
+// Splits the networks by family into ranges.
+func splitRanges(nets []IPNet) (v4, v6 []ipRange) {
+	for _, n := range nets {
+		beg, end := n.Range()
+		if n.IP.isMapped() {
+			v4 = append(v4, ipRange{beg, end})
+		} else {
+			v6 = append(v6, ipRange{beg, end})
+		}
+	}
+	return mergeRanges(v4), mergeRanges(v6)
+}
+
+// SummarizeNets returns the minimal list of networks covering the same addresses, merging the ones
+// that overlap or are adjacent, IPv4 networks first.
+func SummarizeNets(nets []IPNet) []IPNet {
+	v4, v6 := splitRanges(nets)
+	var out []IPNet
+	for _, r := range v4 {
+		out = r.appendNets(out, 32)
+	}
+	for _, r := range v6 {
+		out = r.appendNets(out, 128)
+	}
+	return out
+}
+
+// IPSet is a set of networks answering membership tests with a binary search, the networks are merged
+// so that its size does not depend on how they were written.
+type IPSet struct {
+	v4, v6 []ipRange
+}
+
+func NewIPSet(nets ...IPNet) *IPSet {
+	s := &IPSet{}
+	s.v4, s.v6 = splitRanges(nets)
+	return s
+}
+
+// Contains returns true if the address belongs to one of the networks.
+func (s *IPSet) Contains(ip IP) bool {
+	if s == nil {
+		return false
+	}
+	arr := s.v6
+	if ip.IsV4() {
+		arr = s.v4
+	}
+	i, ok := slices.BinarySearchFunc(arr, ip, func(r ipRange, ip IP) int {
+		return int(r.end.Compare(ip))
+	})
+	return ok || (i < len(arr) && arr[i].beg.Compare(ip) <= 0)
+}
+
+// Len returns the number of disjoint ranges in the set.
+func (s *IPSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.v4) + len(s.v6)
+}
+
+// Nets returns the minimal list of networks of the set.
+func (s *IPSet) Nets() (out []IPNet) {
+	if s == nil {
+		return
+	}
+	for _, r := range s.v4 {
+		out = r.appendNets(out, 32)
+	}
+	for _, r := range s.v6 {
+		out = r.appendNets(out, 128)
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package netx
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func parseNets(s string) (nets []IPNet) {
+	for _, n := range strings.Fields(s) {
+		nets = append(nets, ParseIPNet(n))
+	}
+	return
+}
+func netsString(nets []IPNet) string {
+	var out []string
+	for _, n := range nets {
+		out = append(out, n.String())
+	}
+	return strings.Join(out, " ")
+}
+
+func TestSummarizeNets(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", ""},
+		{"single", "10.0.0.0/8", "10.0.0.0/8"},
+		{"host bits", "10.1.2.3/8", "10.0.0.0/8"},
+		{"adjacent", "10.0.0.0/25 10.0.0.128/25", "10.0.0.0/24"},
+		{"adjacent unaligned", "10.0.0.128/25 10.0.1.0/24", "10.0.0.128/25 10.0.1.0/24"},
+		{"adjacent chain", "10.0.3.0/24 10.0.0.0/24 10.0.2.0/24 10.0.1.0/24", "10.0.0.0/22"},
+		{"overlapping", "10.0.0.0/23 10.0.1.0/24 10.0.2.0/23", "10.0.0.0/22"},
+		{"nested", "10.0.0.0/8 10.1.0.0/16 10.1.1.1/32", "10.0.0.0/8"},
+		{"duplicate", "192.0.2.1/32 192.0.2.1/32", "192.0.2.1/32"},
+		{"disjoint", "192.0.2.0/24 10.0.0.0/8", "10.0.0.0/8 192.0.2.0/24"},
+		{"v4 mapped", "::ffff:10.0.0.0/104 10.0.0.0/9", "10.0.0.0/8"},
+		{"v4 mapped adjacent", "::ffff:10.0.0.0/105 10.128.0.0/9", "10.0.0.0/8"},
+		{"v4 mapped host", "::ffff:10.0.0.1/128 10.0.0.0/32", "10.0.0.0/31"},
+		{"v4 all", "0.0.0.0/1 128.0.0.0/1", "0.0.0.0/0"},
+		{"v6 adjacent", "2001:db8::/33 2001:db8:8000::/33", "2001:db8::/32"},
+		{"v6 nested", "2001:db8::/32 2001:db8:1::/48", "2001:db8::/32"},
+		{"v6 high half", "2001:db8::8000:0:0:0/65 2001:db8::/65", "2001:db8::/64"},
+		{"v6 all", "::/1 8000::/1", "::/0"},
+		{"families apart", "2001:db8::/32 10.0.0.0/8 fc00::/7", "10.0.0.0/8 2001:db8::/32 fc00::/7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := netsString(SummarizeNets(parseNets(tt.in)))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if set := NewIPSet(parseNets(tt.in)...); netsString(set.Nets()) != tt.want {
+				t.Errorf("set nets %q, want %q", netsString(set.Nets()), tt.want)
+			}
+		})
+	}
+}
+
+func TestIPSetContains(t *testing.T) {
+	set := NewIPSet(parseNets("10.0.0.0/24 10.0.1.0/24 192.0.2.128/25 2001:db8::/48 2001:db8:1::/48 ::ffff:198.51.100.0/120")...)
+	if set.Len() != 4 {
+		t.Errorf("len = %d, want 4", set.Len())
+	}
+	tests := map[string]bool{
+		"10.0.0.0":          true,
+		"10.0.1.255":        true,
+		"10.0.2.0":          false,
+		"9.255.255.255":     false,
+		"192.0.2.127":       false,
+		"192.0.2.128":       true,
+		"192.0.2.255":       true,
+		"::ffff:10.0.0.1":   true,
+		"198.51.100.7":      true,
+		"198.51.101.0":      false,
+		"2001:db8::1":       true,
+		"2001:db8:1:ffff::": true,
+		"2001:db8:2::":      false,
+		"2001:db7::":        false,
+		"::a00:1":           false, // IPv4-compatible, not mapped
+	}
+	for addr, want := range tests {
+		if got := set.Contains(ParseIP(addr)); got != want {
+			t.Errorf("%s: got %v, want %v", addr, got, want)
+		}
+	}
+	var empty *IPSet
+	if empty.Contains(ParseIP("10.0.0.1")) || empty.Len() != 0 || empty.Nets() != nil {
+		t.Error("nil set not empty")
+	}
+}
+
+func TestIPMapBuild(t *testing.T) {
+	names := []string{"outer", "inner", "host", "other", "v6", "v6inner", "mapped"}
+	nets := parseNets("10.0.0.0/8 10.1.0.0/16 10.1.0.5/32 10.2.0.0/16 2001:db8::/32 2001:db8:1::/48 ::ffff:192.0.2.0/120")
+	var m IPMap[string]
+	for i, n := range nets {
+		m.AddNet(&names[i], n)
+	}
+	m.Build()
+	tests := map[string]string{
+		"10.0.0.0":          "outer",
+		"10.1.0.0":          "inner",
+		"10.1.0.4":          "inner",
+		"10.1.0.5":          "host",
+		"10.1.0.6":          "inner",
+		"10.1.255.255":      "inner",
+		"10.2.0.1":          "other",
+		"10.3.0.0":          "outer",
+		"10.255.255.255":    "outer",
+		"11.0.0.0":          "",
+		"9.255.255.255":     "",
+		"192.0.2.1":         "mapped",
+		"::ffff:192.0.2.1":  "mapped",
+		"2001:db8::1":       "v6",
+		"2001:db8:1::1":     "v6inner",
+		"2001:db8:2::":      "v6",
+		"2001:db8:ffff::":   "v6",
+		"2001:db9::":        "",
+		"::ffff:0a01:0005":  "host",
+		"64:ff9b::a01:5":    "",
+		"2001:db8:0:1::":    "v6",
+		"2001:db8:1:ffff::": "v6inner",
+	}
+	for addr, want := range tests {
+		got := ""
+		if v := m.Find(ParseIP(addr)); v != nil {
+			got = *v
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", addr, got, want)
+		}
+	}
+
+	// Same result whatever the order the networks are added in.
+	var rev IPMap[string]
+	for i := len(nets) - 1; i >= 0; i-- {
+		rev.AddNet(&names[i], nets[i])
+	}
+	rev.Build()
+	for addr := range tests {
+		if m.Find(ParseIP(addr)) != rev.Find(ParseIP(addr)) {
+			t.Errorf("%s: depends on the order of the networks", addr)
+		}
+	}
+}
+
+func TestParseMappedNet(t *testing.T) {
+	want := ParseIPNet("10.0.0.0/8")
+	if got := ParseIPNet("::ffff:10.0.0.0/104"); got != want {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+	_, n, err := net.ParseCIDR("::ffff:10.0.0.0/104")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FromIPNet(n); got != want {
+		t.Errorf("converted %v, want %v", got, want)
+	}
+	var invalid IPNet
+	if err := invalid.UnmarshalText([]byte("::ffff:10.0.0.0/64")); err == nil {
+		t.Errorf("parsed %v", invalid)
+	}
+}
@@ -75,6 +75,7 @@ func (d LocalDialer) DialContext(ctx context.Context, network, addr string) (net
 	dialer := d.Dialer
 	conn, err := MakeLocalDialer(&dialer).DialContext(ctx, network, addr)
 	if err != nil {
+		CheckFDExhausted(err, "dial")
 		return nil, err
 	}
 	return NewTracedConn(conn), nil
@@ -91,9 +92,12 @@ func MakeLocalTransport(idle int, max int, opts *http.Transport) *http.Transport
 	return opts
 }
 
+// Requests with "Expect: 100-continue" only send their body once the app agrees, so that the
+// uploads it rejects are not transferred, the client being asked for the body as it is read.
 var LocalTransport = MakeLocalTransport(16384, 0, &http.Transport{
 	TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
 	ResponseHeaderTimeout: 1 * time.Minute,
+	ExpectContinueTimeout: 1 * time.Second,
 })
 var LocalH2Transport = &http2.Transport{
 	DisableCompression: true,
@@ -105,6 +109,7 @@ var LocalH2Transport = &http2.Transport{
 		}
 		conn, err := tdial.DialContext(ctx, network, addr)
 		if err != nil {
+			CheckFDExhausted(err, "dial")
 			return nil, err
 		}
 		return NewTracedConn(conn), nil
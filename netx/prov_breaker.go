@@ -0,0 +1,76 @@
+package netx
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// BreakerProvider is a circuit breaker around a provider whose lookups may wait on the network. A lookup
+// that does not complete within the timeout counts as a failure, once the failures reach the threshold the
+// lookups are answered with NullIPInfo right away, letting a single one through every cooldown to probe
+// whether the provider recovered.
+type BreakerProvider struct {
+	Inner     IPInfoProvider
+	Timeout   time.Duration // Deadline of a lookup
+	Threshold int32         // Consecutive failures opening the breaker
+	Cooldown  time.Duration // Time between two probes while open
+
+	failures  atomic.Int32
+	openUntil atomic.Int64 // Unix nanoseconds until the next probe, zero if closed
+	probing   atomic.Bool
+}
+
+func NewBreakerProvider(inner IPInfoProvider, timeout time.Duration) *BreakerProvider {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &BreakerProvider{
+		Inner:     inner,
+		Timeout:   timeout,
+		Threshold: 3,
+		Cooldown:  30 * time.Second,
+	}
+}
+
+// Open returns true if the lookups are short-circuited.
+func (b *BreakerProvider) Open() bool {
+	return b.openUntil.Load() != 0
+}
+
+func (b *BreakerProvider) LookupContext(ctx context.Context, ip IP) IPInfo {
+	if until := b.openUntil.Load(); until != 0 {
+		if time.Now().UnixNano() < until || !b.probing.CompareAndSwap(false, true) {
+			return NullIPInfo{}
+		}
+		defer b.probing.Store(false)
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, b.Timeout)
+	defer cancel()
+	info := b.Inner.LookupContext(lctx, ip)
+	if ctx.Err() != nil {
+		// The caller went away, says nothing about the provider.
+	} else if lctx.Err() != nil {
+		b.fail()
+	} else {
+		b.succeed()
+	}
+	return info
+}
+func (b *BreakerProvider) fail() {
+	if b.failures.Add(1) < b.Threshold {
+		return
+	}
+	if b.openUntil.Swap(time.Now().Add(b.Cooldown).UnixNano()) == 0 {
+		xlog.Warn().Dur("timeout", b.Timeout).Msg("IP info lookups are timing out, skipping them until the provider recovers")
+	}
+}
+func (b *BreakerProvider) succeed() {
+	b.failures.Store(0)
+	if b.openUntil.Swap(0) != 0 {
+		xlog.Info().Msg("IP info provider recovered")
+	}
+}
@@ -0,0 +1,87 @@
+package netx
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type asnInfo struct {
+	NullIPInfo
+	asn uint32
+}
+
+func (i asnInfo) ASN() uint32 { return i.asn }
+
+// Answers right away, or once the context is done while stalling.
+type stallingProvider struct {
+	stall atomic.Bool
+	calls atomic.Int32
+}
+
+func (p *stallingProvider) LookupContext(ctx context.Context, ip IP) IPInfo {
+	p.calls.Add(1)
+	if p.stall.Load() {
+		<-ctx.Done()
+		return NullIPInfo{}
+	}
+	return asnInfo{asn: 64500}
+}
+
+func TestBreakerProvider(t *testing.T) {
+	inner := &stallingProvider{}
+	b := NewBreakerProvider(inner, 20*time.Millisecond)
+	b.Cooldown = 50 * time.Millisecond
+	ip := ParseIP("192.0.2.1")
+	ctx := context.Background()
+
+	if info := b.LookupContext(ctx, ip); info.ASN() != 64500 || b.Open() {
+		t.Fatalf("got ASN %d, open %v", info.ASN(), b.Open())
+	}
+
+	// Opens after the threshold of timed out lookups.
+	inner.stall.Store(true)
+	for i := range b.Threshold {
+		if b.Open() {
+			t.Fatalf("opened after %d failures", i)
+		}
+		b.LookupContext(ctx, ip)
+	}
+	if !b.Open() {
+		t.Fatal("not opened")
+	}
+	calls := inner.calls.Load()
+	start := time.Now()
+	if info := b.LookupContext(ctx, ip); info.ASN() != 0 || inner.calls.Load() != calls {
+		t.Error("lookup not short-circuited while open")
+	}
+	if d := time.Since(start); d >= b.Timeout {
+		t.Errorf("short-circuited lookup took %v", d)
+	}
+
+	// A probe after the cooldown that still times out keeps it open.
+	time.Sleep(b.Cooldown)
+	b.LookupContext(ctx, ip)
+	if inner.calls.Load() != calls+1 || !b.Open() {
+		t.Fatalf("probe not let through or closed the breaker: %d calls, open %v", inner.calls.Load()-calls, b.Open())
+	}
+
+	// A successful probe closes it.
+	inner.stall.Store(false)
+	time.Sleep(b.Cooldown)
+	if info := b.LookupContext(ctx, ip); info.ASN() != 64500 || b.Open() {
+		t.Fatalf("got ASN %d, open %v after recovery", info.ASN(), b.Open())
+	}
+
+	// A caller going away does not count as a failure.
+	inner.stall.Store(true)
+	for range b.Threshold {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		b.LookupContext(cctx, ip)
+	}
+	if b.Open() {
+		t.Error("opened by cancelled lookups")
+	}
+}
@@ -0,0 +1,129 @@
+package netx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// StaticIPInfo is the info an operator gives to a range of addresses, the fields left empty are looked up.
+type StaticIPInfo struct {
+	ASN     uint32     `yaml:"asn,omitempty"`
+	Org     string     `yaml:"org,omitempty"`
+	Country CountryISO `yaml:"country,omitempty"`
+	VPN     bool       `yaml:"vpn,omitempty"`
+	CF      bool       `yaml:"cf,omitempty"`
+	Marked  bool       `yaml:"marked,omitempty"`
+}
+
+func (s *StaticIPInfo) complete() bool {
+	return s.ASN != 0 && s.Org != "" && s.Country.IsValid()
+}
+func (s *StaticIPInfo) flags() (f Flags) {
+	if s.VPN {
+		f |= FlagVPN
+	}
+	if s.CF {
+		f |= FlagCF
+	}
+	if s.Marked {
+		f |= FlagMarked
+	}
+	return
+}
+
+// StaticIPMap maps addresses or CIDR ranges to their info, the most specific range wins.
+type StaticIPMap map[string]StaticIPInfo
+
+// Parses the ranges into a map resolving the most specific one.
+func (m StaticIPMap) build() (*IPMap[StaticIPInfo], error) {
+	set := &IPMap[StaticIPInfo]{}
+	for k, info := range m {
+		var n IPNet
+		if strings.IndexByte(k, '/') < 0 {
+			if err := n.IP.UnmarshalText([]byte(k)); err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", k, err)
+			}
+		} else if err := n.UnmarshalText([]byte(k)); err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", k, err)
+		}
+		info.Country = CountryISO([]byte(strings.ToUpper(info.Country.String())))
+		if info.Org != "" {
+			info.Org = NormalizeOrg(info.Org)
+		}
+		set.AddNet(&info, n)
+	}
+	set.Build()
+	return set, nil
+}
+
+// Validate checks the ranges.
+func (m StaticIPMap) Validate() error {
+	_, err := m.build()
+	return err
+}
+
+type staticInfo struct {
+	s *StaticIPInfo
+	i IPInfo
+}
+
+func (s staticInfo) ASN() uint32 {
+	if s.s.ASN != 0 {
+		return s.s.ASN
+	}
+	return s.i.ASN()
+}
+func (s staticInfo) Desc() string {
+	if s.s.Org != "" {
+		return s.s.Org
+	}
+	return s.i.Desc()
+}
+func (s staticInfo) Country() CountryISO {
+	if s.s.Country.IsValid() {
+		return s.s.Country
+	}
+	return s.i.Country()
+}
+func (s staticInfo) Flags() Flags { return s.s.flags() | s.i.Flags() }
+func (s staticInfo) Unwrap(v any) bool {
+	if p, ok := v.(*StaticIPInfo); ok {
+		*p = *s.s
+		return true
+	}
+	return s.i.Unwrap(v)
+}
+
+// StaticProvider answers the lookups of the addresses in the map ahead of the inner provider. Loopback
+// and private addresses are answered from the map alone, see LookupLocal.
+type StaticProvider struct {
+	inner IPInfoProvider
+	set   *IPMap[StaticIPInfo]
+}
+
+func NewStaticProvider(inner IPInfoProvider, m StaticIPMap) (*StaticProvider, error) {
+	set, err := m.build()
+	if err != nil {
+		return nil, err
+	}
+	return &StaticProvider{inner: inner, set: set}, nil
+}
+
+func (p *StaticProvider) LookupContext(ctx context.Context, ip IP) IPInfo {
+	info := p.set.Find(ip)
+	if info == nil {
+		return p.inner.LookupContext(ctx, ip)
+	}
+	if info.complete() {
+		return staticInfo{info, NullIPInfo{}}
+	}
+	return staticInfo{info, p.inner.LookupContext(ctx, ip)}
+}
+func (p *StaticProvider) LookupLocal(ip IP) (IPInfo, bool) {
+	info := p.set.Find(ip)
+	if info == nil {
+		return nil, false
+	}
+	return staticInfo{info, LocalIPInfo{}}, true
+}
@@ -0,0 +1,81 @@
+package netx
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+type countingProvider struct{ calls atomic.Int32 }
+
+func (p *countingProvider) LookupContext(ctx context.Context, ip IP) IPInfo {
+	p.calls.Add(1)
+	return asnInfo{asn: 64500}
+}
+
+func TestStaticProvider(t *testing.T) {
+	inner := &countingProvider{}
+	p, err := NewStaticProvider(inner, StaticIPMap{
+		"203.0.113.0/24":  {ASN: 64501, Org: "Example, Inc.", Country: CountryISO{'u', 's'}},
+		"203.0.113.7":     {Country: CountryISO{'D', 'E'}, VPN: true},
+		"2001:db8::/32":   {Org: "doc net", Marked: true},
+		"10.0.0.0/8":      {Org: "office"},
+		"198.51.100.0/24": {ASN: 64502, Org: "partial"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tests := []struct {
+		ip      string
+		asn     uint32
+		org     string
+		country string
+		flags   Flags
+		lookup  bool
+	}{
+		{"203.0.113.1", 64501, "EXAMPLE-INC", "US", 0, false},
+		{"203.0.113.7", 64500, "", "DE", FlagVPN, true}, // Most specific wins, the rest is looked up.
+		{"198.51.100.1", 64502, "PARTIAL", "", 0, true},
+		{"2001:db8::1", 64500, "DOC-NET", "", FlagMarked, true},
+		{"192.0.2.1", 64500, "", "", 0, true},
+	}
+	for _, tt := range tests {
+		before := inner.calls.Load()
+		info := p.LookupContext(ctx, ParseIP(tt.ip))
+		country := ""
+		if info.Country().IsValid() {
+			country = info.Country().String()
+		}
+		if info.ASN() != tt.asn || info.Desc() != tt.org || country != tt.country || info.Flags() != tt.flags {
+			t.Errorf("%s: got %d %q %q %v", tt.ip, info.ASN(), info.Desc(), country, info.Flags())
+		}
+		if looked := inner.calls.Load() != before; looked != tt.lookup {
+			t.Errorf("%s: looked up = %v, want %v", tt.ip, looked, tt.lookup)
+		}
+	}
+
+	var s StaticIPInfo
+	if !p.LookupContext(ctx, ParseIP("203.0.113.7")).Unwrap(&s) || !s.VPN {
+		t.Errorf("unwrapped %+v", s)
+	}
+
+	// Local addresses are answered from the map alone.
+	if info, ok := LookupLocal(p, ParseIP("10.1.2.3")); !ok || info.Desc() != "OFFICE" {
+		t.Errorf("local: %v %v", info, ok)
+	}
+	if info, ok := LookupLocal(p, ParseIP("127.0.0.1")); ok {
+		t.Errorf("unmapped local: %v", info)
+	}
+}
+
+func TestStaticIPMapValidate(t *testing.T) {
+	for _, k := range []string{"203.0.113.0/33", "203.0.113", "example.com", "2001:db8::/129"} {
+		if err := (StaticIPMap{k: {ASN: 1}}).Validate(); err == nil {
+			t.Errorf("%q: valid", k)
+		}
+	}
+	if err := (StaticIPMap{"203.0.113.0/24": {}, "2001:db8::1": {}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -30,15 +30,23 @@ func (s markerInfo) Country() CountryISO { return s.i.Country() }
 func (s markerInfo) Flags() Flags        { return s.i.Flags() | FlagMarked }
 func (s markerInfo) Unwrap(v any) bool   { return s.i.Unwrap(v) }
 
-func (s *MarkerProvider) LookupContext(ctx context.Context, ip IP) (info IPInfo) {
-	info = s.inner.LookupContext(ctx, ip)
+func (s *MarkerProvider) mark(info IPInfo) IPInfo {
 	if desc := info.Desc(); desc != "" {
 		for _, s := range s.list[desc[0]] {
 			if strings.HasPrefix(desc, s) {
-				info = markerInfo{info}
-				break
+				return markerInfo{info}
 			}
 		}
 	}
-	return
+	return info
+}
+func (s *MarkerProvider) LookupContext(ctx context.Context, ip IP) IPInfo {
+	return s.mark(s.inner.LookupContext(ctx, ip))
+}
+func (s *MarkerProvider) LookupLocal(ip IP) (IPInfo, bool) {
+	info, ok := LookupLocal(s.inner, ip)
+	if !ok {
+		return nil, false
+	}
+	return s.mark(info), true
 }
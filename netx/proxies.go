@@ -2,6 +2,8 @@ package netx
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 )
@@ -27,14 +29,17 @@ type ProxyTraits struct {
 	CountryHint CountryISO
 }
 
-func findFirstPublicIPInForwardList(values []string) IP {
-	for _, value := range values {
-		ip := ParseIP(strings.TrimSpace(value))
-		if ip.IsPublic() {
-			return ip
+// Parses an address of a forwarding header, which may be quoted or carry a port.
+func parseForwardedIP(value string) IP {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if strings.HasPrefix(value, "[") {
+		if end := strings.IndexByte(value, ']'); end > 0 {
+			value = value[1:end]
 		}
+	} else if host, _, ok := strings.Cut(value, ":"); ok && strings.Count(value, ":") == 1 {
+		value = host
 	}
-	return IP{}
+	return ParseIP(value).Unmap()
 }
 
 func IsCloudflareIP(ip IP) (bool, error) {
@@ -42,35 +47,190 @@ func IsCloudflareIP(ip IP) (bool, error) {
 	return (res.Flags() & FlagCF) == FlagCF, nil
 }
 
-func ResolveProxyTraits(request *http.Request) (traits ProxyTraits) {
-	addrPort := ParseIPPort(request.RemoteAddr)
-	traits.Edge = addrPort.IP
-	if traits.Edge.IsZero() {
-		panic("invalid remote address")
+// Special proxies of a trusted header.
+const (
+	TrustLocal      = "local"      // Loopback and private networks
+	TrustCloudflare = "cloudflare" // Cloudflare's ranges
+)
+
+// TrustedHeader is a header carrying the address of the client, trusted only if the request comes from
+// one of the proxies.
+type TrustedHeader struct {
+	Header   string   `yaml:"header"`
+	Proxies  []string `yaml:"proxies,omitempty"`  // CIDR ranges of the proxies setting it, local or cloudflare, default = local
+	Position int      `yaml:"position,omitempty"` // Position of the address in the list, negative counts from the end, default = first public address
+	Country  string   `yaml:"country,omitempty"`  // Header carrying the country of the client
+
+	// Walks the list from the end past the addresses of the proxies, the first other one being the client,
+	// instead of taking the address at the position. Each proxy appends the address it received the request
+	// from, so the entries before the last untrusted hop may be set by the client.
+	SkipProxies bool `yaml:"skip_proxies,omitempty"`
+}
+
+type proxyRule struct {
+	header, country string
+	nets            *IPSet
+	local, cf       bool
+	skipProxies     bool
+	position        int
+}
+
+func (r *proxyRule) trusts(ip IP) bool {
+	if r.local && (ip.IsLoopback() || ip.IsPrivate()) {
+		return true
+	}
+	if r.nets.Contains(ip) {
+		return true
 	}
-	traits.Origin = traits.Edge
-
-	// Trust X-Forwarded-For only if the origin is loopback or private
-	if values, ok := request.Header[hdrXForwardedFor]; ok {
-		if traits.Origin.IsLoopback() || traits.Origin.IsPrivate() {
-			if adr := findFirstPublicIPInForwardList(values); !adr.IsZero() {
-				traits.Proxier = ProxierGeneric
-				traits.Origin = adr
+	if r.cf {
+		iscf, e := IsCloudflareIP(ip)
+		return e == nil && iscf
+	}
+	return false
+}
+
+// Returns the address at the position of the forwarding list, counting from the end if negative, or
+// the first public address if zero. If the proxies are skipped, the last address not from one of them is
+// returned instead. Non-public addresses are ignored.
+func (r *proxyRule) pick(values []string) IP {
+	var list []string
+	for _, line := range values {
+		list = append(list, strings.Split(line, ",")...)
+	}
+	if r.skipProxies {
+		for i := len(list) - 1; i >= 0; i-- {
+			// Unmapped before the trust check, a proxy is recognized in any of its forms.
+			ip := parseForwardedIP(list[i])
+			if ip.IsZero() {
+				return IP{}
+			}
+			if !r.trusts(ip) {
+				if ip.IsPublic() {
+					return ip
+				}
+				return IP{}
 			}
 		}
+		return IP{}
 	}
+	position := r.position
+	if position == 0 {
+		for _, value := range list {
+			if ip := parseForwardedIP(value); ip.IsPublic() {
+				return ip
+			}
+		}
+		return IP{}
+	}
+	if position < 0 {
+		position += len(list)
+	} else {
+		position--
+	}
+	if position < 0 || position >= len(list) {
+		return IP{}
+	}
+	if ip := parseForwardedIP(list[position]); ip.IsPublic() {
+		return ip
+	}
+	return IP{}
+}
+
+// ProxyRules resolves the client address of the requests, consulting the trusted headers in order.
+type ProxyRules struct {
+	rules []proxyRule
+}
+
+// DefaultProxyRules trusts X-Forwarded-For from the local proxies and CF-Connecting-IP from Cloudflare.
+var DefaultProxyRules = &ProxyRules{rules: []proxyRule{
+	{header: hdrXForwardedFor, local: true},
+	{header: hdrCfConnectingIP, cf: true, country: hdrCfCountry},
+}}
 
-	// Trust CF-Connecting-IP only if the origin is cloudflare
-	if values, ok := request.Header[hdrCfConnectingIP]; ok {
-		if iscf, e := IsCloudflareIP(traits.Origin); e == nil && iscf {
-			if adr := findFirstPublicIPInForwardList(values); !adr.IsZero() {
-				traits.Proxier = ProxierCloudflare
-				traits.Origin = adr
-				if values := request.Header[hdrCfCountry]; len(values) == 1 {
-					if v := values[0]; len(v) == 2 {
-						traits.CountryHint = CountryISO{v[0], v[1]}
+// NewProxyRules compiles the trusted headers, the default rules are used if there are none.
+func NewProxyRules(headers []TrustedHeader) (*ProxyRules, error) {
+	if len(headers) == 0 {
+		return DefaultProxyRules, nil
+	}
+	r := &ProxyRules{}
+	for _, h := range headers {
+		if h.Header == "" {
+			return nil, errors.New("trusted header without a name")
+		}
+		rule := proxyRule{
+			header:      http.CanonicalHeaderKey(h.Header),
+			position:    h.Position,
+			skipProxies: h.SkipProxies,
+		}
+		if h.Country != "" {
+			rule.country = http.CanonicalHeaderKey(h.Country)
+		}
+		if len(h.Proxies) == 0 {
+			rule.local = true
+		}
+		var nets []IPNet
+		for _, p := range h.Proxies {
+			switch p {
+			case TrustLocal:
+				rule.local = true
+			case TrustCloudflare:
+				rule.cf = true
+			default:
+				var n IPNet
+				if strings.IndexByte(p, '/') < 0 {
+					if err := n.IP.UnmarshalText([]byte(p)); err != nil {
+						return nil, fmt.Errorf("trusted header %s: invalid proxy %q", h.Header, p)
 					}
+				} else if err := n.UnmarshalText([]byte(p)); err != nil {
+					return nil, fmt.Errorf("trusted header %s: invalid proxy %q", h.Header, p)
 				}
+				nets = append(nets, n)
+			}
+		}
+		if len(nets) > 0 {
+			rule.nets = NewIPSet(nets...)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+func ResolveProxyTraits(request *http.Request) ProxyTraits {
+	return DefaultProxyRules.Resolve(request)
+}
+
+// Resolve returns the proxy traits of the request, each trusted header replacing the origin if the hop
+// it came from is one of its proxies.
+func (r *ProxyRules) Resolve(request *http.Request) (traits ProxyTraits) {
+	addrPort := ParseIPPort(request.RemoteAddr)
+	traits.Edge = addrPort.IP
+	if traits.Edge.IsZero() {
+		panic("invalid remote address")
+	}
+	traits.Origin = traits.Edge.Unmap()
+
+	// The trust decisions are made on the address as received rather than the one embedded in a
+	// transition address, which the sender could forge.
+	hop := traits.Edge
+	for i := range r.rules {
+		rule := &r.rules[i]
+		values, ok := request.Header[rule.header]
+		if !ok || !rule.trusts(hop) {
+			continue
+		}
+		adr := rule.pick(values)
+		if adr.IsZero() {
+			continue
+		}
+		traits.Proxier = ProxierGeneric
+		if rule.cf {
+			traits.Proxier = ProxierCloudflare
+		}
+		traits.Origin = adr
+		hop = adr
+		if values := request.Header[rule.country]; rule.country != "" && len(values) == 1 {
+			if v := values[0]; len(v) == 2 {
+				traits.CountryHint = CountryISO{v[0], v[1]}
 			}
 		}
 	}
@@ -0,0 +1,94 @@
+package netx
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResolveProxyTraits(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		origin string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"direct nat64", "[64:ff9b::cb00:7107]:1234", nil, "203.0.113.7"},
+		{"direct 6to4", "[2002:cb00:7107::1]:1234", nil, "203.0.113.7"},
+		{"direct teredo", "[2001:0:4136:e378:8000:63bf:34ff:8ef8]:1234", nil, "203.0.113.7"},
+		{"forged 6to4 is not local", "[2002:c0a8:101::1]:1234", []string{"198.51.100.1"}, "2002:c0a8:101::1"},
+		{"untrusted proxy", "198.51.100.1:1234", []string{"203.0.113.7"}, "198.51.100.1"},
+		{"local proxy", "10.0.0.1:1234", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"port and quotes", "10.0.0.1:1234", []string{`"203.0.113.7:443"`}, "203.0.113.7"},
+		{"bracketed v6", "10.0.0.1:1234", []string{"[2001:db8::1]:443"}, "2001:db8::1"},
+		{"forwarded nat64", "10.0.0.1:1234", []string{"64:ff9b::cb00:7107"}, "203.0.113.7"},
+		{"first public entry", "10.0.0.1:1234", []string{"192.0.2.1, 203.0.113.7"}, "192.0.2.1"},
+		{"first public line", "10.0.0.1:1234", []string{"192.0.2.1", "203.0.113.7"}, "192.0.2.1"},
+		{"local entries are skipped", "10.0.0.1:1234", []string{"10.0.0.3, 203.0.113.7, 10.0.0.2"}, "203.0.113.7"},
+		{"only local hops", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.1"},
+		{"garbage", "10.0.0.1:1234", []string{"nope"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			traits := ResolveProxyTraits(r)
+			if got := traits.Origin.String(); got != tt.origin {
+				t.Errorf("origin = %s, want %s", got, tt.origin)
+			}
+		})
+	}
+}
+
+func TestProxyRulesPosition(t *testing.T) {
+	rules, err := NewProxyRules([]TrustedHeader{
+		{Header: "X-Real-IP", Proxies: []string{"198.51.100.0/24"}, Position: 1},
+		{Header: "X-Forwarded-For", Proxies: []string{"198.51.100.0/24"}, Position: -2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &http.Request{RemoteAddr: "198.51.100.1:1234", Header: http.Header{}}
+	r.Header.Set("X-Forwarded-For", "192.0.2.1, 203.0.113.7, 198.51.100.2")
+	if got := rules.Resolve(r).Origin.String(); got != "203.0.113.7" {
+		t.Errorf("origin = %s, want 203.0.113.7", got)
+	}
+
+	// The origin is not a proxy, the second header is not trusted.
+	r.Header.Set("X-Real-IP", "192.0.2.9")
+	if got := rules.Resolve(r).Origin.String(); got != "192.0.2.9" {
+		t.Errorf("origin = %s, want 192.0.2.9", got)
+	}
+}
+
+func TestProxyRulesSkipProxies(t *testing.T) {
+	rules, err := NewProxyRules([]TrustedHeader{
+		{Header: "X-Forwarded-For", Proxies: []string{"local", "198.51.100.0/24"}, SkipProxies: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		xff    string
+		origin string
+	}{
+		{"spoofed entries are skipped", "192.0.2.1, 203.0.113.7", "203.0.113.7"},
+		{"proxies are skipped", "192.0.2.1, 203.0.113.7, 198.51.100.2, 10.0.0.2", "203.0.113.7"},
+		{"mapped proxies are skipped", "192.0.2.1, 203.0.113.7, [::ffff:10.0.0.2]:80, 64:ff9b::c633:6402", "203.0.113.7"},
+		{"forged 6to4 is not a proxy", "203.0.113.7, 2002:c0a8:101::1", "2002:c0a8:101::1"},
+		{"only proxies", "172.16.0.1, 198.51.100.2", "10.0.0.1"},
+		{"garbage", "203.0.113.7, nope", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: "10.0.0.1:1234", Header: http.Header{}}
+			r.Header.Set("X-Forwarded-For", tt.xff)
+			if got := rules.Resolve(r).Origin.String(); got != tt.origin {
+				t.Errorf("origin = %s, want %s", got, tt.origin)
+			}
+		})
+	}
+}
@@ -19,6 +19,9 @@ import (
 
 const remoteRecheckInterval = 32 * time.Hour
 
+// Deadline of a fetch, a hung endpoint would otherwise keep the file from being loaded again.
+const remoteFetchTimeout = 5 * time.Minute
+
 type RemoteFile struct {
 	uri      string
 	filepath string
@@ -39,7 +42,9 @@ func (r *RemoteFile) loadIfChanged() (data []byte, changed bool, err error) {
 		return nil, false, nil
 	}
 
-	req, err := http.NewRequest("GET", r.uri, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), remoteFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", r.uri, nil)
 	if err != nil {
 		return nil, false, err
 	}
@@ -0,0 +1,101 @@
+package pmtp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"get.pme.sh/pmesh/revision"
+)
+
+// ProtocolVersion is raised when the framing of the codes changes in a way older peers cannot read.
+const ProtocolVersion = 1
+
+// Capabilities describes what a server supports, it is answered to a GET of the upgrade endpoint
+// without an Upgrade header so that a client can pick a code before connecting.
+type Capabilities struct {
+	Protocol    int      `json:"protocol"`              // ProtocolVersion of the server, zero if it predates it
+	Version     string   `json:"version,omitempty"`     // Version of pmesh
+	Codes       []string `json:"codes"`                 // Codes the connections can be upgraded to, in order of preference
+	Compression []string `json:"compression,omitempty"` // Compression schemes of the streams, always empty as they are not compressed yet
+}
+
+// Supports returns true if the server can be upgraded to the code.
+func (c *Capabilities) Supports(code Code) bool {
+	return code != nil && slices.Contains(c.Codes, code.String())
+}
+
+// Pick returns the first of the codes the server supports, nil if none.
+func (c *Capabilities) Pick(codes ...Code) Code {
+	for _, code := range codes {
+		if c.Supports(code) {
+			return code
+		}
+	}
+	return nil
+}
+
+// Codes of the servers that predate the introspection, they rejected the request. Frozen, the codes
+// added since then are not known to these servers.
+var legacyCodes = []string{"jrpc+yamux", "jrpc", "json-rpc+yamux", "json-rpc"}
+
+func legacyCapabilities() Capabilities {
+	return Capabilities{Codes: slices.Clone(legacyCodes)}
+}
+
+// Capabilities returns the capabilities of the server.
+func (u *UpgradeServer[Proto, Arg]) Capabilities() Capabilities {
+	return Capabilities{
+		Protocol: ProtocolVersion,
+		Version:  revision.GetVersion(),
+		Codes:    slices.Clone(u.Websocket.Subprotocols),
+	}
+}
+
+// Capabilities queries the capabilities of the server without connecting.
+func (d *Dialer) Capabilities(ctx context.Context, u *ConnURL) (c Capabilities, err error) {
+	target := u.URL()
+	switch target.Scheme {
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    target,
+		Header: http.Header{
+			"Accept":     {"application/json"},
+			"Connection": {"close"},
+		},
+	}
+	conn, resp, err := d.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&c)
+	case http.StatusBadRequest:
+		c = legacyCapabilities()
+	default:
+		resmsg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		err = fmt.Errorf("jrpc: failed to query capabilities, status %d: %s", resp.StatusCode, resmsg)
+	}
+	return
+}
+
+// QueryCapabilities queries the capabilities of the server at the URL without connecting.
+func QueryCapabilities(ctx context.Context, url string) (Capabilities, error) {
+	u, err := ParseURL(url)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return u.Dialer().Capabilities(ctx, u)
+}
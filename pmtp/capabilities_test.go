@@ -0,0 +1,87 @@
+package pmtp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCapabilities(t *testing.T, handler http.HandlerFunc) (Capabilities, error) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	u := &ConnURL{Host: srv.Listener.Addr().String(), Path: "/connect"}
+	return (&Dialer{}).Capabilities(ctx, u)
+}
+
+func TestCapabilities(t *testing.T) {
+	server := MakeRPCServer(func(conn net.Conn, code Code, arg struct{}) { conn.Close() })
+	c, err := testCapabilities(t, func(w http.ResponseWriter, r *http.Request) {
+		server.Upgrade(w, r, struct{}{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Protocol != ProtocolVersion {
+		t.Errorf("protocol %d, want %d", c.Protocol, ProtocolVersion)
+	}
+	want := []string{"jrpc+yamux", "jrpc", "json-rpc+yamux", "json-rpc"}
+	if !slices.Equal(c.Codes, want) {
+		t.Errorf("codes %v, want %v", c.Codes, want)
+	}
+	if len(c.Compression) != 0 {
+		t.Errorf("compression %v, want none", c.Compression)
+	}
+	if got := c.Pick(CodeJSON, CodeYRPC); got != CodeJSON {
+		t.Errorf("picked %v, want %v", got, CodeJSON)
+	}
+}
+
+func TestCapabilitiesLegacy(t *testing.T) {
+	// The servers predating the introspection reject the GETs that are not upgrades.
+	c, err := testCapabilities(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Protocol != 0 || !slices.Equal(c.Codes, legacyCodes) {
+		t.Errorf("got %+v, want the legacy codes", c)
+	}
+	if !c.Supports(CodeYRPC) || c.Supports(nil) {
+		t.Errorf("supports %v", c.Codes)
+	}
+	c.Codes[0] = "changed"
+	if legacyCodes[0] != "jrpc+yamux" {
+		t.Error("legacy codes modified through the result")
+	}
+}
+
+func TestCapabilitiesError(t *testing.T) {
+	_, err := testCapabilities(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got %v, want the status", err)
+	}
+}
+
+func TestCapabilitiesWithoutUpgrade(t *testing.T) {
+	server := MakeRPCServer(func(conn net.Conn, code Code, arg struct{}) { conn.Close() })
+	for _, header := range []http.Header{{}, {"Connection": {"close"}}, {"Connection": {"keep-alive"}}} {
+		r := httptest.NewRequest(http.MethodGet, "/connect", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		server.Upgrade(w, r, struct{}{})
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%v: status %d, content type %q", header, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+}
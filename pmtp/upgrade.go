@@ -2,6 +2,7 @@ package pmtp
 
 import (
 	"bytes"
+	"encoding/json"
 	"net"
 	"net/http"
 	"net/url"
@@ -59,6 +60,12 @@ func (u *UpgradeServer[Proto, Arg]) Upgrade(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	if conn := r.Header.Get("Connection"); conn != "Upgrade" && conn != "upgrade" {
+		if r.Header.Get("Upgrade") == "" {
+			// Not an upgrade, describe what the server supports.
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(u.Capabilities())
+			return
+		}
 		vhttp.Error(w, r, http.StatusBadRequest)
 		return
 	}
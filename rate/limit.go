@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -239,8 +238,14 @@ func (l *Limit) Enforce(ctx context.Context, ctr *LimitCounter) error {
 	}
 }
 
+// CounterStore holds the counters of a client, satisfied by *sync.Map.
+type CounterStore interface {
+	Load(key any) (value any, ok bool)
+	LoadOrStore(key, value any) (actual any, loaded bool)
+}
+
 // Returns the counter for the given limit, creating it if necessary.
-func (l *Limit) GetCounters(s *sync.Map) *LimitCounter {
+func (l *Limit) GetCounters(s CounterStore) *LimitCounter {
 	k := l.tokey()
 	v, ok := s.Load(k)
 	if !ok {
@@ -249,8 +254,8 @@ func (l *Limit) GetCounters(s *sync.Map) *LimitCounter {
 	return v.(*LimitCounter)
 }
 
-// Enforces a limit given the context and sync.Map to store counters per client.
-func (l Limit) EnforceVar(ctx context.Context, s *sync.Map) error {
+// Enforces a limit given the context and the store of the counters per client.
+func (l Limit) EnforceVar(ctx context.Context, s CounterStore) error {
 	return l.Enforce(ctx, l.GetCounters(s))
 }
 
@@ -0,0 +1,39 @@
+package ray
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+
+	"get.pme.sh/pmesh/snowflake"
+)
+
+// SnowflakeGenerator generates plain snowflake IDs as ray IDs, formatted the same way
+// as session and service IDs (decimal).
+//
+// The ID is laid out as follows (most significant bit first):
+//
+//	[63..22] milliseconds since snowflake.EpochBegin (2024-01-01T00:00:00Z)
+//	[21..12] node ID, derived from the host name (see NodeID)
+//	[11..0]  sequence number
+//
+// Since the host name is not included in its original form, the node has to be
+// matched using NodeID(host) == id.MachineID().
+type SnowflakeGenerator struct {
+	gen snowflake.Generator
+}
+
+// NodeID returns the 10-bit node identifier encoded in snowflake rays for the given host.
+func NodeID(host string) uint32 {
+	hash := sha1.Sum([]byte(ToHostString(host)))
+	return binary.BigEndian.Uint32(hash[:4]) & uint32(snowflake.MachineIDMask>>snowflake.MachineIDShift)
+}
+
+func NewSnowflakeGenerator(host string) (r *SnowflakeGenerator) {
+	r = &SnowflakeGenerator{}
+	r.gen.MachineID = NodeID(host) << snowflake.MachineIDShift
+	r.gen.Sequence = snowflake.DefaultGenerator.Sequence
+	return
+}
+func (r *SnowflakeGenerator) Next() string {
+	return r.gen.Next().String()
+}
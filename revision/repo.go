@@ -17,9 +17,10 @@ type Repo interface {
 	System() string
 	Head() (Reference, error)
 	RemoteHead() (Reference, error)
+	RemoteBranch(branch string) (Reference, error)
 	RemoteURL() (string, error)
 	Fetch(ctx context.Context) error
-	Update() error
+	Checkout(branch, hash string) error
 }
 
 type System interface {
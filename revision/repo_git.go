@@ -3,9 +3,11 @@ package revision
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -73,6 +75,20 @@ func (r GitRepo) RemoteHead() (Reference, error) {
 	}
 	return Reference{}, errors.New("no remote branch")
 }
+func (r GitRepo) RemoteBranch(branch string) (Reference, error) {
+	remote, e := r.getRemote()
+	if e != nil {
+		return Reference{}, e
+	}
+	ref, e := r.Repository.Reference(plumbing.NewRemoteReferenceName(remote.Config().Name, branch), true)
+	if e != nil {
+		return Reference{}, e
+	}
+	if ref.Hash().IsZero() {
+		return Reference{}, plumbing.ErrReferenceNotFound
+	}
+	return r.convertReference(ref), nil
+}
 func (r GitRepo) Fetch(ctx context.Context) (err error) {
 	remote, e := r.getRemote()
 	if e != nil {
@@ -95,27 +111,25 @@ func (r GitRepo) Fetch(ctx context.Context) (err error) {
 	}
 	return
 }
-func (r GitRepo) Update() error {
-	remote, err := r.RemoteHead()
-	if err != nil {
-		return err
+
+// Checkout switches to the branch, pointing it to the commit. Local changes to the tracked files are
+// discarded, the untracked files are kept, which go-git does not do properly.
+func (r GitRepo) Checkout(branch, hash string) error {
+	for _, ref := range []string{branch, hash} {
+		if strings.HasPrefix(ref, "-") {
+			return fmt.Errorf("invalid git reference: %q", ref)
+		}
 	}
-	local, err := r.Head()
+	w, err := r.Worktree()
 	if err != nil {
 		return err
 	}
-	if remote.Hash == local.Hash {
-		return nil
+	cmd := exec.Command("git", "checkout", "-q", "--force", "-B", branch, hash, "--")
+	cmd.Dir = w.Filesystem.Root()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout: %w: %s", err, strings.TrimSpace(string(out)))
 	}
-	return exec.Command("git", "reset", "--hard", remote.Hash).Run()
-
-	// NOTE: go-git does not implement this properly and removes untracked files
-	//
-	//w, e := r.Worktree()
-	//if e != nil {
-	//	return e
-	//}
-	//return w.Reset(&git.ResetOptions{Mode: git.HardReset, Commit: plumbing.NewHash(remote.Hash)})
+	return nil
 }
 
 type GitSystem struct{}
@@ -137,3 +151,52 @@ func (s GitSystem) Open(path string) (Repo, error) {
 func init() {
 	Systems["git"] = GitSystem{}
 }
+
+// Checkout fetches the reference of the remote repository into the directory with a shallow fetch and
+// checks it out, initializing the repository first if needed. Files that are not tracked are kept.
+// Returns true if the checked out commit changed.
+func Checkout(ctx context.Context, url, ref, dir string) (changed bool, err error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// Never options of git, the fetch also passes them after "--".
+	if strings.HasPrefix(ref, "-") {
+		return false, fmt.Errorf("invalid git reference: %q", ref)
+	}
+	if strings.HasPrefix(url, "-") {
+		return false, fmt.Errorf("invalid git url: %q", url)
+	}
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+		if _, err := git("init", "-q"); err != nil {
+			return false, err
+		}
+	}
+	prev, _ := git("rev-parse", "-q", "--verify", "HEAD")
+	if _, err := git("fetch", "-q", "--depth", "1", "--", url, ref); err != nil {
+		return false, err
+	}
+	next, err := git("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return false, err
+	}
+	if next == prev {
+		return false, nil
+	}
+	if _, err := git("checkout", "-q", "--force", "--detach", next); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package revision
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates a repository with a single commit, returns its path.
+func testRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pm3.yml"), []byte("services: {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "pm3.yml"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	return dir
+}
+
+func TestCheckout(t *testing.T) {
+	url, dir := testRepo(t), t.TempDir()
+	ctx := context.Background()
+	for i, want := range []bool{true, false} {
+		changed, err := Checkout(ctx, url, "main", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != want {
+			t.Errorf("checkout %d: changed = %v, want %v", i, changed, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pm3.yml")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckoutRejectsOptions(t *testing.T) {
+	url := testRepo(t)
+	ctx := context.Background()
+	for _, tt := range []struct{ url, ref string }{
+		{url, "--upload-pack=touch pwned"},
+		{url, "-h"},
+		{"--upload-pack=touch pwned", "main"},
+	} {
+		dir := t.TempDir()
+		if _, err := Checkout(ctx, tt.url, tt.ref, dir); err == nil || !strings.Contains(err.Error(), "invalid git") {
+			t.Errorf("%q %q: got %v, want an invalid reference", tt.url, tt.ref, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			t.Errorf("%q %q: repository initialized", tt.url, tt.ref)
+		}
+	}
+}
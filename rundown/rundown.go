@@ -23,6 +23,28 @@ func init() {
 	}()
 }
 
+var reload = make(chan struct{}, 1)
+
+var notifyReload = sync.OnceFunc(func() {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			select {
+			case reload <- struct{}{}:
+			default: // A reload is already pending.
+			}
+		}
+	}()
+})
+
+// Reload returns a channel receiving SIGHUP, repeated signals coalesce while a reload is pending.
+// SIGHUP keeps its default behaviour until the first call.
+func Reload() <-chan struct{} {
+	notifyReload()
+	return reload
+}
+
 func WithContext(rctx context.Context) (ctx context.Context, cancel context.CancelFunc) {
 	ctx, cancel = context.WithCancel(rctx)
 	go func() {
@@ -0,0 +1,115 @@
+package schema
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GoIdent converts a name to an exported Go identifier, e.g. "user_id" -> "UserId". The separators
+// are dropped, and the prefix is prepended if the result would not start with a letter.
+func GoIdent(name, prefix string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	res := b.String()
+	if first, _ := utf8.DecodeRuneInString(res); !unicode.IsLetter(first) {
+		res = prefix + res
+	}
+	return res
+}
+
+// GoType returns the Go type of the values conforming to the schema, declaring the types it needs
+// through declare. Objects with properties become structs named after name, nested ones after their
+// parent and property. Schemas without a single Go equivalent, such as the combinators or a type list,
+// become json.RawMessage, and the optional properties are pointers omitted when empty.
+func (s *Schema) GoType(name string, declare func(name, def string)) string {
+	if s == nil || s.always != nil {
+		return "json.RawMessage"
+	}
+	types := s.types
+	nullable := false
+	if i := slices.Index(types, "null"); i >= 0 && len(types) == 2 {
+		types = []string{types[1-i]}
+		nullable = true
+	}
+	t := ""
+	if len(types) == 0 && (s.properties != nil || s.additional != nil) {
+		types = []string{"object"}
+	}
+	if len(types) != 1 || len(s.allOf)+len(s.anyOf)+len(s.oneOf) != 0 {
+		return "json.RawMessage"
+	}
+	switch types[0] {
+	case "string":
+		t = "string"
+	case "integer":
+		t = "int64"
+	case "number":
+		t = "float64"
+	case "boolean":
+		t = "bool"
+	case "array":
+		return "[]" + s.items.GoType(name+"Item", declare)
+	case "object":
+		if len(s.properties) == 0 {
+			if s.additional != nil {
+				return "map[string]" + s.additional.GoType(name+"Value", declare)
+			}
+			return "map[string]json.RawMessage"
+		}
+		declare(name, s.goStruct(name, declare))
+		t = name
+	default:
+		return "json.RawMessage"
+	}
+	if nullable {
+		t = "*" + t
+	}
+	return t
+}
+
+// Returns the definition of the struct holding the properties of the object.
+func (s *Schema) goStruct(name string, declare func(name, def string)) string {
+	keys := make([]string, 0, len(s.properties))
+	for k := range s.properties {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	seen := make(map[string]string, len(keys))
+	for _, k := range keys {
+		field := GoIdent(k, "F")
+		for i := 2; seen[field] != ""; i++ {
+			field = GoIdent(k, "F") + strconv.Itoa(i)
+		}
+		seen[field] = k
+
+		t := s.properties[k].GoType(name+field, declare)
+		tag := k
+		if !slices.Contains(s.required, k) {
+			tag += ",omitempty"
+			if !strings.HasPrefix(t, "*") && !strings.HasPrefix(t, "[]") && !strings.HasPrefix(t, "map[") && t != "json.RawMessage" {
+				t = "*" + t
+			}
+		}
+		fmt.Fprintf(&b, "\t%s %s `json:%s`\n", field, t, strconv.Quote(tag))
+	}
+	b.WriteString("}")
+	return b.String()
+}
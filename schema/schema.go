@@ -0,0 +1,578 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is a JSON schema supporting the commonly used subset of the keywords:
+// type, enum, const, properties, required, additionalProperties, items, minItems, maxItems,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern,
+// allOf, anyOf, oneOf, not and $ref to the definitions of the same document ("#/$defs/name").
+// Unknown keywords are ignored.
+//
+// In the manifest it is either declared inline or as the path to a JSON or YAML file.
+type Schema struct {
+	Path string // Path of the file the schema is loaded from, if any.
+
+	always *bool // Set for the boolean schemas.
+
+	types      []string
+	enum       []any
+	constant   *any
+	properties map[string]*Schema
+	required   []string
+	additional *Schema
+	items      *Schema
+	minItems   *int
+	maxItems   *int
+	minimum    *float64
+	maximum    *float64
+	exclMin    *float64
+	exclMax    *float64
+	minLength  *int
+	maxLength  *int
+	pattern    *regexp.Regexp
+	allOf      []*Schema
+	anyOf      []*Schema
+	oneOf      []*Schema
+	not        *Schema
+	ref        string  // JSON pointer to the referenced schema, if any.
+	target     *Schema // Referenced schema, resolved once the document is compiled.
+
+	source any
+}
+
+// Compilation of a document, sharing the schemas referenced within it.
+type document struct {
+	source  any
+	refs    map[string]*Schema
+	pending []*Schema // Schemas whose reference is not yet resolved.
+}
+
+// ValidationError describes the first violation of the schema.
+type ValidationError struct {
+	Path    string // JSON pointer to the offending value.
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compiles the schema from its decoded form.
+func Compile(v any) (*Schema, error) {
+	doc := &document{source: v, refs: map[string]*Schema{}}
+	s, err := doc.compile(v)
+	if err != nil {
+		return nil, err
+	}
+	for len(doc.pending) != 0 {
+		p := doc.pending[0]
+		doc.pending = doc.pending[1:]
+		if p.target, err = doc.resolve(p.ref); err != nil {
+			return nil, fmt.Errorf("$ref %q: %w", p.ref, err)
+		}
+	}
+
+	// A reference leading back to itself without descending into the value would never end.
+	for ref, r := range doc.refs {
+		seen := map[*Schema]bool{}
+		for ; r != nil; r = r.target {
+			if seen[r] {
+				return nil, fmt.Errorf("$ref %q: circular reference", ref)
+			}
+			seen[r] = true
+		}
+	}
+	return s, nil
+}
+
+// Returns the schema the JSON pointer refers to, compiling it once.
+func (doc *document) resolve(ref string) (*Schema, error) {
+	if s, ok := doc.refs[ref]; ok {
+		return s, nil
+	}
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("only references within the document are supported")
+	}
+	v := doc.source
+	if pointer != "" {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("invalid JSON pointer")
+		}
+		for _, token := range strings.Split(pointer[1:], "/") {
+			token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+			switch node := v.(type) {
+			case map[string]any:
+				if v, ok = node[token]; !ok {
+					return nil, fmt.Errorf("%q not found", token)
+				}
+			case []any:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(node) {
+					return nil, fmt.Errorf("%q not found", token)
+				}
+				v = node[i]
+			default:
+				return nil, fmt.Errorf("%q not found", token)
+			}
+		}
+	}
+
+	// Registered before compiling so that recursive references resolve to it.
+	s := &Schema{source: v}
+	doc.refs[ref] = s
+	if err := doc.init(s, v); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (doc *document) compile(v any) (*Schema, error) {
+	s := &Schema{source: v}
+	return s, doc.init(s, v)
+}
+
+func (doc *document) init(s *Schema, v any) error {
+	switch v := v.(type) {
+	case bool:
+		s.always = &v
+		return nil
+	case map[string]any:
+		return doc.compileObject(s, v)
+	default:
+		return fmt.Errorf("schema must be an object or a boolean, got %T", v)
+	}
+}
+
+func (doc *document) compileObject(s *Schema, m map[string]any) (err error) {
+	sub := func(key string) (*Schema, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		res, err := doc.compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		return res, nil
+	}
+	list := func(key string) (res []*Schema, err error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an array", key)
+		}
+		for i, e := range arr {
+			c, err := doc.compile(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", key, i, err)
+			}
+			res = append(res, c)
+		}
+		return
+	}
+	number := func(key string) (*float64, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a number", key)
+		}
+		return &f, nil
+	}
+	integer := func(key string) (*int, error) {
+		f, err := number(key)
+		if f == nil || err != nil {
+			return nil, err
+		}
+		if *f < 0 || *f != math.Trunc(*f) {
+			return nil, fmt.Errorf("%s must be a non-negative integer", key)
+		}
+		n := int(*f)
+		return &n, nil
+	}
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, e := range t {
+			name, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("type must be a string or an array of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	for _, t := range s.types {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("unknown type %q", t)
+		}
+	}
+	if v, ok := m["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return fmt.Errorf("enum must be an array")
+		}
+	}
+	if v, ok := m["const"]; ok {
+		s.constant = &v
+	}
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("properties must be an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, p := range props {
+			if s.properties[name], err = doc.compile(p); err != nil {
+				return fmt.Errorf("properties.%s: %w", name, err)
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("required must be an array")
+		}
+		for _, e := range arr {
+			name, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("required must be an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if v, ok := m["$ref"]; ok {
+		if s.ref, ok = v.(string); !ok {
+			return fmt.Errorf("$ref must be a string")
+		}
+		doc.pending = append(doc.pending, s)
+	}
+	if v, ok := m["pattern"]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("pattern must be a string")
+		}
+		if s.pattern, err = regexp.Compile(str); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+	}
+	if s.additional, err = sub("additionalProperties"); err != nil {
+		return
+	}
+	if s.items, err = sub("items"); err != nil {
+		return
+	}
+	if s.not, err = sub("not"); err != nil {
+		return
+	}
+	if s.allOf, err = list("allOf"); err != nil {
+		return
+	}
+	if s.anyOf, err = list("anyOf"); err != nil {
+		return
+	}
+	if s.oneOf, err = list("oneOf"); err != nil {
+		return
+	}
+	if s.minimum, err = number("minimum"); err != nil {
+		return
+	}
+	if s.maximum, err = number("maximum"); err != nil {
+		return
+	}
+	if s.exclMin, err = number("exclusiveMinimum"); err != nil {
+		return
+	}
+	if s.exclMax, err = number("exclusiveMaximum"); err != nil {
+		return
+	}
+	if s.minItems, err = integer("minItems"); err != nil {
+		return
+	}
+	if s.maxItems, err = integer("maxItems"); err != nil {
+		return
+	}
+	if s.minLength, err = integer("minLength"); err != nil {
+		return
+	}
+	s.maxLength, err = integer("maxLength")
+	return
+}
+
+// Load reads the schema from a JSON or YAML file.
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err = yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s, err := Compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.Path = path
+	return s, nil
+}
+
+// Resolve loads the schema from its file if it was declared as a path, relative paths are
+// resolved against root.
+func (s *Schema) Resolve(root string) error {
+	if s == nil || s.Path == "" || s.source != nil {
+		return nil
+	}
+	p := s.Path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	res, err := Load(p)
+	if err != nil {
+		return err
+	}
+	*s = *res
+	return nil
+}
+
+func (s *Schema) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		*s = Schema{Path: node.Value}
+		return nil
+	}
+	var v any
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	res, err := Compile(v)
+	if err != nil {
+		return err
+	}
+	*s = *res
+	return nil
+}
+func (s Schema) MarshalYAML() (any, error) {
+	if s.source == nil {
+		return s.Path, nil
+	}
+	return s.source, nil
+}
+
+// Validate checks the JSON document against the schema.
+func (s *Schema) Validate(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return &ValidationError{Message: "invalid JSON: " + err.Error()}
+	}
+	return s.ValidateValue(v)
+}
+
+// ValidateValue checks a decoded JSON value against the schema.
+func (s *Schema) ValidateValue(v any) error {
+	if e := s.validate(v, ""); e != nil {
+		return e
+	}
+	return nil
+}
+
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Compares two decoded values, numbers are compared by value regardless of their Go type.
+func equal(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			if bv, ok := b[k]; !ok || !equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func (s *Schema) validate(v any, path string) *ValidationError {
+	fail := func(format string, args ...any) *ValidationError {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if s.always != nil {
+		if !*s.always {
+			return fail("no value is allowed")
+		}
+		return nil
+	}
+
+	if len(s.types) != 0 {
+		t := typeOf(v)
+		ok := slices.Contains(s.types, t) || (t == "integer" && slices.Contains(s.types, "number"))
+		if !ok {
+			return fail("expected %s, got %s", strings.Join(s.types, " or "), t)
+		}
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return equal(e, v) }) {
+		return fail("value is not one of the allowed values")
+	}
+	if s.constant != nil && !equal(*s.constant, v) {
+		return fail("value does not match the constant")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		for name, pv := range v {
+			if ps, ok := s.properties[name]; ok {
+				if e := ps.validate(pv, path+"/"+name); e != nil {
+					return e
+				}
+			} else if s.additional != nil {
+				if s.additional.always != nil && !*s.additional.always {
+					return fail("unexpected property %q", name)
+				}
+				if e := s.additional.validate(pv, path+"/"+name); e != nil {
+					return e
+				}
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fail("expected at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fail("expected at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, e := range v {
+				if err := s.items.validate(e, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := len([]rune(v))
+		if s.minLength != nil && n < *s.minLength {
+			return fail("expected at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fail("expected at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fail("does not match the pattern %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return fail("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return fail("must be at most %v", *s.maximum)
+		}
+		if s.exclMin != nil && v <= *s.exclMin {
+			return fail("must be greater than %v", *s.exclMin)
+		}
+		if s.exclMax != nil && v >= *s.exclMax {
+			return fail("must be less than %v", *s.exclMax)
+		}
+	}
+
+	if s.target != nil {
+		if e := s.target.validate(v, path); e != nil {
+			return e
+		}
+	}
+	for _, c := range s.allOf {
+		if e := c.validate(v, path); e != nil {
+			return e
+		}
+	}
+	if s.anyOf != nil && !slices.ContainsFunc(s.anyOf, func(c *Schema) bool { return c.validate(v, path) == nil }) {
+		return fail("does not match any of the schemas")
+	}
+	if s.oneOf != nil {
+		n := 0
+		for _, c := range s.oneOf {
+			if c.validate(v, path) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return fail("matches %d of the schemas, expected exactly one", n)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fail("matches a disallowed schema")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustCompile(t *testing.T, src string) *Schema {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(src), &v); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Compile(v)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		doc    string
+		err    string // Expected error, valid if empty.
+	}{
+		{"type", `{"type": "string"}`, `"a"`, ""},
+		{"type mismatch", `{"type": "string"}`, `1`, "expected string, got integer"},
+		{"type list", `{"type": ["string", "null"]}`, `null`, ""},
+		{"integer is a number", `{"type": "number"}`, `3`, ""},
+		{"number is not an integer", `{"type": "integer"}`, `1.5`, "expected integer, got number"},
+		{"boolean true", `true`, `{"a": 1}`, ""},
+		{"boolean false", `false`, `1`, "no value is allowed"},
+
+		{"required", `{"required": ["a"]}`, `{"a": 1}`, ""},
+		{"required missing", `{"required": ["a", "b"]}`, `{"a": 1}`, `missing required property "b"`},
+		{"required ignores non-objects", `{"required": ["a"]}`, `1`, ""},
+
+		{"enum", `{"enum": ["a", 2, {"x": [1]}]}`, `{"x": [1]}`, ""},
+		{"enum number", `{"enum": [2]}`, `2.0`, ""},
+		{"enum mismatch", `{"enum": ["a", "b"]}`, `"c"`, "value is not one of the allowed values"},
+		{"const", `{"const": "a"}`, `"b"`, "value does not match the constant"},
+
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, ""},
+		{"pattern mismatch", `{"pattern": "^[a-z]+$"}`, `"aBc"`, `does not match the pattern "^[a-z]+$"`},
+		{"pattern is unanchored", `{"pattern": "b"}`, `"abc"`, ""},
+		{"min length in runes", `{"minLength": 2}`, `"é"`, "expected at least 2 characters"},
+
+		{"properties", `{"properties": {"a": {"type": "integer"}}}`, `{"a": 1, "b": "x"}`, ""},
+		{"property mismatch", `{"properties": {"a": {"properties": {"b": {"type": "integer"}}}}}`, `{"a": {"b": "x"}}`, "/a/b: expected integer, got string"},
+		{"additional false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, `unexpected property "b"`},
+		{"additional false allows declared", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, ""},
+		{"additional schema", `{"additionalProperties": {"type": "string"}}`, `{"a": "x", "b": 2}`, "/b: expected string, got integer"},
+
+		{"items", `{"items": {"type": "integer"}, "maxItems": 2}`, `[1, "x"]`, "/1: expected integer, got string"},
+		{"max items", `{"maxItems": 1}`, `[1, 2]`, "expected at most 1 items"},
+		{"exclusive minimum", `{"exclusiveMinimum": 1}`, `1`, "must be greater than 1"},
+		{"maximum", `{"maximum": 1}`, `1`, ""},
+
+		{"any of", `{"anyOf": [{"type": "string"}, {"type": "null"}]}`, `1`, "does not match any of the schemas"},
+		{"one of", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, "matches 2 of the schemas, expected exactly one"},
+		{"not", `{"not": {"type": "null"}}`, `null`, "matches a disallowed schema"},
+
+		{"ref", `{"$defs": {"id": {"type": "integer"}}, "properties": {"a": {"$ref": "#/$defs/id"}}}`, `{"a": 1}`, ""},
+		{"ref mismatch", `{"$defs": {"id": {"type": "integer"}}, "properties": {"a": {"$ref": "#/$defs/id"}}}`, `{"a": "x"}`, "/a: expected integer, got string"},
+		{"ref escaped", `{"$defs": {"a/b": {"type": "integer"}}, "$ref": "#/$defs/a~1b"}`, `"x"`, "expected integer, got string"},
+		{"ref recursive", `{"properties": {"next": {"$ref": "#"}, "v": {"type": "integer"}}}`, `{"next": {"next": {"v": "x"}}}`, "/next/next/v: expected integer, got string"},
+
+		{"invalid JSON", `{}`, `{`, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		err := mustCompile(t, tt.schema).Validate([]byte(tt.doc))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: got %v, want valid", tt.name, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: got %v, want a validation error", tt.name, err)
+		} else if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %q, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`1`, "schema must be an object or a boolean"},
+		{`{"type": "text"}`, `unknown type "text"`},
+		{`{"type": [1]}`, "type must be a string or an array of strings"},
+		{`{"enum": "a"}`, "enum must be an array"},
+		{`{"required": [1]}`, "required must be an array of strings"},
+		{`{"pattern": "("}`, "pattern:"},
+		{`{"properties": {"a": 1}}`, "properties.a: schema must be an object or a boolean"},
+		{`{"anyOf": {}}`, "anyOf must be an array"},
+		{`{"allOf": [{"type": 1}]}`, "allOf[0]: type must be a string"},
+		{`{"minItems": -1}`, "minItems must be a non-negative integer"},
+		{`{"maximum": "1"}`, "maximum must be a number"},
+		{`{"$ref": 1}`, "$ref must be a string"},
+		{`{"$ref": "other.json#/a"}`, "only references within the document are supported"},
+		{`{"$ref": "#/$defs/missing"}`, `"$defs" not found`},
+		{`{"$defs": {"a": {"type": 1}}, "$ref": "#/$defs/a"}`, "type must be a string"},
+		{`{"$ref": "#"}`, "circular reference"},
+		{`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, "circular reference"},
+	}
+	for _, tt := range tests {
+		var v any
+		if err := json.Unmarshal([]byte(tt.schema), &v); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Compile(v); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.schema, err, tt.err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ok.yml"), []byte("type: object\nrequired: [a]\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "bad.yml"), []byte("type: text\n"), 0644)
+
+	s := &Schema{Path: "ok.yml"}
+	if err := s.Resolve(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate([]byte(`{}`)); err == nil {
+		t.Error("schema loaded from the file not applied")
+	}
+	for _, path := range []string{"bad.yml", "missing.yml"} {
+		if err := (&Schema{Path: path}).Resolve(dir); err == nil {
+			t.Errorf("%s: resolved", path)
+		}
+	}
+}
+
+func TestGoIdent(t *testing.T) {
+	tests := map[string]string{
+		"user_id":      "UserId",
+		"user.created": "UserCreated",
+		"a-b c":        "ABC",
+		"2fa":          "X2fa",
+		"ünicode":      "Ünicode",
+		"":             "X",
+	}
+	for name, want := range tests {
+		if got := GoIdent(name, "X"); got != want {
+			t.Errorf("GoIdent(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -1,22 +1,29 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"get.pme.sh/pmesh/glob"
 	"get.pme.sh/pmesh/snowflake"
+	"get.pme.sh/pmesh/xlog"
+
+	"github.com/gofrs/flock"
 )
 
 const (
-	BuilderRunDir        = ".run"     // -> links to .build-%s
-	BuilderIdFile        = ".buildid" // Contains the current build id
-	BuilderBuildDir      = ".build"   // Used while building, renamed to .build-%s when done
-	BuilderArchivePrefix = ".build-"  // The build directory format
+	BuilderRunDir        = ".run"       // -> links to .build-%s
+	BuilderIdFile        = ".buildid"   // Contains the current build id
+	BuilderBuildDir      = ".build"     // Used while building, renamed to .build-%s when done
+	BuilderArchivePrefix = ".build-"    // The build directory format
+	BuilderLockDir       = ".buildlock" // Holds the lock serializing the builders
 )
 
 // Lexicographically comparable build timestamp.
@@ -86,6 +93,53 @@ func (bfs BuildFS) Folders() (r map[string]string) {
 	return
 }
 
+// Lock waits until no other builder runs in the directory, be it in this process, another one or
+// on another node sharing the file system, and locks it until unlock is called.
+func (bfs BuildFS) Lock(ctx context.Context) (unlock func(), err error) {
+	dir := filepath.Join(bfs.Root, BuilderLockDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create build lock: %w", err)
+	}
+	lock := flock.New(filepath.Join(dir, "lock"))
+	ok, err := lock.TryLock()
+	if err == nil && !ok {
+		xlog.InfoC(ctx).Str("root", bfs.Root).Msg("Waiting for another build to finish")
+		ok, err = lock.TryLockContext(ctx, 250*time.Millisecond)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire build lock: %w", err)
+	} else if !ok {
+		return nil, fmt.Errorf("failed to acquire build lock: %w", context.Cause(ctx))
+	}
+	return func() { lock.Unlock() }, nil
+}
+
+// ClusterLocker acquires a lock shared by the nodes of the cluster, waiting until it is released by
+// the other holders, the key is made of the characters allowed in a KV key.
+type ClusterLocker func(ctx context.Context, key string) (unlock func(), err error)
+
+type clusterLockerKey struct{}
+
+// WithClusterLocker returns a context serializing the builds it starts across the cluster.
+func WithClusterLocker(c context.Context, locker ClusterLocker) context.Context {
+	return context.WithValue(c, clusterLockerKey{}, locker)
+}
+
+// LockCluster waits until no other node builds the same checksum of the directory, and locks it
+// until unlock is called. Without a cluster locker in the context, only the local lock is held.
+func (bfs BuildFS) LockCluster(ctx context.Context, chk glob.Checksum) (unlock func(), err error) {
+	locker, _ := ctx.Value(clusterLockerKey{}).(ClusterLocker)
+	if locker == nil {
+		return func() {}, nil
+	}
+	root := sha256.Sum256([]byte(filepath.Clean(bfs.Root)))
+	key := "build." + hex.EncodeToString(root[:8]) + "." + hex.EncodeToString(chk[:8])
+	if unlock, err = locker(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to acquire cluster build lock: %w", err)
+	}
+	return unlock, nil
+}
+
 // Cleans the directory removing past builds.
 func (bfs BuildFS) Clean() {
 	f := bfs.Folders()
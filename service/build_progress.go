@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// Status of a build.
+const (
+	BuildRunning = "building"
+	BuildDone    = "done"
+	BuildFailed  = "failed"
+)
+
+// Number of output lines kept per build for the subscribers joining late.
+const buildBacklog = 1000
+
+// BuildEvent is a line of output or a status change of a build.
+type BuildEvent struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Stream  string    `json:"stream,omitempty"` // stdout or stderr, empty for status changes.
+	Line    string    `json:"line,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"` // Failure details.
+}
+
+// BuildProgress is the state of the last build of a service.
+type BuildProgress struct {
+	Service  string       `json:"service"`
+	Status   string       `json:"status"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Error    string       `json:"error,omitempty"`
+	Output   []BuildEvent `json:"output,omitempty"` // Last lines of output.
+}
+
+type buildState struct {
+	mu       sync.Mutex
+	progress BuildProgress
+	subs     map[chan BuildEvent]struct{}
+	writers  []*buildWriter // Flushed once the build ends.
+	lines    int            // Number of lines of output since the start of the build.
+}
+
+// Publishes the event to the subscribers, dropping it for the ones not keeping up.
+func (b *buildState) publish(ev BuildEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ev.Status != "" {
+		b.progress.Status = ev.Status
+		if ev.Status != BuildRunning {
+			b.progress.Finished = ev.Time
+			b.progress.Error = ev.Error
+		}
+	} else {
+		b.lines++
+		if len(b.progress.Output) >= buildBacklog {
+			b.progress.Output = b.progress.Output[1:]
+		}
+		b.progress.Output = append(b.progress.Output, ev)
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+type buildRegistry struct {
+	mu     sync.Mutex
+	builds map[string]*buildState
+}
+
+// Builds tracks the progress of the builds by service.
+var Builds = &buildRegistry{builds: map[string]*buildState{}}
+
+func (r *buildRegistry) get(service string) *buildState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.builds[service]
+	if !ok {
+		b = &buildState{subs: map[chan BuildEvent]struct{}{}}
+		b.progress.Service = service
+		r.builds[service] = b
+	}
+	return b
+}
+
+// Progress returns the state of the last build of the service, false if it was never built.
+func (r *buildRegistry) Progress(service string) (BuildProgress, bool) {
+	b := r.get(service)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := b.progress
+	res.Output = append([]BuildEvent(nil), res.Output...)
+	return res, res.Status != ""
+}
+
+// Subscribe returns the state of the last build of the service and a channel receiving its events
+// until the context is done.
+func (r *buildRegistry) Subscribe(ctx context.Context, service string) (BuildProgress, <-chan BuildEvent) {
+	b := r.get(service)
+	ch := make(chan BuildEvent, 256)
+	b.mu.Lock()
+	res := b.progress
+	res.Output = append([]BuildEvent(nil), res.Output...)
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	})
+	return res, ch
+}
+
+// Starts tracking a new build of the service.
+func (r *buildRegistry) begin(service string) *buildState {
+	b := r.get(service)
+	b.mu.Lock()
+	b.progress.Started, b.progress.Finished = time.Now(), time.Time{}
+	b.progress.Error, b.progress.Output, b.lines = "", nil, 0
+	b.mu.Unlock()
+	b.publish(BuildEvent{Time: b.progress.Started, Service: service, Status: BuildRunning})
+	return b
+}
+
+// Records the result of the build.
+func (b *buildState) end(err error) {
+	b.mu.Lock()
+	writers := b.writers
+	b.writers = nil
+	b.mu.Unlock()
+	for _, w := range writers {
+		w.Close()
+	}
+
+	ev := BuildEvent{Time: time.Now(), Service: b.progress.Service, Status: BuildDone}
+	if err != nil {
+		ev.Status, ev.Error = BuildFailed, err.Error()
+	}
+	b.publish(ev)
+}
+
+// Returns the number of lines of output so far, to be passed to outputSince.
+func (b *buildState) mark() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lines
+}
+
+// Returns the lines of output since the mark, as far as kept.
+func (b *buildState) outputSince(mark int) (res []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := min(b.lines-mark, len(b.progress.Output))
+	for _, ev := range b.progress.Output[len(b.progress.Output)-n:] {
+		res = append(res, ev.Line)
+	}
+	return
+}
+
+// Returns a writer publishing the lines written to it as output of the build.
+func (b *buildState) writer(stream string) *buildWriter {
+	w := &buildWriter{build: b, stream: stream}
+	b.mu.Lock()
+	b.writers = append(b.writers, w)
+	b.mu.Unlock()
+	return w
+}
+
+type buildWriter struct {
+	build  *buildState
+	stream string
+	buf    []byte
+}
+
+func (w *buildWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flushes the last line if not terminated.
+func (w *buildWriter) Close() error {
+	if len(w.buf) != 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *buildWriter) emit(line []byte) {
+	w.build.publish(BuildEvent{
+		Time:    time.Now(),
+		Service: w.build.progress.Service,
+		Stream:  w.stream,
+		Line:    string(bytes.TrimSuffix(line, []byte{'\r'})),
+	})
+}
+
+type buildKey struct{}
+
+// Returns the build tracked by the context, if any.
+func buildFromContext(c context.Context) *buildState {
+	b, _ := c.Value(buildKey{}).(*buildState)
+	return b
+}
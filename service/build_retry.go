@@ -0,0 +1,66 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Output of the build commands denoting a network or registry failure worth retrying.
+var transientBuildPatterns = []string{
+	"ETIMEDOUT",
+	"ECONNRESET",
+	"ECONNREFUSED",
+	"EAI_AGAIN",
+	"ENOTFOUND",
+	"socket hang up",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"connection reset by peer",
+	"connection refused",
+	"Could not resolve host",
+	"Temporary failure in name resolution",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"429 Too Many Requests",
+	"Read timed out",
+	"Connection timed out",
+}
+
+// Returns true if the output of a failed build command denotes a transient failure.
+func isTransientBuildFailure(output []string) bool {
+	for _, line := range output {
+		for _, pattern := range transientBuildPatterns {
+			if strings.Contains(line, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Number of output lines included in a build error.
+const buildErrorTail = 10
+
+// BuildError is the failure of a build command.
+type BuildError struct {
+	Command   string
+	Attempts  int      // Number of times the command was run.
+	Transient bool     // Whether the failure looked transient.
+	Output    []string // Last lines of output of the command.
+	Err       error
+}
+
+func (e *BuildError) Error() string {
+	var b strings.Builder
+	if e.Transient {
+		fmt.Fprintf(&b, "transient build failure after %d attempts: ", e.Attempts)
+	}
+	b.WriteString(e.Err.Error())
+	if len(e.Output) != 0 {
+		b.WriteString("\n")
+		b.WriteString(strings.Join(e.Output, "\n"))
+	}
+	return b.String()
+}
+func (e *BuildError) Unwrap() error { return e.Err }
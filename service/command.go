@@ -16,11 +16,25 @@ import (
 )
 
 type command struct {
-	Dir  string            `yaml:"cwd,omitempty"`  // The working directory to run the script in.
-	Exec string            `yaml:"exec,omitempty"` // The executable used to run the script.
-	Args []string          `yaml:"args,omitempty"` // Arguments passed.
-	Env  map[string]string `yaml:"env,omitempty"`  // The environment variables to set.
+	Dir   string            `yaml:"cwd,omitempty"`   // The working directory to run the script in.
+	Exec  string            `yaml:"exec,omitempty"`  // The executable used to run the script.
+	Args  []string          `yaml:"args,omitempty"`  // Arguments passed.
+	Shell string            `yaml:"shell,omitempty"` // Script run by the system shell instead of an executable.
+	Env   map[string]string `yaml:"env,omitempty"`   // The environment variables to set.
 }
+
+// Command is a command run by a service, in one of two modes:
+//
+//   - Exec: the executable is started directly with the arguments, no shell is involved. The string
+//     form `[@cwd] [KEY=value...] exec [args...]` is split with POSIX shell quoting rules, so
+//     quotes group words and backslashes escape, but pipes, redirections and globs are passed
+//     literally to the executable.
+//   - Shell: the script is run by /bin/sh -c, or cmd.exe /S /C on Windows, given verbatim so the
+//     operators of the shell are interpreted. Selected with the shell key of the mapping form.
+//
+// When run by an app, ${NAME} in the arguments and the environment values expands to the variable
+// of the process environment once its address is allocated, see ExpandTemplate. The script given
+// to a shell such as `bash -c script` is left to the shell.
 type Command struct {
 	command
 }
@@ -30,7 +44,12 @@ func CommandFromString(s string) (c Command, e error) {
 	return
 }
 func NewCommand(exec string, args ...string) Command {
-	return Command{command{"", exec, args, nil}}
+	return Command{command{Exec: exec, Args: args}}
+}
+
+// NewShellCommand returns a command running the script with the system shell.
+func NewShellCommand(script string) Command {
+	return Command{command{Shell: script}}
 }
 func (c *Command) Clone() *Command {
 	return &Command{
@@ -38,32 +57,43 @@ func (c *Command) Clone() *Command {
 			c.Dir,
 			c.Exec,
 			append([]string{}, c.Args...),
+			c.Shell,
 			lo.Assign(c.Env),
 		},
 	}
 }
 func (c Command) IsZero() bool {
-	return c.Exec == ""
+	return c.Exec == "" && c.Shell == ""
+}
+
+// Quotes the word so that it splits back to itself.
+func quoteWord(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\#") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
 }
 func (c Command) String() string {
 	builder := strings.Builder{}
 	if c.Dir != "" {
-		builder.WriteString("@")
-		builder.WriteString(c.Dir)
+		builder.WriteString(quoteWord("@" + c.Dir))
 		builder.WriteString(" ")
 	}
 	if c.Env != nil {
 		for k, v := range c.Env {
-			builder.WriteString(k)
-			builder.WriteString("=")
-			builder.WriteString(v)
+			builder.WriteString(quoteWord(k + "=" + v))
 			builder.WriteString(" ")
 		}
 	}
-	builder.WriteString(c.Exec)
+	if c.Shell != "" {
+		builder.WriteString("sh -c ")
+		builder.WriteString(quoteWord(c.Shell))
+		return builder.String()
+	}
+	builder.WriteString(quoteWord(c.Exec))
 	for _, arg := range c.Args {
 		builder.WriteString(" ")
-		builder.WriteString(arg)
+		builder.WriteString(quoteWord(arg))
 	}
 	return builder.String()
 }
@@ -115,7 +145,13 @@ func (c *Command) MergeEnv(m map[string]string) {
 
 func (c *Command) UnmarshalYAML(node *yaml.Node) error {
 	if node.Kind != yaml.ScalarNode {
-		return node.Decode(&c.command)
+		if err := node.Decode(&c.command); err != nil {
+			return err
+		}
+		if c.Shell != "" && (c.Exec != "" || len(c.Args) != 0) {
+			return fmt.Errorf("line %d: shell cannot be combined with exec or args", node.Line)
+		}
+		return nil
 	}
 	var res string
 	if err := node.Decode(&res); err != nil {
@@ -124,13 +160,18 @@ func (c *Command) UnmarshalYAML(node *yaml.Node) error {
 	return c.UnmarshalText([]byte(res))
 }
 func (c *Command) Create(root string, ctx context.Context) *exec.Cmd {
-	executable := c.Exec
-	if executable == "python3" || executable == "python2" {
-		if _, err := exec.LookPath(executable); err != nil {
-			executable = "python"
+	var cmd *exec.Cmd
+	if c.Shell != "" {
+		cmd = shellCommand(ctx, c.Shell)
+	} else {
+		executable := c.Exec
+		if executable == "python3" || executable == "python2" {
+			if _, err := exec.LookPath(executable); err != nil {
+				executable = "python"
+			}
 		}
+		cmd = exec.CommandContext(ctx, executable, c.Args...)
 	}
-	cmd := exec.CommandContext(ctx, executable, c.Args...)
 	if c.Dir == "" {
 		cmd.Dir = root
 	} else if filepath.IsAbs(c.Dir) {
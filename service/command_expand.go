@@ -0,0 +1,124 @@
+package service
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Returns true if the name is a valid environment variable name.
+func isEnvName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExpandTemplate replaces the ${NAME} references in s with the value given by the lookup, empty
+// if undefined. $${ escapes a literal ${, and the references that are not a plain variable name
+// such as ${NAME:-default} are left as is for the shell.
+func ExpandTemplate(s string, lookup func(string) string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 || !isEnvName(s[i+2:i+end]) {
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(lookup(s[i+2 : i+end]))
+		s = s[i+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// Shells whose -c argument is a script, left to the shell when given in the exec form.
+var scriptShells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "ash": true, "zsh": true, "ksh": true, "fish": true,
+	"busybox": true, "pwsh": true, "powershell": true,
+}
+
+// Returns the index in the arguments of the script of a shell run as `shell [flags] -c script`, or -1.
+func shellScriptArg(exec string, args []string) int {
+	name := strings.TrimSuffix(filepath.Base(filepath.ToSlash(exec)), ".exe")
+	if !scriptShells[name] {
+		return -1
+	}
+	for i, arg := range args[:max(len(args)-1, 0)] {
+		if arg == "-c" || arg == "-Command" {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// Parts of a command declared in the manifest, the only ones expanded so that the inherited environment
+// and the references meant for a shell are passed as is.
+type cmdTemplates struct {
+	args   bool            // Whether the arguments are expanded, the script of a shell command is not.
+	script int             // Index of the argument that is a shell script, not expanded either, or -1.
+	env    map[string]bool // Names of the declared variables.
+}
+
+// Returns the templates of the command, env lists the variables declared for it elsewhere.
+func newCmdTemplates(c *Command, env ...map[string]string) cmdTemplates {
+	t := cmdTemplates{args: c.Shell == "", script: -1, env: make(map[string]bool)}
+	if t.args {
+		t.script = shellScriptArg(c.Exec, c.Args)
+	}
+	for k := range c.Env {
+		t.env[k] = true
+	}
+	for _, m := range env {
+		for k := range m {
+			t.env[k] = true
+		}
+	}
+	return t
+}
+
+// Expands the templates in the declared arguments and environment of the command, referencing the
+// environment it is started with, including the address allocated to the process. Shell scripts are
+// left to the shell, whether given with the shell key, which also covers the command line passed
+// verbatim to cmd.exe on Windows, or as the -c argument of a shell.
+func (t cmdTemplates) expand(cmd *exec.Cmd) {
+	env := make(map[string]string, len(cmd.Env))
+	for _, kv := range cmd.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	lookup := func(name string) string { return env[name] }
+	if t.args {
+		for i := 1; i < len(cmd.Args); i++ {
+			if i-1 != t.script {
+				cmd.Args[i] = ExpandTemplate(cmd.Args[i], lookup)
+			}
+		}
+	}
+	for i, kv := range cmd.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok && t.env[k] {
+			cmd.Env[i] = k + "=" + ExpandTemplate(v, lookup)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestCmdTemplatesExpand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+		want []string
+	}{
+		{"args", NewCommand("node", "--port=${PORT}", "${UNSET}x", "$${PORT}", "${PORT:-1}"),
+			[]string{"node", "--port=80", "x", "${PORT}", "${PORT:-1}"}},
+		{"shell script", NewCommand("bash", "-c", "X=1; echo ${X} ${PORT}"),
+			[]string{"bash", "-c", "X=1; echo ${X} ${PORT}"}},
+		{"shell flags", NewCommand("/bin/sh", "-e", "-c", "echo ${PORT}", "${PORT}"),
+			[]string{"/bin/sh", "-e", "-c", "echo ${PORT}", "80"}},
+		{"not a shell", NewCommand("grep", "-c", "${PORT}"),
+			[]string{"grep", "-c", "80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templates := newCmdTemplates(&tt.cmd)
+			cmd := exec.Command(tt.cmd.Exec, tt.cmd.Args...)
+			cmd.Args[0] = tt.cmd.Exec
+			cmd.Env = []string{"PORT=80"}
+			templates.expand(cmd)
+			if !slices.Equal(cmd.Args, tt.want) {
+				t.Errorf("got %q, want %q", cmd.Args, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Returns the command running the script with the POSIX shell.
+func shellCommand(ctx context.Context, script string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", script)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// Returns the command running the script with cmd.exe. The command line is passed verbatim since
+// cmd.exe does not follow the quoting rules exec applies to the arguments.
+func shellCommand(ctx context.Context, script string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd.exe /S /C "` + script + `"`}
+	return cmd
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package service
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Running as another user is not supported on Windows.
+func setCredential(cmd *exec.Cmd, username, group string) error {
+	if username == "" && group == "" {
+		return nil
+	}
+	return errors.New("running an app as another user or group is not supported on windows")
+}
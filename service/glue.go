@@ -170,6 +170,7 @@ func glueChild(pid int) (err error) {
 
 type GluedCommand struct {
 	*exec.Cmd
+	templates cmdTemplates
 }
 
 func (cmd GluedCommand) Start() (err error) {
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// Linux namespaces an app can be isolated in.
+//
+// In a PID namespace the main process of the app is its init, started without one in front of it: the
+// kernel does not deliver it the signals it has no handler for, so an app that does not handle SIGINT
+// or SIGTERM is killed once stop_timeout passes, and the orphans of the namespace are reparented to it
+// and left as zombies unless it reaps them. Apps that fork should be started through an init such as
+// tini or dumb-init.
+const (
+	NamespacePID   = "pid"   // The processes of the app only see each other, the main process being PID 1.
+	NamespaceMount = "mount" // Mounts made by the app are not visible outside.
+	NamespaceIPC   = "ipc"   // System V IPC and POSIX message queues are private.
+	NamespaceUTS   = "uts"   // The hostname can be changed without affecting the node.
+	NamespaceNet   = "net"   // No network but a loopback of its own, only for background apps.
+)
+
+var namespaceNames = []string{NamespacePID, NamespaceMount, NamespaceIPC, NamespaceUTS, NamespaceNet}
+
+// Validates the namespaces requested by the app and returns the ones the platform supports.
+func (app *AppService) prepareNamespaces() ([]string, error) {
+	for _, ns := range app.Namespaces {
+		if !slices.Contains(namespaceNames, ns) {
+			return nil, fmt.Errorf("invalid namespace %q", ns)
+		}
+		if ns == NamespaceNet && !app.Background {
+			return nil, fmt.Errorf("the net namespace requires a background app, the upstream would be unreachable")
+		}
+	}
+	if len(app.Namespaces) == 0 {
+		return nil, nil
+	}
+	if err := namespacesSupported(); err != nil {
+		xlog.Warn().Err(err).Strs("namespaces", app.Namespaces).Str("app", app.Name).Msg("Namespaces unavailable, running without isolation")
+		return nil, nil
+	}
+	return app.Namespaces, nil
+}
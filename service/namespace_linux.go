@@ -0,0 +1,44 @@
+//go:build linux
+
+package service
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var namespaceFlags = map[string]uintptr{
+	NamespacePID: syscall.CLONE_NEWPID,
+	NamespaceIPC: syscall.CLONE_NEWIPC,
+	NamespaceUTS: syscall.CLONE_NEWUTS,
+	NamespaceNet: syscall.CLONE_NEWNET,
+}
+
+// Creating namespaces requires CAP_SYS_ADMIN, approximated by running as root.
+func namespacesSupported() error {
+	if os.Geteuid() != 0 {
+		return errors.New("creating namespaces requires root")
+	}
+	return nil
+}
+
+// Starts the command in new namespaces.
+func setNamespaces(cmd *exec.Cmd, namespaces []string) {
+	if len(namespaces) == 0 {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	for _, ns := range namespaces {
+		if ns == NamespaceMount {
+			// Unshared in the child rather than cloned, so that it remounts / as MS_PRIVATE|MS_REC
+			// before the exec: the mounts would otherwise propagate back to the shared mounts of the host.
+			cmd.SysProcAttr.Unshareflags |= syscall.CLONE_NEWNS
+			continue
+		}
+		cmd.SysProcAttr.Cloneflags |= namespaceFlags[ns]
+	}
+}
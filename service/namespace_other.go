@@ -0,0 +1,14 @@
+//go:build !linux
+
+package service
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func namespacesSupported() error {
+	return errors.New("namespaces are only supported on linux")
+}
+
+func setNamespaces(cmd *exec.Cmd, namespaces []string) {}
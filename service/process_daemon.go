@@ -0,0 +1,29 @@
+package service
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Environment variable marking the processes of an app instance, inherited by their forks.
+const envInstance = "PM3_INSTANCE"
+
+var errDaemonized = errors.New("app daemonized, the main process exited leaving its children running: run it in the foreground")
+
+// Returns the running processes carrying the marker of the instance. The environment of the
+// processes is not readable on every platform, nothing is found there.
+func findInstanceProcesses(instance string) (res []*process.Process) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil
+	}
+	marker := envInstance + "=" + instance
+	for _, p := range procs {
+		if env, err := p.Environ(); err == nil && slices.Contains(env, marker) {
+			res = append(res, p)
+		}
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Reasons a process exited.
+const (
+	ExitSuccess    = "success"    // Exited on its own with code 0.
+	ExitCrashed    = "crashed"    // Exited on its own with a non-zero code.
+	ExitSignaled   = "signaled"   // Terminated by a signal not sent by pmesh.
+	ExitKilled     = "killed"     // Killed by SIGKILL not sent by pmesh.
+	ExitOOM        = "oom"        // Killed by the OOM killer, as counted in the memory.events of its cgroup.
+	ExitStopped    = "stopped"    // Stopped by pmesh, see the cause.
+	ExitTimeout    = "timeout"    // Killed by pmesh after failing to stop in time.
+	ExitDaemonized = "daemonized" // Exited leaving its forks running, which pmesh killed.
+)
+
+var errKilledTimeout = errors.New("killed (timeout)")
+
+// Number of exits kept per service.
+const maxProcessExits = 16
+
+// ProcessExit describes how a process of a service exited.
+type ProcessExit struct {
+	PID      int       `json:"pid"`
+	Time     time.Time `json:"time"`
+	Code     int       `json:"code"`             // Exit code, -1 if terminated by a signal.
+	Signal   string    `json:"signal,omitempty"` // Signal terminating the process, if any.
+	Reason   string    `json:"reason"`
+	ByPmesh  bool      `json:"by_pmesh"`        // Whether pmesh initiated the exit.
+	Cause    string    `json:"cause,omitempty"` // Why pmesh stopped the process or the wait error.
+	Lifetime float64   `json:"lifetime"`        // Seconds the process ran.
+}
+
+// InstanceExits is implemented by the instances keeping track of the exits of their processes.
+type InstanceExits interface {
+	GetExits() []ProcessExit
+}
+
+// Describes the exit of the process given the wait result, the cause of its context if pmesh killed
+// it, the reason pmesh requested it to stop if it did, and whether the OOM killer killed a process of
+// its cgroup meanwhile.
+func newProcessExit(ps *os.ProcessState, werr error, started time.Time, killed error, stopReason string, oomKilled bool) ProcessExit {
+	exit := ProcessExit{Time: time.Now(), Code: -1, Lifetime: time.Since(started).Seconds()}
+	signaled := false
+	if ps != nil {
+		exit.PID, exit.Code = ps.Pid(), ps.ExitCode()
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			signaled = true
+			exit.Signal = ws.Signal().String()
+		}
+	}
+	switch {
+	case killed != nil:
+		exit.ByPmesh, exit.Reason, exit.Cause = true, ExitStopped, killed.Error()
+		if errors.Is(killed, errKilledTimeout) {
+			exit.Reason = ExitTimeout
+		}
+	case stopReason != "":
+		exit.ByPmesh, exit.Reason, exit.Cause = true, ExitStopped, stopReason
+	case signaled && exit.Signal == syscall.SIGKILL.String():
+		exit.Reason = ExitKilled
+		if oomKilled {
+			exit.Reason = ExitOOM
+		}
+	case signaled:
+		exit.Reason = ExitSignaled
+	case exit.Code == 0 && werr == nil:
+		exit.Reason = ExitSuccess
+	default:
+		exit.Reason = ExitCrashed
+		if werr != nil && ps == nil {
+			exit.Cause = werr.Error()
+		}
+	}
+	return exit
+}
+
+// oomCounter tracks the oom_kill counter of the cgroup (v2) of a process, only available on Linux.
+type oomCounter struct {
+	path  string // memory.events of the cgroup, empty if unavailable.
+	start int64
+}
+
+// Starts tracking the counter of the cgroup of the process.
+func newOOMCounter(pid int) (c oomCounter) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if group, ok := strings.CutPrefix(line, "0::"); ok {
+			path := filepath.Join("/sys/fs/cgroup", group, "memory.events")
+			if n, ok := readOOMKills(path); ok {
+				c.path, c.start = path, n
+			}
+			break
+		}
+	}
+	return
+}
+
+// Killed returns true if the OOM killer killed a process of the cgroup since the tracking started.
+func (c oomCounter) Killed() bool {
+	if c.path == "" {
+		return false
+	}
+	n, ok := readOOMKills(c.path)
+	return ok && n > c.start
+}
+
+func readOOMKills(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	return parseOOMKills(string(data))
+}
+func parseOOMKills(events string) (int64, bool) {
+	for _, line := range strings.Split(events, "\n") {
+		if v, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Returns the error the context was cancelled with, nil if not cancelled.
+func contextCause(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
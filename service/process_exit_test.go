@@ -0,0 +1,34 @@
+package service
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseOOMKills(t *testing.T) {
+	events := "low 0\nhigh 0\nmax 3\noom 2\noom_kill 1\noom_group_kill 0\n"
+	if n, ok := parseOOMKills(events); !ok || n != 1 {
+		t.Errorf("got %d %v, want 1", n, ok)
+	}
+	if _, ok := parseOOMKills("low 0\n"); ok {
+		t.Error("parsed a counter missing from the events")
+	}
+}
+
+func TestProcessExitKilled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals")
+	}
+	cmd := exec.Command("sh", "-c", "kill -9 $$")
+	err := cmd.Run()
+	for oom, want := range map[bool]string{false: ExitKilled, true: ExitOOM} {
+		if exit := newProcessExit(cmd.ProcessState, err, time.Now(), nil, "", oom); exit.Reason != want {
+			t.Errorf("oom=%v: got %q, want %q", oom, exit.Reason, want)
+		}
+	}
+	if exit := newProcessExit(cmd.ProcessState, err, time.Now(), nil, "stopping", true); exit.Reason != ExitStopped {
+		t.Errorf("got %q for a process stopped by pmesh, want %q", exit.Reason, ExitStopped)
+	}
+}
@@ -51,3 +51,11 @@ func (t *Service) UnmarshalYAML(node *yaml.Node) (e error) {
 	t.service, e = Registry.Unmarshal(node)
 	return
 }
+
+// AsApp returns the app the service is built on, or nil if the service is not an app.
+func (t Service) AsApp() *AppService {
+	if a, ok := t.service.(interface{ appService() *AppService }); ok {
+		return a.appService()
+	}
+	return nil
+}
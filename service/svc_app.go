@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"slices"
 	"strconv"
@@ -20,8 +22,10 @@ import (
 	"get.pme.sh/pmesh/glob"
 	"get.pme.sh/pmesh/health"
 	"get.pme.sh/pmesh/lb"
+	"get.pme.sh/pmesh/retry"
 	"get.pme.sh/pmesh/rundown"
 	"get.pme.sh/pmesh/security"
+	"get.pme.sh/pmesh/snowflake"
 	"get.pme.sh/pmesh/util"
 	"get.pme.sh/pmesh/vhttp"
 	"get.pme.sh/pmesh/xlog"
@@ -31,35 +35,49 @@ import (
 )
 
 type AppService struct {
-	Options
-	Monitor          health.Monitor     `yaml:"monitor,omitempty"`           // The health monitor.
-	LoadBalancerOpts lb.Options         `yaml:"lb,omitempty"`                // The load balancer.
-	Root             string             `yaml:"root,omitempty"`              // The root directory of the app.
-	Run              Command            `yaml:"run,omitempty"`               // The command to run the app.
-	Build            util.Some[Command] `yaml:"build,omitempty"`             // The command to build the app.
-	Shutdown         util.Some[Command] `yaml:"shutdown,omitempty"`          // The command to shutdown the app.
-	Cluster          string             `yaml:"cluster,omitempty"`           // The number of instances to run.
-	ClusterMin       string             `yaml:"cluster_min,omitempty"`       // The minimum number of instances to run.
-	Env              map[string]string  `yaml:"env,omitempty"`               // The environment variables to set.
-	EnvHost          string             `yaml:"env_host,omitempty"`          // The environment variable for the host.
-	EnvPort          string             `yaml:"env_port,omitempty"`          // The environment variable for the port.
-	EnvListen        string             `yaml:"env_listen,omitempty"`        // The environment variable for the address.
-	ReadyTimeout     util.Duration      `yaml:"ready_timeout,omitempty"`     // The timeout for the app to become ready.
-	StopTimeout      util.Duration      `yaml:"stop_timeout,omitempty"`      // The timeout for the app to stop.
-	NoBuildControl   bool               `yaml:"no_build_control,omitempty"`  // If true, linking logic between .run and .build will be disabled.
-	Background       bool               `yaml:"background,omitempty"`        // If true, the app is not a HTTP server.
-	LogFile          string             `yaml:"log,omitempty"`               // The log file for stdout&stderr, default = app.log
-	UnhealtyTimeout  util.Duration      `yaml:"unhealthy_timeout,omitempty"` // The timeout after which an unhealthy instance is killed.
-	SlowStart        bool               `yaml:"slow_start,omitempty"`        // If true, instances are started one by one.
-	MaxMemory        util.Size          `yaml:"max_memory,omitempty"`        // Maximum amount of memory the process is allowed to use, <= 0 means unlimited.
-	AutoScale        bool               `yaml:"auto_scale,omitempty"`        // If true, the app will be auto-scaled.
-	AutoScaleStreak  int                `yaml:"auto_scale_streak,omitempty"` // The number of consecutive ticks to trigger auto-scaling.
-	AutoScaleDefer   util.Duration      `yaml:"auto_scale_defer,omitempty"`  // The time to wait until considering a process in auto-scaling.
-	UpscalePercent   float64            `yaml:"upscale_percent,omitempty"`   // The percentage of CPU usage to trigger upscale.
-	DownscalePercent float64            `yaml:"downscale_percent,omitempty"` // The percentage of CPU usage to trigger downscale.
-	Stdin            bool               `yaml:"stdin,omitempty"`             // If true, the app will read from stdin.
+	Options          `yaml:"-"`
+	Monitor          health.Monitor     `yaml:"monitor,omitempty"`            // The health monitor.
+	LoadBalancerOpts lb.Options         `yaml:"lb,omitempty"`                 // The load balancer.
+	Root             string             `yaml:"root,omitempty"`               // The root directory of the app.
+	Workdir          string             `yaml:"workdir,omitempty"`            // The working directory of the commands without cwd, relative to the root, default = root.
+	User             string             `yaml:"user,omitempty"`               // The user to run the commands as, by name or uid, requires privileges, unsupported on Windows.
+	Group            string             `yaml:"group,omitempty"`              // The group to run the commands as, by name or gid, default = primary group of the user.
+	Namespaces       []string           `yaml:"namespaces,omitempty"`         // Linux namespaces to run the app in: pid, mount, ipc, uts or net, ignored where unavailable. The hooks run outside of them, see NamespacePID for the app being PID 1.
+	Run              Command            `yaml:"run,omitempty"`                // The command to run the app, it must stay in the foreground, apps that daemonize are killed.
+	Build            util.Some[Command] `yaml:"build,omitempty"`              // The command to build the app.
+	Shutdown         util.Some[Command] `yaml:"shutdown,omitempty"`           // The command to shutdown the app.
+	PostStart        util.Some[Command] `yaml:"post_start,omitempty"`         // The command to run once an instance is healthy, before it receives traffic.
+	PostStartFailure string             `yaml:"post_start_failure,omitempty"` // What to do if the post-start command fails: "reject" (default) or "ignore".
+	PreStop          util.Some[Command] `yaml:"pre_stop,omitempty"`           // The command to run before an instance is signalled to stop, given at most half of the stop timeout.
+	Cluster          string             `yaml:"cluster,omitempty"`            // The number of instances to run.
+	ClusterMin       string             `yaml:"cluster_min,omitempty"`        // The minimum number of instances to run.
+	Env              map[string]string  `yaml:"env,omitempty"`                // The environment variables to set, ${NAME} in the values and arguments expands to the variables of the process, including the allocated address.
+	EnvHost          string             `yaml:"env_host,omitempty"`           // The environment variable for the host.
+	EnvPort          string             `yaml:"env_port,omitempty"`           // The environment variable for the port.
+	EnvListen        string             `yaml:"env_listen,omitempty"`         // The environment variable for the address.
+	Warmup           Warmup             `yaml:"warmup,omitempty"`             // The traffic ramp of the instances started by a rolling restart.
+	Liveness         Liveness           `yaml:"liveness,omitempty"`           // The liveness check of the background apps.
+	Ready            string             `yaml:"ready,omitempty"`              // How the app signals readiness: "check" (default), "file" or "file+check", see ReadyFile.
+	ReadyTimeout     util.Duration      `yaml:"ready_timeout,omitempty"`      // The timeout for the app to become ready.
+	BuildRetry       retry.Policy       `yaml:"build_retry,omitempty"`        // The retries of the build commands failing with a transient error such as a network failure, default = 2 retries.
+	StopTimeout      util.Duration      `yaml:"stop_timeout,omitempty"`       // The timeout for the app to stop.
+	NoBuildControl   bool               `yaml:"no_build_control,omitempty"`   // If true, linking logic between .run and .build will be disabled.
+	Background       bool               `yaml:"background,omitempty"`         // If true, the app is not a HTTP server.
+	LogFile          string             `yaml:"log,omitempty"`                // The log file for stdout&stderr, default = app.log
+	LogFormat        string             `yaml:"log_format,omitempty"`         // The format of the app's output, "text" (default) or "json" to keep the level and fields of JSON lines.
+	UnhealtyTimeout  util.Duration      `yaml:"unhealthy_timeout,omitempty"`  // The timeout after which an unhealthy instance is killed.
+	SlowStart        bool               `yaml:"slow_start,omitempty"`         // If true, instances are started one by one, shortcut for start_concurrency = 1.
+	StartConcurrency int                `yaml:"start_concurrency,omitempty"`  // The number of instances started per tick when below the minimum, <= 0 means all at once.
+	MaxMemory        util.Size          `yaml:"max_memory,omitempty"`         // Maximum amount of memory the process is allowed to use, <= 0 means unlimited.
+	AutoScale        bool               `yaml:"auto_scale,omitempty"`         // If true, the app will be auto-scaled.
+	AutoScaleStreak  int                `yaml:"auto_scale_streak,omitempty"`  // The number of consecutive ticks to trigger auto-scaling.
+	AutoScaleDefer   util.Duration      `yaml:"auto_scale_defer,omitempty"`   // The time to wait until considering a process in auto-scaling.
+	UpscalePercent   float64            `yaml:"upscale_percent,omitempty"`    // The percentage of CPU usage to trigger upscale.
+	DownscalePercent float64            `yaml:"downscale_percent,omitempty"`  // The percentage of CPU usage to trigger downscale.
+	Stdin            bool               `yaml:"stdin,omitempty"`              // If true, the app will read from stdin.
 	cluterN          int
 	clusterMin       int
+	namespaces       []string // Namespaces supported by the platform.
 }
 
 var DefaultRunEnv = map[string]string{
@@ -75,6 +93,7 @@ var DefaultBuildEnv = map[string]string{
 	"PM3_BUILDING":    "1",
 }
 
+func (app *AppService) appService() *AppService { return app }
 func (app *AppService) String() string {
 	r := fmt.Sprintf("Exec{Root: %s, Run: %v, Build: %v}", app.Root, app.Run, app.Build)
 	if app.cluterN > 1 {
@@ -105,9 +124,27 @@ func (app *AppService) Prepare(opt Options) error {
 	if app.LogFile == "" {
 		app.LogFile = opt.Name + ".log"
 	}
+	if app.LogFormat != "" && app.LogFormat != "text" && app.LogFormat != "json" {
+		return fmt.Errorf("invalid log format %q", app.LogFormat)
+	}
+	switch app.Ready {
+	case "":
+		app.Ready = ReadyCheck
+	case ReadyCheck, ReadyFile, ReadyFileCheck:
+	default:
+		return fmt.Errorf("invalid readiness mode %q", app.Ready)
+	}
 	app.EnvHost = cmp.Or(app.EnvHost, "HOST")
 	app.EnvListen = cmp.Or(app.EnvListen, "LISTEN")
 	app.EnvPort = cmp.Or(app.EnvPort, "PORT")
+	if err := setCredential(&exec.Cmd{}, app.User, app.Group); err != nil {
+		return err
+	}
+	namespaces, err := app.prepareNamespaces()
+	if err != nil {
+		return err
+	}
+	app.namespaces = namespaces
 	if app.Root == "" {
 		app.Root = filepath.Join(opt.ServiceRoot, opt.Name)
 	} else if !filepath.IsAbs(app.Root) {
@@ -143,6 +180,25 @@ func (app *AppService) Prepare(opt Options) error {
 			"tcp": health.NewChecker(&health.TcpCheck{}),
 		}
 	}
+	if app.SlowStart {
+		app.StartConcurrency = 1
+	}
+	app.Warmup.prepare()
+	if err := app.Liveness.prepare(app.Background); err != nil {
+		return err
+	}
+	switch app.PostStartFailure {
+	case "":
+		app.PostStartFailure = PostStartReject
+	case PostStartReject, PostStartIgnore:
+	default:
+		return fmt.Errorf("invalid post_start_failure value %q", app.PostStartFailure)
+	}
+	if app.BuildRetry.Attempts == 0 {
+		app.BuildRetry.Attempts = 2
+	}
+	app.BuildRetry.Backoff = app.BuildRetry.Backoff.Or(5 * time.Second)
+	app.BuildRetry.Timeout = app.BuildRetry.Timeout.Or(10 * time.Minute)
 	app.ReadyTimeout = app.ReadyTimeout.Or(30 * time.Second)
 	app.StopTimeout = app.StopTimeout.Or(10 * time.Second)
 
@@ -170,7 +226,9 @@ func (app *AppService) Prepare(opt Options) error {
 
 func (app *AppService) createCmd(c context.Context, cmd *Command, build bool, chk glob.Checksum) (g GluedCommand, err error) {
 	cmd = cmd.Clone()
+	g.templates = newCmdTemplates(cmd, app.Env)
 	cmd.Env["PM3_BUILD"] = chk.String()
+	cmd.Env["PM3_SERVICE"] = app.Name
 	rootca := config.CertDir.File(security.GetSecretHash(config.Get().Secret) + "root.crt")
 	cmd.Env["ROOT_CA"] = rootca
 	cmd.Env["NODE_EXTRA_CA_CERTS"] = rootca
@@ -180,12 +238,22 @@ func (app *AppService) createCmd(c context.Context, cmd *Command, build bool, ch
 	}
 	cmd.MergeEnv(DefaultRunEnv)
 	cmd.MergeEnv(app.Env)
+	if cmd.Dir == "" {
+		cmd.Dir = app.Workdir
+	}
 	g.Cmd = cmd.Create(app.Root, c)
+	if err = setCredential(g.Cmd, app.User, app.Group); err != nil {
+		return
+	}
 
 	if f := xlog.FileWriter(app.LogFile); f != nil {
 		log := xlog.NewDomain(app.Options.Name, f)
-		wstdout, enc := xlog.ToTextWriter(log, xlog.LevelInfo)
-		wstderr, ence := xlog.ToTextWriter(log, xlog.LevelError)
+		toWriter := xlog.ToTextWriter
+		if app.LogFormat == "json" {
+			toWriter = xlog.ToStructuredWriter
+		}
+		wstdout, enc := toWriter(log, xlog.LevelInfo)
+		wstderr, ence := toWriter(log, xlog.LevelError)
 		context.AfterFunc(c, func() {
 			ence.Flush()
 			enc.Flush()
@@ -197,6 +265,10 @@ func (app *AppService) createCmd(c context.Context, cmd *Command, build bool, ch
 		g.Stdout = nil
 		g.Stderr = nil
 	}
+	if b := buildFromContext(c); b != nil && build {
+		g.Stdout = teeWriter(g.Stdout, b.writer("stdout"))
+		g.Stderr = teeWriter(g.Stderr, b.writer("stderr"))
+	}
 	if app.Stdin && !build {
 		g.Stdin = os.Stdin
 	} else {
@@ -204,7 +276,9 @@ func (app *AppService) createCmd(c context.Context, cmd *Command, build bool, ch
 	}
 	return
 }
-func (app *AppService) execCmd(c context.Context, cmd *Command, build bool, chk glob.Checksum) (GluedCommand, error) {
+
+// Runs the command to completion, env is appended to its environment.
+func (app *AppService) execCmd(c context.Context, cmd *Command, build bool, chk glob.Checksum, env ...string) (GluedCommand, error) {
 	if cmd.IsZero() {
 		return GluedCommand{}, nil
 	}
@@ -214,6 +288,8 @@ func (app *AppService) execCmd(c context.Context, cmd *Command, build bool, chk
 	if err != nil {
 		return GluedCommand{}, fmt.Errorf("failed to create command %q: %w", cmd.String(), err)
 	}
+	x.Env = append(x.Env, env...)
+	x.templates.expand(x.Cmd)
 	err = x.Run()
 	if err != nil {
 		err = fmt.Errorf("failed to run command %q: %w", cmd.String(), err)
@@ -223,10 +299,29 @@ func (app *AppService) execCmd(c context.Context, cmd *Command, build bool, chk
 
 func (app *AppService) runBuilder(chk glob.Checksum, c context.Context) error {
 	t0 := time.Now()
+	b := buildFromContext(c)
 	for _, cmd := range app.Build {
-		_, e := app.execCmd(c, &cmd, true, chk)
-		if e != nil {
-			return e
+		// Retry the command as long as it fails with a transient error, such as a network failure.
+		rt := app.BuildRetry.RetrierContext(c)
+		for attempt := 1; ; attempt++ {
+			var mark int
+			if b != nil {
+				mark = b.mark()
+			}
+			_, e := app.execCmd(c, &cmd, true, chk)
+			if e == nil {
+				break
+			}
+			berr := &BuildError{Command: cmd.String(), Attempts: attempt, Err: e}
+			if b != nil {
+				output := b.outputSince(mark)
+				berr.Transient = isTransientBuildFailure(output)
+				berr.Output = output[max(0, len(output)-buildErrorTail):]
+			}
+			if !berr.Transient || rt.ConsumeAny() != nil {
+				return berr
+			}
+			app.Logger.Warn().Err(e).Str("command", cmd.String()).Int("attempt", attempt).Msg("Transient build failure, retrying")
 		}
 	}
 	app.Logger.Info().Dur("time", time.Since(t0)).Hex("chk", chk[:4]).Msg("Build finished")
@@ -255,7 +350,19 @@ func (app *AppService) BuildApp(c context.Context, force bool) (chk glob.Checksu
 	// If we are not controlling the build, just run it.
 	//
 	if app.NoBuildControl {
-		err = app.runBuilder(chk, c)
+		bfs := BuildFS{Root: app.Root}
+		var unlock, unlockCluster func()
+		if unlockCluster, err = bfs.LockCluster(c, chk); err != nil {
+			return
+		}
+		defer unlockCluster()
+		if unlock, err = bfs.Lock(c); err != nil {
+			return
+		}
+		defer unlock()
+		b := Builds.begin(app.Name)
+		err = app.runBuilder(chk, context.WithValue(c, buildKey{}, b))
+		b.end(err)
 		return
 	}
 
@@ -263,28 +370,51 @@ func (app *AppService) BuildApp(c context.Context, force bool) (chk glob.Checksu
 	//
 	chk = glob.Hash(app.Root, glob.IgnoreArtifacts(), glob.AddGitIgnores(app.Root)).All()
 
-	// Check the cache.
+	// Wait for the other builders of the root, be it on this node or on the rest of the cluster, then
+	// check the cache. A forced build is skipped if another builder produced the same build while
+	// waiting.
 	//
 	bfs := BuildFS{Root: app.Root}
+	before, _ := bfs.ReadBuildId()
+	unlockCluster, err := bfs.LockCluster(c, chk)
+	if err != nil {
+		return
+	}
+	defer unlockCluster()
+	unlock, err := bfs.Lock(c)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	prev, _ := bfs.ReadBuildId()
+	if prev == chk && (!force || prev != before) {
+		app.Logger.Info().Hex("chk", chk[:4]).Msg("Skipping build")
+		return
+	}
 	if !force {
-		prev, _ := bfs.ReadBuildId()
-		if prev == chk {
-			app.Logger.Info().Hex("chk", chk[:4]).Msg("Skipping build")
-			return
-		}
 		app.Logger.Info().Hex("chk", chk[:4]).Hex("prev", prev[:4]).Msg("Building")
 	} else {
 		app.Logger.Info().Hex("chk", chk[:4]).Msg("Rebuilding")
 	}
 
-	// Build the app.
+	// Build the app, publishing the progress.
 	//
+	b := Builds.begin(app.Name)
 	err = bfs.RunBuild(chk, func() error {
-		return app.runBuilder(chk, c)
+		return app.runBuilder(chk, context.WithValue(c, buildKey{}, b))
 	})
+	b.end(err)
 	return
 }
 
+// Returns a writer writing to both, w may be nil.
+func teeWriter(w io.Writer, other io.Writer) io.Writer {
+	if w == nil {
+		return other
+	}
+	return io.MultiWriter(w, other)
+}
+
 type noopServer struct{ *AppService }
 
 func (noopServer) ServeHTTP(http.ResponseWriter, *http.Request) vhttp.Result {
@@ -344,13 +474,23 @@ type appProcessState struct {
 	die      context.CancelCauseFunc
 	upstream *lb.Upstream
 	logger   *xlog.Logger
+	started  time.Time
+	oom      oomCounter    // OOM kills in the cgroup of the process.
+	build    glob.Checksum // Build the process runs.
+	env      []string      // Variables of the instance, also given to its hooks.
+	// File touched by the instance to signal liveness, if checked.
+	heartbeatFile string
 
 	// Shared variable state
 	terminateDeadline atomic.Int64
 	signalSent        atomic.Bool
+	stopReason        atomic.Pointer[string] // Why pmesh requested the process to stop.
+	livenessBusy      atomic.Bool            // Whether a liveness check is running.
+	livenessFails     atomic.Int32           // Number of liveness check failures in a row.
 
 	// Variable state exclusively for ticker
-	downTicks int32
+	downTicks    int32
+	livenessNext time.Time // Time of the next liveness check.
 }
 
 func (state *appProcessState) dead() bool {
@@ -423,10 +563,16 @@ func (state *appProcessState) drain() bool {
 	}
 	return true // Drained.
 }
+
+// Records why pmesh stops the process, the first reason given is kept.
+func (state *appProcessState) setStopReason(reason string) {
+	state.stopReason.CompareAndSwap(nil, &reason)
+}
 func (state *appProcessState) tryTerminate(ctx context.Context) bool {
 	if state.dead() {
 		return true // Already terminated.
 	}
+	state.setStopReason("stop")
 
 	// Update upstream.
 	if state.upstream != nil {
@@ -444,16 +590,20 @@ func (state *appProcessState) tryTerminate(ctx context.Context) bool {
 		timeout = dl < time.Now().UnixMilli()
 	}
 	if timeout {
-		state.die(errors.New("killed (timeout)"))
+		state.die(errKilledTimeout)
 		return true // Timeout.
 	}
 
 	// Send a signal if it hasn't been sent yet.
 	if !state.signalSent.Swap(true) {
-		// If there is an upstream serving requests, wait for it to drain, then signal.
-		if state.upstream != nil {
+		// Run the pre-stop hook and if there is an upstream serving requests, wait for it to drain,
+		// then signal.
+		if state.upstream != nil || len(state.cfg.PreStop) != 0 {
 			go func() {
-				state.drain()
+				state.preStop()
+				if state.upstream != nil {
+					state.drain()
+				}
 				state.requestTermination()
 			}()
 		} else {
@@ -502,12 +652,16 @@ func (state *appProcessState) getCPUUsage() float64 {
 
 type AppServer struct {
 	*AppService
-	Checksum     glob.Checksum
-	Context      context.Context
-	LoadBalancer *lb.LoadBalancer
-	ticker       *time.Ticker
-	mu           sync.Mutex
-	processes    []*appProcessState
+	Checksum      glob.Checksum
+	Context       context.Context
+	LoadBalancer  *lb.LoadBalancer
+	ticker        *time.Ticker
+	mu            sync.Mutex
+	processes     []*appProcessState
+	exits         []ProcessExit  // Last exits of the processes, oldest first.
+	rolling       atomic.Bool    // Whether a rolling restart is in progress.
+	ramp          RampProgress   // Traffic ramp of the last rolling restart.
+	rampUpstreams []*lb.Upstream // Upstreams of the instances it started.
 }
 
 func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
@@ -524,13 +678,16 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 		}
 	}()
 
-	cmd, err := run.createCmd(pctx, &run.Run, false, run.Checksum)
+	chk := run.checksum()
+	cmd, err := run.createCmd(pctx, &run.Run, false, chk)
 	if err != nil {
 		return
 	}
+	setNamespaces(cmd.Cmd, run.namespaces)
 
 	// Allocate an IP address and create the upstream.
 	var upstream *lb.Upstream
+	var instanceEnv []string // Variables of the instance, also given to its hooks.
 	if run.LoadBalancer != nil {
 		const port = 8080
 		ip, err := SubnetAllocator().AllocateContext(pctx, port)
@@ -542,10 +699,31 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 		host := ip.String()
 		address := fmt.Sprintf("%s:%d", host, port)
 		upstream = lb.NewHttpUpstream(address)
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", run.EnvHost, host))
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", run.EnvListen, address))
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", run.EnvPort, port))
+		instanceEnv = append(instanceEnv,
+			fmt.Sprintf("%s=%s", run.EnvHost, host),
+			fmt.Sprintf("%s=%s", run.EnvListen, address),
+			fmt.Sprintf("%s=%d", run.EnvPort, port),
+		)
+		cmd.Env = append(cmd.Env, instanceEnv...)
 	}
+	var readyFile string
+	if upstream != nil && run.usesReadyFile() {
+		if readyFile, err = newInstanceFile(pctx, "ready", run.Name); err != nil {
+			return
+		}
+		cmd.Env = append(cmd.Env, "PM3_READY_FILE="+readyFile)
+	}
+	var heartbeatFile string
+	if upstream == nil && run.Liveness.Heartbeat {
+		if heartbeatFile, err = newInstanceFile(pctx, "heartbeat", run.Name); err != nil {
+			return
+		}
+		instanceEnv = append(instanceEnv, "PM3_HEARTBEAT_FILE="+heartbeatFile)
+		cmd.Env = append(cmd.Env, "PM3_HEARTBEAT_FILE="+heartbeatFile)
+	}
+	instance := snowflake.New().String()
+	cmd.Env = append(cmd.Env, envInstance+"="+instance)
+	cmd.templates.expand(cmd.Cmd)
 
 	// Start the app.
 	if err = cmd.Start(); err != nil {
@@ -556,6 +734,7 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 	// If context is cancelled, kill the process tree.
 	proc := cmd.Process
 	pid := proc.Pid
+	instanceEnv = append(instanceEnv, fmt.Sprintf("PM3_PID=%d", pid))
 	pproc := lo.Must(process.NewProcess(int32(pid)))
 	context.AfterFunc(pctx, func() {
 		NewProcessTree(pproc).Kill()
@@ -571,6 +750,13 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 		die:      die,
 		upstream: upstream,
 		logger:   xlog.NewDomain(fmt.Sprintf("%s.%d", run.Name, pid)),
+		started:  time.Now(),
+		oom:      newOOMCounter(pid),
+		build:    chk,
+		env:      instanceEnv,
+
+		heartbeatFile: heartbeatFile,
+		livenessNext:  time.Now().Add(run.Liveness.Interval.Duration()),
 	}
 	logger := state.logger
 	logger.Info().Msg("Process started")
@@ -581,6 +767,25 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 	// Monitor the process exit.
 	go func() {
 		err := cmd.Wait()
+		var stopReason string
+		if r := state.stopReason.Load(); r != nil {
+			stopReason = *r
+		}
+		killed := contextCause(pctx)
+		exit := newProcessExit(cmd.ProcessState, err, state.started, killed, stopReason, state.oom.Killed())
+		exit.PID = pid
+
+		// If the process exited on its own leaving forks behind, it daemonized.
+		if killed == nil && stopReason == "" {
+			if forks := findInstanceProcesses(instance); len(forks) != 0 {
+				logger.Error().Int("count", len(forks)).Msg("App daemonized, killing its forks, it must run in the foreground")
+				for _, fork := range forks {
+					NewProcessTree(fork).Kill()
+				}
+				exit.Reason, exit.Cause = ExitDaemonized, errDaemonized.Error()
+				err = errDaemonized
+			}
+		}
 		if err == nil {
 			err = errors.New("success")
 		}
@@ -590,7 +795,18 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 		if upstream != nil {
 			run.LoadBalancer.RemoveUpstream(upstream)
 		}
-		logger.Info().Err(err).Msg("Process exited")
+		run.mu.Lock()
+		if len(run.exits) >= maxProcessExits {
+			run.exits = run.exits[1:]
+		}
+		run.exits = append(run.exits, exit)
+		run.mu.Unlock()
+
+		ev := logger.Info()
+		if !exit.ByPmesh && exit.Reason != ExitSuccess {
+			ev = logger.Warn()
+		}
+		ev.Err(err).Int("code", exit.Code).Str("signal", exit.Signal).Str("reason", exit.Reason).Bool("by_pmesh", exit.ByPmesh).Msg("Process exited")
 	}()
 
 	// If there's an upstream:
@@ -599,7 +815,20 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 		if initialProcess {
 			readyCtx, cancel := context.WithTimeout(pctx, run.ReadyTimeout.Duration())
 			defer cancel()
-			for {
+			if readyFile != "" {
+				logger.Info().Msg("Waiting for app to signal readiness")
+				if !waitReadyFile(readyCtx, readyFile) {
+					select {
+					case <-pctx.Done():
+						return context.Cause(pctx)
+					default:
+						err = errors.New("timed out waiting for app to signal readiness")
+						die(err)
+						return
+					}
+				}
+			}
+			for run.Ready != ReadyFile {
 				logger.Info().Msg("Waiting for app to become healthy")
 				healthy := run.Monitor.Check(readyCtx, logger, upstream.Address)
 				if healthy {
@@ -621,40 +850,100 @@ func (run *AppServer) spawnProcess(initialProcess bool) (err error) {
 				case <-time.After(500 * time.Millisecond):
 				}
 			}
+			if run.Ready == ReadyFile {
+				logger.Info().Str("address", upstream.Address).Msg("App started and ready")
+				upstream.SetHealthy(true)
+			}
 		} else {
 			upstream.SetHealthy(false) // Assume unhealthy, let the monitor decide.
 		}
 
-		// Monitor the health of the instance.
-		if timeout := run.UnhealtyTimeout.Or(10 * time.Second).Duration(); timeout > 0 {
-			var timer *time.Timer
-			run.Monitor.Observe(pctx, logger, upstream.Address, health.ObserverFunc(func(healthy bool) {
-				upstream.SetHealthy(healthy)
-				if !healthy {
-					if timer == nil {
-						timer = time.AfterFunc(timeout, func() {
-							if !upstream.Healthy.Load() {
-								logger.Warn().Str("address", upstream.Address).Msg("Unhealthy instance did not recover, killing it")
-								state.tryTerminate(context.Background())
-							}
-						})
-					}
-				} else {
-					if timer != nil {
-						timer.Stop()
-						timer = nil
+		// Monitor the health of the instance, once it signaled readiness if it was not waited for.
+		if readyFile != "" && !initialProcess {
+			go func() {
+				readyCtx, cancel := context.WithTimeout(pctx, run.ReadyTimeout.Duration())
+				defer cancel()
+				if !waitReadyFile(readyCtx, readyFile) {
+					if pctx.Err() == nil {
+						die(errors.New("timed out waiting for app to signal readiness"))
 					}
+					return
 				}
-			}))
+				logger.Info().Str("address", upstream.Address).Msg("App signaled readiness")
+				if run.Ready == ReadyFile {
+					upstream.SetHealthy(true)
+				}
+				run.observeInstance(state)
+			}()
 		} else {
-			run.Monitor.Observe(pctx, logger, upstream.Address, upstream)
+			run.observeInstance(state)
 		}
 
-		// Add the upstream to the load balancer.
-		run.LoadBalancer.AddUpstream(upstream)
+		// The instances replacing the old ones in a rolling restart start with a reduced share of the
+		// traffic until they are warm.
+		ramp := initialProcess && run.rolling.Load()
+		if ramp && run.Warmup.enabled() {
+			upstream.SetWeight(run.Warmup.Weight)
+		}
+
+		// Add the upstream to the load balancer, once the post-start hook succeeds if any.
+		if len(run.PostStart) == 0 {
+			run.LoadBalancer.AddUpstream(upstream)
+		} else if initialProcess {
+			if err = run.postStart(state); err != nil {
+				return
+			}
+			run.LoadBalancer.AddUpstream(upstream)
+		} else {
+			go func() {
+				if waitHealthy(pctx, upstream) && run.postStart(state) == nil {
+					run.LoadBalancer.AddUpstream(upstream)
+				}
+			}()
+		}
+		if ramp {
+			run.rampInstance(state)
+		}
+	} else if len(run.PostStart) != 0 {
+		if initialProcess {
+			err = run.postStart(state)
+		} else {
+			go run.postStart(state)
+		}
 	}
 	return
 }
+
+// Monitors the health of an instance, killing it if it stays unhealthy.
+func (run *AppServer) observeInstance(state *appProcessState) {
+	upstream, logger := state.upstream, state.logger
+	timeout := run.UnhealtyTimeout.Or(10 * time.Second).Duration()
+	if timeout <= 0 {
+		run.Monitor.Observe(state.ctx, logger, upstream.Address, upstream)
+		return
+	}
+	var timer *time.Timer
+	run.Monitor.Observe(state.ctx, logger, upstream.Address, health.WithLatency(health.ObserverFunc(func(healthy bool) {
+		upstream.SetHealthy(healthy)
+		if !healthy {
+			if timer == nil {
+				timer = time.AfterFunc(timeout, func() {
+					if !upstream.Healthy.Load() {
+						logger.Warn().Str("address", upstream.Address).Msg("Unhealthy instance did not recover, killing it")
+						state.setStopReason("unhealthy")
+						state.tryTerminate(context.Background())
+					}
+				})
+			}
+		} else {
+			if timer != nil {
+				timer.Stop()
+				timer = nil
+			}
+		}
+	}), upstream))
+}
+
 func (run *AppServer) getProcesses() (res []*appProcessState) {
 	run.mu.Lock()
 	run.processes = lo.Filter(run.processes, func(state *appProcessState, _ int) bool {
@@ -691,19 +980,34 @@ tick_loop:
 				}
 				if mem := proc.getMemoryUsage(); mem > uint64(run.MaxMemory) {
 					proc.logger.Warn().Stringer("max", run.MaxMemory).Stringer("rss", util.Size(mem)).Msg("Memory usage exceeded")
+					proc.setStopReason("max_memory")
 					proc.tryTerminate(context.Background())
 				}
 			}
 		}
 
-		// If we're below the minimum amount, match it.
-		for count := len(list); count < run.clusterMin; count++ {
-			if err := run.spawnProcess(false); err != nil {
-				run.Logger.Err(err).Msg("Failed to spawn instance")
-				break
+		// If the background app is checked for liveness, restart the hung instances.
+		if run.LoadBalancer == nil && run.Liveness.enabled() {
+			for _, proc := range list {
+				if !proc.terminating() && proc.tickLiveness() {
+					proc.logger.Warn().Msg("Instance is not alive, restarting it")
+					proc.setStopReason("not_alive")
+					proc.tryTerminate(context.Background())
+				}
 			}
-			if run.SlowStart {
-				continue tick_loop
+		}
+
+		// If we're below the minimum amount, match it, starting at most StartConcurrency per tick.
+		if count := len(list); count < run.clusterMin {
+			started := 0
+			for ; count < run.clusterMin; count++ {
+				if err := run.spawnProcess(false); err != nil {
+					run.Logger.Err(err).Msg("Failed to spawn instance")
+					break
+				}
+				if started++; run.StartConcurrency > 0 && started >= run.StartConcurrency {
+					continue tick_loop
+				}
 			}
 		}
 
@@ -763,6 +1067,7 @@ tick_loop:
 					}
 					if proc.downTicks >= int32(run.AutoScaleStreak) {
 						run.Logger.Info().Int("total", total).Int("up", up).Int("down", down).Int("neutral", neutral).Floats64("usage", usageList).Msg("Auto-scaling down")
+						proc.setStopReason("downscale")
 						proc.tryTerminate(context.Background())
 						break
 					}
@@ -803,7 +1108,7 @@ func (run *AppServer) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.Re
 func (run *AppServer) Stop(c context.Context) {
 	// Stop the ticker and shutdown the app.
 	run.ticker.Stop()
-	run.ShutdownApp(c, run.Checksum)
+	run.ShutdownApp(c, run.checksum())
 
 	// Terminate all processes.
 	wg := sync.WaitGroup{}
@@ -821,6 +1126,11 @@ func (run *AppServer) Stop(c context.Context) {
 func (run *AppServer) GetLoadBalancer() *lb.LoadBalancer {
 	return run.LoadBalancer
 }
+func (run *AppServer) GetExits() []ProcessExit {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return slices.Clone(run.exits)
+}
 func (run *AppServer) GetProcessTrees() (res []ProcessTree) {
 	return lo.Map(run.getProcesses(), func(s *appProcessState, _ int) (t ProcessTree) { return NewProcessTree(s.proc) })
 }
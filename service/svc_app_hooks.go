@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"get.pme.sh/pmesh/lb"
+)
+
+// Handling of the failures of the post-start hook.
+const (
+	PostStartReject = "reject" // The instance is killed without ever receiving traffic, the default.
+	PostStartIgnore = "ignore" // The failure is logged and the instance receives traffic anyway.
+)
+
+// Runs the post-start hook of the instance, bounded by the ready timeout. The variables of the
+// instance are added to the environment of the commands. Returns an error if the instance must
+// not be admitted, in which case it is killed.
+func (run *AppServer) postStart(state *appProcessState) error {
+	ctx, cancel := context.WithTimeout(state.ctx, run.ReadyTimeout.Duration())
+	defer cancel()
+
+	t0 := time.Now()
+	for _, cmd := range run.PostStart {
+		_, err := run.execCmd(ctx, &cmd, false, state.build, state.env...)
+		if err == nil {
+			continue
+		}
+		if state.ctx.Err() != nil {
+			return context.Cause(state.ctx)
+		}
+		if run.PostStartFailure == PostStartIgnore {
+			state.logger.Warn().Err(err).Msg("Post-start hook failed, ignoring")
+			return nil
+		}
+		state.logger.Error().Err(err).Msg("Post-start hook failed, killing the instance")
+		state.setStopReason("post_start")
+		state.die(err)
+		return err
+	}
+	state.logger.Info().Dur("time", time.Since(t0)).Msg("Post-start hook finished")
+	return nil
+}
+
+// Runs the pre-stop hook of the instance, bounded by half of the time left until it is killed so
+// that it can still stop gracefully. Failures are logged, the instance is stopped anyway.
+func (state *appProcessState) preStop() {
+	if len(state.cfg.PreStop) == 0 {
+		return
+	}
+	deadline := time.UnixMilli(state.terminateDeadline.Load())
+	ctx, cancel := context.WithTimeout(state.ctx, time.Until(deadline)/2)
+	defer cancel()
+
+	t0 := time.Now()
+	for _, cmd := range state.cfg.PreStop {
+		if _, err := state.cfg.execCmd(ctx, &cmd, false, state.build, state.env...); err != nil {
+			if state.ctx.Err() == nil {
+				state.logger.Warn().Err(err).Msg("Pre-stop hook failed")
+			}
+			return
+		}
+	}
+	state.logger.Info().Dur("time", time.Since(t0)).Msg("Pre-stop hook finished")
+}
+
+// Waits until the upstream is first seen healthy, returns false if the context is done first.
+func waitHealthy(ctx context.Context, upstream *lb.Upstream) bool {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for !upstream.Healthy.Load() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
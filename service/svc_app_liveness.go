@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"get.pme.sh/pmesh/util"
+	"get.pme.sh/pmesh/vhttp"
+)
+
+// Liveness configures the liveness check of the background apps, which have no address to check
+// the health of, so that a hung instance is restarted rather than assumed healthy as long as its
+// process runs. All the configured checks must pass.
+type Liveness struct {
+	Exec      util.Some[Command] `yaml:"exec,omitempty"`      // A command exiting with 0 if the instance is alive, PM3_PID is its process.
+	Heartbeat bool               `yaml:"heartbeat,omitempty"` // If true, the instance must touch the file named by PM3_HEARTBEAT_FILE at least every timeout.
+	Nats      string             `yaml:"nats,omitempty"`      // A subject the instance must reply to, ${NAME} expands to the variables of the instance.
+	Interval  util.Duration      `yaml:"interval,omitempty"`  // The interval between the checks, default = 10s.
+	Timeout   util.Duration      `yaml:"timeout,omitempty"`   // The timeout of the checks and the maximum age of the heartbeat, default = 30s.
+	Threshold int                `yaml:"threshold,omitempty"` // The number of failures in a row after which the instance is restarted, default = 3.
+}
+
+func (l *Liveness) enabled() bool {
+	return len(l.Exec) != 0 || l.Heartbeat || l.Nats != ""
+}
+func (l *Liveness) prepare(background bool) error {
+	if !l.enabled() {
+		return nil
+	}
+	if !background {
+		return errors.New("liveness is only supported by background apps, use the monitor instead")
+	}
+	l.Interval = l.Interval.Or(10 * time.Second)
+	l.Timeout = l.Timeout.Or(30 * time.Second)
+	if l.Threshold <= 0 {
+		l.Threshold = 3
+	}
+	return nil
+}
+
+// Checks the liveness of the instance.
+func (state *appProcessState) checkLiveness() error {
+	l := &state.cfg.Liveness
+	ctx, cancel := context.WithTimeout(state.ctx, l.Timeout.Duration())
+	defer cancel()
+
+	if state.heartbeatFile != "" {
+		last := state.started
+		if fi, err := os.Stat(state.heartbeatFile); err == nil {
+			last = fi.ModTime()
+		}
+		if age := time.Since(last); age > l.Timeout.Duration() {
+			return fmt.Errorf("no heartbeat for %s", util.Duration(age).Display())
+		}
+	}
+	for _, cmd := range l.Exec {
+		if _, err := state.cfg.execCmd(ctx, &cmd, false, state.build, state.env...); err != nil {
+			return err
+		}
+	}
+	if l.Nats != "" {
+		cli := vhttp.ResolveNatsFromContext(state.ctx)
+		if cli == nil {
+			return nil // Not known either way.
+		}
+		subject := ExpandTemplate(l.Nats, func(name string) string {
+			for _, kv := range state.env {
+				if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+					return v
+				}
+			}
+			return os.Getenv(name)
+		})
+		if _, err := cli.RequestWithContext(ctx, subject, nil); err != nil {
+			return fmt.Errorf("no reply on %q: %w", subject, err)
+		}
+	}
+	return nil
+}
+
+// Runs the liveness check of the instance if due, called by the ticker. Returns true if the
+// instance failed it enough times in a row to be considered hung.
+func (state *appProcessState) tickLiveness() bool {
+	l := &state.cfg.Liveness
+	if now := time.Now(); now.After(state.livenessNext) && !state.livenessBusy.Swap(true) {
+		state.livenessNext = now.Add(l.Interval.Duration())
+		go func() {
+			defer state.livenessBusy.Store(false)
+			if err := state.checkLiveness(); err != nil {
+				if state.dead() {
+					return
+				}
+				n := state.livenessFails.Add(1)
+				state.logger.Warn().Err(err).Int32("failures", n).Msg("Liveness check failed")
+			} else {
+				state.livenessFails.Store(0)
+			}
+		}()
+	}
+	return state.livenessFails.Load() >= int32(l.Threshold)
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/snowflake"
+)
+
+// Readiness modes of an app, applied to every instance it starts, including the replacements and
+// the scale-ups. There is no mode signaling over an inherited descriptor like sd_notify.
+const (
+	ReadyCheck     = "check"      // The instance is ready once the health check passes, the default.
+	ReadyFile      = "file"       // The instance is ready once it creates the file named by PM3_READY_FILE.
+	ReadyFileCheck = "file+check" // The instance is ready once it creates the file and the health check passes.
+)
+
+// Interval of the polling of the readiness file.
+const readyFilePoll = 50 * time.Millisecond
+
+func (app *AppService) usesReadyFile() bool {
+	return app.Ready == ReadyFile || app.Ready == ReadyFileCheck
+}
+
+// Returns the path of a new file of the given kind for an instance, such as a readiness file,
+// removed once the context is done.
+func newInstanceFile(ctx context.Context, kind, name string) (string, error) {
+	dir := filepath.Join(config.Home(), kind)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	// The apps may run as another user.
+	os.Chmod(dir, 0777|os.ModeSticky)
+	path := filepath.Join(dir, fmt.Sprintf("%s.%s", name, snowflake.New()))
+	context.AfterFunc(ctx, func() { os.Remove(path) })
+	return path, nil
+}
+
+// Waits until the readiness file is created, returns false if the context is done first.
+func waitReadyFile(ctx context.Context, path string) bool {
+	ticker := time.NewTicker(readyFilePoll)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"get.pme.sh/pmesh/glob"
+
+	"github.com/samber/lo"
+)
+
+// InstanceRolling is implemented by the instances that can replace their processes one at a time.
+type InstanceRolling interface {
+	RollingRestart(ctx context.Context, invalidate bool) (int, error)
+}
+
+// Returns the build the new processes run.
+func (run *AppServer) checksum() glob.Checksum {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.Checksum
+}
+
+// RollingRestart rebuilds the app if it changed, then replaces the instances one at a time: a new
+// instance is started and awaited healthy before the one it replaces is drained and stopped, so
+// the capacity never drops. With warm checks, it is only stopped once its replacement is warm,
+// see Warmup. The running definition of the app is kept, changes to the manifest need a reload.
+// Returns the number of instances replaced.
+func (run *AppServer) RollingRestart(ctx context.Context, invalidate bool) (n int, err error) {
+	if !run.rolling.CompareAndSwap(false, true) {
+		return 0, errors.New("rolling restart already in progress")
+	}
+	defer run.rolling.Store(false)
+
+	chk, err := run.BuildApp(ctx, invalidate)
+	if err != nil {
+		return 0, err
+	}
+	if chk != (glob.Checksum{}) {
+		run.mu.Lock()
+		run.Checksum = chk
+		run.mu.Unlock()
+	}
+
+	procs := lo.Filter(run.getProcesses(), func(proc *appProcessState, _ int) bool {
+		return !proc.terminating()
+	})
+	run.beginRamp(len(procs))
+	defer run.endRamp()
+	for _, proc := range procs {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if err = run.spawnProcess(true); err != nil {
+			return n, fmt.Errorf("replacement instance failed to start: %w", err)
+		}
+		run.mu.Lock()
+		run.ramp.Replaced++
+		run.mu.Unlock()
+		proc.setStopReason("rolling restart")
+		proc.terminate(ctx)
+		n++
+	}
+	run.Logger.Info().Int("count", n).Msg("Rolling restart finished")
+	return
+}
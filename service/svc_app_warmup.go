@@ -0,0 +1,119 @@
+package service
+
+import (
+	"time"
+
+	"get.pme.sh/pmesh/health"
+	"get.pme.sh/pmesh/lb"
+	"get.pme.sh/pmesh/util"
+)
+
+// Warmup configures the traffic ramp of the rolling restarts. The new instances first receive a
+// reduced share of the traffic, then the full share once they pass the warm checks, so that the
+// traffic moves to the new fleet as it warms up rather than as soon as it is healthy.
+type Warmup struct {
+	Checks   map[string]health.Checker `yaml:"test,omitempty"`     // The checks an instance must pass to be warm, no ramp if none.
+	Weight   int32                     `yaml:"weight,omitempty"`   // The share of the traffic of a cold instance in percent, default = 10.
+	Interval util.Duration             `yaml:"interval,omitempty"` // The interval between the checks, default = 2s.
+	Timeout  util.Duration             `yaml:"timeout,omitempty"`  // The time after which a cold instance gets the full share anyway, default = 2m.
+}
+
+func (w *Warmup) enabled() bool {
+	return len(w.Checks) != 0
+}
+func (w *Warmup) prepare() {
+	if w.Weight <= 0 || w.Weight > 100 {
+		w.Weight = 10
+	}
+	w.Interval = w.Interval.Or(2 * time.Second)
+	w.Timeout = w.Timeout.Or(2 * time.Minute)
+}
+
+// RampProgress is the progress of the traffic ramp of the last rolling restart.
+type RampProgress struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started"`
+	Total    int       `json:"total"`    // Number of instances to replace.
+	Replaced int       `json:"replaced"` // Number of new instances started.
+	Warm     int       `json:"warm"`     // Number of new instances with the full share of the traffic.
+	Traffic  float64   `json:"traffic"`  // Share of the traffic going to the new instances, 0-1.
+}
+
+// InstanceRamp is implemented by the instances reporting the progress of their traffic ramp.
+type InstanceRamp interface {
+	GetRamp() (RampProgress, bool)
+}
+
+// GetRamp returns the progress of the traffic ramp of the last rolling restart, false if none.
+func (run *AppServer) GetRamp() (RampProgress, bool) {
+	run.mu.Lock()
+	res, upstreams := run.ramp, run.rampUpstreams
+	run.mu.Unlock()
+	if res.Started.IsZero() {
+		return res, false
+	}
+	if run.LoadBalancer != nil {
+		weight := func(u *lb.Upstream) float64 {
+			if !u.Healthy.Load() {
+				return 0
+			}
+			return float64(u.Weight.Load())
+		}
+		var total, ramped float64
+		for _, u := range run.LoadBalancer.Upstreams() {
+			total += weight(u)
+		}
+		for _, u := range upstreams {
+			ramped += weight(u)
+		}
+		if total > 0 {
+			res.Traffic = min(ramped/total, 1)
+		}
+	}
+	return res, true
+}
+
+func (run *AppServer) beginRamp(total int) {
+	run.mu.Lock()
+	run.ramp = RampProgress{Running: true, Started: time.Now(), Total: total}
+	run.rampUpstreams = nil
+	run.mu.Unlock()
+}
+func (run *AppServer) endRamp() {
+	run.mu.Lock()
+	run.ramp.Running = false
+	run.mu.Unlock()
+}
+
+// Ramps the traffic of an instance started by a rolling restart, admitted with the reduced share
+// of the traffic if warm checks are configured: waits until it passes the checks or the timeout
+// expires, then gives it the full share.
+func (run *AppServer) rampInstance(state *appProcessState) {
+	upstream := state.upstream
+	run.mu.Lock()
+	run.rampUpstreams = append(run.rampUpstreams, upstream)
+	run.mu.Unlock()
+
+	if run.Warmup.enabled() {
+		t0 := time.Now()
+		monitor := health.Monitor{Checks: run.Warmup.Checks}
+		deadline := time.After(run.Warmup.Timeout.Duration())
+	loop:
+		for !monitor.Check(state.ctx, nil, upstream.Address) {
+			select {
+			case <-state.ctx.Done():
+				return
+			case <-deadline:
+				state.logger.Warn().Msg("Instance did not warm up in time, giving it the full share of traffic")
+				break loop
+			case <-run.Warmup.Interval.After():
+			}
+		}
+		upstream.SetWeight(100)
+		state.logger.Info().Dur("time", time.Since(t0)).Msg("Instance warmed up")
+	}
+
+	run.mu.Lock()
+	run.ramp.Warm++
+	run.mu.Unlock()
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"get.pme.sh/pmesh/lyml"
+	"get.pme.sh/pmesh/util"
+
+	"gopkg.in/yaml.v3"
+)
+
+func (t Service) MarshalYAML() (any, error) {
+	if t.service == nil {
+		return nil, nil
+	}
+	tag, ok := Registry.TagOf(t.service)
+	if !ok {
+		return nil, fmt.Errorf("unregistered service type %T", t.service)
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(t.service); err != nil {
+		return nil, err
+	}
+	node.Tag = "!" + tag
+	return node, nil
+}
+
+// Environment variables whose names contain any of these are considered secrets.
+var secretEnvMarkers = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE", "KEY"}
+
+func isSecretEnv(name string) bool {
+	name = strings.ToUpper(name)
+	for _, m := range secretEnvMarkers {
+		if strings.Contains(name, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the values of secret environment variables with a reference to the
+// environment of the importing node.
+func referenceSecrets(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		for _, c := range node.Content {
+			referenceSecrets(c)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if k.Value == "env" && v.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(v.Content); j += 2 {
+				if name := v.Content[j].Value; isSecretEnv(name) {
+					v.Content[j+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "$(env." + name + ")"}
+				}
+			}
+			continue
+		}
+		referenceSecrets(v)
+	}
+}
+
+// Export encodes the service definitions as YAML that can be imported into another manifest,
+// secret environment variables are referenced rather than inlined.
+func Export(services util.OrderedMap[string, Service]) ([]byte, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(services); err != nil {
+		return nil, err
+	}
+	referenceSecrets(node)
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+// Import decodes exported service definitions, validating each as if it was loaded from a manifest
+// with the given service root.
+func Import(data []byte, serviceRoot string) (services util.OrderedMap[string, Service], err error) {
+	if err = lyml.Unmarshal(data, &services); err != nil {
+		return
+	}
+	for _, kv := range services {
+		if kv.B.service == nil {
+			return nil, fmt.Errorf("service %q: empty definition", kv.A)
+		}
+		if err = kv.B.Prepare(Options{Name: kv.A, ServiceRoot: serviceRoot}); err != nil {
+			return nil, fmt.Errorf("service %q: %w", kv.A, err)
+		}
+	}
+	return
+}
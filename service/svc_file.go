@@ -11,24 +11,31 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"get.pme.sh/pmesh/util"
 	"get.pme.sh/pmesh/vhttp"
 
 	"github.com/andybalholm/brotli"
+	"github.com/samber/lo"
 )
 
 type FileService struct {
-	Options
-	Path             string         `yaml:"path,omitempty"`               // The path to serve
-	NotFound         string         `yaml:"404,omitempty"`                // Static file for 404 errors
-	Dynamic          bool           `yaml:"dynamic,omitempty"`            // If true, the fileserver will serve files directly from the filesystem instead of in-memory
-	Immutable        bool           `yaml:"immutable,omitempty"`          // If true, the fileserver will assume the files are immutable and set cache headers accordingly
-	NoImmutableMatch bool           `yaml:"no_immutable_match,omitempty"` // If true, the fileserver will not assume the files are immutable if the path contains /immutable/
-	IndexFile        string         `yaml:"index,omitempty"`              // The index file to serve if the path is a directory
-	Match            *regexp.Regexp `yaml:"match,omitempty"`              // The pattern to match
+	Options          `yaml:"-"`
+	FS               fs.FS             `yaml:"-"`                            // If set, serves this filesystem instead of the path
+	Path             string            `yaml:"path,omitempty"`               // The path to serve, either a directory or an archive (.zip, .tar, .tar.gz)
+	NotFound         string            `yaml:"404,omitempty"`                // Static file for 404 errors
+	Dynamic          bool              `yaml:"dynamic,omitempty"`            // If true, the fileserver will serve files directly from the filesystem instead of in-memory
+	Immutable        bool              `yaml:"immutable,omitempty"`          // If true, the fileserver will assume the files are immutable and set cache headers accordingly
+	NoImmutableMatch bool              `yaml:"no_immutable_match,omitempty"` // If true, the fileserver will not assume the files are immutable if the path contains /immutable/
+	IndexFile        util.Some[string] `yaml:"index,omitempty"`              // The index files tried in order if the path is a directory
+	Fingerprint      bool              `yaml:"fingerprint,omitempty"`        // If true, assets are also served under names containing their hash (in-memory only), see memoryFileSystem.fingerprint
+	Match            *regexp.Regexp    `yaml:"match,omitempty"`              // The pattern to match
+	Symlinks         string            `yaml:"symlinks,omitempty"`           // Symlink policy when serving a directory: root (default), never or follow, see SymlinksRoot
+	CaseInsensitive  bool              `yaml:"case_insensitive,omitempty"`   // If true, paths are matched regardless of their case (in-memory only)
 }
 
 func (fsrv *FileService) UnmarshalInline(text string) error {
@@ -37,7 +44,7 @@ func (fsrv *FileService) UnmarshalInline(text string) error {
 }
 
 func init() {
-	Registry.Define("FS", func() any { return &FileService{IndexFile: "/index.html"} })
+	Registry.Define("FS", func() any { return &FileService{IndexFile: util.One("/index.html")} })
 }
 
 // Implement service.Service
@@ -61,6 +68,30 @@ type memoryFile struct {
 	children []*memoryFile
 }
 
+func (mf *memoryFile) Size() int64 {
+	if mf.FileInfo.Mode().IsRegular() {
+		return int64(len(mf.data))
+	}
+	return mf.FileInfo.Size()
+}
+
+// Updates the brotli compressed data of the file if it is worth it.
+func (mf *memoryFile) compress(name string) {
+	mf.brdata = nil
+	if _, bad := badcompressionExt[filepath.Ext(name)]; !bad {
+		brwr := bytes.NewBuffer(nil)
+		wr := brotli.NewWriterV2(brwr, 5)
+		wr.Write(mf.data)
+		if wr.Close() != nil {
+			return
+		}
+		compressionRatio := float64(brwr.Len()) / float64(len(mf.data))
+		if compressionRatio < 0.9 {
+			mf.brdata = brwr.Bytes()
+		}
+	}
+}
+
 type memoryFileHandle struct {
 	*memoryFile
 	bytes.Reader
@@ -82,8 +113,24 @@ func (f *memoryFileHandle) Readdir(count int) ([]os.FileInfo, error) {
 }
 
 type memoryFileSystem struct {
-	files   map[string]*memoryFile
-	pattern *regexp.Regexp
+	files     map[string]*memoryFile
+	pattern   *regexp.Regexp
+	immutable map[string]struct{}    // Fingerprinted names
+	folded    map[string]*memoryFile // Files by lowercase name, if case-insensitive
+}
+
+// Makes the lookups case-insensitive, names differing only by their case resolve to the first in
+// lexical order.
+func (mfs *memoryFileSystem) foldCase() {
+	names := lo.Keys(mfs.files)
+	slices.Sort(names)
+	mfs.folded = make(map[string]*memoryFile, len(names))
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if _, ok := mfs.folded[key]; !ok {
+			mfs.folded[key] = mfs.files[name]
+		}
+	}
 }
 
 func (mfs *memoryFileSystem) Open(name string) (http.File, error) {
@@ -93,7 +140,11 @@ func (mfs *memoryFileSystem) Open(name string) (http.File, error) {
 		name = "."
 	}
 	name, br := strings.CutSuffix(name, "@br")
-	if f, ok := mfs.files[name]; ok {
+	f, ok := mfs.files[name]
+	if !ok && mfs.folded != nil {
+		f, ok = mfs.folded[strings.ToLower(name)]
+	}
+	if ok {
 		if !f.Mode().IsRegular() {
 			return nil, os.ErrNotExist
 		}
@@ -173,19 +224,7 @@ func (mfs *memoryFileSystem) recursiveAdd(hfs fs.FS, path string, root *memoryFi
 			defer file.Close()
 			defer wg.Done()
 			io.ReadFull(file, data)
-
-			if _, bad := badcompressionExt[filepath.Ext(npath)]; !bad {
-				brwr := bytes.NewBuffer(nil)
-				wr := brotli.NewWriterV2(brwr, 5)
-				wr.Write(data)
-				if wr.Close() != nil {
-					return
-				}
-				compressionRatio := float64(brwr.Len()) / float64(len(data))
-				if compressionRatio < 0.9 {
-					mf.brdata = brwr.Bytes()
-				}
-			}
+			mf.compress(npath)
 		}()
 	} else {
 		defer file.Close()
@@ -206,12 +245,11 @@ func (mfs *memoryFileSystem) recursiveAdd(hfs fs.FS, path string, root *memoryFi
 	}
 	return nil
 }
-func NewMemoryFileSystem(path string, pattern *regexp.Regexp) (*memoryFileSystem, error) {
+func NewMemoryFileSystem(hfs fs.FS, pattern *regexp.Regexp) (*memoryFileSystem, error) {
 	mfs := &memoryFileSystem{
 		files:   make(map[string]*memoryFile),
 		pattern: pattern,
 	}
-	hfs := os.DirFS(path)
 	wg := &sync.WaitGroup{}
 	err := mfs.recursiveAdd(hfs, ".", &memoryFile{}, wg)
 	wg.Wait()
@@ -225,19 +263,42 @@ type FileServer struct {
 
 func (fsrv *FileService) Start(c context.Context, invaliate bool) (Instance, error) {
 	inst := &FileServer{FileService: fsrv}
-	if stat, err := os.Stat(fsrv.Path); err != nil {
-		return nil, err
-	} else if !stat.IsDir() {
-		return nil, fmt.Errorf("path %q is not a directory", fsrv.Path)
+	hfs, isDir := fsrv.FS, false
+	if hfs == nil {
+		if stat, err := os.Stat(fsrv.Path); err != nil {
+			return nil, err
+		} else if isDir = stat.IsDir(); isDir {
+			if fsrv.Symlinks == SymlinksFollow {
+				hfs = os.DirFS(fsrv.Path)
+			} else if hfs, err = newRootedFS(fsrv.Path, fsrv.Symlinks, fsrv.Logger); err != nil {
+				return nil, err
+			}
+		} else if isArchivePath(fsrv.Path) {
+			if hfs, err = openArchiveFS(fsrv.Path); err != nil {
+				return nil, fmt.Errorf("failed to open archive %q: %w", fsrv.Path, err)
+			}
+		} else {
+			return nil, fmt.Errorf("path %q is not a directory or an archive", fsrv.Path)
+		}
 	}
 
 	if fsrv.Dynamic {
-		inst.filesystem = http.Dir(fsrv.Path)
+		if isDir && fsrv.Symlinks == SymlinksFollow {
+			inst.filesystem = http.Dir(fsrv.Path)
+		} else {
+			inst.filesystem = http.FS(hfs)
+		}
 	} else {
-		fs, err := NewMemoryFileSystem(fsrv.Path, fsrv.Match)
+		fs, err := NewMemoryFileSystem(hfs, fsrv.Match)
 		if err != nil {
 			return nil, err
 		}
+		if fsrv.Fingerprint {
+			fs.fingerprint()
+		}
+		if fsrv.CaseInsensitive {
+			fs.foldCase()
+		}
 		inst.filesystem = fs
 	}
 	return inst, nil
@@ -271,6 +332,22 @@ func (fsrv *FileServer) setContentType(w http.ResponseWriter, name string) {
 		w.Header()["Content-Type"] = []string{ctype}
 	}
 }
+
+// Maximum number of ranges in a request, requests for more are served in full.
+const maxRanges = 32
+
+// Drops the Range header that should be ignored rather than answered with 416: units other than
+// bytes and excessive numbers of ranges.
+func sanitizeRange(r *http.Request) {
+	rng := r.Header.Get("Range")
+	if rng == "" {
+		return
+	}
+	if !strings.HasPrefix(rng, "bytes=") || strings.Count(rng, ",") >= maxRanges {
+		delete(r.Header, "Range")
+		delete(r.Header, "If-Range")
+	}
+}
 func (fsrv *FileServer) serveContent(w http.ResponseWriter, r *http.Request, name string, mod time.Time, content io.ReadSeeker, status int) {
 	// If the request is not a GET or HEAD, force it to be treated as a GET
 	if method := r.Method; method != http.MethodGet && method != http.MethodHead {
@@ -283,6 +360,9 @@ func (fsrv *FileServer) serveContent(w http.ResponseWriter, r *http.Request, nam
 		if immutable && !fsrv.NoImmutableMatch {
 			immutable = strings.Contains(name, "/immutable/")
 		}
+		if mfs, ok := fsrv.filesystem.(*memoryFileSystem); ok && mfs.IsImmutable(name) {
+			immutable = true
+		}
 		if immutable {
 			w.Header()["Cache-Control"] = []string{"public, max-age=31536000, immutable"}
 			if len(r.Header["Range"]) == 0 {
@@ -301,6 +381,11 @@ func (fsrv *FileServer) serveContent(w http.ResponseWriter, r *http.Request, nam
 	delete(r.Header, "If-Match")
 	delete(r.Header, "If-None-Match")
 	delete(r.Header, "If-Modified-Since")
+	delete(r.Header, "If-Unmodified-Since")
+
+	// Error pages are served in full, a range would turn the status into 206 or 416.
+	delete(r.Header, "Range")
+	delete(r.Header, "If-Range")
 
 	// If status is not 200, wrap it around a fake writer to enforce status
 	bw := &failedFileWriter{ResponseWriter: w, Status: status}
@@ -336,48 +421,60 @@ func (fsrv *FileServer) notFound(w http.ResponseWriter, r *http.Request) vhttp.R
 	fsrv.serveContent(w, r, fsrv.NotFound, modify, file404, http.StatusNotFound)
 	return vhttp.Done
 }
-func (fsrv *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.Result {
-	// Clean up the path
-	filePath := r.URL.Path
-	if filePath == "/" {
-		if fsrv.IndexFile == "" {
-			return fsrv.notFound(w, r)
+
+// Opens the file if it matches the pattern.
+func (fsrv *FileServer) open(name string) (file http.File, stat fs.FileInfo, err error) {
+	if fsrv.Match != nil && !fsrv.Match.MatchString(name) {
+		return nil, nil, fs.ErrNotExist
+	}
+	if file, err = fsrv.filesystem.Open(name); err != nil {
+		return
+	}
+	if stat, err = file.Stat(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return
+}
+
+// Opens the first index file of the directory that exists.
+func (fsrv *FileServer) openIndex(dir string) (name string, file http.File, stat fs.FileInfo, err error) {
+	err = fs.ErrNotExist
+	for _, index := range fsrv.IndexFile {
+		if index == "" {
+			continue
+		}
+		name = strings.TrimSuffix(dir, "/") + "/" + strings.TrimPrefix(index, "/")
+		file, stat, err = fsrv.open(name)
+		if err == nil {
+			if !stat.IsDir() {
+				return
+			}
+			file.Close()
+			err = fs.ErrNotExist
+		} else if !os.IsNotExist(err) {
+			return
 		}
-		filePath = fsrv.IndexFile
 	}
+	return
+}
 
+func (fsrv *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.Result {
+	// Open the file, or the index file if the path is a directory
+	filePath := r.URL.Path
 	var stat fs.FileInfo
 	var file http.File
 	var err error
-	for i := 0; i < 2; i++ {
-		// If it doesn't match the pattern, continue or 404
-		if fsrv.Match != nil && !fsrv.Match.MatchString(filePath) {
-			err = fs.ErrNotExist
-			break
+	isDir := strings.HasSuffix(filePath, "/")
+	if !isDir {
+		file, stat, err = fsrv.open(filePath)
+		if err == nil && stat.IsDir() {
+			file.Close()
+			isDir = true
 		}
-
-		// Open the file
-		file, err = fsrv.filesystem.Open(filePath)
-		if err != nil {
-			break
-		}
-		defer file.Close()
-
-		// Get the file info, break if it's not a directory
-		stat, err = file.Stat()
-		if err != nil {
-			break
-		}
-		if !stat.IsDir() {
-			break
-		}
-		err = fs.ErrNotExist
-
-		// Try to open the index file
-		if i == 1 || fsrv.IndexFile == "" {
-			break
-		}
-		filePath += fsrv.IndexFile
+	}
+	if isDir {
+		filePath, file, stat, err = fsrv.openIndex(filePath)
 	}
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -385,13 +482,15 @@ func (fsrv *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.
 		}
 		return fsrv.internalError(w, r, filePath, err)
 	}
+	defer file.Close()
 
+	// Ranges are served from the uncompressed file, the offsets refer to it.
+	sanitizeRange(r)
 	if len(r.Header["Range"]) == 0 {
-		accpt := r.Header.Get("Accept-Encoding")
-		if strings.Contains(accpt, "br") {
-			if cfile, e := fsrv.filesystem.Open(filePath + "@br"); e == nil {
-				defer cfile.Close()
-				file.Close()
+		if cfile, e := fsrv.filesystem.Open(filePath + "@br"); e == nil {
+			defer cfile.Close()
+			w.Header()["Vary"] = []string{"Accept-Encoding"}
+			if strings.Contains(r.Header.Get("Accept-Encoding"), "br") {
 				file = cfile
 				w.Header()["Content-Encoding"] = []string{"br"}
 			}
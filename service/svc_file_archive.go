@@ -0,0 +1,199 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Returns true if the path refers to an archive that can be served by the file service.
+func isArchivePath(p string) bool {
+	p = strings.ToLower(p)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(p, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Opens the archive at the given path as a read-only filesystem, the archive is read into memory
+// so that there is nothing to close.
+func openArchiveFS(p string) (fs.FS, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	lp := strings.ToLower(p)
+	if strings.HasSuffix(lp, ".zip") {
+		return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	}
+
+	var rd io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(lp, ".gz") || strings.HasSuffix(lp, ".tgz") {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	return readTarFS(tar.NewReader(rd))
+}
+
+// tarFS is a read-only in-memory filesystem holding the contents of a tar archive, by clean path.
+type tarFS map[string]*tarEntry
+
+type tarEntry struct {
+	name     string // Base name.
+	data     []byte
+	mode     fs.FileMode
+	modTime  time.Time
+	children []*tarEntry // Sorted by name, for directories.
+}
+
+func (e *tarEntry) Name() string               { return e.name }
+func (e *tarEntry) Size() int64                { return int64(len(e.data)) }
+func (e *tarEntry) Mode() fs.FileMode          { return e.mode }
+func (e *tarEntry) ModTime() time.Time         { return e.modTime }
+func (e *tarEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *tarEntry) Sys() any                   { return nil }
+func (e *tarEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *tarEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// Returns the directory at the path, creating it and its parents if missing.
+func (t tarFS) dir(name string) (*tarEntry, error) {
+	if e, ok := t[name]; ok {
+		if !e.IsDir() {
+			return nil, fmt.Errorf("file and directory at the same path in archive: %q", name)
+		}
+		return e, nil
+	}
+	e := &tarEntry{name: path.Base(name), mode: fs.ModeDir | 0755}
+	if name != "." {
+		parent, err := t.dir(path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		parent.children = append(parent.children, e)
+	}
+	t[name] = e
+	return e, nil
+}
+
+// Adds the file at the path, replacing the earlier entries for it.
+func (t tarFS) file(name string, data []byte, modTime time.Time) error {
+	if e, ok := t[name]; ok {
+		if e.IsDir() {
+			return fmt.Errorf("file and directory at the same path in archive: %q", name)
+		}
+		e.data, e.modTime = data, modTime
+		return nil
+	}
+	parent, err := t.dir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	e := &tarEntry{name: path.Base(name), data: data, mode: 0644, modTime: modTime}
+	parent.children = append(parent.children, e)
+	t[name] = e
+	return nil
+}
+
+func (t tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if e.IsDir() {
+		return &tarDir{tarEntry: e, path: name}, nil
+	}
+	return &tarFile{tarEntry: e, Reader: bytes.NewReader(e.data)}, nil
+}
+
+type tarFile struct {
+	*tarEntry
+	*bytes.Reader
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.tarEntry, nil }
+func (f *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	*tarEntry
+	path   string
+	offset int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.tarEntry, nil }
+func (d *tarDir) Close() error               { return nil }
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.children[d.offset:]
+	if n > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		rest = rest[:min(n, len(rest))]
+	}
+	d.offset += len(rest)
+	res := make([]fs.DirEntry, len(rest))
+	for i, e := range rest {
+		res[i] = e
+	}
+	return res, nil
+}
+
+func readTarFS(tr *tar.Reader) (fs.FS, error) {
+	tfs := tarFS{}
+	tfs.dir(".")
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid path in archive: %q", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			dir, err := tfs.dir(name)
+			if err != nil {
+				return nil, err
+			}
+			dir.modTime = hdr.ModTime
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if err := tfs.file(name, data, hdr.ModTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, e := range tfs {
+		slices.SortFunc(e.children, func(a, b *tarEntry) int { return strings.Compare(a.name, b.name) })
+	}
+	return tfs, nil
+}
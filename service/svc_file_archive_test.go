@@ -0,0 +1,54 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func tarArchive(t *testing.T, entries ...string) *tar.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(name))}
+		if strings.HasSuffix(name, "/") {
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tar.NewReader(buf)
+}
+
+func TestReadTarFS(t *testing.T) {
+	fsys, err := readTarFS(tarArchive(t, "index.html", "/assets/", "assets/app.js", "docs/guide/intro.md", "./docs/b.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "index.html", "assets/app.js", "docs/guide/intro.md", "docs/b.md"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadTarFSConflicts(t *testing.T) {
+	for _, entries := range [][]string{
+		{"a", "a/b"},
+		{"a/b", "a"},
+		{"a/", "a"},
+		{"../a"},
+	} {
+		if _, err := readTarFS(tarArchive(t, entries...)); err == nil {
+			t.Errorf("%q: read without error", entries)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// FingerprintManifest is the name of the file listing the fingerprinted name of each asset.
+const FingerprintManifest = "asset-manifest.json"
+
+// Files that reference assets, their references are rewritten to the fingerprinted names.
+var fingerprintRewriteExt = map[string]struct{}{
+	".html": {},
+	".htm":  {},
+	".css":  {},
+}
+
+// Returns the fingerprinted name of the file, e.g. js/app.js -> js/app.1a2b3c4d.js
+func fingerprintName(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+}
+
+// Fingerprints all assets in the filesystem, making each available under a name containing the hash
+// of its contents, which are served as immutable.
+//
+// References to the assets in HTML and CSS files are rewritten to the fingerprinted names, which is
+// a plain textual replacement with the following limits:
+//   - Only root-relative references (e.g. "/js/app.js") are rewritten, relative ones are left as is.
+//   - The reference must be quoted or wrapped in url(), and must not have a query or fragment.
+//   - CSS files referencing other CSS files (@import) are not rewritten to the fingerprinted name.
+//
+// For anything else, FingerprintManifest maps the original path to the fingerprinted one.
+func (mfs *memoryFileSystem) fingerprint() {
+	mfs.immutable = make(map[string]struct{})
+	manifest := make(map[string]string)
+	fingerprinted := make(map[string]*memoryFile) // Merged once done, the files are not added while ranging over them.
+	add := func(name string, mf *memoryFile) {
+		hashed := fingerprintName(name, mf.data)
+		fingerprinted[hashed] = mf
+		mfs.immutable[hashed] = struct{}{}
+		manifest["/"+name] = "/" + hashed
+	}
+
+	// Fingerprint everything but the files referencing assets.
+	var rewrite []string
+	for name, mf := range mfs.files {
+		if !mf.Mode().IsRegular() {
+			continue
+		}
+		if _, ok := fingerprintRewriteExt[path.Ext(name)]; ok {
+			rewrite = append(rewrite, name)
+		} else {
+			add(name, mf)
+		}
+	}
+
+	// Rewrite the references, CSS files first so that HTML files can refer to their fingerprinted names.
+	rewriteRefs := func(css bool) {
+		pairs := make([]string, 0, len(manifest)*6)
+		for from, to := range manifest {
+			pairs = append(pairs,
+				`"`+from+`"`, `"`+to+`"`,
+				`'`+from+`'`, `'`+to+`'`,
+				`(`+from+`)`, `(`+to+`)`,
+			)
+		}
+		replacer := strings.NewReplacer(pairs...)
+		for _, name := range rewrite {
+			if (path.Ext(name) == ".css") != css {
+				continue
+			}
+			mf := mfs.files[name]
+			if res := replacer.Replace(string(mf.data)); res != string(mf.data) {
+				mf.data = []byte(res)
+				mf.compress(name)
+			}
+			if css {
+				add(name, mf)
+			}
+		}
+	}
+	rewriteRefs(true)
+	rewriteRefs(false)
+	for name, mf := range fingerprinted {
+		mfs.files[name] = mf
+	}
+
+	// Expose the manifest.
+	data, _ := json.Marshal(manifest)
+	mfs.files[FingerprintManifest] = &memoryFile{
+		FileInfo: syntheticFileInfo{name: FingerprintManifest, size: int64(len(data)), modTime: time.Now()},
+		data:     data,
+	}
+}
+
+// Returns true if the file is served under a fingerprinted name.
+func (mfs *memoryFileSystem) IsImmutable(name string) bool {
+	if mfs.immutable == nil {
+		return false
+	}
+	_, ok := mfs.immutable[strings.TrimPrefix(name, "/")]
+	return ok
+}
+
+type syntheticFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi syntheticFileInfo) Name() string       { return fi.name }
+func (fi syntheticFileInfo) Size() int64        { return fi.size }
+func (fi syntheticFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi syntheticFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi syntheticFileInfo) IsDir() bool        { return false }
+func (fi syntheticFileInfo) Sys() any           { return nil }
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Compressible content so that the in-memory server keeps a brotli variant.
+var rangeData = strings.Repeat("0123456789", 100)
+
+func rangeServer(t *testing.T, fsrv *FileService) *FileServer {
+	t.Helper()
+	fsrv.FS = fstest.MapFS{
+		"data.txt": {Data: []byte(rangeData)},
+		"404.html": {Data: []byte("missing")},
+	}
+	inst, err := fsrv.Start(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inst.(*FileServer)
+}
+
+func rangeGet(fsrv *FileServer, path, rng string, hdr ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if rng != "" {
+		r.Header.Set("Range", rng)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		r.Header.Set(hdr[i], hdr[i+1])
+	}
+	w := httptest.NewRecorder()
+	fsrv.ServeHTTP(w, r)
+	return w
+}
+
+// Returns a list of n single byte ranges.
+func manyRanges(n int) string {
+	rs := make([]string, n)
+	for i := range rs {
+		rs[i] = fmt.Sprintf("%d-%d", 2*i, 2*i)
+	}
+	return "bytes=" + strings.Join(rs, ",")
+}
+
+func TestFileServerRanges(t *testing.T) {
+	size := len(rangeData)
+	tests := []struct {
+		name   string
+		rng    string
+		status int
+		body   string // Expected body, empty to skip the check.
+		crange string // Expected Content-Range, empty if none.
+	}{
+		{"none", "", http.StatusOK, rangeData, ""},
+		{"single", "bytes=0-9", http.StatusPartialContent, rangeData[:10], fmt.Sprintf("bytes 0-9/%d", size)},
+		{"open", "bytes=990-", http.StatusPartialContent, rangeData[990:], fmt.Sprintf("bytes 990-%d/%d", size-1, size)},
+		{"suffix", "bytes=-5", http.StatusPartialContent, rangeData[size-5:], fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size)},
+		{"suffix larger than file", fmt.Sprintf("bytes=-%d", 2*size), http.StatusPartialContent, rangeData, fmt.Sprintf("bytes 0-%d/%d", size-1, size)},
+		{"end past EOF", fmt.Sprintf("bytes=%d-%d", size-5, 2*size), http.StatusPartialContent, rangeData[size-5:], fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size)},
+		{"start past EOF", fmt.Sprintf("bytes=%d-", size), http.StatusRequestedRangeNotSatisfiable, "", fmt.Sprintf("bytes */%d", size)},
+		{"multiple", "bytes=0-1,10-11", http.StatusPartialContent, "", ""},
+		{"max ranges", manyRanges(maxRanges), http.StatusPartialContent, "", ""},
+		{"too many ranges", manyRanges(maxRanges + 1), http.StatusOK, rangeData, ""},
+		{"invalid", "bytes=abc", http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"reversed", "bytes=9-0", http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"unknown unit", "items=0-9", http.StatusOK, rangeData, ""},
+	}
+	servers := map[string]*FileService{
+		"memory":    {},
+		"dynamic":   {Dynamic: true},
+		"immutable": {Immutable: true, NoImmutableMatch: true},
+	}
+	for sname, svc := range servers {
+		fsrv := rangeServer(t, svc)
+		for _, tt := range tests {
+			w := rangeGet(fsrv, "/data.txt", tt.rng)
+			if w.Code != tt.status {
+				t.Errorf("%s: %s: status %d, want %d", sname, tt.name, w.Code, tt.status)
+				continue
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("%s: %s: body %q, want %q", sname, tt.name, w.Body.String(), tt.body)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.crange {
+				t.Errorf("%s: %s: Content-Range %q, want %q", sname, tt.name, got, tt.crange)
+			}
+			if tt.status == http.StatusPartialContent && tt.crange == "" {
+				if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+					t.Errorf("%s: %s: Content-Type %q, want multipart/byteranges", sname, tt.name, ct)
+				}
+			}
+		}
+	}
+}
+
+func TestFileServerRangeSkipsBrotli(t *testing.T) {
+	fsrv := rangeServer(t, &FileService{})
+	w := rangeGet(fsrv, "/data.txt", "", "Accept-Encoding", "br")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("status %d, Content-Encoding %q, want a brotli response", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	// The offsets refer to the uncompressed file.
+	w = rangeGet(fsrv, "/data.txt", "bytes=10-19", "Accept-Encoding", "br")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("status %d, want 206", w.Code)
+	}
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding %q, want none", ce)
+	}
+	if w.Body.String() != rangeData[10:20] {
+		t.Errorf("body %q, want %q", w.Body.String(), rangeData[10:20])
+	}
+}
+
+func TestFileServerRangeNotFound(t *testing.T) {
+	fsrv := rangeServer(t, &FileService{NotFound: "404.html"})
+	for _, rng := range []string{"bytes=0-1", "bytes=100-", "bytes=abc"} {
+		w := rangeGet(fsrv, "/nope.txt", rng)
+		if w.Code != http.StatusNotFound || w.Body.String() != "missing" {
+			t.Errorf("%s: status %d, body %q, want the full 404 page", rng, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// Policies for the symlinks found under the path of a file server.
+const (
+	SymlinksRoot   = "root"   // Follow the symlinks resolving within the path, the default.
+	SymlinksNever  = "never"  // Do not follow any symlink.
+	SymlinksFollow = "follow" // Follow any symlink, even outside the path.
+)
+
+var errSymlinkRejected = errors.New("symlink rejected by policy")
+
+// rootedFS serves a directory, applying the symlink policy so that files outside of it cannot be
+// reached through a symlink. The path is checked when the file is opened, so a symlink swapped in
+// between the check and the open can still escape: the directory is assumed to be trusted, the
+// policy guards against mistakes and archives extracted with links, not against a local attacker.
+type rootedFS struct {
+	root   string // Resolved path of the directory.
+	policy string
+	logger *xlog.Logger
+}
+
+func newRootedFS(root, policy string, logger *xlog.Logger) (*rootedFS, error) {
+	switch policy {
+	case "":
+		policy = SymlinksRoot
+	case SymlinksRoot, SymlinksNever, SymlinksFollow:
+	default:
+		return nil, fmt.Errorf("invalid symlink policy %q", policy)
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+	return &rootedFS{root: root, policy: policy, logger: logger}, nil
+}
+
+// Returns true if the path is the root or under it.
+func (r *rootedFS) contains(p string) bool {
+	rel, err := filepath.Rel(r.root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// Resolves the name relative to the root into the path to open.
+func (r *rootedFS) resolve(name string) (string, error) {
+	// Clean the name as http.Dir does so that ".." cannot climb above the root.
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	full := filepath.Join(r.root, filepath.FromSlash(name))
+	switch r.policy {
+	case SymlinksNever:
+		p := r.root
+		for _, part := range strings.Split(name, "/") {
+			if part == "" {
+				continue
+			}
+			p = filepath.Join(p, part)
+			stat, err := os.Lstat(p)
+			if err != nil {
+				return "", err
+			}
+			if stat.Mode()&fs.ModeSymlink != 0 {
+				return "", errSymlinkRejected
+			}
+		}
+	case SymlinksRoot:
+		resolved, err := filepath.EvalSymlinks(full)
+		if err != nil {
+			return "", err
+		}
+		if !r.contains(resolved) {
+			if r.logger != nil {
+				r.logger.Warn().Str("path", name).Str("target", resolved).Msg("Symlink escaping the served path rejected")
+			}
+			return "", errSymlinkRejected
+		}
+		full = resolved
+	}
+	return full, nil
+}
+
+func (r *rootedFS) Open(name string) (fs.File, error) {
+	p, err := r.resolve(name)
+	if err != nil {
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			return nil, err
+		} else if errors.Is(err, errSymlinkRejected) {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.Open(p)
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Creates a served directory with symlinks escaping it in various ways, next to a secret file.
+func symlinkFixture(t *testing.T) (root string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(root, "index.html"):    "index",
+		filepath.Join(root, "sub", "a.txt"):  "a",
+		filepath.Join(outside, "secret.txt"): "secret",
+	}
+	for p, data := range files {
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"escape":      filepath.Join(outside, "secret.txt"), // Absolute link to a file outside
+		"escape-rel":  "../outside/secret.txt",              // Relative link climbing out
+		"escdir":      outside,                              // Link to a directory outside
+		"chain":       "escape",                             // Link to a link escaping
+		"inside":      "sub/a.txt",                          // Link staying within the root
+		"sub/up":      "../index.html",                      // Link climbing back to the root
+		"sub/escsub":  "../../outside",                      // Nested link climbing out
+		"sub/self":    ".",                                  // Link to its own directory
+		"rootlink":    ".",                                  // Link to the root itself
+		"sub/dangles": "missing",                            // Link to nothing
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return root
+}
+
+func readRooted(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return string(data), err
+}
+
+func TestRootedFSSymlinks(t *testing.T) {
+	root := symlinkFixture(t)
+	tests := []struct {
+		name   string
+		root   string // Expected content with the root policy, empty if rejected.
+		never  string // Expected content with the never policy, empty if rejected.
+		follow string // Expected content with the follow policy, empty if not found.
+	}{
+		{"index.html", "index", "index", "index"},
+		{"sub/a.txt", "a", "a", "a"},
+		{"inside", "a", "", "a"},
+		{"sub/up", "index", "", "index"},
+		{"sub/self/a.txt", "a", "", "a"},
+		{"rootlink/index.html", "index", "", "index"},
+		{"escape", "", "", "secret"},
+		{"escape-rel", "", "", "secret"},
+		{"escdir/secret.txt", "", "", "secret"},
+		{"chain", "", "", "secret"},
+		{"sub/escsub/secret.txt", "", "", "secret"},
+		{"sub/dangles", "", "", ""},
+		{"../outside/secret.txt", "", "", ""},
+		{"sub/../../outside/secret.txt", "", "", ""},
+		{"/../../outside/secret.txt", "", "", ""},
+	}
+	for _, policy := range []string{SymlinksRoot, SymlinksNever, SymlinksFollow} {
+		fsys, err := newRootedFS(root, policy, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := map[string]string{SymlinksRoot: tt.root, SymlinksNever: tt.never, SymlinksFollow: tt.follow}[policy]
+			got, err := readRooted(fsys, tt.name)
+			if want == "" {
+				if err == nil {
+					t.Errorf("%s: %s: read %q, want an error", policy, tt.name, got)
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%s: %s: got %v, want fs.ErrNotExist", policy, tt.name, err)
+				}
+				continue
+			}
+			if err != nil || got != want {
+				t.Errorf("%s: %s: read %q, %v, want %q", policy, tt.name, got, err, want)
+			}
+		}
+	}
+}
+
+func TestRootedFSSymlinkedRoot(t *testing.T) {
+	root := symlinkFixture(t)
+	link := filepath.Join(t.TempDir(), "served")
+	if err := os.Symlink(root, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	fsys, err := newRootedFS(link, SymlinksRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readRooted(fsys, "inside"); err != nil || got != "a" {
+		t.Errorf("read %q, %v, want %q", got, err, "a")
+	}
+	if _, err := readRooted(fsys, "escape"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestRootedFSDynamic(t *testing.T) {
+	root := symlinkFixture(t)
+	fsys, err := newRootedFS(root, SymlinksRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hfs := http.FS(fsys)
+	for _, name := range []string{"/escape", "/escdir/secret.txt", "/sub/escsub/secret.txt"} {
+		if f, err := hfs.Open(name); err == nil {
+			f.Close()
+			t.Errorf("%s: opened, want an error", name)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: got %v, want fs.ErrNotExist", name, err)
+		}
+	}
+	f, err := hfs.Open("/inside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestRootedFSInvalidPolicy(t *testing.T) {
+	if _, err := newRootedFS(t.TempDir(), "sometimes", nil); err == nil {
+		t.Fatal("invalid policy accepted")
+	}
+}
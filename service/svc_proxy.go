@@ -14,7 +14,7 @@ import (
 )
 
 type ProxyService struct {
-	Options
+	Options      `yaml:"-"`
 	Monitor      health.Monitor    `yaml:"monitor,omitempty"`
 	LoadBalancer lb.LoadBalancer   `yaml:"lb,omitempty"`
 	Upstreams    util.Some[string] `yaml:"upstreams,omitempty"`
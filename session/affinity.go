@@ -0,0 +1,126 @@
+package session
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"get.pme.sh/pmesh/netx"
+	"get.pme.sh/pmesh/variant"
+	"get.pme.sh/pmesh/vhttp"
+	"get.pme.sh/pmesh/xlog"
+	"get.pme.sh/pmesh/xpost"
+)
+
+// AffinityHandler steers the requests to the best ranked peer whose user data matches the tags, see
+// xpost.Affinity, the request continues down the route if the local node is the one selected:
+//
+//	affinity region=@country                 a peer in the country of the client
+//	affinity api region=@self tier=gold      a peer advertising the api service as healthy, in the same region
+//	affinity! api tier=gold                  fails with 503 rather than falling back
+//
+// If no matching peer is alive, the request falls back to the best ranked peer advertising the service.
+type AffinityHandler struct {
+	Service string
+	Tags    xpost.Affinity
+	Strict  bool
+}
+
+func (h AffinityHandler) String() string {
+	return fmt.Sprintf("Affinity(%s)", strings.TrimSpace(h.Service+" "+h.Tags.String()))
+}
+func (h *AffinityHandler) UnmarshalInline(text string) (err error) {
+	var args string
+	if rest, ok := strings.CutPrefix(text, "affinity! "); ok {
+		h.Strict, args = true, rest
+	} else if rest, ok := strings.CutPrefix(text, "affinity "); ok {
+		args = rest
+	} else {
+		return variant.RejectMatch(h)
+	}
+	var tags []string
+	for _, f := range strings.Fields(args) {
+		if strings.Contains(f, "=") {
+			tags = append(tags, f)
+		} else if h.Service == "" {
+			h.Service = f
+		} else {
+			return fmt.Errorf("affinity: unexpected argument %q", f)
+		}
+	}
+	if len(tags) == 0 {
+		return fmt.Errorf("affinity: no tags")
+	}
+	h.Tags, err = xpost.ParseAffinity(tags)
+	return
+}
+
+// Selects the peer to serve the request, nil if there is none.
+func (h AffinityHandler) selectPeer(s *Session, r *http.Request) *xpost.Peer {
+	var weights xpost.PeerWeights
+	if manifest := s.Manifest(); manifest != nil {
+		weights = manifest.PeerWeights
+	}
+	healthy := func(p *xpost.Peer) bool {
+		return h.Service == "" || p.Advertises(h.Service)
+	}
+	self := s.Peerlist.Self()
+	country := r.Header.Get(netx.HdrIPGeo)
+	peer := s.Peerlist.Select(weights, func(p *xpost.Peer) bool {
+		return healthy(p) && h.Tags.Matches(p, &self, country)
+	})
+	if peer == nil && !h.Strict {
+		peer = s.Peerlist.Select(weights, healthy)
+	}
+	return peer
+}
+
+func (h AffinityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.Result {
+	s, ok := vhttp.StateResolverFromContext(r.Context()).(*Session)
+	if !ok || s.Peerlist == nil {
+		return vhttp.Continue
+	}
+
+	// Already steered by a peer, serve it here.
+	if vhttp.IsSteered(r) {
+		return vhttp.Continue
+	}
+
+	peer := h.selectPeer(s, r)
+	if peer == nil {
+		if h.Strict {
+			vhttp.Error(w, r, http.StatusServiceUnavailable)
+			return vhttp.Done
+		}
+		return vhttp.Continue
+	}
+	if peer.Me {
+		return vhttp.Continue
+	}
+
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	req.URL.Host = r.Host
+	vhttp.SteerRequest(req)
+	res, err := peer.SendRequest(req)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return vhttp.Done
+		}
+		xlog.WarnC(r.Context()).Err(err).Str("peer", peer.Host).Msg("Failed to steer the request")
+		vhttp.Error(w, r, http.StatusBadGateway)
+		return vhttp.Done
+	}
+	defer res.Body.Close()
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+	return vhttp.Done
+}
+
+func init() {
+	vhttp.Registry.Define("Affinity", func() any { return &AffinityHandler{} })
+}
@@ -0,0 +1,29 @@
+package session
+
+import "testing"
+
+func TestAffinityHandlerParse(t *testing.T) {
+	tests := []struct {
+		text, want string
+		strict     bool
+	}{
+		{"affinity region=@country", "Affinity(region=@country)", false},
+		{"affinity api region=@self tier=gold", "Affinity(api region=@self tier=gold)", false},
+		{"affinity! api tier=gold", "Affinity(api tier=gold)", true},
+	}
+	for _, tt := range tests {
+		var h AffinityHandler
+		if err := h.UnmarshalInline(tt.text); err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		if h.String() != tt.want || h.Strict != tt.strict {
+			t.Errorf("%q: got %s, strict %v", tt.text, h, h.Strict)
+		}
+	}
+	for _, text := range []string{"affinity api", "affinity api web tier=gold", "affinity api tier", "affinityx tier=gold"} {
+		var h AffinityHandler
+		if err := h.UnmarshalInline(text); err == nil {
+			t.Errorf("%q: parsed as %s", text, h)
+		}
+	}
+}
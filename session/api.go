@@ -3,6 +3,7 @@ package session
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -10,6 +11,8 @@ import (
 	"strings"
 	"time"
 
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/enats"
 	"get.pme.sh/pmesh/vhttp"
 	"get.pme.sh/pmesh/xlog"
 
@@ -159,6 +162,15 @@ func ServeRPC(originalReq *http.Request, method string, body json.RawMessage) (o
 	}
 	req.RequestURI = ""
 
+	if _, pattern := ApiRouter.Handler(req); pattern != "" {
+		if err = authorize(req, pattern); err != nil {
+			return
+		}
+		if err = checkNats(req, pattern); err != nil {
+			return
+		}
+	}
+
 	buf := vhttp.NewBufferedResponse(nil)
 	ApiRouter.ServeHTTP(buf, req)
 	if buf.Status != http.StatusOK {
@@ -182,10 +194,50 @@ func init() {
 	ApiRouter.HandleFunc("GET /connect", func(w http.ResponseWriter, r *http.Request) {
 		sv.Upgrade(w, r, r)
 	})
+	// Each RPC is authorized separately.
+	Grant(config.AccessViewer, "GET /connect")
 }
 
 const ApiRequestMaxDuration = time.Minute
 
+var ErrForbidden = errors.New("forbidden")
+
+// Access level required for each route pattern, routes not listed require admin access.
+var routeAccess = map[string]config.Access{}
+
+// Allows callers with the given access level to use the routes matching the patterns.
+func Grant(access config.Access, patterns ...string) {
+	for _, pattern := range patterns {
+		routeAccess[pattern] = access
+	}
+}
+
+// Routes that need NATS, answered with 503 while running without it.
+var natsRoutes = map[string]struct{}{}
+
+// Marks the routes matching the patterns as unavailable while running without NATS.
+func RequireNats(patterns ...string) {
+	for _, pattern := range patterns {
+		natsRoutes[pattern] = struct{}{}
+	}
+}
+func checkNats(r *http.Request, pattern string) error {
+	if _, ok := natsRoutes[pattern]; ok && !RequestSession(r).Nats.Available() {
+		return enats.ErrUnavailable
+	}
+	return nil
+}
+func authorize(r *http.Request, pattern string) error {
+	required, ok := routeAccess[pattern]
+	if !ok {
+		required = config.AccessAdmin
+	}
+	if vhttp.RequestAccess(r) < required {
+		return ErrForbidden
+	}
+	return nil
+}
+
 func RequestSession(r *http.Request) *Session {
 	return vhttp.StateResolverFromContext(r.Context()).(*Session)
 }
@@ -195,13 +247,30 @@ func Match[T any, U any](path string, cb func(*Session, *http.Request, T) (U, er
 func MatchLocked[T any, U any](path string, cb func(*Session, *http.Request, T) (U, error)) {
 	ApiRouter.Handle(path, LockedHandler{TypedHandler[T, U]{cb}})
 }
+func MatchAudited[T any, U any](action, path string, cb func(*Session, *http.Request, T) (U, error)) {
+	ApiRouter.Handle(path, AuditedHandler{action, TypedHandler[T, U]{cb}})
+}
+func MatchLockedAudited[T any, U any](action, path string, cb func(*Session, *http.Request, T) (U, error)) {
+	ApiRouter.Handle(path, AuditedHandler{action, LockedHandler{TypedHandler[T, U]{cb}}})
+}
 
 type apiHandler struct{}
 
 func (h apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.Result {
+	// Open to the internal callers and the configured users, each route is then authorized.
+	if vhttp.RequestAccess(r) == config.AccessNone {
+		vhttp.Error(w, r, http.StatusForbidden)
+		return vhttp.Done
+	}
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
 	_, pattern := ApiRouter.Handler(r) // Waste of time, but it's the only way to avoid 404s.
 	if pattern == "" {
 		vhttp.Error(w, r, http.StatusNotFound)
+	} else if authorize(r, pattern) != nil {
+		vhttp.Error(w, r, http.StatusForbidden)
+	} else if err := checkNats(r, pattern); err != nil {
+		vhttp.Error(w, r, http.StatusServiceUnavailable, err.Error())
 	} else {
 		ApiRouter.ServeHTTP(w, r)
 	}
@@ -214,8 +283,6 @@ func CreateAPIHost(session *Session) *vhttp.VirtualHost {
 	vh := vhttp.NewVirtualHost(vhttp.VirtualHostOptions{
 		Hostnames: []string{"pm3"},
 	})
-	vh.Mux.Then(vhttp.InternalHandler{
-		Inner: vhttp.Subhandler{Handler: apiHandler{}},
-	})
+	vh.Mux.Then(apiHandler{})
 	return vh
 }
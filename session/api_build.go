@@ -0,0 +1,67 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/service"
+)
+
+func init() {
+	Grant(config.AccessViewer, "/service/build/{svc}", "/service/build/{svc}/events")
+
+	Match("/service/build/{svc}", func(session *Session, r *http.Request, _ struct{}) (res service.BuildProgress, err error) {
+		res, ok := service.Builds.Progress(r.PathValue("svc"))
+		if !ok {
+			err = errors.New("no build found")
+		}
+		return
+	})
+
+	// Streams the progress of the build as server-sent events: a "progress" event with the state and
+	// the output so far, then an event per line of output until the build ends.
+	Match("/service/build/{svc}/events", func(session *Session, r *http.Request, w http.ResponseWriter) (res struct{}, err error) {
+		progress, events := service.Builds.Subscribe(r.Context(), r.PathValue("svc"))
+
+		rc := http.NewResponseController(w)
+		conn, bwr, err := rc.Hijack()
+		if err != nil {
+			err = fmt.Errorf("failed enable server-sent events: %w", err)
+			return
+		}
+		defer conn.Close()
+
+		bwr.WriteString("HTTP/1.1 200 OK\r\n")
+		bwr.WriteString("Content-Type: text/event-stream\r\n")
+		bwr.WriteString("Cache-Control: no-cache\r\n")
+		bwr.WriteString("Connection: close\r\n")
+		bwr.WriteString("\r\n")
+
+		send := func(event string, v any) error {
+			data, _ := json.Marshal(v)
+			fmt.Fprintf(bwr, "event: %s\ndata: %s\n\n", event, data)
+			return bwr.Flush()
+		}
+		if err := send("progress", progress); err != nil || progress.Status != service.BuildRunning {
+			return res, http.ErrAbortHandler
+		}
+		for {
+			select {
+			case <-session.Context.Done():
+				return res, http.ErrAbortHandler
+			case <-r.Context().Done():
+				return res, http.ErrAbortHandler
+			case ev := <-events:
+				if err := send("build", ev); err != nil {
+					return res, http.ErrAbortHandler
+				}
+				if ev.Status != "" && ev.Status != service.BuildRunning {
+					return res, http.ErrAbortHandler
+				}
+			}
+		}
+	})
+}
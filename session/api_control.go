@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/enats"
 	"get.pme.sh/pmesh/rundown"
 	"get.pme.sh/pmesh/vhttp"
@@ -23,7 +24,17 @@ func init() {
 		peerid = strings.ToLower(peerid)
 
 		session := RequestSession(r)
-		peer := session.Peerlist.Find(peerid)
+		var peer *xpost.Peer
+		if peerid == "auto" {
+			// Pick the best ranked peer.
+			var weights xpost.PeerWeights
+			if manifest := session.Manifest(); manifest != nil {
+				weights = manifest.PeerWeights
+			}
+			peer = session.Peerlist.Select(weights, nil)
+		} else {
+			peer = session.Peerlist.Find(peerid)
+		}
 		if peer == nil {
 			writeOutput(r, w, nil, fmt.Errorf("peer not found"))
 			return
@@ -52,7 +63,7 @@ func init() {
 		}
 	})
 
-	Match("/shutdown", func(session *Session, r *http.Request, p struct{}) (_ any, err error) {
+	MatchAudited("shutdown", "/shutdown", func(session *Session, r *http.Request, p struct{}) (_ any, err error) {
 		go func() {
 			time.Sleep(500 * time.Millisecond)
 			rundown.Force()
@@ -60,6 +71,10 @@ func init() {
 		}()
 		return
 	})
+	Grant(config.AccessViewer, "/peers", "/peers/alive", "/reload/status")
+	Grant(config.AccessOperator, "/reload", "/reload/plan", "/config/diff")
+	RequireNats("/publish/{topic}")
+
 	Match("/peers", func(session *Session, r *http.Request, p struct{}) (res []xpost.Peer, _ error) {
 		res = session.Peerlist.List(false)
 		return
@@ -70,12 +85,21 @@ func init() {
 	})
 	Match("/publish/{topic}", func(session *Session, r *http.Request, p json.RawMessage) (ack json.RawMessage, err error) {
 		subject := enats.ToSubject(r.PathValue("topic"))
+		gw := session.Nats
+		if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+			if _, ok := session.Manifest().Tenants[tenant]; !ok {
+				return nil, fmt.Errorf("unknown tenant %q", tenant)
+			}
+			if gw, err = session.Nats.Tenant(r.Context(), tenant); err != nil {
+				return
+			}
+		}
 
 		deadline, ok := r.Context().Deadline()
 		if !ok {
 			deadline = time.Now().Add(30 * time.Second)
 		}
-		res, err := session.Nats.RequestMsg(&nats.Msg{
+		res, err := gw.RequestMsg(&nats.Msg{
 			Subject: subject,
 			Data:    p,
 			Header:  nats.Header(r.Header),
@@ -86,8 +110,17 @@ func init() {
 		ack = res.Data
 		return
 	})
-	MatchLocked("/reload", func(session *Session, r *http.Request, p ServiceInvalidate) (_ any, err error) {
-		err = session.ReloadLocked(p.Invalidate)
+	MatchLocked("/reload/plan", func(session *Session, r *http.Request, _ struct{}) (ReloadPlan, error) {
+		return session.PlanReloadLocked(r.Context())
+	})
+	MatchLocked("/config/diff", func(session *Session, r *http.Request, _ struct{}) ([]ConfigDrift, error) {
+		return session.DiffConfigLocked()
+	})
+	MatchAudited("reload", "/reload", func(session *Session, r *http.Request, p ServiceInvalidate) (_ any, err error) {
+		err = session.Reload(p.Invalidate)
 		return
 	})
+	Match("/reload/status", func(session *Session, r *http.Request, _ struct{}) (ReloadStatus, error) {
+		return session.ReloadStatus(), nil
+	})
 }
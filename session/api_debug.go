@@ -24,6 +24,7 @@ func init() {
 			fmt.Fprintf(w, "%s: %v\n", k, v)
 		}
 	})
+	Grant(config.AccessViewer, "/ping")
 	Match("/ping", func(session *Session, r *http.Request, p struct{}) (res string, err error) {
 		res = config.Get().Host
 		return
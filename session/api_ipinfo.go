@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 
+	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/netx"
 )
 
@@ -19,6 +20,7 @@ type IPInfoQuery struct {
 }
 
 func init() {
+	Grant(config.AccessViewer, "/ipinfo")
 	Match("/ipinfo", func(s *Session, r *http.Request, i IPInfoQuery) (res IPInfoResult, err error) {
 		ip := netx.ParseIP(i.IP)
 		if ip.IsZero() {
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"net/http"
 
+	"get.pme.sh/pmesh/enats"
+
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nsf/jsondiff"
 )
@@ -46,6 +48,8 @@ func getKeyState(ctx context.Context, session *Session, key string) (state KVSta
 }
 
 func init() {
+	RequireNats("/kv/{key}/cas", "GET /kv/{key}", "PUT /kv/{key}", "POST /kv/{key}", "DELETE /kv/{key}", "GET /kv", "/result/{stream}/{seq}")
+
 	Match("/kv/{key}/cas", func(session *Session, r *http.Request, p KVCompareAndSwap) (res KVCompareAndSwapResult, err error) {
 		key := r.PathValue("key")
 		kv := session.Nats.DefaultKV
@@ -148,8 +152,10 @@ func init() {
 	Match("/result/{stream}/{seq}", func(session *Session, r *http.Request, _ struct{}) (res json.RawMessage, err error) {
 		stream, seq := r.PathValue("stream"), r.PathValue("seq")
 		kv := session.Nats.ResultKV
-		v, e := kv.Get(r.Context(), stream+"-"+seq)
-		if e == jetstream.ErrKeyNotFound {
+		v, e := enats.RetryTransientValue(r.Context(), "result.get", func(ctx context.Context) (jetstream.KeyValueEntry, error) {
+			return kv.Get(ctx, stream+"-"+seq)
+		})
+		if errors.Is(e, jetstream.ErrKeyNotFound) {
 			err = errors.New("Result not found")
 			return
 		}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"net/http"
 
+	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/xlog"
 )
 
 func init() {
+	Grant(config.AccessViewer, "POST /tail")
 	Match("POST /tail", func(s *Session, r *http.Request, w http.ResponseWriter) (res struct{}, err error) {
 		// Read the tail options.
 		dec := json.NewDecoder(r.Body)
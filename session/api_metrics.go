@@ -3,12 +3,17 @@ package session
 import (
 	"cmp"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
 	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/netx"
+	"get.pme.sh/pmesh/tlsmux"
 	"get.pme.sh/pmesh/vhttp"
+	"get.pme.sh/pmesh/xlog"
 	"get.pme.sh/pmesh/xpost"
 
 	"github.com/samber/lo"
@@ -39,6 +44,14 @@ type SystemMetrics struct {
 	VirtualizationSystem string             `json:"virtualization_system"`
 	VirtualizationRole   string             `json:"virtualization_role"`
 	RTT                  map[string]float64 `json:"rtt"`
+	OpenFiles            int32              `json:"open_files"`            // File descriptors opened by the process.
+	FileLimit            uint64             `json:"file_limit"`            // Limit of the file descriptors of the process, zero if unknown.
+	Unreachable          map[string]string  `json:"unreachable,omitempty"` // Peers that did not answer the ping, by machine ID, with the reason.
+	Listeners            tlsmux.Stats       `json:"listeners,omitempty"`   // Backlogs of the protocols sharing the internal port.
+	DroppedLogLines      uint64             `json:"dropped_log_lines"`     // Log lines dropped by the collectors too slow to keep up, such as remote tails.
+}
+type SessionClearResult struct {
+	Values int `json:"values"` // Number of values cleared.
 }
 type SessionMetrics struct {
 	NumClients int                            `json:"num_clients"`
@@ -47,12 +60,20 @@ type SessionMetrics struct {
 
 func GetSystemMetrics(session *Session) (m SystemMetrics) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	pendingRtts := lo.Async(func() map[string]float64 {
+	pendingRtts := lo.Async2(func() (map[string]float64, []xpost.PeerError) {
 		return xpost.MeasureConnectivity(ctx, session.Peerlist.List(true))
 	})
 	defer func() {
+		// The pings are bounded by the per-peer timeout, wait for them rather than cutting them short.
+		var failed []xpost.PeerError
+		m.RTT, failed = (<-pendingRtts).Unpack()
 		cancel()
-		m.RTT = <-pendingRtts
+		for _, f := range failed {
+			if m.Unreachable == nil {
+				m.Unreachable = make(map[string]string, len(failed))
+			}
+			m.Unreachable[f.MachineID] = f.Err.Error()
+		}
 	}()
 
 	m.MachineID = config.GetMachineID().String()
@@ -95,6 +116,12 @@ func GetSystemMetrics(session *Session) (m SystemMetrics) {
 		m.Rx /= tdelta
 		m.Tx /= tdelta
 	}
+	m.Listeners = tlsmux.GetStats()
+	m.DroppedLogLines = xlog.DroppedLines()
+	if u, e := netx.GetFDUsage(); e == nil {
+		m.OpenFiles = u.Open
+		m.FileLimit = u.Limit
+	}
 	if d, e := disk.Usage("."); e == nil {
 		m.FreeDisk = d.Free
 		m.TotalDisk = d.Total
@@ -120,6 +147,10 @@ var systemMetricsCacheTime time.Time
 var systemMetricsCacheLock = sync.RWMutex{}
 
 func init() {
+	Grant(config.AccessViewer, "/system", "/session", "/jetstream", "/raft")
+	Grant(config.AccessOperator, "/session/clear/{ip}")
+	RequireNats("/jetstream")
+
 	Match("/system", func(s *Session, r *http.Request, _ struct{}) (SystemMetrics, error) {
 		systemMetricsCacheLock.RLock()
 		if time.Since(systemMetricsCacheTime) < time.Second {
@@ -139,9 +170,28 @@ func init() {
 		systemMetricsCacheTime = time.Now()
 		return m, nil
 	})
+	Match("/jetstream", func(session *Session, r *http.Request, _ struct{}) (JetStreamMetrics, error) {
+		return session.GetJetStreamMetrics(r.Context())
+	})
+	Match("/raft", func(session *Session, r *http.Request, _ struct{}) (RaftStatus, error) {
+		return session.GetRaftStatus()
+	})
 	Match("/session", func(session *Session, r *http.Request, _ struct{}) (m SessionMetrics, _ error) {
 		m.NumClients = vhttp.NumClients()
 		m.Clients = vhttp.GetClientMetrics()
 		return
 	})
+	MatchAudited("session.clear", "/session/clear/{ip}", func(session *Session, r *http.Request, _ struct{}) (res SessionClearResult, err error) {
+		ip := netx.ParseIP(r.PathValue("ip"))
+		if ip.IsZero() {
+			return res, fmt.Errorf("invalid ip %q", r.PathValue("ip"))
+		}
+		cs := vhttp.GetClientSession(ip)
+		if cs == nil {
+			return res, errors.New("client session not found")
+		}
+		res.Values = cs.Values.Clear()
+		cs.Unblock()
+		return
+	})
 }
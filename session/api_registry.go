@@ -0,0 +1,29 @@
+package session
+
+import (
+	"net/http"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/health"
+	"get.pme.sh/pmesh/service"
+	"get.pme.sh/pmesh/variant"
+	"get.pme.sh/pmesh/vhttp"
+)
+
+// Registries describes the types that can be used in a manifest, by kind: the services, the
+// route handlers including the directives, and the health checks.
+func Registries() map[string][]variant.Description {
+	return map[string][]variant.Description{
+		"services": service.Registry.Describe(),
+		"handlers": vhttp.Registry.Describe(),
+		"checks":   health.Registry.Describe(),
+	}
+}
+
+func init() {
+	Grant(config.AccessViewer, "/registry")
+
+	Match("/registry", func(session *Session, r *http.Request, _ struct{}) (map[string][]variant.Description, error) {
+		return Registries(), nil
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/revision"
 )
 
@@ -45,6 +46,7 @@ func getRepoState(session *Session, ctx context.Context, fetch bool) (res revisi
 }
 
 func init() {
+	Grant(config.AccessViewer, "/repo", "/version")
 	Match("/repo", func(session *Session, r *http.Request, p struct{}) (info RepoInfo, err error) {
 		repo, err := getRepoState(session, r.Context(), false)
 		if err != nil {
@@ -56,7 +58,7 @@ func init() {
 		info.URL, _ = repo.RemoteURL()
 		return
 	})
-	Match("/repo/update", func(session *Session, r *http.Request, p UpdateParams) (res PullResult, err error) {
+	MatchAudited("repo.update", "/repo/update", func(session *Session, r *http.Request, p UpdateParams) (res PullResult, err error) {
 		repo, err := getRepoState(session, r.Context(), true)
 		if err != nil {
 			return
@@ -68,16 +70,23 @@ func init() {
 		}
 		res.From = head
 
-		remote, _ := repo.RemoteHead()
-		res.To = remote
-		if remote.Hash == "" {
-			res.To = head
+		// Without a tracked branch, a detached head or a branch without a remote is left as is.
+		opts := session.Manifest().Deploy
+		res.To = head
+		if opts.Branch == "" {
+			if _, e := repo.RemoteHead(); e != nil {
+				return
+			}
+		}
+		branch, remote, err := deployTarget(repo, head, opts)
+		if err != nil {
 			return
 		}
-		if res.From.Hash == res.To.Hash {
+		res.To = remote
+		if res.From.Hash == res.To.Hash && head.Branch == branch {
 			return
 		}
-		err = repo.Update()
+		err = deployCommit(repo, branch, remote.Hash)
 		if err == nil {
 			go session.Reload(p.Invalidate)
 		}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+
+	"get.pme.sh/pmesh/config"
+)
+
+type RunnerState struct {
+	Paused    bool     `json:"paused"`          // Whether the runner is paused.
+	Consuming bool     `json:"consuming"`       // Whether this node is subscribed to the runner.
+	Inflight  int      `json:"inflight"`        // Number of messages being served by this node.
+	Nodes     []string `json:"nodes,omitempty"` // Hosts of the alive nodes subscribed to the runner.
+}
+type RunnerCommandResult struct {
+	Changed bool `json:"changed"` // False if the runner was already in the requested state.
+}
+
+var ErrRunnerNotFound = errors.New("runner not found")
+
+// Pauses or resumes the runner, the state is kept across reloads.
+func (s *Session) setRunnerPausedLocked(name string, paused bool) (changed bool, err error) {
+	manifest := s.Manifest()
+	if manifest == nil {
+		return false, ErrRunnerNotFound
+	}
+	runner, ok := manifest.Runners[name]
+	if !ok {
+		return false, ErrRunnerNotFound
+	}
+	if paused {
+		if s.pausedRunners == nil {
+			s.pausedRunners = make(map[string]struct{})
+		}
+		s.pausedRunners[name] = struct{}{}
+		return runner.Pause(), nil
+	}
+	delete(s.pausedRunners, name)
+	return runner.Resume(), nil
+}
+
+// Returns the hosts consuming each runner, as advertised by the alive peers.
+func (s *Session) runnerNodes() map[string][]string {
+	res := make(map[string][]string)
+	if s.Peerlist == nil {
+		return res
+	}
+	for _, peer := range s.Peerlist.List(true) {
+		runners, _ := peer.SD["runners"].([]any)
+		for _, r := range runners {
+			if name, ok := r.(string); ok {
+				res[name] = append(res[name], peer.Host)
+			}
+		}
+	}
+	return res
+}
+
+func init() {
+	Grant(config.AccessViewer, "/runner")
+	Grant(config.AccessOperator, "/runner/pause/{runner}", "/runner/resume/{runner}")
+
+	Match("/runner", func(session *Session, r *http.Request, _ struct{}) (res map[string]RunnerState, _ error) {
+		res = make(map[string]RunnerState)
+		manifest := session.Manifest()
+		if manifest == nil {
+			return
+		}
+		nodes := session.runnerNodes()
+		for name, runner := range manifest.Runners {
+			res[name] = RunnerState{
+				Paused:    runner.Paused(),
+				Consuming: runner.Consuming(),
+				Inflight:  runner.Inflight(),
+				Nodes:     nodes[name],
+			}
+		}
+		return
+	})
+	MatchLockedAudited("runner.pause", "/runner/pause/{runner}", func(session *Session, r *http.Request, _ struct{}) (res RunnerCommandResult, err error) {
+		res.Changed, err = session.setRunnerPausedLocked(r.PathValue("runner"), true)
+		return
+	})
+	MatchLockedAudited("runner.resume", "/runner/resume/{runner}", func(session *Session, r *http.Request, _ struct{}) (res RunnerCommandResult, err error) {
+		res.Changed, err = session.setRunnerPausedLocked(r.PathValue("runner"), false)
+		return
+	})
+}
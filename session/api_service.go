@@ -6,9 +6,11 @@ import (
 	"reflect"
 	"strings"
 
+	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/lb"
 	"get.pme.sh/pmesh/service"
 	"get.pme.sh/pmesh/snowflake"
+	"get.pme.sh/pmesh/util"
 )
 
 type ServiceHealth struct {
@@ -22,6 +24,8 @@ type ServiceMetrics struct {
 	Type      string                    `json:"type"`
 	Server    lb.LoadBalancerMetrics    `json:"server"`
 	Processes []service.ProcTreeMetrics `json:"processes"`
+	Exits     []service.ProcessExit     `json:"exits,omitempty"` // Last exits of the processes, oldest first.
+	Ramp      *service.RampProgress     `json:"ramp,omitempty"`  // Traffic ramp of the last rolling restart.
 	ServiceHealth
 }
 
@@ -87,6 +91,12 @@ func (m *ServiceMetrics) Fill(sv *ServiceState) {
 			m.Processes[i] = tree.Metrics()
 		}
 	}
+	if exits, ok := sv.GetExits(); ok {
+		m.Exits = exits
+	}
+	if ramp, ok := sv.GetRamp(); ok {
+		m.Ramp = &ramp
+	}
 	if l, ok := sv.GetLoadBalancer(); ok && l != nil {
 		m.Server = l.Metrics()
 	}
@@ -98,6 +108,7 @@ func registerServiceView(name string, view func(*ServiceState) any) {
 		h = view(sv)
 		return
 	})
+	Grant(config.AccessViewer, "/service/"+name+"/{svc}", "/service/"+name)
 	Match("/service/"+name, func(session *Session, r *http.Request, _ struct{}) (h map[string]any, _ error) {
 		h = make(map[string]any)
 		session.ServiceMap.Range(func(k string, v *ServiceState) bool {
@@ -113,9 +124,16 @@ type ServiceCommandResult struct {
 }
 type ServiceInvalidate struct {
 	Invalidate bool `json:"invalidate"`
+	Rolling    bool `json:"rolling,omitempty"` // Replace the instances one at a time, see RollingRestartService.
+}
+type ServiceExport struct {
+	YAML string `json:"yaml"` // Definition of the service, see service.Export
 }
 
 func init() {
+	Grant(config.AccessViewer, "/service")
+	Grant(config.AccessOperator, "/service/restart/{svc}", "/service/restart", "/service/stop/{svc}", "/service/stop")
+
 	registerServiceView("health", func(sv *ServiceState) any {
 		var h ServiceHealth
 		h.Fill(sv)
@@ -142,19 +160,27 @@ func init() {
 		return
 	})
 
-	Match("/service/restart/{svc}", func(session *Session, r *http.Request, p ServiceInvalidate) (res ServiceCommandResult, err error) {
+	MatchAudited("service.restart", "/service/restart/{svc}", func(session *Session, r *http.Request, p ServiceInvalidate) (res ServiceCommandResult, err error) {
 		svcn := r.PathValue("svc")
-		res.Count = session.RestartService(&svcn, p.Invalidate)
-		if res.Count == 0 {
+		if p.Rolling {
+			res.Count, err = session.RollingRestartService(&svcn, p.Invalidate)
+		} else {
+			res.Count = session.RestartService(&svcn, p.Invalidate)
+		}
+		if res.Count == 0 && err == nil {
 			err = errors.New("service not found")
 		}
 		return
 	})
-	Match("/service/restart", func(session *Session, r *http.Request, p ServiceInvalidate) (res ServiceCommandResult, _ error) {
-		res.Count = session.RestartService(nil, p.Invalidate)
+	MatchAudited("service.restart", "/service/restart", func(session *Session, r *http.Request, p ServiceInvalidate) (res ServiceCommandResult, err error) {
+		if p.Rolling {
+			res.Count, err = session.RollingRestartService(nil, p.Invalidate)
+		} else {
+			res.Count = session.RestartService(nil, p.Invalidate)
+		}
 		return
 	})
-	Match("/service/stop/{svc}", func(session *Session, r *http.Request, p struct{}) (res ServiceCommandResult, err error) {
+	MatchAudited("service.stop", "/service/stop/{svc}", func(session *Session, r *http.Request, p struct{}) (res ServiceCommandResult, err error) {
 		svcn := r.PathValue("svc")
 		res.Count = session.StopService(&svcn)
 		if res.Count == 0 {
@@ -162,7 +188,21 @@ func init() {
 		}
 		return
 	})
-	Match("/service/stop", func(session *Session, r *http.Request, _ struct{}) (res ServiceCommandResult, _ error) {
+	Match("/service/export/{svc}", func(session *Session, r *http.Request, _ struct{}) (res ServiceExport, err error) {
+		name := r.PathValue("svc")
+		manifest := session.Manifest()
+		if manifest == nil {
+			return res, errors.New("service not found")
+		}
+		sv, ok := manifest.Services.Get(name)
+		if !ok {
+			return res, errors.New("service not found")
+		}
+		data, err := service.Export(util.OrderedMap[string, service.Service]{{A: name, B: sv}})
+		res.YAML = string(data)
+		return
+	})
+	MatchAudited("service.stop", "/service/stop", func(session *Session, r *http.Request, _ struct{}) (res ServiceCommandResult, _ error) {
 		res.Count = session.StopService(nil)
 		return
 	})
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/vhttp"
+)
+
+func init() {
+	Grant(config.AccessViewer, "POST /tap")
+	Match("POST /tap", func(s *Session, r *http.Request, w http.ResponseWriter) (res struct{}, err error) {
+		// Read the tap options.
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		var opt vhttp.TapOptions
+		err = dec.Decode(&opt)
+		if err != nil {
+			return
+		}
+
+		// Hijack the connection and start streaming.
+		rc := http.NewResponseController(w)
+		conn, bwr, err := rc.Hijack()
+		if err != nil {
+			err = fmt.Errorf("failed enable server-sent events: %w", err)
+			return
+		}
+		defer conn.Close()
+
+		bwr.Write([]byte("HTTP/1.1 200 OK\r\n"))
+		bwr.Write([]byte("Content-Type: application/stream+json\r\n"))
+		bwr.Write([]byte("Cache-Control: no-cache\r\n"))
+		bwr.Write([]byte("Connection: keep-alive\r\n"))
+		bwr.Write([]byte("\r\n"))
+		bwr.Flush()
+
+		tap, cancel := s.Server.Tap(opt)
+		defer cancel()
+
+		// Detect the subscriber going away, it never sends anything.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var buf [1]byte
+			conn.Read(buf[:])
+		}()
+
+		enc := json.NewEncoder(conn)
+		for {
+			select {
+			case <-s.Context.Done():
+			case <-closed:
+			case ev := <-tap.Events:
+				if enc.Encode(ev) == nil {
+					continue
+				}
+			}
+			break
+		}
+		err = http.ErrAbortHandler
+		return
+	})
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/enats"
+	"get.pme.sh/pmesh/vhttp"
+	"get.pme.sh/pmesh/xlog"
+)
+
+// Subject audit entries are published to, captured by the event stream.
+const AuditSubject = enats.EventStreamPrefix + "audit"
+
+// AuditEntry is a record of a control-plane action performed through the API.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`             // Time the action was performed.
+	Identity string    `json:"identity"`         // How the caller was authenticated, see vhttp.RequestIdentity.
+	IP       string    `json:"ip"`               // Client IP.
+	Action   string    `json:"action"`           // Name of the action.
+	Target   string    `json:"target,omitempty"` // Target of the action, if any.
+	Status   int       `json:"status"`           // Status code of the response.
+}
+
+// AuditOptions selects where the audit entries go besides the sinks registered with RegisterAuditSink.
+type AuditOptions struct {
+	NoLog     bool `yaml:"no_log,omitempty"`     // Do not write the entries to audit.log
+	NoPublish bool `yaml:"no_publish,omitempty"` // Do not publish the entries to the event stream
+}
+
+// AuditSink receives the audit entries of the node, such as to forward them to a SIEM. It is called
+// synchronously and must not block.
+type AuditSink interface {
+	Audit(e AuditEntry)
+}
+
+var (
+	auditSinksMu sync.RWMutex
+	auditSinks   []AuditSink
+)
+
+// RegisterAuditSink adds a sink receiving every audit entry recorded afterwards.
+func RegisterAuditSink(sink AuditSink) {
+	auditSinksMu.Lock()
+	defer auditSinksMu.Unlock()
+	auditSinks = append(auditSinks, sink)
+}
+
+// The audit log is kept in its own file so that it is not rotated away with the session log.
+var auditLogger = sync.OnceValue(func() *xlog.Logger {
+	return xlog.NewDomain("audit", xlog.FileWriter("audit.log"))
+})
+
+// Records the entry to the audit log and the registered sinks, and publishes it to the cluster if possible.
+func (s *Session) Audit(e AuditEntry) {
+	var opts AuditOptions
+	if manifest := s.Manifest(); manifest != nil {
+		opts = manifest.Audit
+	}
+	if !opts.NoLog {
+		auditLogger().Info().
+			Str("identity", e.Identity).
+			Str("ip", e.IP).
+			Str("action", e.Action).
+			Str("target", e.Target).
+			Int("status", e.Status).
+			Msg("Audit")
+	}
+	auditSinksMu.RLock()
+	for _, sink := range auditSinks {
+		sink.Audit(e)
+	}
+	auditSinksMu.RUnlock()
+
+	if opts.NoPublish || s.Nats == nil || s.Nats.Client.Conn == nil {
+		return
+	}
+	// Published on the connection directly, the audit trail is not subject to the publish quotas.
+	if data, err := json.Marshal(e); err == nil {
+		if err := s.Nats.Client.Publish(AuditSubject, data); err != nil {
+			xlog.Warn().Err(err).Str("action", e.Action).Msg("Failed to publish audit entry")
+		}
+	}
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AuditedHandler records an audit entry for each request served by the inner handler.
+// The target is taken from the {svc} or {runner} path wildcard, if any.
+type AuditedHandler struct {
+	Action  string
+	Handler http.Handler
+}
+
+func (h AuditedHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	aw := &auditResponseWriter{ResponseWriter: w}
+	h.Handler.ServeHTTP(aw, req)
+
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Identity: vhttp.RequestIdentity(req),
+		Action:   h.Action,
+		Target:   cmp.Or(req.PathValue("svc"), req.PathValue("runner")),
+		Status:   aw.status,
+	}
+	if cs := vhttp.ClientSessionFromContext(req.Context()); cs != nil {
+		entry.IP = cs.IP.String()
+	}
+	RequestSession(req).Audit(entry)
+}
@@ -0,0 +1,27 @@
+package session
+
+import (
+	"testing"
+)
+
+type auditRecorder []AuditEntry
+
+func (r *auditRecorder) Audit(e AuditEntry) { *r = append(*r, e) }
+
+func TestAuditSinks(t *testing.T) {
+	rec := &auditRecorder{}
+	RegisterAuditSink(rec)
+	t.Cleanup(func() {
+		auditSinksMu.Lock()
+		auditSinks = nil
+		auditSinksMu.Unlock()
+	})
+
+	// Neither logged nor published, the registered sink still receives it.
+	s := &Session{}
+	s.manifest.Store(&Manifest{Audit: AuditOptions{NoLog: true, NoPublish: true}})
+	s.Audit(AuditEntry{Action: "reload", Status: 200})
+	if len(*rec) != 1 || (*rec)[0].Action != "reload" {
+		t.Fatalf("sink received %+v", *rec)
+	}
+}
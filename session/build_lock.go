@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"get.pme.sh/pmesh/enats"
+	"get.pme.sh/pmesh/xlog"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Lifetime of a build lock, refreshed by its holder so that the lock of a node that went away
+// expires rather than blocking the builds of the cluster.
+const buildLockTTL = 30 * time.Second
+
+// Acquires a build lock in the scheduler bucket, waiting for the other nodes holding it.
+func (s *Session) lockBuild(ctx context.Context, key string) (unlock func(), err error) {
+	if !s.Nats.Available() {
+		return func() {}, nil
+	}
+	kv := s.Nats.SchedulerKV
+	expiry := func() []byte {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(s.Nats.Now(ctx).Add(buildLockTTL).UnixMilli()))
+		return buf
+	}
+	expired := func(val []byte) bool {
+		if len(val) != 8 {
+			return true
+		}
+		return s.Nats.Now(ctx).After(time.UnixMilli(int64(binary.LittleEndian.Uint64(val))))
+	}
+
+	var rev uint64
+	for waiting := false; ; waiting = true {
+		rev, err = enats.RetryTransientValue(ctx, "build.lock", func(ctx context.Context) (uint64, error) {
+			return kv.Create(ctx, key, expiry())
+		})
+		if err == nil {
+			break
+		} else if !errors.Is(err, jetstream.ErrKeyExists) {
+			return nil, err
+		}
+		if entry, e := kv.Get(ctx, key); e == nil && expired(entry.Value()) {
+			if rev, err = kv.Update(ctx, key, expiry(), entry.Revision()); err == nil {
+				break
+			}
+		}
+		if !waiting {
+			xlog.InfoC(ctx).Str("lock", key).Msg("Waiting for another node to finish the build")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	// Refresh the lock until released.
+	refreshCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(buildLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-refreshCtx.Done():
+				return
+			case <-ticker.C:
+				next, err := kv.Update(refreshCtx, key, expiry(), rev)
+				if err != nil {
+					if refreshCtx.Err() == nil {
+						xlog.WarnC(ctx).Err(err).Str("lock", key).Msg("Failed to refresh the build lock")
+					}
+					return
+				}
+				rev = next
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		kv.Delete(ctx, key, jetstream.LastRevision(rev))
+	}, nil
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"net/http"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/xlog"
+)
+
+type CordonResult struct {
+	Changed bool `json:"changed"` // False if the node already was in the requested state.
+}
+
+// Cordon takes the node out of rotation for maintenance: its services are no longer advertised to
+// the peers and new external traffic is shed with 503, while the requests in flight drain and the
+// services, runners and the management API keep running.
+func (s *Session) Cordon() bool {
+	if !s.Server.Cordon() {
+		return false
+	}
+	xlog.Info().Msg("Node cordoned, shedding external traffic")
+	if s.Peerlist != nil {
+		s.Peerlist.Refresh()
+	}
+	return true
+}
+
+// Uncordon puts the node back into rotation.
+func (s *Session) Uncordon() bool {
+	if !s.Server.Uncordon() {
+		return false
+	}
+	xlog.Info().Msg("Node uncordoned, accepting external traffic")
+	if s.Peerlist != nil {
+		s.Peerlist.Refresh()
+	}
+	return true
+}
+
+func (s *Session) Cordoned() bool {
+	return s.Server.IsCordoned()
+}
+
+func init() {
+	Grant(config.AccessViewer, "/cordon/status")
+	Grant(config.AccessOperator, "/cordon", "/uncordon")
+
+	Match("/cordon/status", func(session *Session, r *http.Request, _ struct{}) (res bool, _ error) {
+		res = session.Cordoned()
+		return
+	})
+	MatchAudited("cordon", "/cordon", func(session *Session, r *http.Request, _ struct{}) (res CordonResult, _ error) {
+		res.Changed = session.Cordon()
+		return
+	})
+	MatchAudited("uncordon", "/uncordon", func(session *Session, r *http.Request, _ struct{}) (res CordonResult, _ error) {
+		res.Changed = session.Uncordon()
+		return
+	})
+}
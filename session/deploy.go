@@ -0,0 +1,106 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"get.pme.sh/pmesh/revision"
+	"get.pme.sh/pmesh/util"
+	"get.pme.sh/pmesh/xlog"
+)
+
+// DeployOptions enables continuous deployment from the repository of the manifest root, when a new
+// commit lands on the tracked branch the checkout is updated and the node reloads, the builds see the
+// new commit in PM3_COMMIT.
+type DeployOptions struct {
+	Poll    util.Duration `yaml:"poll,omitempty"`    // Interval at which the remote is fetched, disabled if zero
+	Branch  string        `yaml:"branch,omitempty"`  // Branch to track, default = the checked out branch
+	Rebuild bool          `yaml:"rebuild,omitempty"` // Rebuild all the services rather than only the ones whose sources changed
+}
+
+// Polls the repository of the manifest root while continuous deployment is enabled.
+func (s *Session) pollRepository() {
+	for {
+		opts := s.Manifest().Deploy
+		interval := opts.Poll.Duration()
+		enabled := interval > 0
+		if !enabled {
+			interval = time.Minute // Check again later in case a reload enables it.
+		}
+		select {
+		case <-s.Context.Done():
+			return
+		case <-time.After(interval):
+		}
+		if enabled {
+			s.deployLatest(opts)
+		}
+	}
+}
+
+// Updates the checkout to the latest commit of the tracked branch, reloading if it changed.
+func (s *Session) deployLatest(opts DeployOptions) error {
+	ctx, cancel := context.WithTimeout(s.Context, time.Minute)
+	defer cancel()
+
+	repo, err := getRepoState(s, ctx, true)
+	if err != nil {
+		xlog.Warn().Err(err).Msg("Continuous deployment: failed to open the repository")
+		return fmt.Errorf("failed to open the repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		xlog.Warn().Err(err).Msg("Continuous deployment: failed to read the head")
+		return fmt.Errorf("failed to read the head: %w", err)
+	}
+	branch, remote, err := deployTarget(repo, head, opts)
+	if err != nil {
+		xlog.Warn().Err(err).Str("branch", branch).Msg("Continuous deployment: failed to read the remote branch")
+		return err
+	}
+	if remote.Hash == head.Hash && head.Branch == branch {
+		return nil
+	}
+
+	xlog.Info().Str("branch", branch).Str("from", head.Hash).Str("to", remote.Hash).Msg("Continuous deployment: new commit, updating")
+	entry := AuditEntry{Time: time.Now(), Identity: "deploy", Action: "repo.update", Target: branch, Status: http.StatusOK}
+	defer func() { s.Audit(entry) }()
+	if err := deployCommit(repo, branch, remote.Hash); err != nil {
+		entry.Status = http.StatusInternalServerError
+		xlog.Err(err).Msg("Continuous deployment: failed to update the checkout")
+		return fmt.Errorf("failed to update the checkout: %w", err)
+	}
+	if err := s.Reload(opts.Rebuild); err != nil {
+		entry.Status = http.StatusInternalServerError
+		xlog.Err(err).Msg("Continuous deployment: failed to reload the manifest")
+		return fmt.Errorf("failed to reload the manifest: %w", err)
+	}
+	xlog.Info().Str("commit", remote.Hash).Msg("Continuous deployment: deployed")
+	return nil
+}
+
+// Returns the branch deployed, the checked out one unless configured, and its commit on the remote.
+func deployTarget(repo revision.Repo, head revision.Reference, opts DeployOptions) (branch string, remote revision.Reference, err error) {
+	branch = opts.Branch
+	if branch == "" {
+		if head.Branch == "HEAD" {
+			return "", remote, errors.New("no branch checked out, set deploy.branch")
+		}
+		branch = head.Branch
+	}
+	if remote, err = repo.RemoteBranch(branch); err != nil {
+		return branch, remote, fmt.Errorf("failed to read the remote branch %q: %w", branch, err)
+	}
+	return
+}
+
+// Checks out the branch at the commit, so that deploying another branch than the checked out one
+// switches to it rather than moving the current branch onto its history.
+func deployCommit(repo revision.Repo, branch, hash string) error {
+	repoLock.Lock()
+	defer repoLock.Unlock()
+	return repo.Checkout(branch, hash)
+}
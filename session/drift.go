@@ -0,0 +1,176 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"get.pme.sh/pmesh/lyml"
+	"get.pme.sh/pmesh/service"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of configuration drift.
+const (
+	DriftAdded   = "added"   // Only on disk, a reload applies it.
+	DriftRemoved = "removed" // Only in the running manifest, a reload drops it.
+	DriftChanged = "changed" // Differs between the running manifest and the disk.
+	DriftRuntime = "runtime" // Live state changed through the API.
+)
+
+// ConfigDrift is a difference between the running configuration and the manifest on disk.
+type ConfigDrift struct {
+	Path    string `json:"path"`              // Path of the value, e.g. "services.api.env".
+	Kind    string `json:"kind"`              // One of the Drift* constants.
+	Running any    `json:"running,omitempty"` // Running value.
+	Disk    any    `json:"disk,omitempty"`    // Value on disk.
+	Note    string `json:"note,omitempty"`    // What a reload does to it, for the runtime drift.
+}
+
+// Converts the manifest document into plain values before decoding it consumes the tags. Custom
+// tags such as the service kinds are kept under the "!" key of mappings and as a prefix of scalars.
+func manifestValues(node *yaml.Node) (res any, err error) {
+	if node == nil {
+		return nil, nil
+	}
+	custom := node.Tag != "" && !strings.HasPrefix(node.Tag, "!!")
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return manifestValues(node.Content[0])
+	case yaml.AliasNode:
+		return manifestValues(node.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if m[node.Content[i].Value], err = manifestValues(node.Content[i+1]); err != nil {
+				return
+			}
+		}
+		if custom {
+			m["!"] = node.Tag
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		l := make([]any, len(node.Content))
+		for i, n := range node.Content {
+			if l[i], err = manifestValues(n); err != nil {
+				return
+			}
+		}
+		return l, nil
+	}
+	if custom {
+		return node.Tag + " " + node.Value, nil
+	}
+	err = node.Decode(&res)
+	return
+}
+
+// Appends the differences between a and b under the path.
+func diffValues(res []ConfigDrift, path string, a, b any) []ConfigDrift {
+	join := func(k string) string {
+		if path == "" {
+			return k
+		}
+		return path + "." + k
+	}
+	switch {
+	case a == nil && b == nil:
+		return res
+	case a == nil:
+		return append(res, ConfigDrift{Path: path, Kind: DriftAdded, Disk: b})
+	case b == nil:
+		return append(res, ConfigDrift{Path: path, Kind: DriftRemoved, Running: a})
+	}
+
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := lo.Keys(av)
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			res = diffValues(res, join(k), av[k], bv[k])
+		}
+		return res
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(av), len(bv)); i++ {
+			var ai, bi any
+			if i < len(av) {
+				ai = av[i]
+			}
+			if i < len(bv) {
+				bi = bv[i]
+			}
+			res = diffValues(res, join(strconv.Itoa(i)), ai, bi)
+		}
+		return res
+	}
+	if !reflect.DeepEqual(a, b) {
+		res = append(res, ConfigDrift{Path: path, Kind: DriftChanged, Running: a, Disk: b})
+	}
+	return res
+}
+
+// DiffConfigLocked compares the running configuration against the manifest on disk, listing what a
+// reload would change or revert.
+func (s *Session) DiffConfigLocked() (res []ConfigDrift, err error) {
+	manifest := s.Manifest()
+	if manifest == nil {
+		return nil, errors.New("no manifest loaded")
+	}
+	var disk *yaml.Node
+	if err = lyml.Load(s.ManifestPath, &disk); err != nil {
+		return nil, fmt.Errorf("failed to load the manifest: %w", err)
+	}
+	ondisk, err := manifestValues(disk)
+	if err != nil {
+		return
+	}
+	res = diffValues([]ConfigDrift{}, "", manifest.values, ondisk)
+
+	// Services stopped through the API are started again by a reload.
+	manifest.Services.ForEach(func(name string, _ service.Service) {
+		if sv, ok := s.ServiceMap.Load(name); ok && sv.ctx.Err() == nil {
+			return
+		}
+		res = append(res, ConfigDrift{
+			Path:    "services." + name,
+			Kind:    DriftRuntime,
+			Running: "stopped",
+			Note:    "started on reload",
+		})
+	})
+
+	// Paused runners are kept paused across reloads.
+	paused := lo.Keys(s.pausedRunners)
+	slices.Sort(paused)
+	for _, name := range paused {
+		res = append(res, ConfigDrift{
+			Path:    "runners." + name,
+			Kind:    DriftRuntime,
+			Running: "paused",
+			Note:    "kept paused on reload",
+		})
+	}
+	return
+}
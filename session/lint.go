@@ -0,0 +1,290 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"get.pme.sh/pmesh/vhttp"
+
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// LintOptions configures the manifest linter, see LintManifest.
+type LintOptions struct {
+	Disable []string `yaml:"disable,omitempty"` // Rules that are not checked
+	Ignore  []string `yaml:"ignore,omitempty"`  // Findings that are suppressed, by ID with glob patterns, e.g. missing-health-check@services.worker*
+}
+
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+	LintInfo    LintSeverity = "info"
+)
+
+// LintFinding is an anti-pattern found in the manifest.
+type LintFinding struct {
+	Rule     string       `json:"rule"`
+	Severity LintSeverity `json:"severity"`
+	Location string       `json:"location"`
+	Message  string       `json:"message"`
+}
+
+// ID identifies the finding in the ignore list of the lint options.
+func (f LintFinding) ID() string {
+	return f.Rule + "@" + f.Location
+}
+
+// LintRule checks the manifest before it is prepared.
+type LintRule struct {
+	Name        string
+	Description string
+	Check       func(m *Manifest, report func(severity LintSeverity, location, format string, args ...any))
+}
+
+var secretKeyRegex = regexp.MustCompile(`(?i)(secret|passw(or)?d|token|api_?key|private_?key|credential)`)
+
+// Requests per second past which a rate limit no longer protects anything.
+const broadRateLimit = 1000
+
+// LintRules is the rule set of the linter, embedders can add their own.
+var LintRules = []LintRule{
+	{
+		Name:        "inline-secret",
+		Description: "Secrets written in the manifest instead of being read from the environment",
+		Check: func(m *Manifest, report func(LintSeverity, string, string, ...any)) {
+			// Only the values written as is are checked, the rendered ones may come from an expression
+			// such as $(env.API_TOKEN).
+			check := func(location string, env map[string]string) {
+				keys := lo.Keys(env)
+				slices.Sort(keys)
+				for _, k := range keys {
+					v, ok := m.literal[location+"."+k]
+					if ok && v != "" && secretKeyRegex.MatchString(k) && !strings.Contains(v, "${") {
+						report(LintWarning, location+"."+k, "%s looks like a secret written inline", k)
+					}
+				}
+			}
+			check("env", m.Env)
+			for _, tup := range m.Services {
+				if app := tup.B.AsApp(); app != nil {
+					check("services."+tup.A+".env", app.Env)
+				}
+			}
+			if v := m.literal["ipinfo.maxmind"]; v != "" {
+				report(LintWarning, "ipinfo.maxmind", "license key written inline")
+			}
+		},
+	},
+	{
+		Name:        "broad-rate-limit",
+		Description: "Rate limits too permissive to have any effect",
+		Check: func(m *Manifest, report func(LintSeverity, string, string, ...any)) {
+			m.walkRoutes(func(location string, h vhttp.Handler) {
+				var limit *vhttp.RateLimitHandler
+				switch h := h.(type) {
+				case *vhttp.RateLimitHandler:
+					limit = h
+				case vhttp.RateLimitHandler:
+					limit = &h
+				default:
+					return
+				}
+				r := limit.Rate
+				if r.Period <= 0 {
+					return
+				}
+				if perSec := float64(r.Count) / r.Period.Seconds(); perSec > broadRateLimit {
+					report(LintWarning, location, "rate limit of %.0f requests per second", perSec)
+				}
+			})
+		},
+	},
+	{
+		Name:        "missing-health-check",
+		Description: "HTTP apps only checked for an open port",
+		Check: func(m *Manifest, report func(LintSeverity, string, string, ...any)) {
+			for _, tup := range m.Services {
+				if app := tup.B.AsApp(); app != nil && !app.Background && len(app.Monitor.Checks) == 0 {
+					report(LintWarning, "services."+tup.A, "no health check, the app is healthy as soon as its port is open")
+				}
+			}
+		},
+	},
+	{
+		Name:        "missing-resource-limit",
+		Description: "Apps without a memory limit",
+		Check: func(m *Manifest, report func(LintSeverity, string, string, ...any)) {
+			for _, tup := range m.Services {
+				if app := tup.B.AsApp(); app != nil && app.MaxMemory <= 0 {
+					report(LintInfo, "services."+tup.A, "no max_memory, the app may use all of the memory of the node")
+				}
+			}
+		},
+	},
+	{
+		Name:        "unbounded-cluster",
+		Description: "Apps running more instances than there are CPUs",
+		Check: func(m *Manifest, report func(LintSeverity, string, string, ...any)) {
+			for _, tup := range m.Services {
+				app := tup.B.AsApp()
+				if app == nil {
+					continue
+				}
+				for _, c := range [][2]string{{"cluster", app.Cluster}, {"cluster_min", app.ClusterMin}} {
+					if p, ok := strings.CutSuffix(c[1], "%"); ok {
+						if n, err := strconv.Atoi(p); err == nil && n > 100 {
+							report(LintWarning, "services."+tup.A+"."+c[0], "%s runs more instances than there are CPUs", c[1])
+						}
+					}
+				}
+				if app.AutoScale && app.Cluster == "" {
+					report(LintWarning, "services."+tup.A+".cluster", "auto_scale without a cluster size can never scale up")
+				}
+			}
+		},
+	},
+	{
+		Name:        "deprecated-tls",
+		Description: "TLS versions and cipher suites deprecated by RFC 8996 and RFC 9325",
+		Check: func(m *Manifest, report func(LintSeverity, string, string, ...any)) {
+			for _, key := range m.ServerKeys() {
+				o := m.Server[key].TLS
+				if o == nil {
+					continue
+				}
+				location := "server." + key + ".tls"
+				if o.MinVersion == "1.0" || o.MinVersion == "1.1" {
+					report(LintWarning, location+".min_version", "TLS %s is deprecated, use 1.2 or later", o.MinVersion)
+				}
+				for _, name := range o.CipherSuites {
+					switch {
+					case strings.HasPrefix(name, "TLS_RSA_"):
+						report(LintWarning, location+".ciphers", "%s has no forward secrecy and is deprecated", name)
+					case strings.Contains(name, "_CBC_"):
+						report(LintWarning, location+".ciphers", "%s is not an AEAD cipher suite, prefer GCM or ChaCha20-Poly1305", name)
+					}
+				}
+			}
+		},
+	},
+}
+
+// Calls the function for each handler of the routes of the servers and the runners.
+func (m *Manifest) walkRoutes(fn func(location string, h vhttp.Handler)) {
+	visit := func(owner string) func([]string, vhttp.Handler) {
+		return func(location []string, h vhttp.Handler) {
+			where := owner
+			if p := lo.Compact(location); len(p) != 0 {
+				where += " > " + strings.Join(p, " > ")
+			}
+			fn(where, h)
+		}
+	}
+	for _, key := range m.ServerKeys() {
+		vhttp.Walk(&m.Server[key].Router, visit("server."+key))
+	}
+	runners := lo.Keys(m.Runners)
+	slices.Sort(runners)
+	for _, name := range runners {
+		vhttp.Walk(&m.Runners[name].Route, visit("runners."+name))
+	}
+}
+
+// Returns the secrets checked by the linter that are written as is in the manifest file by location, e.g.
+// services.api.env.KEY. Values computed by lyml expressions are left out, as are the ones coming from
+// imports or generated by templates since their source is not known.
+func literalSecrets(manifestPath string) map[string]string {
+	res := make(map[string]string)
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return res
+	}
+	var doc yaml.Node
+	if yaml.Unmarshal(data, &doc) != nil || len(doc.Content) == 0 {
+		return res
+	}
+	get := func(node *yaml.Node, key string) *yaml.Node {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+		return nil
+	}
+	add := func(location string, v *yaml.Node) {
+		if v != nil && v.Kind == yaml.ScalarNode && !strings.Contains(v.Value, "$(") {
+			res[location] = v.Value
+		}
+	}
+	collect := func(location string, env *yaml.Node) {
+		if env == nil || env.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(env.Content); i += 2 {
+			add(location+"."+env.Content[i].Value, env.Content[i+1])
+		}
+	}
+	root := doc.Content[0]
+	add("ipinfo.maxmind", get(get(root, "ipinfo"), "maxmind"))
+	collect("env", get(root, "env"))
+	if services := get(root, "services"); services != nil && services.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(services.Content); i += 2 {
+			collect("services."+services.Content[i].Value+".env", get(services.Content[i+1], "env"))
+		}
+	}
+	return res
+}
+
+// LintManifest checks the manifest for anti-patterns, the rules disabled and the findings ignored by the
+// lint options of the manifest or by the given options are left out. The manifest is prepared without
+// applying its side effects, a manifest that fails to load is reported as an error finding.
+func LintManifest(manifestPath string, opts LintOptions) ([]LintFinding, error) {
+	m, err := ParseManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	m.literal = literalSecrets(manifestPath)
+	opts.Disable = append(opts.Disable, m.Lint.Disable...)
+	opts.Ignore = append(opts.Ignore, m.Lint.Ignore...)
+
+	var findings []LintFinding
+	for _, rule := range LintRules {
+		if slices.Contains(opts.Disable, rule.Name) {
+			continue
+		}
+		rule.Check(m, func(severity LintSeverity, location, format string, args ...any) {
+			f := LintFinding{
+				Rule:     rule.Name,
+				Severity: severity,
+				Location: location,
+				Message:  fmt.Sprintf(format, args...),
+			}
+			for _, pattern := range opts.Ignore {
+				if ok, _ := path.Match(pattern, f.ID()); ok {
+					return
+				}
+			}
+			findings = append(findings, f)
+		})
+	}
+	if err := m.prepare(); err != nil {
+		findings = append(findings, LintFinding{
+			Rule:     "invalid",
+			Severity: LintError,
+			Location: manifestPath,
+			Message:  err.Error(),
+		})
+	}
+	return findings, nil
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Lints the manifest, returns the IDs of the findings.
+func testLint(t *testing.T, doc string, opts LintOptions) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pm3.yml")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := LintManifest(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range findings {
+		if f.Rule == "invalid" {
+			t.Fatalf("invalid manifest: %s", f.Message)
+		}
+		ids = append(ids, f.ID())
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+const lintApp = `
+    run: sleep infinity
+    monitor:
+      test:
+        home: GET / 200
+    max_memory: 1g
+`
+
+func TestLintManifest(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		want      []string
+	}{
+		{"clean", "services:\n  api: !App" + lintApp, nil},
+		{
+			"inline secret",
+			"env:\n  API_TOKEN: abc\n  FROM_ENV: ${TOKEN}\n  DB_PASSWORD: $(\"x\")\n  NAME: api\n",
+			[]string{"inline-secret@env.API_TOKEN"},
+		},
+		{
+			"service secret",
+			"services:\n  api: !App" + lintApp + "    env:\n      SECRET_KEY: abc\n      KEY_FILE: ${HOME}/key\n",
+			[]string{"inline-secret@services.api.env.SECRET_KEY"},
+		},
+		{
+			"broad rate limit",
+			"server:\n  example.com:\n    router:\n      - limit 5000/s\n      - limit 10/s\n",
+			[]string{"broad-rate-limit@server.example.com"},
+		},
+		{
+			"app defaults",
+			"services:\n  api: !App\n    run: sleep infinity\n  worker: !App\n    run: sleep infinity\n    background: true\n",
+			[]string{
+				"missing-health-check@services.api",
+				"missing-resource-limit@services.api",
+				"missing-resource-limit@services.worker",
+			},
+		},
+		{
+			"unbounded cluster",
+			"services:\n  api: !App" + lintApp + "    cluster: 200%\n    cluster_min: 50%\n  worker: !App" + lintApp + "    auto_scale: true\n",
+			[]string{"unbounded-cluster@services.api.cluster", "unbounded-cluster@services.worker.cluster"},
+		},
+		{
+			"deprecated tls",
+			"server:\n  a.example.com:\n    tls:\n      min_version: \"1.1\"\n      ciphers: [TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]\n" +
+				"  b.example.com:\n    tls:\n      min_version: \"1.2\"\n",
+			[]string{"deprecated-tls@server.a.example.com.tls.ciphers", "deprecated-tls@server.a.example.com.tls.min_version"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testLint(t, tt.doc, LintOptions{}); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLintManifestSuppression(t *testing.T) {
+	doc := "env:\n  API_TOKEN: abc\nservices:\n  api: !App\n    run: sleep infinity\n  worker: !App" + lintApp
+	all := testLint(t, doc, LintOptions{})
+	if len(all) != 3 {
+		t.Fatalf("got %q, want 3 findings", all)
+	}
+
+	got := testLint(t, doc, LintOptions{Disable: []string{"missing-resource-limit"}, Ignore: []string{"inline-secret@env.*"}})
+	if !slices.Equal(got, []string{"missing-health-check@services.api"}) {
+		t.Errorf("with options: got %q", got)
+	}
+
+	// Same from the lint options of the manifest.
+	got = testLint(t, doc+"lint:\n  disable: [missing-health-check]\n  ignore: ['*@services.a*']\n", LintOptions{})
+	if !slices.Equal(got, []string{"inline-secret@env.API_TOKEN"}) {
+		t.Errorf("from the manifest: got %q", got)
+	}
+}
+
+func TestLintManifestInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pm3.yml")
+	if err := os.WriteFile(path, []byte("readiness:\n  critical: [missing]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := LintManifest(path, LintOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Rule != "invalid" || findings[0].Severity != LintError {
+		t.Errorf("got %+v, want an invalid finding", findings)
+	}
+	if !strings.Contains(findings[0].Message, "missing") {
+		t.Errorf("message %q", findings[0].Message)
+	}
+}
@@ -2,11 +2,17 @@ package session
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"html/template"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strings"
 
+	"get.pme.sh/pmesh/enats"
 	"get.pme.sh/pmesh/hosts"
 	"get.pme.sh/pmesh/lyml"
 	"get.pme.sh/pmesh/netx"
@@ -14,8 +20,10 @@ import (
 	"get.pme.sh/pmesh/util"
 	"get.pme.sh/pmesh/vhttp"
 	"get.pme.sh/pmesh/xlog"
+	"get.pme.sh/pmesh/xpost"
 
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/samber/lo"
 	"gopkg.in/yaml.v3"
 )
 
@@ -31,26 +39,63 @@ func (sv *Server) CreateVirtualHost() *vhttp.VirtualHost {
 }
 
 type IPInfoOptions struct {
-	Disable    bool     `yaml:"disable,omitempty"`
-	MaxmindKey string   `yaml:"maxmind,omitempty"`
-	Mark       []string `yaml:"mark,omitempty"`
+	Disable    bool          `yaml:"disable,omitempty"`
+	MaxmindKey string        `yaml:"maxmind,omitempty"`
+	Mark       []string      `yaml:"mark,omitempty"`
+	Timeout    util.Duration `yaml:"timeout,omitempty"` // Lookups taking longer are skipped and count as failures of the providers, defaults to 1s
+	Async      bool          `yaml:"async,omitempty"`   // Look up a new client in the background rather than before serving its first request, which then escapes the rules on its country or ASN
+
+	// Info of addresses or CIDR ranges taking precedence over the providers, e.g. an office range or a
+	// partner's ASN; the fields left empty are still looked up. Loopback and private addresses are
+	// never looked up, the map alone applies to them.
+	Static netx.StaticIPMap `yaml:"static,omitempty"`
+}
+
+// ReadinessOptions controls when a starting node begins serving external traffic. External requests
+// are answered with 503 until the manifest is loaded and, if services are listed as critical, until
+// they are healthy, while internal ones are served as usual.
+type ReadinessOptions struct {
+	Critical []string      `yaml:"critical,omitempty"` // Services that must be healthy before serving external traffic
+	Timeout  util.Duration `yaml:"timeout,omitempty"`  // Maximum time to wait for the critical services, defaults to 1m
+}
+
+// ShutdownOptions splits the shutdown deadline between its phases, services are given whatever is left.
+type ShutdownOptions struct {
+	Timeout   util.Duration `yaml:"timeout,omitempty"`    // Deadline of the graceful shutdown, defaults to 30s
+	Drain     util.Duration `yaml:"drain,omitempty"`      // Grace period for in-flight requests, defaults to a third of the deadline
+	Close     util.Duration `yaml:"close,omitempty"`      // Time reserved for closing NATS and the peer list, defaults to 5s
+	ForceExit util.Duration `yaml:"force_exit,omitempty"` // Time past the deadline after which the process exits if the shutdown hangs, defaults to 10s, never if negative
 }
 
 func (i IPInfoOptions) CreateProvider() (info netx.IPInfoProvider) {
 	if i.Disable {
-		return netx.NullIPInfoProvider
-	}
-
-	info = netx.IP2ASNProvider
-	if i.MaxmindKey != "" {
+		info = netx.NullIPInfoProvider
+	} else {
+		info = netx.IP2ASNProvider
+		if i.MaxmindKey != "" {
+			info = netx.CombinedProvider{
+				OrgPrimary: netx.NewMaxmindProvider(i.MaxmindKey),
+				GeoPrimary: info,
+			}
+		}
 		info = netx.CombinedProvider{
-			OrgPrimary: netx.NewMaxmindProvider(i.MaxmindKey),
+			OrgPrimary: netx.CloudflareProvider,
 			GeoPrimary: info,
 		}
+
+		// Lookups are made in the request path, do not let a provider outage stall the requests.
+		info = netx.NewBreakerProvider(info, i.Timeout.Duration())
 	}
-	info = netx.CombinedProvider{
-		OrgPrimary: netx.CloudflareProvider,
-		GeoPrimary: info,
+
+	// The static mappings are kept out of the breaker so that they still apply during an outage.
+	if len(i.Static) > 0 {
+		// Validated when the manifest is loaded.
+		if static, err := netx.NewStaticProvider(info, i.Static); err == nil {
+			info = static
+		}
+	}
+	if i.Disable && len(i.Static) == 0 {
+		return
 	}
 
 	if len(i.Mark) > 0 {
@@ -62,21 +107,39 @@ func (i IPInfoOptions) CreateProvider() (info netx.IPInfoProvider) {
 type JetStreamManifest struct {
 	Stream    jetstream.StreamConfig              `yaml:"stream"`
 	Consumers map[string]jetstream.ConsumerConfig `yaml:"consumers"`
+	Quota     util.Size                           `yaml:"quota,omitempty"` // Storage quota, applied as the maximum bytes of the stream
 }
 
-func (j *JetStreamManifest) Init(ctx context.Context, name string, js jetstream.JetStream) error {
+// Returns the configuration applied for the stream declared under the name, with the defaults filled in.
+func (j JetStreamManifest) normalized(name string, quota util.Size) JetStreamManifest {
 	if j.Stream.Name == "" {
 		j.Stream.Name = name
 	}
-	s, err := js.CreateOrUpdateStream(ctx, j.Stream)
-	if err != nil {
-		return err
+	if j.Quota > 0 {
+		quota = j.Quota
 	}
-	xlog.InfoC(ctx).Str("stream", j.Stream.Name).Msg("Stream created")
+	if quota > 0 && j.Stream.MaxBytes <= 0 {
+		j.Stream.MaxBytes = int64(quota)
+	}
+	consumers := make(map[string]jetstream.ConsumerConfig, len(j.Consumers))
 	for name, c := range j.Consumers {
 		if c.Durable == "" {
 			c.Durable = name
 		}
+		consumers[name] = c
+	}
+	j.Consumers = consumers
+	return j
+}
+
+func (j *JetStreamManifest) Init(ctx context.Context, name string, js jetstream.JetStream, quota util.Size) error {
+	*j = j.normalized(name, quota)
+	s, err := js.CreateOrUpdateStream(ctx, j.Stream)
+	if err != nil {
+		return err
+	}
+	xlog.InfoC(ctx).Str("stream", j.Stream.Name).Msg("Stream created")
+	for _, c := range j.Consumers {
 		_, err := s.CreateOrUpdateConsumer(ctx, c)
 		if err != nil {
 			return err
@@ -87,14 +150,18 @@ func (j *JetStreamManifest) Init(ctx context.Context, name string, js jetstream.
 }
 
 type JetManifest struct {
-	Streams map[string]JetStreamManifest           `yaml:"streams,omitempty"`
-	KV      map[string]jetstream.KeyValueConfig    `yaml:"kv,omitempty"`
-	Obj     map[string]jetstream.ObjectStoreConfig `yaml:"obj,omitempty"`
+	Streams    map[string]JetStreamManifest           `yaml:"streams,omitempty"`
+	KV         map[string]jetstream.KeyValueConfig    `yaml:"kv,omitempty"`
+	Obj        map[string]jetstream.ObjectStoreConfig `yaml:"obj,omitempty"`
+	Quota      util.Size                              `yaml:"quota,omitempty"`       // Default storage quota of the streams without one
+	EventQuota util.Size                              `yaml:"event_quota,omitempty"` // Storage quota of the event stream, the oldest events are discarded past it
+	QuotaAlert float64                                `yaml:"quota_alert,omitempty"` // Usage ratio of a quota or limit past which a warning is logged, defaults to 0.8
+	Clock      enats.ClockOptions                     `yaml:"clock,omitempty"`       // Reference clock of the scheduler
 }
 
 func (j *JetManifest) Init(ctx context.Context, js jetstream.JetStream) error {
 	for name, s := range j.Streams {
-		if err := s.Init(ctx, name, js); err != nil {
+		if err := s.Init(ctx, name, js, j.Quota); err != nil {
 			return err
 		}
 	}
@@ -121,6 +188,13 @@ func (j *JetManifest) Init(ctx context.Context, js jetstream.JetStream) error {
 	return nil
 }
 
+var tenantNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// TenantManifest declares a tenant, whose runners and streams live in an isolated NATS account.
+type TenantManifest struct {
+	Jet JetManifest `yaml:"jet,omitempty"` // JetStream configuration of the tenant
+}
+
 type HostsLine struct {
 	Hostname string
 	IP       string
@@ -139,24 +213,101 @@ func (h *HostsLine) UnmarshalYAML(node *yaml.Node) error {
 }
 
 type Manifest struct {
-	Root         string                                   `yaml:"root,omitempty"`          // Root directory
-	ServiceRoot  string                                   `yaml:"service_root,omitempty"`  // Service root directory
-	Services     util.OrderedMap[string, service.Service] `yaml:"services,omitempty"`      // Services
-	Server       map[string]*Server                       `yaml:"server,omitempty"`        // Virtual hosts
-	IPInfo       IPInfoOptions                            `yaml:"ipinfo,omitempty"`        // IP information provider
-	Env          map[string]string                        `yaml:"env,omitempty"`           // Environment variables
-	Runners      map[string]*Runner                       `yaml:"runners,omitempty"`       // Runners
-	Jet          JetManifest                              `yaml:"jet,omitempty"`           // JetStream configuration
-	Hosts        []HostsLine                              `yaml:"hosts,omitempty"`         // Hostname to IP mapping
-	CustomErrors string                                   `yaml:"custom_errors,omitempty"` // Path to custom error pages
+	Root          string                                   `yaml:"root,omitempty"`           // Root directory
+	ServiceRoot   string                                   `yaml:"service_root,omitempty"`   // Service root directory
+	Services      util.OrderedMap[string, service.Service] `yaml:"services,omitempty"`       // Services
+	Server        map[string]*Server                       `yaml:"server,omitempty"`         // Virtual hosts
+	IPInfo        IPInfoOptions                            `yaml:"ipinfo,omitempty"`         // IP information provider
+	ClientIP      []netx.TrustedHeader                     `yaml:"client_ip,omitempty"`      // Headers trusted for the address of the clients, in order, defaults to X-Forwarded-For from local proxies and CF-Connecting-IP from Cloudflare
+	Env           map[string]string                        `yaml:"env,omitempty"`            // Environment variables
+	Runners       map[string]*Runner                       `yaml:"runners,omitempty"`        // Runners
+	Jet           JetManifest                              `yaml:"jet,omitempty"`            // JetStream configuration
+	Hosts         []HostsLine                              `yaml:"hosts,omitempty"`          // Hostname to IP mapping
+	CustomErrors  string                                   `yaml:"custom_errors,omitempty"`  // Path to custom error pages
+	Readiness     ReadinessOptions                         `yaml:"readiness,omitempty"`      // Startup readiness gate
+	Shutdown      ShutdownOptions                          `yaml:"shutdown,omitempty"`       // Shutdown phases
+	RequestLog    xlog.RequestLogOptions                   `yaml:"request_log,omitempty"`    // Request data included in the logs
+	LogSampling   xlog.SamplingOptions                     `yaml:"log_sampling,omitempty"`   // Sampling of verbose logs per domain
+	SlowRequest   util.Duration                            `yaml:"slow_request,omitempty"`   // Requests taking longer are logged at warning level, disabled if zero
+	PublishLimit  enats.PublishLimits                      `yaml:"publish_limit,omitempty"`  // Publish rate limits per topic pattern
+	Tenants       map[string]TenantManifest                `yaml:"tenants,omitempty"`        // Tenants isolated in their own NATS account
+	PeerWeights   xpost.PeerWeights                        `yaml:"peer_weights,omitempty"`   // Ranking of the peers when steering requests to them
+	Heartbeat     xpost.HeartbeatOptions                   `yaml:"heartbeat,omitempty"`      // Encoding of the heartbeats published to the peers
+	Lint          LintOptions                              `yaml:"lint,omitempty"`           // Rules and findings of the linter to leave out
+	RemoteRefresh util.Duration                            `yaml:"remote_refresh,omitempty"` // Interval at which a manifest loaded from a remote source is pulled again, defaults to 1m
+	Deploy        DeployOptions                            `yaml:"deploy,omitempty"`         // Continuous deployment from the repository of the root
+	Webhooks      map[string]*WebhookOptions               `yaml:"webhooks,omitempty"`       // Webhooks triggering actions, served by routing to webhook <name>
+	Audit         AuditOptions                             `yaml:"audit,omitempty"`          // Destinations of the audit trail of the API
+
+	values any // Plain values of the rendered document, see manifestValues
+
+	errorTemplates *template.Template // Custom error pages, parsed by prepare
+
+	imports *lyml.ImportTracker // Files read to render the manifest
+
+	literal map[string]string // Secrets written as is in the manifest file by location, set by LintManifest
 }
 
-func LoadManifest(manifestPath string) (*Manifest, error) {
+// Returns the keys of the server map in a stable order.
+func (m *Manifest) ServerKeys() []string {
+	keys := lo.Keys(m.Server)
+	slices.Sort(keys)
+	return keys
+}
+
+// Verifies that the services the routes of the servers and runners forward to exist.
+func (m *Manifest) checkServiceRefs() error {
+	var errs []error
+	check := func(owner string, mux *vhttp.HandleMux) {
+		vhttp.Walk(mux, func(location []string, h vhttp.Handler) {
+			var name string
+			switch h := h.(type) {
+			case *vhttp.HandleService:
+				name = h.Name()
+			case vhttp.HandleService:
+				name = h.Name()
+			default:
+				return
+			}
+			if _, ok := m.Services.Get(name); ok {
+				return
+			}
+			where := owner
+			if path := lo.Compact(location); len(path) != 0 {
+				where += ", route " + strings.Join(path, " > ")
+			}
+			errs = append(errs, fmt.Errorf("%s: unknown service %q", where, name))
+		})
+	}
+	for _, key := range m.ServerKeys() {
+		check(fmt.Sprintf("server %q", key), &m.Server[key].Router)
+	}
+	runners := lo.Keys(m.Runners)
+	slices.Sort(runners)
+	for _, name := range runners {
+		check(fmt.Sprintf("runner %q", name), &m.Runners[name].Route)
+	}
+	return errors.Join(errs...)
+}
+
+// ParseManifest reads and decodes the manifest without preparing it or applying any of its side effects.
+func ParseManifest(manifestPath string) (*Manifest, error) {
 	// Read the manifest
 	var manifest Manifest
-	if err := lyml.Load(manifestPath, &manifest); err != nil {
+	var source *yaml.Node
+	imports := &lyml.ImportTracker{}
+	if err := lyml.LoadContext(lyml.WithImportTracker(context.Background(), imports), manifestPath, &source); err != nil {
 		return nil, err
 	}
+	values, err := manifestValues(source)
+	if err != nil {
+		return nil, err
+	}
+	if err := source.Decode(&manifest); err != nil {
+		return nil, err
+	}
+	manifest.values = values
+	manifest.imports = imports
 
 	// Prepare it
 	if manifest.Root == "" {
@@ -173,6 +324,14 @@ func LoadManifest(manifestPath string) (*Manifest, error) {
 	} else {
 		manifest.ServiceRoot = filepath.Clean(manifest.ServiceRoot)
 	}
+	return &manifest, nil
+}
+
+func LoadManifest(manifestPath string) (*Manifest, error) {
+	manifest, err := ParseManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
 
 	// Set hosts
 	mapping := hosts.Mapping{}
@@ -189,6 +348,14 @@ func LoadManifest(manifestPath string) (*Manifest, error) {
 	}
 	os.Setenv("PM3_ROOT", manifest.Root)
 
+	if err := manifest.prepare(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Prepares the services and validates the cross references of the manifest.
+func (manifest *Manifest) prepare() error {
 	for _, tup := range manifest.Services {
 		name, s := tup.A, tup.B
 		err := s.Prepare(service.Options{
@@ -197,17 +364,91 @@ func LoadManifest(manifestPath string) (*Manifest, error) {
 			Logger:      xlog.NewDomain(name),
 		})
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
-	for name, sv := range manifest.Server {
-		for _, str := range strings.Split(name, ",") {
-			name = strings.TrimSpace(str)
+	definedBy := make(map[string]string)
+	for _, key := range manifest.ServerKeys() {
+		sv := manifest.Server[key]
+		for _, str := range strings.Split(key, ",") {
+			name := strings.TrimSpace(str)
 			if name == "" {
 				continue
 			}
+			if err := hosts.ValidatePattern(name); err != nil {
+				return err
+			}
+			if prev, ok := definedBy[strings.ToLower(name)]; ok {
+				xlog.Warn().Str("host", name).Str("first", prev).Str("second", key).Msg("Host defined by multiple servers, routes will be tried in order")
+			} else {
+				definedBy[strings.ToLower(name)] = key
+			}
 			sv.Hostnames = append(sv.Hostnames, name)
 		}
 	}
-	return &manifest, nil
+	if err := manifest.checkServiceRefs(); err != nil {
+		return err
+	}
+	for _, name := range manifest.Readiness.Critical {
+		if _, ok := manifest.Services.Get(name); !ok {
+			return fmt.Errorf("readiness: unknown critical service %q", name)
+		}
+	}
+	for name, hook := range manifest.Webhooks {
+		if err := hook.prepare(manifest); err != nil {
+			return fmt.Errorf("webhook %q: %w", name, err)
+		}
+	}
+	if _, err := netx.NewProxyRules(manifest.ClientIP); err != nil {
+		return fmt.Errorf("client_ip: %w", err)
+	}
+	if err := manifest.LogSampling.Validate(); err != nil {
+		return fmt.Errorf("invalid log sampling: %w", err)
+	}
+	if errs := manifest.CustomErrors; errs != "" {
+		if !filepath.IsAbs(errs) {
+			errs = filepath.Join(manifest.Root, errs)
+		}
+		tmp, err := vhttp.ParseErrorTemplates(os.DirFS(errs), "")
+		if err != nil {
+			return fmt.Errorf("failed to load custom error pages: %w", err)
+		}
+		manifest.errorTemplates = tmp
+	}
+	if err := manifest.IPInfo.Static.Validate(); err != nil {
+		return fmt.Errorf("ipinfo: static: %w", err)
+	}
+	if a := manifest.Jet.QuotaAlert; a < 0 || a > 1 {
+		return fmt.Errorf("jet: quota_alert must be between 0 and 1")
+	}
+	for name, tenant := range manifest.Tenants {
+		if !tenantNameRegex.MatchString(name) {
+			return fmt.Errorf("tenant %q: name must be alphanumeric", name)
+		}
+		if a := tenant.Jet.QuotaAlert; a < 0 || a > 1 {
+			return fmt.Errorf("tenant %q: jet: quota_alert must be between 0 and 1", name)
+		}
+	}
+	for name, runner := range manifest.Runners {
+		if runner.Tenant != "" {
+			if _, ok := manifest.Tenants[runner.Tenant]; !ok {
+				return fmt.Errorf("runner %q: unknown tenant %q", name, runner.Tenant)
+			}
+		}
+		if runner.MaxConcurrent < 0 {
+			return fmt.Errorf("runner %q: max_concurrent must be non-negative", name)
+		}
+		if err := runner.Schema.Request.Resolve(manifest.Root); err != nil {
+			return fmt.Errorf("runner %q: request schema: %w", name, err)
+		}
+		if err := runner.Schema.Response.Resolve(manifest.Root); err != nil {
+			return fmt.Errorf("runner %q: response schema: %w", name, err)
+		}
+		for _, pattern := range runner.Nodes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("runner %q: invalid node pattern %q", name, pattern)
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"get.pme.sh/pmesh/service"
+
+	atomicfile "github.com/natefinch/atomic"
+	"gopkg.in/yaml.v3"
+)
+
+// ImportServices adds the exported service definitions to the services of the manifest file,
+// returning their names. The definitions are validated first, and existing services with the
+// same name are only replaced if replace is set.
+func ImportServices(manifestPath string, data []byte, replace bool) (names []string, err error) {
+	// Validate the definitions against the manifest's default service root.
+	imported, err := service.Import(data, filepath.Dir(manifestPath))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse both documents, the exported nodes are copied as is to keep the secret references.
+	var src yaml.Node
+	if err = yaml.Unmarshal(data, &src); err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode || len(src.Content) == 0 || src.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("manifest and definitions must be mappings")
+	}
+
+	// Find or create the services mapping.
+	var services *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "services" {
+			services = root.Content[i+1]
+			break
+		}
+	}
+	if services == nil {
+		services = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "services"}, services)
+	} else if services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("services of the manifest is not a mapping")
+	}
+
+	// Merge the definitions.
+	defs := src.Content[0]
+	for i := 0; i+1 < len(defs.Content); i += 2 {
+		k, v := defs.Content[i], defs.Content[i+1]
+		if _, ok := imported.Get(k.Value); !ok {
+			continue
+		}
+		found := false
+		for j := 0; j+1 < len(services.Content); j += 2 {
+			if services.Content[j].Value == k.Value {
+				if !replace {
+					return nil, fmt.Errorf("service %q already exists in the manifest", k.Value)
+				}
+				services.Content[j+1] = v
+				found = true
+				break
+			}
+		}
+		if !found {
+			services.Content = append(services.Content, k, v)
+		}
+		names = append(names, k.Value)
+	}
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err = enc.Close(); err != nil {
+		return nil, err
+	}
+	return names, atomicfile.WriteFile(manifestPath, buf)
+}
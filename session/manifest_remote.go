@@ -0,0 +1,286 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/lyml"
+	"get.pme.sh/pmesh/revision"
+	"get.pme.sh/pmesh/xlog"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoteManifest is a manifest pulled from a remote source into a local copy, either:
+//
+//	https://example.com/pm3.yml                    a single document served over HTTP
+//	git+https://github.com/org/repo.git            the pm3.yml of the default branch of a git repository
+//	git+ssh://git@host/repo.git#v2:deploy/pm3.yml  a file at a reference of a git repository
+//
+// Imports are resolved against the local copy. The git sources carry them along, the HTTP sources fetch
+// them relative to the URL of the document importing them.
+type RemoteManifest struct {
+	Source string // The source as given by the user
+	URL    string // URL of the document or the repository
+	Git    bool   // Whether the source is a git repository
+	Ref    string // Reference of the repository, default = HEAD
+	Path   string // Path of the manifest within the repository
+}
+
+// ParseRemoteManifest parses the source of a remote manifest, returns false if it is a local path.
+func ParseRemoteManifest(source string) (r RemoteManifest, ok bool) {
+	r.Source = source
+	if url, ok := strings.CutPrefix(source, "git+"); ok {
+		r.Git = true
+		r.URL, r.Ref, _ = strings.Cut(url, "#")
+		r.Ref, r.Path, _ = strings.Cut(r.Ref, ":")
+		if r.Path == "" {
+			r.Path = "pm3.yml"
+		}
+		return r, true
+	}
+	if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+		r.URL = source
+		return r, true
+	}
+	return r, false
+}
+
+// Dir returns the directory holding the local copy.
+func (r RemoteManifest) Dir() string {
+	hash := sha256.Sum256([]byte(r.Source))
+	return config.ManifestDir.File(hex.EncodeToString(hash[:8]))
+}
+
+// LocalPath returns the path of the local copy of the manifest.
+func (r RemoteManifest) LocalPath() string {
+	if r.Git {
+		return filepath.Join(r.Dir(), filepath.FromSlash(r.Path))
+	}
+	name := path.Base(strings.SplitN(r.URL, "?", 2)[0])
+	if !strings.HasSuffix(name, ".yml") && !strings.HasSuffix(name, ".yaml") {
+		name = "pm3.yml"
+	}
+	return filepath.Join(r.Dir(), name)
+}
+
+// Pull updates the local copy, returns true if it changed.
+func (r RemoteManifest) Pull(ctx context.Context) (changed bool, err error) {
+	if r.Git {
+		return revision.Checkout(ctx, r.URL, r.Ref, r.Dir())
+	}
+	seen := map[string]bool{r.URL: true}
+	return r.pullDocument(ctx, r.URL, r.LocalPath(), seen)
+}
+
+// An import of a document pulled over HTTP.
+type remoteImport struct {
+	URL   string // Resolved against the URL of the importing document
+	Local string // Resolved against the local copy of the importing document
+}
+
+// Returns the imports of the document served at the URL. Only the literal relative paths can be
+// followed over HTTP, the globs and the paths leaving the directory of the manifest are rejected.
+func (r RemoteManifest) remoteImports(src, local string, data []byte) (imports []remoteImport, err error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", src, err)
+	}
+	base, err := url.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, imp := range manifestImports(&doc) {
+		if strings.ContainsAny(imp, "*?[\\") || path.IsAbs(imp) || filepath.IsAbs(imp) {
+			return nil, fmt.Errorf("%s: cannot import %q over HTTP, only relative paths without globs are supported", src, imp)
+		}
+		dst := filepath.Join(filepath.Dir(local), filepath.FromSlash(imp))
+		if rel, err := filepath.Rel(r.Dir(), dst); err != nil || !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("%s: cannot import %q over HTTP, it is outside of the directory of the manifest", src, imp)
+		}
+		imports = append(imports, remoteImport{URL: base.ResolveReference(&url.URL{Path: imp}).String(), Local: dst})
+	}
+	return
+}
+
+// Fetches the document served at the URL into the local path and then its imports, returns true if
+// any of them changed. A document with imports that cannot be followed is not written.
+func (r RemoteManifest) pullDocument(ctx context.Context, src, local string, seen map[string]bool) (changed bool, err error) {
+	changed, err = fetchDocument(ctx, src, local, func(data []byte) error {
+		_, err := r.remoteImports(src, local, data)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(local)
+	if err != nil {
+		return false, err
+	}
+	imports, err := r.remoteImports(src, local, data)
+	if err != nil {
+		return false, err
+	}
+	for _, imp := range imports {
+		if seen[imp.URL] {
+			continue
+		}
+		seen[imp.URL] = true
+		if len(seen) > lyml.DefaultMaxImportFiles {
+			return false, fmt.Errorf("%w: %s imports more than %d files", lyml.ErrImportLimit, r.URL, lyml.DefaultMaxImportFiles)
+		}
+		imported, err := r.pullDocument(ctx, imp.URL, imp.Local, seen)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || imported
+	}
+	return
+}
+
+// Returns the paths of the $import directives of a document.
+func manifestImports(node *yaml.Node) (paths []string) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if k.Kind != yaml.ScalarNode || (k.Value != "$import" && k.Value != "$import!") {
+				continue
+			}
+			switch v.Kind {
+			case yaml.ScalarNode:
+				paths = append(paths, v.Value)
+			case yaml.SequenceNode:
+				for _, p := range v.Content {
+					paths = append(paths, p.Value)
+				}
+			}
+		}
+	}
+	for _, c := range node.Content {
+		paths = append(paths, manifestImports(c)...)
+	}
+	return
+}
+
+// Fetches the document at the URL into the local path, revalidating the previous copy with its ETag.
+// The new copy is only written if it passes the check, returns true if it changed.
+func fetchDocument(ctx context.Context, src, local string, check func([]byte) error) (changed bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return false, err
+	}
+	etagFile := filepath.Join(filepath.Dir(local), "."+filepath.Base(local)+".etag")
+	if _, err := os.Stat(local); err == nil {
+		if etag, err := os.ReadFile(etagFile); err == nil {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to fetch %s: %s", src, res.Status)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return false, err
+	}
+	if prev, err := os.ReadFile(local); err == nil && bytes.Equal(prev, data) {
+		return false, nil
+	}
+	if err := check(data); err != nil {
+		return false, err
+	}
+
+	// Write the new copy atomically so that a concurrent reload never sees a partial document.
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return false, err
+	}
+	tmp := local + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, local); err != nil {
+		return false, err
+	}
+	if etag := res.Header.Get("ETag"); etag != "" {
+		os.WriteFile(etagFile, []byte(etag), 0644)
+	} else {
+		os.Remove(etagFile)
+	}
+	return true, nil
+}
+
+// Remote manifests pulled by GetManifestPathFromArgs, keyed by their local path.
+var remoteManifests sync.Map // string -> RemoteManifest
+
+// Pulls the remote manifest, falling back to the local copy of a previous pull if it fails.
+func pullRemoteManifest(r RemoteManifest) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	local := r.LocalPath()
+	if _, err := r.Pull(ctx); err != nil {
+		if _, serr := os.Stat(local); serr == nil {
+			xlog.Warn().Err(err).Str("source", r.Source).Msg("Failed to pull the manifest, using the local copy")
+		} else {
+			xlog.Err(err).Str("source", r.Source).Msg("Failed to pull the manifest")
+		}
+	}
+	remoteManifests.Store(local, r)
+	return local
+}
+
+// Pulls the remote manifest periodically, reloading it when it changes.
+func (s *Session) refreshRemoteManifest(r RemoteManifest) {
+	for {
+		interval := time.Duration(s.Manifest().RemoteRefresh)
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		select {
+		case <-s.Context.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		ctx, cancel := context.WithTimeout(s.Context, time.Minute)
+		changed, err := r.Pull(ctx)
+		cancel()
+		if err != nil {
+			xlog.Warn().Err(err).Str("source", r.Source).Msg("Failed to pull the manifest")
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		xlog.Info().Str("source", r.Source).Msg("Manifest changed, reloading")
+		entry := AuditEntry{Time: time.Now(), Identity: "remote", Action: "reload", Status: http.StatusOK}
+		if err := s.Reload(false); err != nil {
+			entry.Status = http.StatusInternalServerError
+			xlog.Err(err).Msg("Failed to reload the manifest")
+		} else {
+			xlog.Info().Msg("Manifest reloaded")
+		}
+		s.Audit(entry)
+	}
+}
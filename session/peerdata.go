@@ -0,0 +1,75 @@
+package session
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/xlog"
+	"get.pme.sh/pmesh/xpost"
+)
+
+var ErrPeerNotFound = errors.New("peer not found")
+
+// PeerData is the data advertised by a peer.
+type PeerData struct {
+	MachineID string         `json:"machine_id"`
+	Host      string         `json:"host"`
+	UD        map[string]any `json:"ud"`                 // User data, set by the operator
+	SD        map[string]any `json:"sd"`                 // System data, set by pmesh
+	Conflict  string         `json:"conflict,omitempty"` // Set if another node advertises the same machine ID
+}
+
+// PeerUDUpdate modifies the user data of the local node, the fields unset are removed after the fields
+// set are applied.
+type PeerUDUpdate struct {
+	Set   map[string]any `json:"set,omitempty"`   // Fields to add or replace
+	Unset []string       `json:"unset,omitempty"` // Fields to remove
+}
+
+// UpdatePeerUD updates the user data of the local node and advertises it to the peers without waiting
+// for the next heartbeat. The change is saved to the configuration so that it survives a restart.
+func (s *Session) UpdatePeerUD(u PeerUDUpdate) (map[string]any, error) {
+	var ud map[string]any
+	err := config.Update(func(c *config.Config) error {
+		ud = maps.Clone(c.PeerUD)
+		if ud == nil {
+			ud = map[string]any{}
+		}
+		maps.Copy(ud, u.Set)
+		for _, k := range u.Unset {
+			delete(ud, k)
+		}
+		if err := xpost.ValidateUD(ud); err != nil {
+			return err
+		}
+		c.PeerUD = ud
+		return nil
+	})
+	if err == nil {
+		err = s.Peerlist.SetUD(ud)
+	}
+	if err != nil {
+		return nil, err
+	}
+	xlog.Info().Interface("ud", ud).Msg("Peer user data updated")
+	return ud, nil
+}
+
+func init() {
+	Grant(config.AccessViewer, "/peers/data/{peer}")
+	Grant(config.AccessOperator, "/peers/ud")
+
+	Match("/peers/data/{peer}", func(session *Session, r *http.Request, _ struct{}) (res PeerData, err error) {
+		peer := session.Peerlist.Find(r.PathValue("peer"))
+		if peer == nil {
+			return res, ErrPeerNotFound
+		}
+		res = PeerData{MachineID: peer.MachineID, Host: peer.Host, UD: peer.UD, SD: peer.SD, Conflict: peer.Conflict}
+		return
+	})
+	MatchAudited("peer.ud", "/peers/ud", func(session *Session, r *http.Request, u PeerUDUpdate) (map[string]any, error) {
+		return session.UpdatePeerUD(u)
+	})
+}
@@ -0,0 +1,96 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/autonats"
+	"get.pme.sh/pmesh/enats"
+	"get.pme.sh/pmesh/xlog"
+)
+
+const raftCheckInterval = 2 * time.Second
+
+type RaftStatus struct {
+	autonats.RaftState
+	Available        bool      `json:"available"`                    // Whether NATS is available.
+	TransientRetries uint64    `json:"transient_retries"`            // Operations retried after a transient JetStream failure.
+	LeaderChanges    uint64    `json:"leader_changes"`               // Meta leader changes observed since startup.
+	LastLeaderChange time.Time `json:"last_leader_change,omitempty"` // Time of the last meta leader change.
+}
+
+type raftTracker struct {
+	mu         sync.Mutex
+	leader     string
+	seen       bool
+	changes    uint64
+	lastChange time.Time
+}
+
+// Records the observed meta leader, returns the previous one and whether it changed.
+func (t *raftTracker) observe(leader string) (prev string, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev = t.leader
+	if t.seen && prev == leader {
+		return
+	}
+	if t.seen {
+		t.changes++
+	}
+	t.leader, t.seen = leader, true
+	t.lastChange = time.Now()
+	return prev, true
+}
+
+func (s *Session) GetRaftStatus() (st RaftStatus, err error) {
+	st.Available = s.Nats.Available()
+	st.TransientRetries = enats.TransientRetries()
+	s.raft.mu.Lock()
+	st.LeaderChanges, st.LastLeaderChange = s.raft.changes, s.raft.lastChange
+	s.raft.mu.Unlock()
+	if sv := s.Nats.Server; sv != nil {
+		st.RaftState, err = sv.RaftState()
+	}
+	return
+}
+
+// Follows the meta leader of the cluster, logging the elections and the leaderless windows.
+func (s *Session) watchRaft() {
+	sv := s.Nats.Server
+	if sv == nil {
+		return
+	}
+	logger := xlog.NewDomain("raft")
+	var leaderless time.Time
+
+	ticker := time.NewTicker(raftCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+		}
+		st, err := sv.RaftState()
+		if err != nil || !st.Clustered {
+			continue
+		}
+		prev, changed := s.raft.observe(st.MetaLeader)
+		if !changed {
+			continue
+		}
+		switch {
+		case st.MetaLeader == "":
+			leaderless = time.Now()
+			logger.Warn().Str("previous", prev).Msg("JetStream meta leader lost, waiting for an election")
+		case !leaderless.IsZero():
+			logger.Info().Str("leader", st.MetaLeader).Dur("leaderless", time.Since(leaderless)).Msg("JetStream meta leader elected")
+			leaderless = time.Time{}
+		case prev != "":
+			logger.Info().Str("leader", st.MetaLeader).Str("previous", prev).Msg("JetStream meta leader changed")
+		default:
+			logger.Debug().Str("leader", st.MetaLeader).Msg("JetStream meta leader")
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/lyml"
+	"get.pme.sh/pmesh/xlog"
+)
+
+var errReloadSuperseded = errors.New("reload superseded by a newer one")
+
+// ReloadStatus reports the progress of the manifest reloads.
+type ReloadStatus struct {
+	Running   bool      `json:"running"`
+	Step      string    `json:"step,omitempty"`  // Step of the running reload.
+	Queued    bool      `json:"queued"`          // Whether a reload is waiting for the running one.
+	Started   time.Time `json:"started"`         // Start of the running or last reload.
+	Finished  time.Time `json:"finished"`        // End of the last reload.
+	Error     string    `json:"error,omitempty"` // Error of the last reload, if any.
+	Completed int       `json:"completed"`       // Number of reloads run to completion.
+	Cancelled int       `json:"cancelled"`       // Number of reloads cancelled by a newer one.
+	Coalesced int       `json:"coalesced"`       // Number of requests merged into a queued reload.
+
+	Files       []string `json:"files,omitempty"`        // Files the last manifest loaded was rendered from, imports included.
+	ImportDepth int      `json:"import_depth,omitempty"` // Deepest level of nested imports of the last manifest loaded.
+}
+
+// A reload waiting to run, shared by all the requests coalesced into it.
+type pendingReload struct {
+	invalidate bool
+	done       chan struct{}
+	err        error
+	next       *pendingReload // Reload the result is deferred to if superseded.
+}
+
+// Wait waits for the reload, following the newer ones if superseded.
+func (p *pendingReload) Wait() error {
+	for {
+		<-p.done
+		if p.next == nil {
+			return p.err
+		}
+		p = p.next
+	}
+}
+
+type reloadTracker struct {
+	mu     sync.Mutex
+	status ReloadStatus
+	cancel context.CancelCauseFunc // Cancels the running reload.
+	queued *pendingReload
+}
+
+// Reload reloads the manifest. Requests made while a reload is running cancel it, aborting the services
+// it is still building or starting, and are coalesced into a single reload run once it stops, all of
+// them receiving its result.
+func (s *Session) Reload(invalidate bool) error {
+	t := &s.reloads
+	t.mu.Lock()
+	p := t.queued
+	if p == nil {
+		p = &pendingReload{done: make(chan struct{})}
+		t.queued = p
+	} else {
+		t.status.Coalesced++
+	}
+	p.invalidate = p.invalidate || invalidate
+	t.status.Queued = t.status.Running
+	if t.cancel != nil {
+		t.cancel(errReloadSuperseded)
+	}
+	t.mu.Unlock()
+
+	s.Lock()
+	t.mu.Lock()
+	if t.queued != p {
+		// Run by another request.
+		t.mu.Unlock()
+		s.Unlock()
+		return p.Wait()
+	}
+	t.queued = nil
+	ctx, cancel := context.WithCancelCause(s.Context)
+	t.cancel = cancel
+	t.status.Running, t.status.Queued, t.status.Step = true, false, ""
+	t.status.Started = time.Now()
+	t.mu.Unlock()
+
+	err := s.ReloadLocked(ctx, p.invalidate)
+	cancel(nil)
+
+	t.mu.Lock()
+	t.cancel = nil
+	t.status.Running, t.status.Step = false, ""
+	t.status.Finished = time.Now()
+	if errors.Is(err, errReloadSuperseded) && t.queued != nil {
+		p.next = t.queued
+		t.status.Cancelled++
+	} else {
+		p.err = err
+		t.status.Error = ""
+		if err != nil {
+			t.status.Error = err.Error()
+		}
+		t.status.Completed++
+	}
+	t.mu.Unlock()
+	s.Unlock()
+
+	close(p.done)
+	return p.Wait()
+}
+
+// ReloadStatus returns the progress of the reloads.
+func (s *Session) ReloadStatus() ReloadStatus {
+	s.reloads.mu.Lock()
+	defer s.reloads.mu.Unlock()
+	return s.reloads.status
+}
+
+// Records the files the manifest loaded by the running reload was rendered from.
+func (s *Session) setReloadImports(imports *lyml.ImportTracker) {
+	files, depth := imports.Files(), imports.Depth()
+	xlog.Debug().Int("files", len(files)).Int("depth", depth).Msg("Manifest rendered")
+	s.reloads.mu.Lock()
+	s.reloads.status.Files, s.reloads.status.ImportDepth = files, depth
+	s.reloads.mu.Unlock()
+}
+
+// Records the step of the running reload.
+func (s *Session) setReloadStep(step string) {
+	s.reloads.mu.Lock()
+	if s.reloads.status.Running {
+		s.reloads.status.Step = step
+	}
+	s.reloads.mu.Unlock()
+}
+
+// Records the step of the running reload, returning an error if it was cancelled.
+func (s *Session) reloadStep(ctx context.Context, step string) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	s.setReloadStep(step)
+	return nil
+}
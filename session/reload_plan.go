@@ -0,0 +1,152 @@
+package session
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/samber/lo"
+)
+
+// ReloadChanges lists the entries of a manifest section affected by a reload.
+type ReloadChanges struct {
+	Added     []string `json:"added,omitempty"`     // Created by the reload.
+	Removed   []string `json:"removed,omitempty"`   // Removed by the reload.
+	Changed   []string `json:"changed,omitempty"`   // Replaced or updated with a different configuration.
+	Restarted []string `json:"restarted,omitempty"` // Restarted with the same configuration.
+}
+
+// Empty returns true if the reload does not affect the section.
+func (c ReloadChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// ReloadPlan is the effect a reload would have, computed without applying it.
+type ReloadPlan struct {
+	Services ReloadChanges `json:"services"`
+	Hosts    ReloadChanges `json:"hosts"`   // Virtual hosts, by server key.
+	Runners  ReloadChanges `json:"runners"` // Runners, by topic.
+	Tenants  ReloadChanges `json:"tenants"` // Tenants, by name.
+	Streams  ReloadChanges `json:"streams"` // JetStream streams, key-value and object stores, prefixed by their kind.
+	Drift    []ConfigDrift `json:"drift"`   // Differences between the running and the new manifest.
+}
+
+// Returns the subtree of the decoded manifest at the key.
+func section(values any, key string) map[string]any {
+	m, _ := values.(map[string]any)
+	res, _ := m[key].(map[string]any)
+	return res
+}
+
+// Classifies the entries of a manifest section, keys present in both are changed if their values differ.
+func diffSection(running, disk map[string]any) (c ReloadChanges) {
+	for k, v := range disk {
+		if prev, ok := running[k]; !ok {
+			c.Added = append(c.Added, k)
+		} else if len(diffValues(nil, "", prev, v)) != 0 {
+			c.Changed = append(c.Changed, k)
+		} else {
+			c.Restarted = append(c.Restarted, k)
+		}
+	}
+	for k := range running {
+		if _, ok := disk[k]; !ok {
+			c.Removed = append(c.Removed, k)
+		}
+	}
+	for _, l := range []*[]string{&c.Added, &c.Removed, &c.Changed, &c.Restarted} {
+		slices.Sort(*l)
+	}
+	return
+}
+
+// PlanReloadLocked computes what reloading the manifest on disk would change, without applying it.
+func (s *Session) PlanReloadLocked(ctx context.Context) (plan ReloadPlan, err error) {
+	manifest := s.Manifest()
+	if manifest == nil {
+		return plan, errors.New("no manifest loaded")
+	}
+	// Validated like a reload, without the hosts file and the environment it applies.
+	next, err := ParseManifest(s.ManifestPath)
+	if err != nil {
+		return plan, fmt.Errorf("failed to load the manifest: %w", err)
+	}
+	if err = next.prepare(); err != nil {
+		return plan, err
+	}
+	running, ondisk := manifest.values, next.values
+	plan.Drift = diffValues([]ConfigDrift{}, "", running, ondisk)
+
+	// A reload restarts every service, hosts and runners are replaced in place.
+	plan.Services = diffSection(section(running, "services"), section(ondisk, "services"))
+	plan.Hosts = diffSection(section(running, "server"), section(ondisk, "server"))
+	plan.Hosts.Restarted = nil
+	plan.Runners = diffSection(section(running, "runners"), section(ondisk, "runners"))
+	plan.Runners.Restarted = nil
+	plan.Tenants = diffSection(section(running, "tenants"), section(ondisk, "tenants"))
+	plan.Tenants.Restarted = nil
+
+	// Streams are created or updated in place, never removed.
+	if !s.Nats.Available() {
+		return
+	}
+	exists := func(kind, name string) (ok bool, err error) {
+		switch kind {
+		case "stream":
+			_, err = s.Nats.Jet.Stream(ctx, name)
+		case "kv":
+			_, err = s.Nats.Jet.KeyValue(ctx, name)
+		case "obj":
+			_, err = s.Nats.Jet.ObjectStore(ctx, name)
+		}
+		if errors.Is(err, jetstream.ErrStreamNotFound) || errors.Is(err, jetstream.ErrBucketNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	// Existing stores are only changed if their configuration differs from the running one.
+	classify := func(kind, name string, same bool) error {
+		ok, err := exists(kind, name)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s %q: %w", kind, name, err)
+		}
+		if !ok {
+			plan.Streams.Added = append(plan.Streams.Added, kind+":"+name)
+		} else if !same {
+			plan.Streams.Changed = append(plan.Streams.Changed, kind+":"+name)
+		}
+		return nil
+	}
+	prev := manifest.Jet
+	for _, key := range lo.Keys(next.Jet.Streams) {
+		cfg := next.Jet.Streams[key].normalized(key, next.Jet.Quota)
+		old, ok := prev.Streams[key]
+		same := ok && reflect.DeepEqual(old.normalized(key, prev.Quota), cfg)
+		if err = classify("stream", cfg.Stream.Name, same); err != nil {
+			return
+		}
+	}
+	for _, key := range lo.Keys(next.Jet.KV) {
+		cfg := next.Jet.KV[key]
+		old, ok := prev.KV[key]
+		old.Bucket, cfg.Bucket = cmp.Or(old.Bucket, key), cmp.Or(cfg.Bucket, key)
+		if err = classify("kv", cfg.Bucket, ok && reflect.DeepEqual(old, cfg)); err != nil {
+			return
+		}
+	}
+	for _, key := range lo.Keys(next.Jet.Obj) {
+		cfg := next.Jet.Obj[key]
+		old, ok := prev.Obj[key]
+		old.Bucket, cfg.Bucket = cmp.Or(old.Bucket, key), cmp.Or(cfg.Bucket, key)
+		if err = classify("obj", cfg.Bucket, ok && reflect.DeepEqual(old, cfg)); err != nil {
+			return
+		}
+	}
+	slices.Sort(plan.Streams.Added)
+	slices.Sort(plan.Streams.Changed)
+	return
+}
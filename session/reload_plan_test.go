@@ -0,0 +1,50 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"get.pme.sh/pmesh/enats"
+)
+
+func TestPlanReloadLocked(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pm3.yml")
+	write := func(doc string) {
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("tenants:\n  a: {}\n  b: {}\n")
+	running, err := ParseManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{ManifestPath: path, Nats: &enats.Gateway{}}
+	s.manifest.Store(running)
+
+	write("tenants:\n  b: {}\n  c: {}\n")
+	plan, err := s.PlanReloadLocked(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(plan.Tenants.Added, []string{"c"}) || !slices.Equal(plan.Tenants.Removed, []string{"a"}) {
+		t.Errorf("tenants %+v, want c added and a removed", plan.Tenants)
+	}
+
+	// Rejected like a reload.
+	for _, doc := range []string{
+		"log_sampling:\n  http: { debug: 2 }\n",
+		"client_ip:\n  - header: X-Real-IP\n    proxies: [nope]\n",
+		"tenants:\n  not.alnum: {}\n",
+		"readiness:\n  critical: [missing]\n",
+	} {
+		write(doc)
+		if _, err := s.PlanReloadLocked(context.Background()); err == nil {
+			t.Errorf("%q: planned without an error", doc)
+		}
+	}
+}
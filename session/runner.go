@@ -9,14 +9,17 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"get.pme.sh/pmesh/enats"
 	"get.pme.sh/pmesh/rate"
 	"get.pme.sh/pmesh/retry"
+	"get.pme.sh/pmesh/schema"
 	"get.pme.sh/pmesh/util"
 	"get.pme.sh/pmesh/vhttp"
 	"get.pme.sh/pmesh/xlog"
@@ -67,10 +70,13 @@ func (sch *ScheduledRunner) Run(ctx context.Context, idx int, gw *enats.Gateway,
 
 	// Establish the first value of the lock
 	load := func() (revision uint64, nextRun time.Time, err error) {
-		v, e := gw.SchedulerKV.Get(ctx, lock)
-		if e == jetstream.ErrKeyNotFound {
+		get := func(ctx context.Context) (jetstream.KeyValueEntry, error) {
+			return gw.SchedulerKV.Get(ctx, lock)
+		}
+		v, e := enats.RetryTransientValue(ctx, "scheduler.get", get)
+		if errors.Is(e, jetstream.ErrKeyNotFound) {
 			gw.SchedulerKV.Create(ctx, lock, []byte{0})
-			v, e = gw.SchedulerKV.Get(ctx, lock)
+			v, e = enats.RetryTransientValue(ctx, "scheduler.get", get)
 		}
 		if e != nil {
 			err = e
@@ -97,13 +103,15 @@ func (sch *ScheduledRunner) Run(ctx context.Context, idx int, gw *enats.Gateway,
 		return t + time.Duration(float64(t)*r)
 	}
 
+	// The run is only due once past by the skew tolerance, see enats.ClockOptions.
+	skew := gw.ClockSkew(interval)
 	for {
 		revision, nextRun, err := load()
-		now := time.Now()
+		now := gw.Now(ctx)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to load scheduler state")
 			nextRun = now.Add(interval)
-		} else if nextRun.Before(now) {
+		} else if nextRun.Add(skew).Before(now) {
 			nextRun = now.Add(interval)
 			if err := xchg(nextRun, revision); err == nil {
 				err := gw.Publish(subject, payload)
@@ -115,22 +123,119 @@ func (sch *ScheduledRunner) Run(ctx context.Context, idx int, gw *enats.Gateway,
 		}
 
 		select {
-		case <-time.After(jitter(time.Until(nextRun))):
+		case <-time.After(jitter(nextRun.Add(skew).Sub(now))):
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// pauseGate holds back consumption while paused, without affecting the messages in flight.
+type pauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // Closed on resume, nil if not paused.
+}
+
+func (g *pauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		return false
+	}
+	g.resume = make(chan struct{})
+	return true
+}
+func (g *pauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		return false
+	}
+	close(g.resume)
+	g.resume = nil
+	return true
+}
+func (g *pauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// Blocks while paused.
+func (g *pauseGate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	ch := g.resume
+	g.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// RunnerSchema declares the JSON schemas messages and results must conform to, messages that do not
+// are rejected without reaching the route and are not retried.
+type RunnerSchema struct {
+	Request  *schema.Schema `yaml:"request,omitempty"`  // Schema of the payload
+	Response *schema.Schema `yaml:"response,omitempty"` // Schema of the result
+}
+
 type Runner struct {
-	Route        vhttp.HandleMux   `yaml:"route,omitempty"`          // HTTP route for the task
-	Schedule     []ScheduledRunner `yaml:"schedule,omitempty"`       // Schedule for the task
-	Rate         rate.Rate         `yaml:"rate,omitempty"`           // Rate limit for the task
-	NoDeadLetter bool              `yaml:"no_dead_letter,omitempty"` // Do not send to dead letter
-	retry.Policy `yaml:",inline"`
+	Route         vhttp.HandleMux   `yaml:"route,omitempty"`          // HTTP route for the task
+	Schedule      []ScheduledRunner `yaml:"schedule,omitempty"`       // Schedule for the task
+	Rate          rate.Rate         `yaml:"rate,omitempty"`           // Rate limit for the task
+	MaxConcurrent int               `yaml:"max_concurrent,omitempty"` // Maximum number of messages served at once by each node, unlimited if zero
+	Nodes         []string          `yaml:"nodes,omitempty"`          // Host patterns of the nodes consuming the task, all if empty
+	Tenant        string            `yaml:"tenant,omitempty"`         // Tenant whose account the task is consumed from, the default account if empty
+	NoDeadLetter  bool              `yaml:"no_dead_letter,omitempty"` // Do not send to dead letter
+	Schema        RunnerSchema      `yaml:"schema,omitempty"`         // Schemas of the payload and the result
+	retry.Policy  `yaml:",inline"`
+
+	gate      pauseGate
+	inflight  atomic.Int32
+	consuming atomic.Bool
+}
+
+// RunsOn returns true if the task is consumed by the node with the given host name.
+func (t *Runner) RunsOn(host string) bool {
+	if len(t.Nodes) == 0 {
+		return true
+	}
+	for _, pattern := range t.Nodes {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
 }
 
+// Consuming returns true if the node is subscribed to the task.
+func (t *Runner) Consuming() bool { return t.consuming.Load() }
+
+// Inflight returns the number of messages being served by the node.
+func (t *Runner) Inflight() int { return int(t.inflight.Load()) }
+
+// Returns true if the messages should be fetched one by one rather than pushed.
+func (t *Runner) throttled() bool {
+	return !t.Rate.IsZero() || t.MaxConcurrent > 0
+}
+
+// Pause stops the runner from taking new messages until resumed, the subscription and the consumer are
+// kept and the messages already being served complete normally. Returns false if it was already paused.
+func (t *Runner) Pause() bool  { return t.gate.Pause() }
+func (t *Runner) Resume() bool { return t.gate.Resume() }
+func (t *Runner) Paused() bool { return t.gate.Paused() }
+
 func (t *Runner) ServeMsg(ctx context.Context, subject string, data []byte, meta *jetstream.MsgMetadata, headers nats.Header) (res []byte, err error) {
+	t.inflight.Add(1)
+	defer t.inflight.Add(-1)
+
 	paniced := true
 	defer func() {
 		if paniced {
@@ -139,6 +244,14 @@ func (t *Runner) ServeMsg(ctx context.Context, subject string, data []byte, meta
 		}
 	}()
 
+	// Reject malformed messages before they reach the route
+	if t.Schema.Request != nil {
+		if err := t.Schema.Request.Validate(data); err != nil {
+			paniced = false
+			return nil, retry.Disable(fmt.Errorf("%w: %w", ErrInvalidPayload, err))
+		}
+	}
+
 	// Create a request representing the message
 	topic := enats.ToTopic(subject)
 	url := "http://worker/" + strings.ReplaceAll(topic, ".", "/")
@@ -178,6 +291,11 @@ func (t *Runner) ServeMsg(ctx context.Context, subject string, data []byte, meta
 		if buf.Status == 204 || buf.Status == 202 {
 			return nil, nil
 		}
+		if t.Schema.Response != nil {
+			if err := t.Schema.Response.Validate(buf.Body.Bytes()); err != nil {
+				return nil, retry.Disable(fmt.Errorf("invalid result: %w", err))
+			}
+		}
 		return buf.Body.Bytes(), nil
 	}
 	if buf.Status == 404 {
@@ -217,6 +335,17 @@ func (t *Runner) ServeCore(ctx context.Context, gw *enats.Gateway, msg *nats.Msg
 		msg.Respond(data)
 	}
 }
+
+// Stores the result of the message, riding out leader elections of the result bucket so that
+// a completed task is not redelivered.
+func storeResult(gw *enats.Gateway, meta *jetstream.MsgMetadata, data []byte) error {
+	key := fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream)
+	return enats.RetryTransient(context.Background(), "result.put", func(ctx context.Context) error {
+		_, err := gw.ResultKV.Put(ctx, key, data)
+		return err
+	})
+}
+
 func (t *Runner) ServeJetstream(ctx context.Context, gw *enats.Gateway, msg jetstream.Msg) {
 	logger := xlog.Ctx(ctx).With().Str("subject", msg.Subject()).Str("reply", msg.Reply()).Logger()
 	logger.Debug().Msg("Task received")
@@ -262,13 +391,13 @@ func (t *Runner) ServeJetstream(ctx context.Context, gw *enats.Gateway, msg jets
 		}
 
 		if !t.NoDeadLetter {
-			data, err := json.Marshal(map[string]interface{}{"error": err.Error()})
+			letter := map[string]interface{}{"error": err.Error()}
+			if errors.Is(err, ErrInvalidPayload) {
+				letter["rejected"] = true
+			}
+			data, err := json.Marshal(letter)
 			if err == nil {
-				_, err = gw.ResultKV.Put(
-					context.Background(),
-					fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream),
-					data,
-				)
+				err = storeResult(gw, meta, data)
 			}
 			if err != nil {
 				logger.Err(err).Msg("Failed to store result")
@@ -280,11 +409,7 @@ func (t *Runner) ServeJetstream(ctx context.Context, gw *enats.Gateway, msg jets
 	} else {
 		logger.Debug().Msg("Task completed")
 		if len(data) != 0 {
-			_, err := gw.ResultKV.Put(
-				context.Background(),
-				fmt.Sprintf("%s-%d", meta.Stream, meta.Sequence.Stream),
-				data,
-			)
+			err := storeResult(gw, meta, data)
 			if err != nil {
 				logger.Err(err).Msg("Failed to store result")
 				msg.Nak()
@@ -295,14 +420,40 @@ func (t *Runner) ServeJetstream(ctx context.Context, gw *enats.Gateway, msg jets
 	}
 }
 
-func consumeWrapper[T any](ctx context.Context, rate rate.Rate, fetch func() (T, error), serve func(T)) {
+func consumeWrapper[T any](ctx context.Context, gate *pauseGate, rate rate.Rate, concurrency int, fetch func() (T, error), serve func(T)) {
 	left := uint(0)
 	next := time.Time{}
 
+	// Slots of the concurrency limit, messages are not fetched while all are taken. Only a JetStream
+	// consumer leaves them to the other nodes meanwhile; the server keeps delivering the messages of a
+	// core subject to the subscribed node, where they wait in its pending buffer.
+	var slots chan struct{}
+	if concurrency > 0 {
+		slots = make(chan struct{}, concurrency)
+	}
+
 	for ctx.Err() == nil {
+		if gate.Wait(ctx) != nil {
+			return
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		release := func() {
+			if slots != nil {
+				<-slots
+			}
+		}
+
 		// If we're past the previous period, reset the counter
 		now := time.Now()
-		if next.Before(now) {
+		if rate.IsZero() {
+			left = 1
+		} else if next.Before(now) {
 			next = now.Add(rate.Period)
 			left = rate.Count
 		}
@@ -311,12 +462,17 @@ func consumeWrapper[T any](ctx context.Context, rate rate.Rate, fetch func() (T,
 		if left <= 0 {
 			// If we have no quota left, sleep until the next period
 			sleep = next
+			release()
 		} else if msg, err := fetch(); err != nil {
 			// If we have quota left, but next errors, sleep for a bit
 			sleep = now.Add(1 * time.Second)
+			release()
 		} else {
 			// Run the message handler and decrement the quota
-			go serve(msg)
+			go func() {
+				defer release()
+				serve(msg)
+			}()
 			left--
 		}
 
@@ -330,47 +486,43 @@ func consumeWrapper[T any](ctx context.Context, rate rate.Rate, fetch func() (T,
 	}
 }
 
+// The messages are pulled by consumeWrapper so that a pause stops taking them without unsubscribing, core
+// messages delivered meanwhile wait in the pending buffer of the subscription.
 func (t *Runner) ConsumeCore(ctx context.Context, gw *enats.Gateway, subj, queue string) (err error) {
-	var sub *nats.Subscription
-	if t.Rate.IsZero() {
-		sub, err = gw.QueueSubscribe(subj, queue, func(msg *nats.Msg) {
-			t.ServeCore(ctx, gw, msg)
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		sub, err = gw.QueueSubscribeSync(subj, queue)
-		if err != nil {
-			return err
-		}
-		go consumeWrapper(
-			ctx,
-			t.Rate,
-			func() (msg *nats.Msg, err error) { return sub.NextMsgWithContext(ctx) },
-			func(msg *nats.Msg) { t.ServeCore(ctx, gw, msg) },
-		)
+	sub, err := gw.QueueSubscribeSync(subj, queue)
+	if err != nil {
+		return err
 	}
 	context.AfterFunc(ctx, func() { sub.Unsubscribe() })
+	go consumeWrapper(
+		ctx,
+		&t.gate,
+		t.Rate,
+		t.MaxConcurrent,
+		func() (msg *nats.Msg, err error) { return sub.NextMsgWithContext(ctx) },
+		func(msg *nats.Msg) { t.ServeCore(ctx, gw, msg) },
+	)
 	return nil
 }
 func (t *Runner) ConsumeJetstream(ctx context.Context, gw *enats.Gateway, cns jetstream.Consumer) error {
-	if t.Rate.IsZero() {
-		consumer, err := cns.Consume(func(msg jetstream.Msg) {
-			t.ServeJetstream(ctx, gw, msg)
-		})
+	fetch := func() (jetstream.Msg, error) { return cns.Next(jetstream.FetchMaxWait(time.Minute)) }
+	if !t.throttled() {
+		// Messages pulled but not yet served when paused are redelivered after the ack wait.
+		iter, err := cns.Messages()
 		if err != nil {
 			return err
 		}
-		context.AfterFunc(ctx, consumer.Stop)
-	} else {
-		go consumeWrapper(
-			ctx,
-			t.Rate,
-			func() (jetstream.Msg, error) { return cns.Next(jetstream.FetchMaxWait(time.Minute)) },
-			func(msg jetstream.Msg) { t.ServeJetstream(ctx, gw, msg) },
-		)
+		context.AfterFunc(ctx, iter.Stop)
+		fetch = iter.Next
 	}
+	go consumeWrapper(
+		ctx,
+		&t.gate,
+		t.Rate,
+		t.MaxConcurrent,
+		fetch,
+		func(msg jetstream.Msg) { t.ServeJetstream(ctx, gw, msg) },
+	)
 	return nil
 }
 func (t *Runner) Listen(ctx context.Context, gw *enats.Gateway, topic string) (cancel context.CancelFunc, err error) {
@@ -433,6 +585,9 @@ func (t *Runner) Listen(ctx context.Context, gw *enats.Gateway, topic string) (c
 		xlog.Info().Str("subject", topic).Str("queue", queue).Str("stream", streamName).Msg("Jetstream task listening")
 	}
 
+	t.consuming.Store(true)
+	context.AfterFunc(ctx, func() { t.consuming.Store(false) })
+
 	for i, sch := range t.Schedule {
 		go sch.Run(ctx, i, gw, topic, queue)
 	}
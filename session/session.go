@@ -6,16 +6,21 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"get.pme.sh/pmesh/autonats"
 	"get.pme.sh/pmesh/concurrent"
 	"get.pme.sh/pmesh/config"
 	"get.pme.sh/pmesh/enats"
 	"get.pme.sh/pmesh/lb"
+	"get.pme.sh/pmesh/netx"
 	"get.pme.sh/pmesh/revision"
 	"get.pme.sh/pmesh/rundown"
 	"get.pme.sh/pmesh/security"
@@ -27,6 +32,7 @@ import (
 	"get.pme.sh/pmesh/xpost"
 
 	"github.com/samber/lo"
+	"github.com/shirou/gopsutil/v3/cpu"
 )
 
 type ServiceState struct {
@@ -58,15 +64,35 @@ func (s *ServiceState) Shutdown(ctx context.Context) {
 	case <-s.ctx.Done():
 		xlog.InfoC(s.ctx).Msg("Service stopped")
 	case <-ctx.Done():
-		xlog.WarnC(s.ctx).Msg("Service took too long to stop")
+		xlog.WarnC(s.ctx).Msg("Service took too long to stop, killing")
+		s.Kill()
 		s.cancel(errors.New("shutdown"))
 	}
 }
+
+// Kill kills the processes of the service, if any, without waiting for them to exit.
+func (s *ServiceState) Kill() {
+	if proc, ok := s.Instance.(service.InstanceProc); ok {
+		for _, tree := range proc.GetProcessTrees() {
+			tree.Kill()
+		}
+	}
+}
 func (s *ServiceState) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	s.Shutdown(ctx)
 }
+func (s *ServiceState) Healthy() bool {
+	if s.ctx.Err() != nil {
+		return false
+	}
+	if lb, ok := s.Instance.(service.InstanceLB); ok {
+		l := lb.GetLoadBalancer()
+		return l != nil && l.Healthy()
+	}
+	return true
+}
 func (s *ServiceState) GetLoadBalancer() (*lb.LoadBalancer, bool) {
 	if s.ctx.Err() == nil {
 		if lb, ok := s.Instance.(service.InstanceLB); ok {
@@ -84,6 +110,22 @@ func (s *ServiceState) GetProcessTrees() ([]service.ProcessTree, bool) {
 	return nil, false
 }
 
+// GetExits returns the last exits of the processes of the service, if it runs any.
+func (s *ServiceState) GetExits() ([]service.ProcessExit, bool) {
+	if proc, ok := s.Instance.(service.InstanceExits); ok {
+		return proc.GetExits(), true
+	}
+	return nil, false
+}
+
+// GetRamp returns the progress of the traffic ramp of the last rolling restart, if any.
+func (s *ServiceState) GetRamp() (service.RampProgress, bool) {
+	if ramp, ok := s.Instance.(service.InstanceRamp); ok {
+		return ramp.GetRamp()
+	}
+	return service.RampProgress{}, false
+}
+
 type Session struct {
 	ID      snowflake.ID
 	Context context.Context
@@ -97,6 +139,9 @@ type Session struct {
 	ManifestPath      string // immut
 	ServiceMap        concurrent.Map[string, *ServiceState]
 	TaskSubscriptions []context.CancelFunc
+	pausedRunners     map[string]struct{} // Runners paused through the API, kept across reloads.
+	raft              raftTracker
+	reloads           reloadTracker
 	util.TimedMutex
 }
 
@@ -111,8 +156,33 @@ func (s *Session) ResolveService(sv string) vhttp.Handler {
 	}
 	return service
 }
-func (s *Session) ResolveNats() *enats.Client {
-	return &s.Nats.Client
+func (s *Session) ResolveNats() *enats.Gateway {
+	if !s.Nats.Available() {
+		return nil
+	}
+	return s.Nats
+}
+
+func handoffPath() string {
+	return filepath.Join(config.Home(), "handoff.sock")
+}
+
+// Takes over the listeners of the running node, then waits for it to release the lock as it exits.
+func takeover() error {
+	if err := netx.RequestHandoff(handoffPath()); err != nil {
+		return fmt.Errorf("failed to take over the running node: %w", err)
+	}
+	xlog.Info().Msg("Took over the listeners, waiting for the running node to exit")
+	for {
+		if err := config.TryLock(); err == nil {
+			return nil
+		}
+		select {
+		case <-rundown.Signal:
+			return errors.New("interrupted while waiting for the running node to exit")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
 
 func New(path string) (s *Session, err error) {
@@ -122,8 +192,11 @@ func New(path string) (s *Session, err error) {
 	}
 	s.Context, s.Cancel = context.WithCancel(vhttp.WithStateResolver(context.Background(), s))
 
-	// Acquire the lock
+	// Acquire the lock, taking over from the running node if requested
 	err = config.TryLock()
+	if err != nil && *config.Takeover {
+		err = takeover()
+	}
 	if err != nil {
 		return
 	}
@@ -143,9 +216,17 @@ func New(path string) (s *Session, err error) {
 	}
 	xlog.SetLoggerLevel(level)
 	xlog.SetDefaultOutput(xlog.StderrWriter(), xlog.FileWriter("session.log"))
+
+	// A broken override leaves the node with the host-derived ID, which cloned machines share.
+	if _, err := config.ConfiguredMachineID(config.Get()); err != nil {
+		xlog.Warn().Err(err).Stringer("id", config.GetMachineID()).Msg("Using the host-derived machine ID")
+	}
 	return
 }
-func (s *Session) StartService(name string, sv service.Service, invalidate bool) (*ServiceState, error) {
+
+// StartService starts the service, replacing its running instance once started. Cancelling ctx aborts
+// the start, the build included, the instance started lives until the session ends or it is replaced.
+func (s *Session) StartService(startCtx context.Context, name string, sv service.Service, invalidate bool) (*ServiceState, error) {
 	uid := snowflake.New()
 	logger := xlog.NewDomain(name)
 	logger.UpdateContext(func(c xlog.Context) xlog.Context {
@@ -153,9 +234,16 @@ func (s *Session) StartService(name string, sv service.Service, invalidate bool)
 	})
 	ctx, cancel := context.WithCancelCause(s.Context)
 	ctx = logger.WithContext(ctx)
+	ctx = service.WithClusterLocker(ctx, s.lockBuild)
 
 	xlog.InfoC(ctx).Msg("Service starting")
+	abort := context.AfterFunc(startCtx, func() { cancel(context.Cause(startCtx)) })
 	instance, err := sv.Start(ctx, invalidate)
+	if !abort() && err == nil {
+		// Aborted as it completed.
+		instance.Stop(context.Background())
+		err = context.Cause(startCtx)
+	}
 	state := &ServiceState{
 		Instance: instance,
 		name:     name,
@@ -176,11 +264,6 @@ func (s *Session) StartService(name string, sv service.Service, invalidate bool)
 	xlog.InfoC(ctx).Msg("Service started")
 	return state, nil
 }
-func (s *Session) Reload(invalidate bool) error {
-	s.Lock()
-	defer s.Unlock()
-	return s.ReloadLocked(invalidate)
-}
 func (s *Session) StopService(match *string) int {
 	wg := &sync.WaitGroup{}
 	n := 0
@@ -211,19 +294,63 @@ func (s *Session) RestartService(match *string, invalidate bool) int {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s.StartService(name, sv, invalidate)
+			s.StartService(s.Context, name, sv, invalidate)
 		}()
 	}
 	wg.Wait()
 	return n
 }
-func (s *Session) ReloadLocked(invalidate bool) error {
+
+// RollingRestartService restarts the matching services one after the other, replacing the
+// instances of the clustered apps one at a time so that they keep serving. The other services are
+// restarted as a whole. Returns the number of services restarted.
+func (s *Session) RollingRestartService(match *string, invalidate bool) (n int, err error) {
+	manifest := s.Manifest()
+	if manifest == nil {
+		return 0, nil
+	}
+	var errs []error
+	for _, t := range manifest.Services {
+		name, sv := t.A, t.B
+		if match != nil && *match != name {
+			continue
+		}
+		n++
+		if state, ok := s.ServiceMap.Load(name); ok && state.Err() == nil {
+			if rolling, ok := state.Instance.(service.InstanceRolling); ok {
+				if _, err := rolling.RollingRestart(state.ctx, invalidate); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				}
+				continue
+			}
+		}
+		if _, err := s.StartService(s.Context, name, sv, invalidate); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// ReloadLocked reloads the manifest, stopping before it is applied if the context is cancelled. Once
+// applying it started, cancelling aborts the services still starting, their builds included, and the
+// rest of the manifest is applied before returning the cause.
+func (s *Session) ReloadLocked(ctx context.Context, invalidate bool) error {
 	// Load the manifest
+	if err := s.reloadStep(ctx, "manifest"); err != nil {
+		return err
+	}
 	manifest, err := LoadManifest(s.ManifestPath)
 	if err != nil {
 		return err
 	}
-	s.manifest.CompareAndSwap(nil, manifest)
+	s.setReloadImports(manifest.imports)
+	if err := s.reloadStep(ctx, "apply"); err != nil {
+		return err
+	}
+	// On the first load, serve external traffic unless it waits for the critical services, see awaitReady.
+	if s.manifest.CompareAndSwap(nil, manifest) && len(manifest.Readiness.Critical) == 0 {
+		s.Server.Release()
+	}
 
 	// Set revision data where relevant
 	os.Setenv("PM3_COMMIT", "")
@@ -237,30 +364,62 @@ func (s *Session) ReloadLocked(invalidate bool) error {
 
 	// Create the IP info provider
 	s.Server.SetIPInfoProvider(manifest.IPInfo.CreateProvider())
+	s.Server.SetIPInfoAsync(manifest.IPInfo.Async)
+	rules, err := netx.NewProxyRules(manifest.ClientIP)
+	if err != nil {
+		return fmt.Errorf("invalid client_ip: %w", err)
+	}
+	s.Server.SetProxyRules(rules)
+	xlog.SetRequestLogOptions(manifest.RequestLog)
+	s.Server.SetSlowRequestThreshold(manifest.SlowRequest.Duration())
+	s.Nats.SetPublishLimits(manifest.PublishLimit)
+	if err := xlog.SetSampling(manifest.LogSampling); err != nil {
+		return fmt.Errorf("invalid log sampling: %w", err)
+	}
 
 	// Load custom error pages
-	if errs := manifest.CustomErrors; errs != "" {
-		if !filepath.IsAbs(errs) {
-			errs = filepath.Join(manifest.Root, errs)
-		}
-
-		tmp, err := vhttp.ParseErrorTemplates(os.DirFS(errs), "")
-		if err != nil {
-			return fmt.Errorf("failed to load custom error pages: %w", err)
-		}
-		s.Server.SetErrorTemplates(tmp)
+	if manifest.errorTemplates != nil {
+		s.Server.SetErrorTemplates(manifest.errorTemplates)
 	}
 
 	// Create the virtual hosts
+	s.setReloadStep("hosts")
 	vhosts := []*vhttp.VirtualHost{CreateAPIHost(s)}
-	for _, sv := range manifest.Server {
-		vhosts = append(vhosts, sv.CreateVirtualHost())
+	for _, key := range manifest.ServerKeys() {
+		vhosts = append(vhosts, manifest.Server[key].CreateVirtualHost())
 	}
 	s.Server.SetHosts(vhosts...)
 
 	// Initialize the jet stream
-	if err := manifest.Jet.Init(context.Background(), s.Nats.Jet); err != nil {
-		return err
+	s.setReloadStep("jetstream")
+	if s.Nats.Available() {
+		if err := manifest.Jet.Init(context.Background(), s.Nats.Jet); err != nil {
+			return err
+		}
+		if err := s.Nats.SetEventQuota(context.Background(), int64(manifest.Jet.EventQuota)); err != nil {
+			return fmt.Errorf("failed to set the event stream quota: %w", err)
+		}
+		s.Nats.SetClock(manifest.Jet.Clock)
+		for name, tenant := range manifest.Tenants {
+			gw, err := s.Nats.Tenant(context.Background(), name)
+			if err != nil {
+				return err
+			}
+			if err := tenant.Jet.Init(context.Background(), gw.Jet); err != nil {
+				return fmt.Errorf("tenant %q: %w", name, err)
+			}
+			if err := gw.SetEventQuota(context.Background(), int64(tenant.Jet.EventQuota)); err != nil {
+				return fmt.Errorf("tenant %q: failed to set the event stream quota: %w", name, err)
+			}
+			gw.SetClock(tenant.Jet.Clock)
+		}
+		for _, name := range s.Nats.Tenants() {
+			if _, ok := manifest.Tenants[name]; !ok {
+				if err := s.Nats.RemoveTenant(context.Background(), name); err != nil {
+					xlog.Warn().Err(err).Str("tenant", name).Msg("Failed to remove the tenant")
+				}
+			}
+		}
 	}
 
 	// First we need to stop all the services that are not in the new manifest
@@ -274,20 +433,46 @@ func (s *Session) ReloadLocked(invalidate bool) error {
 	// Start all the services that are in the new manifest
 	states := make(map[string]*ServiceState)
 	manifest.Services.ForEach(func(name string, sv service.Service) {
-		if state, err := s.StartService(name, sv, invalidate); err == nil {
+		if ctx.Err() != nil {
+			return // Superseded, left to the newer reload.
+		}
+		s.setReloadStep("service " + name)
+		if state, err := s.StartService(ctx, name, sv, invalidate); err == nil {
 			states[name] = state
 		}
 	})
+	s.setReloadStep("runners")
 
 	// Stop the previous listeners
 	for _, sub := range s.TaskSubscriptions {
 		sub()
 	}
 
-	// Start the listeners
+	// Start the listeners, they all need NATS.
 	s.TaskSubscriptions = nil
+	if !s.Nats.Available() {
+		if len(manifest.Runners) != 0 {
+			xlog.Warn().Int("count", len(manifest.Runners)).Msg("NATS is not available, runners are disabled")
+		}
+		s.manifest.Store(manifest)
+		return context.Cause(ctx)
+	}
 	for subject, task := range manifest.Runners {
-		ctx, err := task.Listen(s.Context, s.Nats, subject)
+		if !task.RunsOn(config.Get().Host) {
+			xlog.Info().Str("subject", subject).Strs("nodes", task.Nodes).Msg("Task pinned to other nodes, not listening")
+			continue
+		}
+		if _, ok := s.pausedRunners[subject]; ok {
+			task.Pause()
+		}
+		gw := s.Nats
+		if task.Tenant != "" {
+			gw, err = s.Nats.Tenant(s.Context, task.Tenant)
+			if err != nil {
+				return err
+			}
+		}
+		ctx, err := task.Listen(s.Context, gw, subject)
 		if err != nil {
 			return err
 		}
@@ -324,21 +509,33 @@ func (s *Session) ReloadLocked(invalidate bool) error {
 	}
 
 	s.manifest.Store(manifest)
-	return nil
+	return context.Cause(ctx)
 }
 
 func (s *Session) Open(ctx context.Context) error {
 	xlog.Info().Stringer("id", s.ID).Msg("Session starting")
 	security.ObtainCertificate(config.Get().Secret) // Ensure the certificate is ready before starting the server
 
-	// Start the NATS gateway
+	// Start the NATS gateway, if allowed, keep serving HTTP without it.
 	if err := s.Nats.Open(ctx); err != nil {
-		return fmt.Errorf("failed to open nats: %w", err)
+		if !*config.AllowDegraded {
+			return fmt.Errorf("failed to open nats: %w", err)
+		}
+		s.Nats.Close(ctx)
+		xlog.Error().Err(err).Msg("NATS is not available, running degraded: runners, schedules and peer discovery are disabled")
 	}
 
 	// Start the peer list
 	s.Peerlist = xpost.NewPeerlist(s.Nats)
-	if err := s.Peerlist.Open(ctx); err != nil {
+	s.Peerlist.Options = func() (o xpost.HeartbeatOptions) {
+		if manifest := s.Manifest(); manifest != nil {
+			o = manifest.Heartbeat
+		}
+		return
+	}
+	if !s.Nats.Available() {
+		s.Peerlist.OpenLocal(ctx)
+	} else if err := s.Peerlist.Open(ctx); err != nil {
 		return fmt.Errorf("failed to open peer list: %w", err)
 	}
 
@@ -353,39 +550,157 @@ func (s *Session) Open(ctx context.Context) error {
 	s.Peerlist.AddSDSource(func(out map[string]any) {
 		out["commit"] = os.Getenv("PM3_COMMIT")
 		out["branch"] = os.Getenv("PM3_BRANCH")
+		if l, err := cpu.Percent(0, false); err == nil && len(l) > 0 {
+			out["load"] = l[0]
+		}
+		cordoned := s.Cordoned()
+		if cordoned {
+			out["cordoned"] = true
+		}
 		if manifest := s.Manifest(); manifest != nil {
+			// A cordoned node advertises no services so that the peers route around it.
 			var healthyServices []string
 			for _, sv := range s.Manifest().Services {
-				if svc, ok := s.ServiceMap.Load(sv.A); ok {
-					if svc.ctx.Err() != nil {
-						continue
-					}
-					if lb, ok := svc.Instance.(service.InstanceLB); ok {
-						if l := lb.GetLoadBalancer(); l != nil {
-							if l.Healthy() {
-								healthyServices = append(healthyServices, sv.A)
-							}
-						}
-					} else {
-						healthyServices = append(healthyServices, sv.A)
-					}
+				if cordoned {
+					break
+				}
+				if svc, ok := s.ServiceMap.Load(sv.A); ok && svc.Healthy() {
+					healthyServices = append(healthyServices, sv.A)
 				}
 			}
 			out["services"] = healthyServices
+
+			var runners []string
+			for name, runner := range manifest.Runners {
+				if runner.Consuming() {
+					runners = append(runners, name)
+				}
+			}
+			slices.Sort(runners)
+			out["runners"] = runners
 		}
 	})
 
-	// Start the server
+	// Start the server, holding external traffic until the manifest is loaded.
+	s.Server.Hold()
 	if err := s.Server.Listen(); err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
+	// Let the next node take over the listeners
+	if err := netx.ServeHandoff(s.Context, handoffPath(), s.Server.Listeners(), rundown.Force); errors.Is(err, errors.ErrUnsupported) {
+		xlog.Debug().Err(err).Msg("Listener handoff not supported on this platform")
+	} else if err != nil {
+		xlog.Warn().Err(err).Msg("Failed to serve the listener handoff")
+	}
+
 	// Start the services
 	if err := s.Reload(false); err != nil {
+		s.Server.Release()
 		return fmt.Errorf("failed to load manifest: %w", err)
 	}
+	go s.awaitReady()
+	go s.reloadOnSignal()
+	go s.pollRepository()
+	if r, ok := remoteManifests.Load(s.ManifestPath); ok {
+		go s.refreshRemoteManifest(r.(RemoteManifest))
+	}
+	if s.Nats.Available() {
+		go s.watchStorage()
+		go s.watchRaft()
+	}
 	return nil
 }
+
+// Reloads the manifest on SIGHUP, signals received within a second of each other trigger a single reload.
+func (s *Session) reloadOnSignal() {
+	signals := rundown.Reload()
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-signals:
+		}
+		select {
+		case <-s.Context.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		select {
+		case <-signals:
+		default:
+		}
+
+		s.reloadSecret()
+		xlog.Info().Msg("SIGHUP received, reloading the manifest")
+		entry := AuditEntry{Time: time.Now(), Identity: "signal", Action: "reload", Status: http.StatusOK}
+		if err := s.Reload(false); err != nil {
+			entry.Status = http.StatusInternalServerError
+			xlog.Err(err).Msg("Failed to reload the manifest")
+		} else {
+			xlog.Info().Msg("Manifest reloaded")
+		}
+		s.Audit(entry)
+	}
+}
+
+// Re-reads the configuration and picks up a changed secret. The connections between the nodes move to
+// the certificates of the new secret but keep trusting the previous one until the next restart, so the
+// nodes of the cluster can be switched one at a time.
+func (s *Session) reloadSecret() {
+	prev := config.Get().Secret
+	if err := config.Update(nil); err != nil {
+		xlog.Err(err).Msg("Failed to reload the configuration")
+		return
+	}
+	secret := config.Get().Secret
+	sv := s.Nats.Server
+	if secret == prev || sv == nil {
+		return
+	}
+	xlog.Info().Msg("Secret changed, rotating the certificates of the connections between the nodes")
+	go func() {
+		if err := sv.RotateTLS(s.Context, autonats.NewRotationTLSConfig(secret, prev)); err != nil && s.Context.Err() == nil {
+			xlog.Err(err).Msg("Failed to rotate the certificates")
+		}
+	}()
+}
+
+// Releases the server once the critical services are healthy or the readiness timeout expires.
+func (s *Session) awaitReady() {
+	defer s.Server.Release()
+
+	opts := s.Manifest().Readiness
+	if len(opts.Critical) == 0 {
+		return
+	}
+	timeout := time.Duration(opts.Timeout)
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(s.Context, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pending := lo.Filter(opts.Critical, func(name string, _ int) bool {
+			sv, ok := s.ServiceMap.Load(name)
+			return !ok || !sv.Healthy()
+		})
+		if len(pending) == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			if s.Context.Err() == nil {
+				xlog.Warn().Strs("services", pending).Msg("Critical services not healthy, accepting traffic anyway")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
 func (s *Session) Close() error {
 	defer s.Cancel()
 	s.ServiceMap.Range(func(name string, sv *ServiceState) bool {
@@ -396,31 +711,74 @@ func (s *Session) Close() error {
 	xlog.Info().Stringer("id", s.ID).Msg("Session ended")
 	return nil
 }
+
+// Shutdown stops the session in order: the server stops accepting new connections and drains the
+// in-flight requests, then the services are stopped, and finally NATS and the peer list are closed.
 func (s *Session) Shutdown(ctx context.Context) {
 	defer s.Close()
 
-	// Stop each service.
+	var opts ShutdownOptions
+	if manifest := s.Manifest(); manifest != nil {
+		opts = manifest.Shutdown
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	drain := opts.Drain.Or(10 * time.Second).Duration()
+	reserve := opts.Close.Or(5 * time.Second).Duration()
+	if hasDeadline {
+		remaining := time.Until(deadline)
+		reserve = min(reserve, remaining/4)
+		if opts.Drain.IsZero() {
+			drain = remaining / 3
+		}
+		drain = min(drain, remaining-reserve)
+	}
+
+	// Stop accepting and drain the in-flight requests.
+	if s.Server != nil {
+		drainCtx, cancel := context.WithTimeout(ctx, drain)
+		if err := s.Server.Shutdown(drainCtx); errors.Is(err, context.DeadlineExceeded) {
+			xlog.Warn().Stringer("grace", drain).Msg("In-flight requests did not finish in time, closing connections")
+			s.Server.Close()
+		} else if err != nil {
+			xlog.Error().Err(err).Msg("Failed to shutdown server")
+		}
+		cancel()
+	}
+
+	// Stop each service, leaving time to close the rest.
+	svcCtx, cancel := ctx, context.CancelFunc(func() {})
+	if hasDeadline {
+		svcCtx, cancel = context.WithDeadline(ctx, deadline.Add(-reserve))
+	}
 	wg := &sync.WaitGroup{}
 	s.ServiceMap.Range(func(name string, sv *ServiceState) bool {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			sv.Shutdown(ctx)
+			sv.Shutdown(svcCtx)
 		}()
 		return true
 	})
+	stopped := true
 	select {
-	case <-ctx.Done():
+	case <-svcCtx.Done():
+		stopped = false
 	case <-s.Context.Done():
 	case <-lo.Async0(func() { wg.Wait() }):
 	}
+	cancel()
 
-	// Stop the server.
-	if s.Server != nil {
-		if err := s.Server.Shutdown(ctx); err != nil {
-			xlog.Error().Err(err).Msg("Failed to shutdown server")
-		}
+	// If the services did not stop in time, kill whatever survived, including the processes the
+	// services lost track of.
+	if !stopped {
+		s.ServiceMap.Range(func(name string, sv *ServiceState) bool {
+			sv.Kill()
+			return true
+		})
+		service.KillOrphans()
 	}
+
+	// Close the cluster connections.
 	if s.Peerlist != nil {
 		if err := s.Peerlist.Close(ctx); err != nil {
 			xlog.Error().Err(err).Msg("Failed to close peer list")
@@ -433,6 +791,25 @@ func (s *Session) Shutdown(ctx context.Context) {
 	}
 }
 
+// Called once the shutdown hangs past its deadline.
+var forceExit = func() {
+	service.KillOrphans()
+	os.Exit(1)
+}
+
+// Arms the timer force exiting the shutdown once it outlasts its deadline by the grace period, nil if
+// disabled.
+func shutdownWatchdog(opts ShutdownOptions, timeout time.Duration) *time.Timer {
+	grace := opts.ForceExit.Or(10 * time.Second)
+	if !grace.IsPositive() {
+		return nil
+	}
+	return time.AfterFunc(timeout+grace.Duration(), func() {
+		xlog.Error().Stringer("timeout", timeout).Msg("Shutdown did not complete in time, exiting")
+		forceExit()
+	})
+}
+
 func OpenAndServe(manifestPath string) {
 	xlog.Info().Str("manifest", manifestPath).Str("host", config.Get().Host).Msg("Starting node")
 
@@ -443,8 +820,32 @@ func OpenAndServe(manifestPath string) {
 	}
 
 	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var opts ShutdownOptions
+		if manifest := s.Manifest(); manifest != nil {
+			opts = manifest.Shutdown
+		}
+		timeout := opts.Timeout.Or(30 * time.Second).Duration()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
+
+		// Exit regardless once the shutdown hangs past its deadline so that the node terminates in
+		// bounded time. Exit as well if signaled again.
+		if watchdog := shutdownWatchdog(opts, timeout); watchdog != nil {
+			defer watchdog.Stop()
+		}
+		go func() {
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(stop)
+			select {
+			case <-stop:
+				xlog.Warn().Msg("Signaled again during shutdown, exiting")
+				service.KillOrphans()
+				os.Exit(1)
+			case <-ctx.Done():
+			}
+		}()
+
 		s.Shutdown(ctx)
 	}()
 	if err = s.Open(context.Background()); err != nil {
@@ -465,6 +866,11 @@ func GetManifestPathFromArgs(args []string) string {
 		manifestPath = args[0]
 	}
 
+	// If the manifest is remote, pull it into its local copy
+	if r, ok := ParseRemoteManifest(manifestPath); ok {
+		return pullRemoteManifest(r)
+	}
+
 	// If the path is empty, use the current working directory
 	if manifestPath == "" {
 		manifestPath = "."
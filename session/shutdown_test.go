@@ -0,0 +1,45 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/util"
+)
+
+func TestShutdownWatchdog(t *testing.T) {
+	exited := make(chan struct{}, 1)
+	prev := forceExit
+	forceExit = func() { exited <- struct{}{} }
+	t.Cleanup(func() { forceExit = prev })
+
+	// A shutdown that never completes still exits, without any option set.
+	watchdog := shutdownWatchdog(ShutdownOptions{}, 50*time.Millisecond)
+	if watchdog == nil {
+		t.Fatal("watchdog disabled by default")
+	}
+	defer watchdog.Stop()
+	select {
+	case <-exited:
+		t.Fatal("exited before the deadline and its grace period")
+	case <-time.After(time.Second):
+	}
+	select {
+	case <-exited:
+	case <-time.After(15 * time.Second):
+		t.Fatal("stuck shutdown did not exit")
+	}
+
+	// A completed shutdown stops it.
+	watchdog = shutdownWatchdog(ShutdownOptions{ForceExit: util.Duration(10 * time.Millisecond)}, 10*time.Millisecond)
+	watchdog.Stop()
+	select {
+	case <-exited:
+		t.Fatal("exited after the shutdown completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if shutdownWatchdog(ShutdownOptions{ForceExit: util.Duration(-1)}, time.Second) != nil {
+		t.Error("negative grace period did not disable the watchdog")
+	}
+}
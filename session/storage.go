@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"get.pme.sh/pmesh/autonats"
+	"get.pme.sh/pmesh/util"
+	"get.pme.sh/pmesh/xlog"
+)
+
+const storageCheckInterval = 30 * time.Second
+
+type StreamUsage struct {
+	Bytes    uint64 `json:"bytes"`     // Bytes stored.
+	Messages uint64 `json:"messages"`  // Messages stored.
+	MaxBytes int64  `json:"max_bytes"` // Quota of the stream, unlimited if negative.
+}
+type JetStreamMetrics struct {
+	Server  *autonats.JetStreamUsage `json:"server,omitempty"` // Usage of the local server, if any.
+	Streams map[string]StreamUsage   `json:"streams"`
+}
+
+func (s *Session) GetJetStreamMetrics(ctx context.Context) (m JetStreamMetrics, err error) {
+	if sv := s.Nats.Server; sv != nil {
+		if u, err := sv.JetStreamUsage(); err == nil {
+			m.Server = &u
+		}
+	}
+	m.Streams = make(map[string]StreamUsage)
+	streams := s.Nats.Jet.ListStreams(ctx)
+	for info := range streams.Info() {
+		m.Streams[info.Config.Name] = StreamUsage{
+			Bytes:    info.State.Bytes,
+			Messages: info.State.Msgs,
+			MaxBytes: info.Config.MaxBytes,
+		}
+	}
+	err = streams.Err()
+	return
+}
+
+// Periodically warns about the streams and server limits nearing exhaustion.
+func (s *Session) watchStorage() {
+	logger := xlog.NewDomain("jetstream")
+	alerted := map[string]bool{}
+	check := func(name string, used uint64, limit int64, threshold float64) {
+		over := limit > 0 && float64(used) >= threshold*float64(limit)
+		if over && !alerted[name] {
+			logger.Warn().Str("resource", name).
+				Str("used", util.Size(used).Display()).
+				Str("limit", util.Size(limit).Display()).
+				Msg("JetStream storage nearing its limit")
+		} else if !over && alerted[name] {
+			logger.Info().Str("resource", name).Msg("JetStream storage back under the alert threshold")
+		}
+		alerted[name] = over
+	}
+
+	ticker := time.NewTicker(storageCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+		}
+		manifest := s.Manifest()
+		if manifest == nil || !s.Nats.Available() {
+			continue
+		}
+		threshold := manifest.Jet.QuotaAlert
+		if threshold <= 0 {
+			threshold = 0.8
+		}
+
+		ctx, cancel := context.WithTimeout(s.Context, storageCheckInterval)
+		m, err := s.GetJetStreamMetrics(ctx)
+		cancel()
+		if err != nil {
+			logger.Debug().Err(err).Msg("Failed to check storage usage")
+		}
+		if m.Server != nil {
+			check("server.memory", m.Server.Memory, m.Server.MaxMemory, threshold)
+			check("server.store", m.Server.Store, m.Server.MaxStore, threshold)
+		}
+		for name, st := range m.Streams {
+			check("stream."+name, st.Bytes, st.MaxBytes, threshold)
+		}
+	}
+}
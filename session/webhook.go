@@ -0,0 +1,230 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/rate"
+	"get.pme.sh/pmesh/variant"
+	"get.pme.sh/pmesh/vhttp"
+	"get.pme.sh/pmesh/xlog"
+)
+
+// WebhookOptions maps the events of a CI or git webhook to actions, the webhook is served by routing to
+// `webhook <name>` and every request must be signed with the secret.
+type WebhookOptions struct {
+	Secret      string                     `yaml:"secret"`                 // HMAC-SHA256 key the payloads are signed with
+	Signature   string                     `yaml:"signature,omitempty"`    // Header carrying the hex signature, optionally prefixed with sha256=, default = X-Hub-Signature-256
+	Event       string                     `yaml:"event,omitempty"`        // Header carrying the event name, default = X-GitHub-Event
+	Limit       rate.Rate                  `yaml:"limit,omitempty"`        // Rate of the signed requests accepted, default = 10/m
+	ClientLimit rate.Rate                  `yaml:"client_limit,omitempty"` // Rate of the requests accepted from each client IP, signed or not, default = 60/m
+	Actions     map[string][]WebhookAction `yaml:"actions"`                // Actions per event, * matches any event
+}
+
+// WebhookAction is one of:
+//
+//	reload           reloads the manifest
+//	deploy           updates the checkout to the tracked branch, see DeployOptions
+//	restart <svc>    restarts the service
+//	rebuild <svc>    rebuilds and restarts the service
+type WebhookAction struct {
+	Verb    string
+	Service string
+}
+
+func (a WebhookAction) String() string {
+	return strings.TrimSpace(a.Verb + " " + a.Service)
+}
+func (a *WebhookAction) UnmarshalText(text []byte) error {
+	a.Verb, a.Service, _ = strings.Cut(strings.TrimSpace(string(text)), " ")
+	a.Service = strings.TrimSpace(a.Service)
+	switch a.Verb {
+	case "reload", "deploy":
+		if a.Service != "" {
+			return fmt.Errorf("webhook action %q takes no argument", a.Verb)
+		}
+	case "restart", "rebuild":
+		if a.Service == "" {
+			return fmt.Errorf("webhook action %q requires a service", a.Verb)
+		}
+	default:
+		return fmt.Errorf("unknown webhook action %q", a.Verb)
+	}
+	return nil
+}
+func (a WebhookAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Validates the options, called while the manifest is prepared.
+func (w *WebhookOptions) prepare(m *Manifest) error {
+	if w.Secret == "" {
+		return fmt.Errorf("a secret is required")
+	}
+	if w.Signature == "" {
+		w.Signature = "X-Hub-Signature-256"
+	}
+	if w.Event == "" {
+		w.Event = "X-GitHub-Event"
+	}
+	if w.Limit.IsZero() {
+		w.Limit = rate.Rate{Count: 10, Period: time.Minute}
+	}
+	if w.ClientLimit.IsZero() {
+		w.ClientLimit = rate.Rate{Count: 60, Period: time.Minute}
+	}
+	for event, actions := range w.Actions {
+		for _, a := range actions {
+			if a.Service == "" {
+				continue
+			}
+			if _, ok := m.Services.Get(a.Service); !ok {
+				return fmt.Errorf("event %q: unknown service %q", event, a.Service)
+			}
+		}
+	}
+	return nil
+}
+
+// Limiters of the webhooks, kept across reloads as long as the limit does not change.
+var webhookLimiters sync.Map // string -> *rate.Limiter
+
+func webhookLimiter(name string, r rate.Rate) *rate.Limiter {
+	key := name + "@" + r.String()
+	v, ok := webhookLimiters.Load(key)
+	if !ok {
+		l := rate.LocalLimiter(rate.Options{Rate: r})
+		v, _ = webhookLimiters.LoadOrStore(key, &l)
+	}
+	return v.(*rate.Limiter)
+}
+
+// Checks the signature of the payload.
+func (w *WebhookOptions) verify(r *http.Request, body []byte) bool {
+	sig := r.Header.Get(w.Signature)
+	sig = strings.TrimPrefix(sig, "sha256=")
+	got, err := hex.DecodeString(sig)
+	if err != nil || len(got) != sha256.Size {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Runs the action, logging it to the audit log.
+func (s *Session) runWebhookAction(name string, a WebhookAction) {
+	entry := AuditEntry{Time: time.Now(), Identity: "webhook:" + name, Action: "webhook." + a.Verb, Target: a.Service, Status: http.StatusOK}
+	var err error
+	switch a.Verb {
+	case "reload":
+		err = s.Reload(false)
+	case "deploy":
+		err = s.deployLatest(s.Manifest().Deploy)
+	case "restart", "rebuild":
+		svc := a.Service
+		if s.RestartService(&svc, a.Verb == "rebuild") == 0 {
+			err = fmt.Errorf("service %q not found", svc)
+		}
+	}
+	if err != nil {
+		entry.Status = http.StatusInternalServerError
+		xlog.Err(err).Str("webhook", name).Stringer("action", a).Msg("Webhook action failed")
+	} else {
+		xlog.Info().Str("webhook", name).Stringer("action", a).Msg("Webhook action done")
+	}
+	s.Audit(entry)
+}
+
+// Maximum size of a webhook payload.
+const webhookMaxBody = 4 << 20
+
+// WebhookHandler serves a webhook declared in the manifest.
+type WebhookHandler struct {
+	Name string
+}
+
+func (h WebhookHandler) String() string {
+	return fmt.Sprintf("Webhook(%s)", h.Name)
+}
+func (h *WebhookHandler) UnmarshalInline(text string) error {
+	name, ok := strings.CutPrefix(text, "webhook ")
+	if !ok {
+		return variant.RejectMatch(h)
+	}
+	h.Name = strings.TrimSpace(name)
+	return nil
+}
+
+func (h WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) vhttp.Result {
+	s, ok := vhttp.StateResolverFromContext(r.Context()).(*Session)
+	if !ok {
+		return vhttp.Continue
+	}
+	opts, ok := s.Manifest().Webhooks[h.Name]
+	if !ok {
+		vhttp.Error(w, r, http.StatusNotFound)
+		return vhttp.Done
+	}
+	if r.Method != http.MethodPost {
+		vhttp.Error(w, r, http.StatusMethodNotAllowed)
+		return vhttp.Done
+	}
+
+	// Each client is limited before the body is read and the signature checked, so that the unsigned
+	// requests of one cannot make the node do that work at will.
+	perClient := rate.NewLimit(rate.Options{ID: "webhook:" + h.Name, Rate: opts.ClientLimit})
+	if hnd := vhttp.EnforceRateReq(r, perClient); hnd != nil {
+		hnd.ServeHTTP(w, r)
+		return vhttp.Done
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		vhttp.Error(w, r, http.StatusBadRequest)
+		return vhttp.Done
+	}
+	if !opts.verify(r, body) {
+		xlog.Warn().EmbedObject(xlog.EnhanceRequest(r)).Str("webhook", h.Name).Msg("Webhook signature mismatch")
+		vhttp.Error(w, r, http.StatusUnauthorized)
+		return vhttp.Done
+	}
+
+	// Only the signed requests are limited, so that unsigned ones cannot lock out the sender.
+	if err := webhookLimiter(h.Name, opts.Limit).Enforce(r.Context()); err != nil {
+		vhttp.Error(w, r, http.StatusTooManyRequests)
+		return vhttp.Done
+	}
+
+	event := r.Header.Get(opts.Event)
+	actions := opts.Actions[event]
+	if actions == nil {
+		actions = opts.Actions["*"]
+	}
+	if len(actions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return vhttp.Done
+	}
+
+	// Run the actions in order outside of the request, the sender is not kept waiting for a build.
+	go func() {
+		for _, a := range actions {
+			if s.Context.Err() != nil {
+				return
+			}
+			s.runWebhookAction(h.Name, a)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+	return vhttp.Done
+}
+
+func init() {
+	vhttp.Registry.Define("Webhook", func() any { return &WebhookHandler{} })
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/sha1"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -106,6 +108,32 @@ func (i ID) MachineID() uint32 {
 func (i ID) Timestamp() time.Time {
 	return time.UnixMilli(int64((uint64(i) >> TimestampShift) + uint64(EpochBegin)))
 }
+
+// Parts are the components an ID is made of.
+type Parts struct {
+	Timestamp time.Time `json:"timestamp"`  // Time the ID was generated at, in milliseconds.
+	MachineID uint32    `json:"machine_id"` // Bits of the generator's machine ID kept in the ID.
+	Sequence  uint32    `json:"sequence"`   // Sequence number within the millisecond.
+}
+
+// Decode splits the ID into its components.
+func (i ID) Decode() Parts {
+	return Parts{
+		Timestamp: i.Timestamp().UTC(),
+		MachineID: i.MachineID(),
+		Sequence:  i.Sequence(),
+	}
+}
+
+// Parse parses an ID from its decimal representation.
+func Parse(s string) (ID, error) {
+	var i ID
+	if err := i.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid snowflake id %q", s)
+	}
+	return i, nil
+}
+
 func (i ID) MarshalText() (res []byte, e error) {
 	res = make([]byte, 0, 20)
 	res = strconv.AppendUint(res, uint64(i), 10)
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"get.pme.sh/pmesh/xlog"
 )
@@ -23,12 +25,17 @@ type sublistener struct {
 	backlog chan net.Conn
 	done    chan struct{}
 	cleanup sync.RWMutex
+
+	accepted atomic.Uint64
+	dropped  atomic.Uint64
+	blocked  atomic.Int32
 }
 
 // Accept waits for and returns the next connection to the listener.
 func (sub *sublistener) Accept() (net.Conn, error) {
 	select {
 	case conn := <-sub.backlog:
+		sub.accepted.Add(1)
 		return conn, nil
 	case <-sub.done:
 		return nil, ErrListenerClosed
@@ -47,6 +54,7 @@ func (sub *sublistener) Close() error {
 	defer sub.cleanup.Unlock()
 	close(sub.backlog)
 	for conn := range sub.backlog {
+		sub.dropped.Add(1)
 		conn.Close()
 	}
 	return sub.mux.close(sub)
@@ -64,9 +72,22 @@ func (sub *sublistener) handleConn(conn net.Conn) {
 
 	select {
 	case <-sub.done:
+		sub.dropped.Add(1)
 		conn.Close()
+		return
 	case sub.backlog <- conn:
 		return
+	default:
+	}
+
+	// The backlog is full, wait for the protocol to catch up.
+	sub.blocked.Add(1)
+	defer sub.blocked.Add(-1)
+	select {
+	case <-sub.done:
+		sub.dropped.Add(1)
+		conn.Close()
+	case sub.backlog <- conn:
 	}
 }
 
@@ -77,6 +98,24 @@ type Listener struct {
 	listener     net.Listener
 	closed       bool
 	closeOnDrain bool
+	fallback     string        // Protocol serving the connections negotiating none of the others
+	handshake    time.Duration // Time given to the clients to complete the handshake, zero if unbounded
+	unrouted     atomic.Uint64
+	timedOut     atomic.Uint64
+}
+
+// SetFallback designates the protocol whose listener serves the connections that negotiate none of the
+// protocols listened to, or none at all. The default listener (*) takes precedence if there is one. With
+// neither, the clients offering protocols are rejected with a no_application_protocol alert. New
+// listeners start with the protocol given by --mux-fallback.
+func (mx *Listener) SetFallback(proto string) error {
+	mx.mu.Lock()
+	defer mx.mu.Unlock()
+	if mx.closed {
+		return ErrListenerClosed
+	}
+	mx.fallback = proto
+	return nil
 }
 
 // close is called by a sublistener to remove itself from the Listener.
@@ -124,7 +163,7 @@ func (mx *Listener) Listen(config *tls.Config, protos ...string) (net.Listener,
 		config:  config,
 		mux:     mx,
 		protos:  protos,
-		backlog: make(chan net.Conn, 8),
+		backlog: make(chan net.Conn, backlogSize()),
 		done:    make(chan struct{}),
 	}
 	for _, proto := range protos {
@@ -133,17 +172,33 @@ func (mx *Listener) Listen(config *tls.Config, protos ...string) (net.Listener,
 	return listener, nil
 }
 
-// findListener returns the sublistener for the given protocol.
-func (mx *Listener) findListener(proto ...string) (sub *sublistener) {
+// findListener returns the sublistener for the given protocol, matched is false if it is the default or
+// the fallback one.
+func (mx *Listener) findListener(proto ...string) (sub *sublistener, matched bool) {
 	mx.mu.RLock()
 	defer mx.mu.RUnlock()
 	for _, proto := range proto {
 		sub = mx.sub[proto]
 		if sub != nil {
-			return
+			return sub, true
 		}
 	}
 	sub = mx.sub["*"]
+	if sub == nil && mx.fallback != "" {
+		sub = mx.sub[mx.fallback]
+	}
+	return sub, false
+}
+
+// Returns the protocols listened to.
+func (mx *Listener) protos() (protos []string) {
+	mx.mu.RLock()
+	defer mx.mu.RUnlock()
+	for proto := range mx.sub {
+		if proto != "*" {
+			protos = append(protos, proto)
+		}
+	}
 	return
 }
 
@@ -151,25 +206,48 @@ func (mx *Listener) findListener(proto ...string) (sub *sublistener) {
 // This is called by the tls package to resolve the configuration for a new connection.
 func (mx *Listener) GetConfigForClient(hello *tls.ClientHelloInfo) (config *tls.Config, err error) {
 	// Resolve the sublistener for this protocol
-	sub := mx.findListener(hello.SupportedProtos...)
+	sub, matched := mx.findListener(hello.SupportedProtos...)
 	if sub == nil {
+		mx.unrouted.Add(1)
+		if protos := mx.protos(); len(protos) != 0 && len(hello.SupportedProtos) != 0 {
+			// None of them overlap, the handshake fails with a no_application_protocol alert.
+			return &tls.Config{NextProtos: protos}, nil
+		}
 		return nil, errors.New("no listener for this protocol")
 	}
 
 	// Resolve the final configuration this sublistener wants to use
 	if sub.config.GetConfigForClient != nil {
-		return sub.config.GetConfigForClient(hello)
+		config, err = sub.config.GetConfigForClient(hello)
 	} else {
-		return sub.config, nil
+		config = sub.config
+	}
+	if err == nil && !matched && len(config.NextProtos) != 0 {
+		// Served by the fallback, do not negotiate a protocol the client did not offer.
+		config = config.Clone()
+		config.NextProtos = nil
 	}
+	return
 }
 
 // handleConn is called in the accept loop to handle a new connection.
 func (mx *Listener) handleConn(conn net.Conn) {
 	tlsc := conn.(*tls.Conn)
 	state := tlsc.ConnectionState()
-	err := tlsc.Handshake()
-	if err != nil {
+	ctx := context.Background()
+	if timeout := mx.handshake; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := tlsc.HandshakeContext(ctx)
+	if err != nil && ctx.Err() != nil {
+		// Stalled clients are expected from scanners, do not flood the logs with them.
+		mx.timedOut.Add(1)
+		xlog.Debug().Err(err).Any("addr", conn.RemoteAddr()).Msg("handshake timed out")
+		conn.Close()
+		return
+	} else if err != nil {
 		xlog.Warn().Err(err).
 			Any("addr", conn.RemoteAddr()).
 			Str("sni", state.ServerName).
@@ -180,13 +258,14 @@ func (mx *Listener) handleConn(conn net.Conn) {
 	}
 
 	proto := tlsc.ConnectionState().NegotiatedProtocol
-	sub := mx.findListener(proto)
+	sub, _ := mx.findListener(proto)
 	if sub == nil {
 		xlog.Warn().Err(err).
 			Any("addr", conn.RemoteAddr()).
 			Str("sni", state.ServerName).
 			Str("proto", state.NegotiatedProtocol).
 			Msg("no listener found for protocol")
+		mx.unrouted.Add(1)
 		conn.Close()
 		return
 	}
@@ -232,7 +311,9 @@ func (mx *Listener) acceptLoop() {
 // NewMuxListener creates a new TLS multiplexing listener.
 func NewMuxListener(listener net.Listener) *Listener {
 	mx := &Listener{
-		sub: make(map[string]*sublistener),
+		sub:       make(map[string]*sublistener),
+		fallback:  defaultFallback(),
+		handshake: handshakeTimeout(),
 	}
 	ln := tls.NewListener(listener, &tls.Config{
 		GetConfigForClient: mx.GetConfigForClient,
@@ -0,0 +1,279 @@
+package tlsmux
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+)
+
+func testConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tlsmux"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"tlsmux"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func testMux(t *testing.T) *Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mx := NewMuxListener(l)
+	t.Cleanup(func() { mx.Close() })
+	return mx
+}
+
+func testDial(mx *Listener, protos ...string) (*tls.Conn, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", mx.listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         protos,
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, nil
+}
+
+// Accepts a connection from the listener, failing if none arrives in time.
+func testAccept(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+	ch := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			ch <- conn
+		}
+	}()
+	select {
+	case conn := <-ch:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted")
+		return nil
+	}
+}
+
+func TestUnknownProtocolRejected(t *testing.T) {
+	mx := testMux(t)
+	if _, err := mx.Listen(testConfig(t), "h2"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := testDial(mx, "foo")
+	if err == nil {
+		// With TLS 1.3 the alert may only surface on the first read.
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "no application protocol") {
+		t.Fatalf("expected a no_application_protocol alert, got %v", err)
+	}
+	if n := mx.unrouted.Load(); n != 1 {
+		t.Errorf("unrouted = %d, want 1", n)
+	}
+}
+
+func TestKnownProtocolRouted(t *testing.T) {
+	mx := testMux(t)
+	h2, err := mx.Listen(testConfig(t), "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mx.Listen(testConfig(t), "nats"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := testDial(mx, "foo", "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		t.Errorf("negotiated %q, want h2", p)
+	}
+	testAccept(t, h2).Close()
+}
+
+func TestUnknownProtocolFallback(t *testing.T) {
+	mx := testMux(t)
+	h2, err := mx.Listen(testConfig(t), "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mx.SetFallback("h2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, protos := range [][]string{{"foo"}, nil} {
+		conn, err := testDial(mx, protos...)
+		if err != nil {
+			t.Fatalf("%v: %v", protos, err)
+		}
+		if p := conn.ConnectionState().NegotiatedProtocol; p != "" {
+			t.Errorf("%v: negotiated %q, want none", protos, p)
+		}
+		testAccept(t, h2).Close()
+		conn.Close()
+	}
+}
+
+func TestDefaultListenerPrecedesFallback(t *testing.T) {
+	mx := testMux(t)
+	if _, err := mx.Listen(testConfig(t), "h2"); err != nil {
+		t.Fatal(err)
+	}
+	def, err := mx.Listen(testConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mx.SetFallback("h2"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := testDial(mx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testAccept(t, def).Close()
+}
+
+func TestSetFallbackClosed(t *testing.T) {
+	mx := testMux(t)
+	mx.Close()
+	if err := mx.SetFallback("h2"); !errors.Is(err, ErrListenerClosed) {
+		t.Fatalf("got %v, want ErrListenerClosed", err)
+	}
+}
+
+func TestNoProtocolRejected(t *testing.T) {
+	mx := testMux(t)
+	if _, err := mx.Listen(testConfig(t), "h2"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := testDial(mx)
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil || !strings.Contains(err.Error(), "internal error") {
+		t.Fatalf("expected an internal_error alert, got %v", err)
+	}
+	if n := mx.unrouted.Load(); n != 1 {
+		t.Errorf("unrouted = %d, want 1", n)
+	}
+}
+
+func TestSharedSetFallback(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0", testConfig(t), "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := SetFallback("127.0.0.1:0", "h2"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := testDial(ln.(*sublistener).mux, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testAccept(t, ln).Close()
+
+	if err := SetFallback("127.0.0.1:1", "h2"); !errors.Is(err, ErrListenerClosed) {
+		t.Errorf("unknown address: got %v, want ErrListenerClosed", err)
+	}
+	ln.Close()
+	if err := SetFallback("127.0.0.1:0", "h2"); !errors.Is(err, ErrListenerClosed) {
+		t.Errorf("closed port: got %v, want ErrListenerClosed", err)
+	}
+}
+
+func TestStalledHandshakeClosed(t *testing.T) {
+	prev := *config.MuxHandshakeTimeout
+	t.Cleanup(func() { *config.MuxHandshakeTimeout = prev })
+	*config.MuxHandshakeTimeout = 100 * time.Millisecond
+
+	mx := testMux(t)
+	h2, err := mx.Listen(testConfig(t), "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Silent, and stalled in the middle of the hello.
+	for i, hello := range [][]byte{nil, {0x16, 0x03, 0x01, 0x02, 0x00, 0x01}} {
+		conn, err := net.DialTimeout("tcp", mx.listener.Addr().String(), 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write(hello)
+		start := time.Now()
+		conn.SetReadDeadline(start.Add(5 * time.Second))
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Fatalf("%d: connection not closed: %v", i, err)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%d: closed after %v", i, d)
+		}
+		if n := mx.timedOut.Load(); n != uint64(i+1) {
+			t.Errorf("%d: timed_out = %d, want %d", i, n, i+1)
+		}
+	}
+	if s := mx.Stats(); s.TimedOut != 2 {
+		t.Errorf("stats timed_out = %d, want 2", s.TimedOut)
+	}
+
+	// The handshakes completing in time are not affected.
+	conn, err := testDial(mx, "h2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testAccept(t, h2).Close()
+}
+
+func TestHandshakeTimeoutDisabled(t *testing.T) {
+	prev := *config.MuxHandshakeTimeout
+	t.Cleanup(func() { *config.MuxHandshakeTimeout = prev })
+	*config.MuxHandshakeTimeout = 0
+
+	mx := testMux(t)
+	if _, err := mx.Listen(testConfig(t), "h2"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout("tcp", mx.listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want the connection left open", err)
+	}
+	if n := mx.timedOut.Load(); n != 0 {
+		t.Errorf("timed_out = %d, want 0", n)
+	}
+}
@@ -45,3 +45,15 @@ func Listen(network, address string, config *tls.Config, protos ...string) (net.
 	sharedMuxMap[address] = muxListener
 	return ln, nil
 }
+
+// SetFallback designates the fallback protocol of the shared listener on the given address, see
+// Listener.SetFallback.
+func SetFallback(address, proto string) error {
+	sharedMuxLock.Lock()
+	l, ok := sharedMuxMap[address]
+	sharedMuxLock.Unlock()
+	if !ok {
+		return ErrListenerClosed
+	}
+	return l.SetFallback(proto)
+}
@@ -0,0 +1,89 @@
+package tlsmux
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+)
+
+// Returns the number of connections a protocol can leave waiting before the handshakes block.
+func backlogSize() int {
+	return max(*config.MuxBacklog, 1)
+}
+
+// Returns the time given to a client to complete the handshake, zero if unbounded.
+func handshakeTimeout() time.Duration {
+	return *config.MuxHandshakeTimeout
+}
+
+// Returns the protocol the new listeners fall back to, see Listener.SetFallback.
+func defaultFallback() string {
+	return *config.MuxFallback
+}
+
+// ProtoStats is the state of the listener of a protocol.
+type ProtoStats struct {
+	Protos   []string `json:"protos"`
+	Backlog  int      `json:"backlog"`  // Connections waiting to be accepted
+	Capacity int      `json:"capacity"` // Size of the backlog
+	Blocked  int32    `json:"blocked"`  // Connections waiting for room in the backlog
+	Accepted uint64   `json:"accepted"` // Connections accepted since the listener started
+	Dropped  uint64   `json:"dropped"`  // Connections closed because the listener was closed before accepting them
+}
+
+// MuxStats is the state of a shared port.
+type MuxStats struct {
+	Address   string       `json:"address"`
+	Unrouted  uint64       `json:"unrouted"`  // Connections closed for lack of a listener for their protocol
+	TimedOut  uint64       `json:"timed_out"` // Connections closed for not completing the handshake in time
+	Listeners []ProtoStats `json:"listeners"`
+}
+
+type Stats []MuxStats
+
+// Stats returns the state of the listeners of the protocols.
+func (mx *Listener) Stats() (s MuxStats) {
+	mx.mu.RLock()
+	defer mx.mu.RUnlock()
+	s.Address = mx.listener.Addr().String()
+	s.Unrouted = mx.unrouted.Load()
+	s.TimedOut = mx.timedOut.Load()
+	for _, sub := range mx.sub {
+		// A listener is registered under each of its protocols.
+		if slices.ContainsFunc(s.Listeners, func(p ProtoStats) bool { return slices.Equal(p.Protos, sub.protos) }) {
+			continue
+		}
+		s.Listeners = append(s.Listeners, ProtoStats{
+			Protos:   sub.protos,
+			Backlog:  len(sub.backlog),
+			Capacity: cap(sub.backlog),
+			Blocked:  sub.blocked.Load(),
+			Accepted: sub.accepted.Load(),
+			Dropped:  sub.dropped.Load(),
+		})
+	}
+	slices.SortFunc(s.Listeners, func(a, b ProtoStats) int {
+		return strings.Compare(strings.Join(a.Protos, ","), strings.Join(b.Protos, ","))
+	})
+	return
+}
+
+// GetStats returns the state of the shared ports.
+func GetStats() (s Stats) {
+	sharedMuxLock.Lock()
+	defer sharedMuxLock.Unlock()
+	for _, mx := range sharedMuxMap {
+		mx.mu.RLock()
+		closed := mx.closed
+		mx.mu.RUnlock()
+		if !closed {
+			s = append(s, mx.Stats())
+		}
+	}
+	slices.SortFunc(s, func(a, b MuxStats) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	return
+}
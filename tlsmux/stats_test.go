@@ -0,0 +1,91 @@
+package tlsmux
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// Waits for the stats of the only protocol listener to satisfy the condition.
+func waitStats(t *testing.T, mx *Listener, cond func(ProtoStats) bool) ProtoStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := mx.Stats()
+		if len(s.Listeners) != 1 {
+			t.Fatalf("listeners %+v", s.Listeners)
+		}
+		if cond(s.Listeners[0]) {
+			return s.Listeners[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats %+v", s.Listeners[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStats(t *testing.T) {
+	mx := testMux(t)
+	ln, err := mx.Listen(testConfig(t), "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saturate the backlog.
+	size := backlogSize()
+	for range size + 2 {
+		conn, err := testDial(mx, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	s := waitStats(t, mx, func(s ProtoStats) bool { return s.Blocked == 2 })
+	if !slices.Equal(s.Protos, []string{"a", "b"}) || s.Backlog != size || s.Capacity != size || s.Accepted != 0 {
+		t.Errorf("saturated %+v", s)
+	}
+
+	// Accepting makes room for a blocked connection.
+	testAccept(t, ln).Close()
+	s = waitStats(t, mx, func(s ProtoStats) bool { return s.Blocked == 1 })
+	if s.Backlog != size || s.Accepted != 1 {
+		t.Errorf("after accept %+v", s)
+	}
+
+	if conn, err := testDial(mx, "c"); err == nil {
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if n := mx.Stats().Unrouted; n != 1 {
+		t.Errorf("unrouted = %d, want 1", n)
+	}
+
+	// Closing drops the waiting connections, blocked ones included.
+	ln.Close()
+	if n := ln.(*sublistener).dropped.Load(); n != uint64(size+1) {
+		t.Errorf("dropped = %d, want %d", n, size+1)
+	}
+	if s := mx.Stats(); len(s.Listeners) != 0 {
+		t.Errorf("closed listener reported: %+v", s.Listeners)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0", testConfig(t), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+	reported := func() bool {
+		return slices.ContainsFunc(GetStats(), func(s MuxStats) bool { return s.Address == addr })
+	}
+	if !reported() {
+		t.Fatalf("%s not reported: %+v", addr, GetStats())
+	}
+	ln.Close()
+	if reported() {
+		t.Errorf("closed port %s still reported", addr)
+	}
+}
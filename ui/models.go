@@ -47,6 +47,21 @@ var ServiceControls = []ServiceControl{
 			}
 		},
 	},
+	{
+		Use:     "roll [name]",
+		Short:   "Restart service replacing the instances one at a time",
+		Aliases: []string{"rolling-restart"},
+		WaitMsg: "Rolling...",
+		Display: "🔁 Rolling restart",
+		Do: func(cli client.Client, name string) (string, error) {
+			n, e := cli.ServiceRollingRestart(name, false)
+			if e != nil {
+				return "", e
+			} else {
+				return fmt.Sprintf("Restarted %d services", n.Count), nil
+			}
+		},
+	},
 	{
 		Use:     "rebuild [name]",
 		Short:   "Invalidates build cache and restarts service",
@@ -169,9 +184,14 @@ func (m ServiceDetailModel) upstreamViewBasic() [][]Pair {
 			"State", state,
 			"Address", u.Address,
 			"Load", fmt.Sprint(u.LoadFactor),
+			"Weight", fmt.Sprintf("%d%%", u.Weight),
+			"p50", DisplayFloatWithGran(u.Latency.P50, 10)+"ms",
+			"p99", DisplayFloatWithGran(u.Latency.P99, 10)+"ms",
 			"Requests", fmt.Sprint(u.RequestCount),
 			"5xx", fmt.Sprint(u.ServerErrorCount),
 			"4xx", fmt.Sprint(u.ClientErrorCount),
+			"In", util.Size(int(u.BytesIn)).Display(),
+			"Out", util.Size(int(u.BytesOut)).Display(),
 		))
 	}
 	return tbl
@@ -186,7 +206,7 @@ func (m ServiceDetailModel) upstreamView(w int) string {
 		return lipgloss.NewStyle().Padding(0, 1)
 	})
 
-	tbl.Headers("🏹 Address", "🔥 Load", "📥 Requests", "🚨 5xx", "😝 4xx")
+	tbl.Headers("🏹 Address", "🔥 Load", "⚖️ Weight", "⏱️ p50/p99", "📥 Requests", "🚨 5xx", "😝 4xx", "📶 In/Out")
 	for _, u := range m.entry.Server.Upstreams {
 		state := "🔴 "
 		if u.Healthy {
@@ -195,9 +215,12 @@ func (m ServiceDetailModel) upstreamView(w int) string {
 		tbl.Row(
 			state+u.Address,
 			fmt.Sprint(u.LoadFactor),
+			fmt.Sprintf("%d%%", u.Weight),
+			DisplayFloatWithGran(u.Latency.P50, 10)+"/"+DisplayFloatWithGran(u.Latency.P99, 10)+"ms",
 			fmt.Sprint(u.RequestCount),
 			fmt.Sprint(u.ServerErrorCount),
 			fmt.Sprint(u.ClientErrorCount),
+			util.Size(int(u.BytesIn)).Display()+"/"+util.Size(int(u.BytesOut)).Display(),
 		)
 	}
 	return tbl.Render()
@@ -265,6 +288,10 @@ func (m ServiceDetailModel) View(w, h int) string {
 		status = lipgloss.NewStyle().PaddingRight(padmax).Render(status)
 	}
 
+	if r := m.entry.Ramp; r != nil && r.Running {
+		status += fmt.Sprintf("\nRamp: %d/%d started, %d warm, %.0f%% traffic", r.Replaced, r.Total, r.Warm, r.Traffic*100)
+	}
+
 	tblstyle := lipgloss.NewStyle().Margin(1, 0, 1, 0)
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
@@ -0,0 +1,86 @@
+package variant
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// UsageDescriber is implemented by the types documenting their inline syntax.
+type UsageDescriber interface {
+	Usage() string
+}
+
+// Field is a configurable field of a registered type.
+type Field struct {
+	Name    string `json:"name"`              // Key in the manifest.
+	Type    string `json:"type"`              // Go type of the value.
+	Default any    `json:"default,omitempty"` // Value set by the defaults, if any.
+}
+
+// Description describes a type registered under a tag.
+type Description struct {
+	Tag    string  `json:"tag"`
+	Type   string  `json:"type"`             // Go type of the instances.
+	Inline bool    `json:"inline"`           // Whether it can be written as a string.
+	Usage  string  `json:"usage,omitempty"`  // Syntax of the inline form, if documented.
+	Fields []Field `json:"fields,omitempty"` // Fields accepted in the mapping form.
+}
+
+// Describe lists the registered types ordered by tag.
+func (r *Registry[I]) Describe() []Description {
+	res := make([]Description, 0, len(r.Tags))
+	for tag, reg := range r.Tags {
+		d := Description{
+			Tag:    tag,
+			Type:   reflect.TypeOf(reg.Instance).String(),
+			Inline: reg.FromString != nil,
+			Fields: DescribeFields(reflect.ValueOf(reg.Instance)),
+		}
+		if u, ok := reg.Instance.(UsageDescriber); ok {
+			d.Usage = u.Usage()
+		}
+		res = append(res, d)
+	}
+	slices.SortFunc(res, func(a, b Description) int { return strings.Compare(a.Tag, b.Tag) })
+	return res
+}
+
+// DescribeFields lists the fields of a struct as decoded from YAML, flattening the inline ones.
+func DescribeFields(v reflect.Value) (res []Field) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+		} else {
+			v = v.Elem()
+		}
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if slices.Contains(strings.Split(opts, ","), "inline") {
+			res = append(res, DescribeFields(v.Field(i))...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		field := Field{Name: name, Type: f.Type.String()}
+		if fv := v.Field(i); !fv.IsZero() {
+			field.Default = fv.Interface()
+		}
+		res = append(res, field)
+	}
+	return
+}
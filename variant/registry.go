@@ -184,6 +184,23 @@ func (r *Registry[I]) Define(tag string, defaults func() any) {
 	}
 }
 
+// TagOf returns the tag the value's type was defined with. If several tags share the type, the one
+// without defaults is preferred, then the first in lexical order.
+func (r *Registry[I]) TagOf(v any) (tag string, ok bool) {
+	t := reflect.TypeOf(v)
+	plain := false
+	for k, reg := range r.Tags {
+		if reflect.TypeOf(reg.Instance) != t {
+			continue
+		}
+		zero := reflect.Indirect(reflect.ValueOf(reg.Instance)).IsZero()
+		if !ok || (zero && !plain) || (zero == plain && k < tag) {
+			tag, ok, plain = k, true, zero
+		}
+	}
+	return
+}
+
 func NewRegistry[IFace any]() *Registry[IFace] {
 	reg := &Registry[IFace]{
 		Tags: make(map[string]*Registration),
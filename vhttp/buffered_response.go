@@ -67,6 +67,8 @@ func (bw *BufferedResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type ConditionalResponse struct {
 	rw      http.ResponseWriter
 	Touched bool
+	Status  int
+	Written int64 // Number of bytes of body written.
 }
 
 func NewConditionalResponse(rw http.ResponseWriter) *ConditionalResponse {
@@ -78,10 +80,19 @@ func (cr *ConditionalResponse) Header() http.Header {
 }
 func (cr *ConditionalResponse) Write(b []byte) (int, error) {
 	cr.Touched = true
-	return cr.rw.Write(b)
+	if cr.Status == 0 {
+		cr.Status = http.StatusOK
+	}
+	n, err := cr.rw.Write(b)
+	cr.Written += int64(n)
+	return n, err
 }
 func (cr *ConditionalResponse) WriteHeader(status int) {
 	cr.Touched = true
+	// Interim 1xx responses are forwarded as-is, only the final status is recorded.
+	if cr.Status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		cr.Status = status
+	}
 	cr.rw.WriteHeader(status)
 }
 func (cr *ConditionalResponse) Unwrap() http.ResponseWriter {
@@ -92,6 +103,9 @@ func (cr *ConditionalResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	c, rw, e := rc.Hijack()
 	if e == nil {
 		cr.Touched = true
+		if cr.Status == 0 {
+			cr.Status = http.StatusSwitchingProtocols
+		}
 	}
 	return c, rw, e
 }
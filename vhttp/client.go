@@ -1,11 +1,15 @@
 package vhttp
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,20 +27,41 @@ type ClientMetrics struct {
 	NumReqs      int32     `json:"num_reqs"`
 	FirstSeen    time.Time `json:"first_seen"`
 	BlockedUntil time.Time `json:"blocked_until,omitempty"`
+	Values       int       `json:"values,omitempty"` // Number of values held by the handlers.
+	BytesIn      uint64    `json:"bytes_in"`         // Bytes of request bodies received.
+	BytesOut     uint64    `json:"bytes_out"`        // Bytes of response bodies sent.
 }
 
-var Raygen = ray.NewGenerator(config.Get().Host)
+// RayGenerator generates the ray IDs attached to each request.
+type RayGenerator interface {
+	Next() string
+}
+
+var Raygen = newRaygen(config.Get())
+
+func newRaygen(c *config.Config) RayGenerator {
+	switch c.RayFormat {
+	case "snowflake":
+		return ray.NewSnowflakeGenerator(c.Host)
+	default:
+		gen := ray.NewGenerator(c.Host)
+		return &gen
+	}
+}
 
 type ClientSession struct {
 	IP             netx.IP
 	IPHash         uint32
-	RemoteAddr     string   // ip:0, for setting request.RemoteAddr
-	Values         sync.Map // For handler's use.
+	RemoteAddr     string        // ip:0, for setting request.RemoteAddr
+	Values         SessionValues // For handler's use.
 	firstRequestMs int64
 	lastRequestMs  atomic.Int64
 	NumRequests    atomic.Int32
+	BytesIn        atomic.Uint64 // Bytes of request bodies received.
+	BytesOut       atomic.Uint64 // Bytes of response bodies sent.
 	BlockedUntilMs atomic.Int64
-	IPInfo         http.Header
+	IPInfo         http.Header // Known when the session is created, see CurrentIPInfo.
+	resolvedIPInfo atomic.Pointer[http.Header]
 	Local          bool
 }
 
@@ -48,6 +73,15 @@ var LocalClientSession = &ClientSession{
 	Local:      true,
 }
 
+// CurrentIPInfo returns the headers describing the IP of the client. With the background lookup, IPInfo
+// only carries the IP and the country hint, the result of the lookup takes over once it completes.
+func (s *ClientSession) CurrentIPInfo() http.Header {
+	if h := s.resolvedIPInfo.Load(); h != nil {
+		return *h
+	}
+	return s.IPInfo
+}
+
 func (s *ClientSession) FirstRequest() time.Time { return time.UnixMilli(s.firstRequestMs) }
 func (s *ClientSession) LastRequest() time.Time  { return time.UnixMilli(s.lastRequestMs.Load()) }
 
@@ -96,6 +130,9 @@ func (s *ClientSession) Metrics() ClientMetrics {
 		NumReqs:      numReq,
 		FirstSeen:    firstReq,
 		BlockedUntil: bt,
+		Values:       s.Values.Len(),
+		BytesIn:      s.BytesIn.Load(),
+		BytesOut:     s.BytesOut.Load(),
 	}
 }
 func GetClientMetrics() (metrics map[string]ClientMetrics) {
@@ -170,30 +207,49 @@ func EnforceRateReq(r *http.Request, l rate.Limit) http.Handler {
 	return ClientSessionFromContext(r.Context()).EnforceRateReq(r.Context(), r, l)
 }
 
-func newClientSession(ctx context.Context, px netx.ProxyTraits, t int64, infoProvider netx.IPInfoProvider) (session *ClientSession) {
+func newClientSession(ctx context.Context, px netx.ProxyTraits, t int64, infoProvider netx.IPInfoProvider, async bool) (session *ClientSession) {
 	hash := sha1.Sum(px.Origin.ToSlice())
 	session = &ClientSession{
 		IP:             px.Origin,
 		IPHash:         binary.LittleEndian.Uint32(hash[:]),
 		firstRequestMs: t,
-		IPInfo:         make(http.Header, 5),
 	}
 	session.Local = px.Origin.IsLoopback() || px.Origin.IsPrivate()
-	if !session.Local {
-		ipstr := px.Origin.String()
-		ipinfo := infoProvider.LookupContext(ctx, px.Origin)
-		netx.SetIPInfoHeaders(session.IPInfo, ipstr, ipinfo)
+	if session.Local {
+		if info, ok := netx.LookupLocal(infoProvider, px.Origin); ok {
+			headers := make(http.Header, 5)
+			netx.SetIPInfoHeaders(headers, px.Origin.String(), info)
+			session.IPInfo = headers
+		} else {
+			session.IPInfo = netx.LocalIPInfoHeaders
+		}
+	} else if !async {
+		session.IPInfo = lookupIPInfo(ctx, px, infoProvider)
+	} else {
+		// Admit the request with what is known without a lookup, see CurrentIPInfo.
+		session.IPInfo = http.Header{netx.HdrIP: []string{px.Origin.String()}}
 		if px.CountryHint.IsValid() {
 			session.IPInfo[netx.HdrIPGeo] = []string{px.CountryHint.String()}
 		}
-	} else {
-		session.IPInfo = netx.LocalIPInfoHeaders
 	}
 	session.lastRequestMs.Store(t)
 	session.RemoteAddr = netx.IPPort{IP: px.Origin, Port: 0}.String()
 	return
 }
 
+// Deadline of a background IP info lookup.
+const ipInfoLookupTimeout = 10 * time.Second
+
+// Looks up the headers describing the IP of the client.
+func lookupIPInfo(ctx context.Context, px netx.ProxyTraits, infoProvider netx.IPInfoProvider) http.Header {
+	info := make(http.Header, 5)
+	netx.SetIPInfoHeaders(info, px.Origin.String(), infoProvider.LookupContext(ctx, px.Origin))
+	if px.CountryHint.IsValid() {
+		info[netx.HdrIPGeo] = []string{px.CountryHint.String()}
+	}
+	return info
+}
+
 type sessionContextKey struct{}
 
 func (s *ClientSession) SetOnContext(ctx context.Context) context.Context {
@@ -235,7 +291,7 @@ func (session *ClientSession) startRequest(ctx context.Context, t int64, r *http
 	// If remote connection, or the local connection is not impersonating a remote connection:
 	if !session.Local || len(rctx.Header[netx.HdrIP]) == 0 {
 		// Inherit IP info from the session.
-		for k, v := range session.IPInfo {
+		for k, v := range session.CurrentIPInfo() {
 			rctx.Header[k] = v
 		}
 	}
@@ -253,7 +309,9 @@ func StartInternalRequest(r *http.Request) (rctx *http.Request) {
 	return
 }
 
-func StartClientRequest(r *http.Request, infoProvider netx.IPInfoProvider) (rctx *http.Request, session *ClientSession) {
+// StartClientRequest starts a request of a client, resolving its address with the proxy rules. The IP
+// info of a new client is looked up before serving it, or in the background if async is set.
+func StartClientRequest(r *http.Request, rules *netx.ProxyRules, infoProvider netx.IPInfoProvider, async bool) (rctx *http.Request, session *ClientSession) {
 	t := time.Now().UnixMilli()
 	startCleaner.Do(func() {
 		go func() {
@@ -265,18 +323,38 @@ func StartClientRequest(r *http.Request, infoProvider netx.IPInfoProvider) (rctx
 	ctx := r.Context()
 
 	// Resolve the proxy traits, retrieve or create the session.
-	px := netx.ResolveProxyTraits(r)
+	px := rules.Resolve(r)
+
+	// The requests steered by a peer are served as if they came from its client, those failing the
+	// verification are not trusted at all rather than as much as the peer.
+	steered, steeredIdentity := false, ""
+	if isPeerConnection(r) && r.Header.Get(HdrSteered) != "" {
+		steered = true
+		if ip, identity, ok := verifySteered(r.Header.Get(HdrSteered)); ok {
+			px.Origin, steeredIdentity = ip, identity
+		}
+	}
 	key := ipToKey(px.Origin)
 	sv, loaded := sessionMap.Load(key)
 	if !loaded {
-		session = newClientSession(ctx, px, t, infoProvider)
+		session = newClientSession(ctx, px, t, infoProvider, async)
 		if ctx.Err() != nil {
 			panic(http.ErrAbortHandler)
 		}
 		sv, loaded = sessionMap.LoadOrStore(key, session)
+		if !loaded && async && !session.Local {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), ipInfoLookupTimeout)
+				defer cancel()
+				info := lookupIPInfo(ctx, px, infoProvider)
+				session.resolvedIPInfo.Store(&info)
+			}()
+		}
 	}
 	if !loaded {
-		sessionCount.Add(1)
+		if n := sessionCount.Add(1); *config.MaxClientSessions > 0 && n > int32(*config.MaxClientSessions) && !evicting.Load() {
+			go evictClientSessions()
+		}
 	} else {
 		session = sv.(*ClientSession)
 	}
@@ -308,15 +386,35 @@ func StartClientRequest(r *http.Request, infoProvider netx.IPInfoProvider) (rctx
 
 	// Set internal flag, stip secret from headers ASAP.
 	internal := session.Local
-	if !internal {
+	identity := IdentityLocal
+	if steered {
+		// Trusted as much as the client was by the peer, not as the peer itself.
+		internal, identity = false, ""
+		if name, ok := strings.CutPrefix(steeredIdentity, IdentityUserPrefix); ok {
+			rctx = rctx.WithContext(context.WithValue(rctx.Context(), userContextKey{}, name))
+		} else if steeredIdentity != "" {
+			internal, identity = true, steeredIdentity
+		}
+		rctx.Header[HdrSteered] = []string{"1"}
+	} else {
+		delete(rctx.Header, HdrSteered)
+	}
+	if !internal && !steered {
 		// If we verified the peer certificate using the mutual authenticator, it's internal.
-		if rctx.TLS != nil && len(rctx.TLS.PeerCertificates) != 0 && rctx.TLS.ServerName == "pm3" {
+		if isPeerConnection(rctx) {
 			internal = true
+			identity = IdentityPeerPrefix + rctx.TLS.PeerCertificates[0].Subject.CommonName
 		} else if scheme == "https" {
 			// If the request is over HTTPS and the secret is in the basic auth, it's internal.
 			if u, pw, ok := rctx.BasicAuth(); ok {
 				if u == config.Get().Secret || pw == config.Get().Secret {
 					internal = true
+					identity = IdentitySecret
+					delete(rctx.Header, "Authorization")
+				} else if user, ok := config.Get().Users[u]; ok && user.Password != "" &&
+					subtle.ConstantTimeCompare([]byte(user.Password), []byte(pw)) == 1 {
+					// Configured users are not internal, only the management API grants them access.
+					rctx = rctx.WithContext(context.WithValue(rctx.Context(), userContextKey{}, u))
 					delete(rctx.Header, "Authorization")
 				}
 			}
@@ -324,14 +422,74 @@ func StartClientRequest(r *http.Request, infoProvider netx.IPInfoProvider) (rctx
 	}
 	if internal {
 		rctx.Header["P-Internal"] = []string{"1"}
+		rctx.Header[HdrIdentity] = []string{identity}
 	} else {
 		delete(rctx.Header, "P-Internal")
+		delete(rctx.Header, HdrIdentity)
 	}
 	delete(rctx.Header, "P-Portal")
 	return
 }
 
+// Returns true if the request comes from a peer authenticated by the mutual authenticator.
+func isPeerConnection(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.PeerCertificates) != 0 && r.TLS.ServerName == "pm3"
+}
+
+// HdrIdentity is set on internal requests to describe how the caller was authenticated.
+var HdrIdentity = http.CanonicalHeaderKey("P-Identity")
+
+const (
+	IdentityLocal      = "local"     // Loopback or private network.
+	IdentitySecret     = "secret"    // Shared secret in basic auth.
+	IdentitySigned     = "signed"    // Signed URL.
+	IdentityPeerPrefix = "peer:"     // Mutual TLS, followed by the certificate common name.
+	IdentityDirective  = "directive" // Elevated by a route directive (allow-internal, portal).
+	IdentityUserPrefix = "user:"     // Configured user in basic auth, followed by the username.
+)
+
+// Marks the request as internal, recording the identity unless it already was internal.
+func markInternal(r *http.Request, identity string) {
+	if r.Header.Get("P-Internal") != "1" {
+		r.Header[HdrIdentity] = []string{identity}
+		r.Header["P-Internal"] = []string{"1"}
+	}
+}
+
+type userContextKey struct{}
+
+// RequestUser returns the name of the configured user authenticated by the request, or an empty string.
+func RequestUser(r *http.Request) string {
+	name, _ := r.Context().Value(userContextKey{}).(string)
+	return name
+}
+
+// RequestIdentity returns the identity of the caller of an internal request or of a configured user,
+// or an empty string.
+func RequestIdentity(r *http.Request) string {
+	if r.Header.Get("P-Internal") != "1" {
+		if name := RequestUser(r); name != "" {
+			return IdentityUserPrefix + name
+		}
+		return ""
+	}
+	return r.Header.Get(HdrIdentity)
+}
+
+// RequestAccess returns the management API access level of the caller.
+// Internal callers are admins, configured users get the access level they are assigned.
+func RequestAccess(r *http.Request) config.Access {
+	if r.Header.Get("P-Internal") == "1" {
+		return config.AccessAdmin
+	}
+	if name := RequestUser(r); name != "" {
+		return config.Get().Users[name].Access
+	}
+	return config.AccessNone
+}
+
 func ipToKey(ip netx.IP) any {
+	ip = ip.Unmap()
 	if ip.IsV4() {
 		return uint32(ip.Low)
 	} else {
@@ -341,20 +499,91 @@ func ipToKey(ip netx.IP) any {
 
 var startCleaner sync.Once
 
+// Removes the session unless it was replaced, returns true if it was removed.
+// The values are cleared so that the requests still holding the session release them too.
+func removeClientSession(key any, session *ClientSession) bool {
+	if !sessionMap.CompareAndDelete(key, session) {
+		return false
+	}
+	sessionCount.Add(-1)
+	session.Values.Clear()
+	return true
+}
+
 func cleanupClientSessions() {
 	threshold := time.Now().Add(-30 * time.Minute).UnixMilli()
-	cleanupCount := int32(0)
+	cleanupCount := 0
 	sessionMap.Range(func(key any, v any) bool {
 		session := v.(*ClientSession)
 		if session.lastRequestMs.Load() >= threshold {
 			return true
 		}
-		sessionMap.Delete(key)
-		cleanupCount++
+		if removeClientSession(key, session) {
+			cleanupCount++
+		}
 		return true
 	})
 	if cleanupCount > 0 {
-		rem := sessionCount.Add(-cleanupCount)
-		xlog.Info().Int32("count", cleanupCount).Int32("remaining", rem).Msg("Cleaned up client sessions")
+		xlog.Info().Int("count", cleanupCount).Int32("remaining", sessionCount.Load()).Msg("Cleaned up client sessions")
+	}
+}
+
+var evicting atomic.Bool
+
+// Evicts the least recently used sessions once the count crosses the limit, down to 90% of it so
+// that a flood of new clients does not trigger an eviction per request. Blocked clients are evicted
+// last so that rotating through addresses does not lift the blocks.
+func evictClientSessions() {
+	if !evicting.CompareAndSwap(false, true) {
+		return
+	}
+	defer evicting.Store(false)
+
+	limit := int32(*config.MaxClientSessions)
+	if sessionCount.Load() <= limit {
+		return
+	}
+	cleanupClientSessions()
+	target := limit - limit/10
+	excess := int(sessionCount.Load() - target)
+	if excess <= 0 {
+		return
+	}
+
+	type entry struct {
+		key      any
+		session  *ClientSession
+		lastUsed int64
+		blocked  bool
+	}
+	entries := make([]entry, 0, sessionCount.Load())
+	sessionMap.Range(func(key any, v any) bool {
+		session := v.(*ClientSession)
+		entries = append(entries, entry{key, session, session.lastRequestMs.Load(), session.IsBlocked()})
+		return true
+	})
+	slices.SortFunc(entries, func(a, b entry) int {
+		if a.blocked != b.blocked {
+			if a.blocked {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(a.lastUsed, b.lastUsed)
+	})
+
+	evicted := 0
+	for _, e := range entries {
+		if evicted >= excess {
+			break
+		}
+		// Skip the sessions used since the snapshot.
+		if e.session.lastRequestMs.Load() != e.lastUsed {
+			continue
+		}
+		if removeClientSession(e.key, e.session) {
+			evicted++
+		}
 	}
+	xlog.Warn().Int("count", evicted).Int32("remaining", sessionCount.Load()).Int32("limit", limit).Msg("Client session limit reached, evicted the least recently used")
 }
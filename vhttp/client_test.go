@@ -0,0 +1,208 @@
+package vhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/netx"
+)
+
+func TestIPToKey(t *testing.T) {
+	v4 := ipToKey(netx.ParseIP("203.0.113.7"))
+	for _, addr := range []string{
+		"::ffff:203.0.113.7",
+		"64:ff9b::cb00:7107",
+		"2002:cb00:7107::1",
+		"2001:0:4136:e378:8000:63bf:34ff:8ef8",
+	} {
+		if k := ipToKey(netx.ParseIP(addr)); k != v4 {
+			t.Errorf("ipToKey(%s) = %v, want %v", addr, k, v4)
+		}
+	}
+	if k := ipToKey(netx.ParseIP("2002:c0a8:101::1")); k == ipToKey(netx.ParseIP("192.168.1.1")) {
+		t.Error("forged 6to4 address shares the key of the private address")
+	}
+	if k := ipToKey(netx.ParseIP("2001:db8::1")); k == v4 {
+		t.Error("unrelated IPv6 address shares the key")
+	}
+}
+
+func testConfig(t *testing.T) {
+	t.Helper()
+	*config.EnvName = t.TempDir()
+	err := config.Update(func(c *config.Config) error {
+		c.Secret = "s3cret"
+		c.Users = map[string]config.User{"alice": {Password: "pw", Access: config.AccessViewer}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientRequestTrust(t *testing.T) {
+	testConfig(t)
+
+	tests := []struct {
+		name     string
+		user, pw string
+		internal bool
+		access   config.Access
+		identity string
+	}{
+		{"anonymous", "", "", false, config.AccessNone, ""},
+		{"secret", "pm3", "s3cret", true, config.AccessAdmin, IdentitySecret},
+		{"user", "alice", "pw", false, config.AccessViewer, IdentityUserPrefix + "alice"},
+		{"wrong password", "alice", "nope", false, config.AccessNone, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://pm3/service", nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		r.Header.Set("P-Internal", "1") // Forged, never trusted.
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pw)
+		}
+		r, _ = StartClientRequest(r, &netx.ProxyRules{}, netx.NullIPInfoProvider, false)
+		if got := r.Header.Get("P-Internal") == "1"; got != tt.internal {
+			t.Errorf("%s: internal = %v, want %v", tt.name, got, tt.internal)
+		}
+		if got := RequestAccess(r); got != tt.access {
+			t.Errorf("%s: access = %v, want %v", tt.name, got, tt.access)
+		}
+		if got := RequestIdentity(r); got != tt.identity {
+			t.Errorf("%s: identity = %q, want %q", tt.name, got, tt.identity)
+		}
+	}
+}
+
+// Returns the header a node sets when steering the request of the client to a peer.
+func steeredHeader(t *testing.T, addr string, user, pw string, internal bool) string {
+	t.Helper()
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.RemoteAddr = addr
+	if user != "" {
+		r.SetBasicAuth(user, pw)
+	}
+	r, _ = StartClientRequest(r, &netx.ProxyRules{}, netx.NullIPInfoProvider, false)
+	if internal {
+		markInternal(r, IdentityDirective)
+	}
+	req := r.Clone(r.Context())
+	SteerRequest(req)
+	if req.Header.Get("P-Internal") != "" || req.Header.Get(HdrIdentity) != "" {
+		t.Fatal("steered request keeps the internal flag")
+	}
+	return req.Header.Get(HdrSteered)
+}
+
+func TestSteeredRequestTrust(t *testing.T) {
+	testConfig(t)
+	expired := "203.0.113.9;" + strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10) + ";" + IdentitySecret
+	valid := steeredHeader(t, "203.0.113.9:1234", "", "", false)
+
+	tests := []struct {
+		name     string
+		peer     bool
+		steered  string
+		internal bool
+		access   config.Access
+		identity string
+		ip       string
+	}{
+		{"peer", true, "", true, config.AccessAdmin, IdentityPeerPrefix + "node2", "198.51.100.2"},
+		{"anonymous client", true, valid, false, config.AccessNone, "", "203.0.113.9"},
+		{"user", true, steeredHeader(t, "203.0.113.10:1234", "alice", "pw", false), false, config.AccessViewer, IdentityUserPrefix + "alice", "203.0.113.10"},
+		{"internal client", true, steeredHeader(t, "203.0.113.11:1234", "", "", true), true, config.AccessAdmin, IdentityDirective, "203.0.113.11"},
+		{"tampered", true, valid[:len(valid)-1] + "0", false, config.AccessNone, "", "198.51.100.2"},
+		{"forged identity", true, "203.0.113.9;9999999999;secret;" + signSteered("203.0.113.9;9999999999;local"), false, config.AccessNone, "", "198.51.100.2"},
+		{"expired", true, expired + ";" + signSteered(expired), false, config.AccessNone, "", "198.51.100.2"},
+		{"not from a peer", false, valid, false, config.AccessNone, "", "198.51.100.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.RemoteAddr = "198.51.100.2:4000"
+		if tt.peer {
+			r.TLS = &tls.ConnectionState{
+				ServerName:       "pm3",
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "node2"}}},
+			}
+		}
+		if tt.steered != "" {
+			r.Header.Set(HdrSteered, tt.steered)
+		}
+		r, session := StartClientRequest(r, &netx.ProxyRules{}, netx.NullIPInfoProvider, false)
+		if got := r.Header.Get("P-Internal") == "1"; got != tt.internal {
+			t.Errorf("%s: internal = %v, want %v", tt.name, got, tt.internal)
+		}
+		if got := RequestAccess(r); got != tt.access {
+			t.Errorf("%s: access = %v, want %v", tt.name, got, tt.access)
+		}
+		if got := RequestIdentity(r); got != tt.identity {
+			t.Errorf("%s: identity = %q, want %q", tt.name, got, tt.identity)
+		}
+		if got := session.IP.String(); got != tt.ip {
+			t.Errorf("%s: client %s, want %s", tt.name, got, tt.ip)
+		}
+		if got, want := IsSteered(r), tt.peer && tt.steered != ""; got != want {
+			t.Errorf("%s: steered = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+type slowIPInfo struct{ netx.NullIPInfo }
+
+func (slowIPInfo) ASN() uint32 { return 64500 }
+
+type slowIPInfoProvider struct{ delay time.Duration }
+
+func (p slowIPInfoProvider) LookupContext(context.Context, netx.IP) netx.IPInfo {
+	time.Sleep(p.delay)
+	return slowIPInfo{}
+}
+
+func TestClientIPInfoLookup(t *testing.T) {
+	provider := slowIPInfoProvider{50 * time.Millisecond}
+	for _, ip := range []string{"192.0.2.51", "192.0.2.52"} {
+		sessionMap.Delete(ipToKey(netx.ParseIP(ip)))
+	}
+
+	// By default the first request waits for the lookup, so that the rules on the ASN apply to it.
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.RemoteAddr = "192.0.2.51:1234"
+	r, session := StartClientRequest(r, &netx.ProxyRules{}, provider, false)
+	if got := r.Header.Get(netx.HdrASN); !strings.HasPrefix(got, "AS64500") {
+		t.Fatalf("first request ASN = %q, want AS64500", got)
+	}
+	if got := session.IPInfo.Get(netx.HdrASN); !strings.HasPrefix(got, "AS64500") {
+		t.Errorf("session ASN = %q, want AS64500", got)
+	}
+
+	// In the background, the first request is admitted without it and the later ones carry it.
+	r = httptest.NewRequest("GET", "https://example.com/", nil)
+	r.RemoteAddr = "192.0.2.52:1234"
+	r, session = StartClientRequest(r, &netx.ProxyRules{}, provider, true)
+	if got := r.Header.Get(netx.HdrASN); got != "" {
+		t.Fatalf("first async request ASN = %q, want none", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for session.CurrentIPInfo().Get(netx.HdrASN) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("background lookup did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r = httptest.NewRequest("GET", "https://example.com/", nil)
+	r.RemoteAddr = "192.0.2.52:1234"
+	r, _ = StartClientRequest(r, &netx.ProxyRules{}, provider, true)
+	if got := r.Header.Get(netx.HdrASN); !strings.HasPrefix(got, "AS64500") {
+		t.Errorf("later request ASN = %q, want AS64500", got)
+	}
+}
@@ -0,0 +1,38 @@
+package vhttp
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// CountingBody counts the bytes read from a request body.
+type CountingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+// NewCountingBody wraps the body of the request, leaving the requests without a body as is.
+// Returns nil if not wrapped.
+func NewCountingBody(r *http.Request) *CountingBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	b := &CountingBody{ReadCloser: r.Body}
+	r.Body = b
+	return b
+}
+
+func (b *CountingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes read so far, zero if nil.
+func (b *CountingBody) Count() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.n.Load()
+}
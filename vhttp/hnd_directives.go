@@ -87,6 +87,9 @@ func (p directiveHandler) vlist() []any {
 func (gd directiveHandler) String() string {
 	return fmt.Sprintf(gd.format, gd.vlist()...)
 }
+func (gd directiveHandler) Usage() string {
+	return gd.format
+}
 func (gd *directiveHandler) UnmarshalText(text []byte) error {
 	return gd.UnmarshalInline(string(text))
 }
@@ -199,7 +202,7 @@ func init() {
 
 	// Portaling directives (use with caution)
 	registerDirective("allow-internal", func(w http.ResponseWriter, r *http.Request) {
-		r.Header["P-Internal"] = []string{"1"}
+		markInternal(r, IdentityDirective)
 	})
 	registerDirective("portal %s", func(w http.ResponseWriter, r *http.Request, nurl string) Result {
 		if r.Header["P-Portal"] == nil {
@@ -212,7 +215,7 @@ func init() {
 			}
 			r.Host = r.URL.Host
 			r.Header["P-Portal"] = []string{"1"}
-			r.Header["P-Internal"] = []string{"1"}
+			markInternal(r, IdentityDirective)
 
 			ctx := r.Context()
 			session := ClientSessionFromContext(ctx)
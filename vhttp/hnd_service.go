@@ -19,7 +19,7 @@ import (
 
 type StateResolver interface {
 	ResolveService(s string) Handler
-	ResolveNats() *enats.Client
+	ResolveNats() *enats.Gateway
 }
 type stateResolver struct{}
 
@@ -36,7 +36,7 @@ func ResolveServiceFromContext(ctx context.Context, s string) Handler {
 	}
 	return nil
 }
-func ResolveNatsFromContext(ctx context.Context) *enats.Client {
+func ResolveNatsFromContext(ctx context.Context) *enats.Gateway {
 	if r := StateResolverFromContext(ctx); r != nil {
 		return r.ResolveNats()
 	}
@@ -102,6 +102,16 @@ func (h HandlePublish) ServeHTTP(w http.ResponseWriter, r *http.Request) Result
 	msg.Header["Referer"] = []string{r.URL.String()}
 	msg.Header["X-Forwarded-Method"] = []string{r.Method}
 
+	// Consume the quota of the topic.
+	if err := cli.CheckPublish(r.Context(), h.topic); err != nil {
+		if r.Context().Err() != nil {
+			return Done
+		}
+		xlog.WarnC(r.Context()).Str("topic", h.topic).Err(err).Msg("Publish rate limit exceeded")
+		Error(w, r, http.StatusTooManyRequests)
+		return Done
+	}
+
 	// If beacon, publish and return
 	if h.beacon {
 		err := cli.PublishMsg(msg)
@@ -114,13 +124,18 @@ func (h HandlePublish) ServeHTTP(w http.ResponseWriter, r *http.Request) Result
 		return Done
 	}
 
-	// Otherwise, request message
-	deadline, ok := r.Context().Deadline()
-	if !ok {
-		deadline = time.Now().Add(30 * time.Second)
+	// Otherwise, request message, cancelling it if the client goes away.
+	ctx := r.Context()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
 	}
-	res, err := cli.RequestMsg(msg, time.Until(deadline))
+	res, err := cli.RequestMsgWithContext(ctx, msg)
 	if err != nil {
+		if r.Context().Err() != nil {
+			return Done
+		}
 		xlog.WarnC(r.Context()).Str("topic", h.topic).Err(err).Msg("Failed to publish message")
 		Error(w, r, StatusPublishError)
 	} else {
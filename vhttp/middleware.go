@@ -0,0 +1,92 @@
+package vhttp
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"get.pme.sh/pmesh/netx"
+)
+
+// Hooks run on every new server, see OnServer.
+var (
+	serverHooksMu sync.Mutex
+	serverHooks   []func(s *Server)
+)
+
+// OnServer registers a function run on every server created afterwards, before it serves any request.
+// It is the extension point of the programs embedding pmesh, which register their middleware from it:
+//
+//	func main() {
+//		vhttp.OnServer(func(s *vhttp.Server) {
+//			s.Use("example.com/internal/", vhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) vhttp.Result {
+//				if r.Header.Get("X-Token") != os.Getenv("TOKEN") {
+//					vhttp.Error(w, r, http.StatusForbidden)
+//					return vhttp.Done
+//				}
+//				return vhttp.Continue
+//			}))
+//		})
+//		cmd.Execute()
+//	}
+//
+// The handler types usable from the manifest are registered with Registry.Define instead.
+func OnServer(hook func(s *Server)) {
+	serverHooksMu.Lock()
+	defer serverHooksMu.Unlock()
+	serverHooks = append(serverHooks, hook)
+}
+func runServerHooks(s *Server) {
+	serverHooksMu.Lock()
+	hooks := slices.Clone(serverHooks)
+	serverHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(s)
+	}
+}
+
+// Use registers a handler run on the requests matching the pattern (see NewPattern) before the
+// virtual hosts of the manifest, in the order of registration. Returning Done ends the request and
+// Drop resets its connection, otherwise it goes on to the next handler and finally the manifest.
+func (s *Server) Use(pattern string, h Handler) error {
+	p, err := NewPattern(pattern)
+	if err != nil {
+		return err
+	}
+	for {
+		prev := s.middleware.Load()
+		next := &Mux{}
+		if prev != nil {
+			next.Routes = slices.Clone(prev.Routes)
+		}
+		next.UsePattern(p, h)
+		if s.middleware.CompareAndSwap(prev, next) {
+			return nil
+		}
+	}
+}
+
+// Runs the middleware, returns true if it handled the request.
+func (s *Server) serveMiddleware(w http.ResponseWriter, r *http.Request) bool {
+	mux := s.middleware.Load()
+	if mux == nil {
+		return false
+	}
+	// Not through Mux.ServeHTTP, which turns Drop into Continue.
+	for _, route := range mux.Routes {
+		if !route.Pattern.Match(r.URL.Host, r.URL.Path) {
+			continue
+		}
+		switch route.Handler.ServeHTTP(w, r) {
+		case Done:
+			if t := RequestTimingFromContext(r.Context()); t != nil && t.Route == "" {
+				t.Route = route.Pattern.String()
+			}
+			return true
+		case Drop:
+			netx.ResetRequestConn(w)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package vhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerMiddleware(t *testing.T) {
+	s := &Server{}
+	var calls []string
+	use := func(pattern, name string, res Result) {
+		err := s.Use(pattern, HandlerFunc(func(w http.ResponseWriter, r *http.Request) Result {
+			calls = append(calls, name)
+			if res == Done {
+				w.WriteHeader(http.StatusTeapot)
+			}
+			return res
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	use("_", "continue", Continue)
+	use("test/done/", "done", Done)
+	use("test/drop/", "drop", Drop)
+	use("other/done/", "other", Done)
+	use("test/", "last", Continue)
+
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Host = "test"
+		if !s.serveMiddleware(w, r) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(front.Close)
+	// No reused connections, the transport retries the requests failing on those.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	tests := []struct {
+		path   string
+		status int
+		calls  []string
+	}{
+		{"/other", http.StatusNotFound, []string{"continue", "last"}},
+		{"/done/x", http.StatusTeapot, []string{"continue", "done"}},
+		{"/drop/x", 0, []string{"continue", "drop"}},
+	}
+	for _, tt := range tests {
+		calls = nil
+		res, err := client.Get(front.URL + tt.path)
+		if tt.status == 0 {
+			if err == nil {
+				res.Body.Close()
+				t.Errorf("%s: got %d, want the connection reset", tt.path, res.StatusCode)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		} else {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Errorf("%s: got %d, want %d", tt.path, res.StatusCode, tt.status)
+			}
+		}
+		if len(calls) != len(tt.calls) {
+			t.Errorf("%s: called %v, want %v", tt.path, calls, tt.calls)
+			continue
+		}
+		for i := range calls {
+			if calls[i] != tt.calls[i] {
+				t.Errorf("%s: called %v, want %v", tt.path, calls, tt.calls)
+				break
+			}
+		}
+	}
+
+	// Scoped to the server.
+	if (&Server{}).middleware.Load() != nil {
+		t.Error("middleware shared between servers")
+	}
+}
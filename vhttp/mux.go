@@ -36,6 +36,9 @@ func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) Result {
 		if route.Pattern.Match(r.URL.Host, r.URL.Path) {
 			switch route.Handler.ServeHTTP(w, r) {
 			case Done:
+				if t := RequestTimingFromContext(r.Context()); t != nil && t.Route == "" {
+					t.Route = route.Pattern.String()
+				}
 				return Done
 			case Drop:
 				return Continue
@@ -8,7 +8,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -29,14 +28,22 @@ type Server struct {
 	logger               *xlog.Logger
 	Server               http.Server
 	ipInfoProvider       atomic.Pointer[ipinfoWrapper]
+	ipInfoAsync          atomic.Bool
+	proxyRules           atomic.Pointer[netx.ProxyRules]
 	errTemplatesOverride atomic.Pointer[template.Template]
+	middleware           atomic.Pointer[Mux]
 
 	TopLevelMux
 
-	killed       atomic.Bool
-	wg           sync.WaitGroup
-	listenerInfo netx.ListenerInfo
-	Signer       *urlsigner.Signer
+	killed        atomic.Bool
+	held          atomic.Bool
+	cordoned      atomic.Bool
+	slowThreshold atomic.Int64
+	wg            sync.WaitGroup
+	listenerInfo  netx.ListenerInfo
+	listeners     map[string]net.Listener
+	Signer        *urlsigner.Signer
+	taps          tapRegistry
 }
 
 func (s *Server) Value(key any) any {
@@ -47,11 +54,28 @@ func (s *Server) Value(key any) any {
 }
 
 func (s *Server) GetIPInfoProvider() netx.IPInfoProvider {
-	return s.ipInfoProvider.Load()
+	return s.ipInfoProvider.Load().IPInfoProvider
 }
 func (s *Server) SetIPInfoProvider(provider netx.IPInfoProvider) {
 	s.ipInfoProvider.Store(&ipinfoWrapper{provider})
 }
+
+// SetIPInfoAsync sets whether the IP info of a new client is looked up in the background rather than
+// before serving its first request, which then carries neither its country nor its ASN.
+func (s *Server) SetIPInfoAsync(async bool) {
+	s.ipInfoAsync.Store(async)
+}
+
+// SetProxyRules sets the headers trusted for the address of the clients, nil restores the defaults.
+func (s *Server) SetProxyRules(rules *netx.ProxyRules) {
+	s.proxyRules.Store(rules)
+}
+func (s *Server) getProxyRules() *netx.ProxyRules {
+	if rules := s.proxyRules.Load(); rules != nil {
+		return rules
+	}
+	return netx.DefaultProxyRules
+}
 func (s *Server) SetErrorTemplates(t *template.Template) {
 	s.errTemplatesOverride.Store(t)
 }
@@ -63,8 +87,16 @@ func GetServerFromContext(ctx context.Context) *Server {
 	return sv
 }
 
-// ServeHTTP implements http.Handler.
+// ServeHTTPSession serves the request for the given client session.
 func (s *Server) ServeHTTPSession(w http.ResponseWriter, r *http.Request, session *ClientSession) {
+	// Only pay for the taps if there are any.
+	if s.taps.active.Load() != 0 {
+		s.serveTapped(w, r, session)
+	} else {
+		s.serveHTTPSession(w, r, session)
+	}
+}
+func (s *Server) serveHTTPSession(w http.ResponseWriter, r *http.Request, session *ClientSession) {
 	t0 := time.Now()
 	ordered, _ := s.TopLevelMux.getGroups()
 	logger := xlog.Ctx(r.Context())
@@ -73,10 +105,15 @@ func (s *Server) ServeHTTPSession(w http.ResponseWriter, r *http.Request, sessio
 		logger.Debug().EmbedObject(xlog.EnhanceRequest(r)).Msg("Request")
 	}
 
-	// Walk through each group, and try to handle the request.
-	handled := false
+	r, timing := s.startTiming(r)
+
+	// Run the middleware, then walk through each group, and try to handle the request.
+	handled := s.serveMiddleware(w, r)
 selector:
 	for _, group := range ordered {
+		if handled {
+			break
+		}
 		switch group.ServeHTTP(w, r) {
 		case Done:
 			handled = true
@@ -87,11 +124,14 @@ selector:
 			break selector
 		}
 	}
-	logger.Trace().EmbedObject(xlog.EnhanceRequest(r)).Msgf("Request took %s", time.Since(t0))
+	elapsed := time.Since(t0)
+	logger.Trace().EmbedObject(xlog.EnhanceRequest(r)).Msgf("Request took %s", elapsed)
 
 	// If no match, 404
 	if !handled {
 		Error(w, r, http.StatusNotFound)
+	} else if timing != nil {
+		s.logSlow(w, r, timing, elapsed)
 	}
 }
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -99,9 +139,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Host = r.Host[:idx]
 	}
 
-	// Reset the connection if the host is not in the list.
+	// Reset the connection if the host is not in the list, unless held as the hosts may not be loaded yet.
 	_, unique := s.TopLevelMux.getGroups()
-	if !hosts.TestMap(unique, r.Host) {
+	if !hosts.TestMap(unique, r.Host) && !s.held.Load() {
 		r.URL.Host = r.Host
 		xlog.WarnC(r.Context()).EmbedObject(xlog.EnhanceRequest(r)).Msg("Resetting connection, no matching host")
 		netx.ResetRequestConn(w)
@@ -109,7 +149,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Start the request.
-	r, session := StartClientRequest(r, s.GetIPInfoProvider())
+	r, session := StartClientRequest(r, s.getProxyRules(), s.GetIPInfoProvider(), s.ipInfoAsync.Load())
 	if session == nil {
 		panic(http.ErrAbortHandler)
 	}
@@ -137,8 +177,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.URL.Path = CleanPath(originalPath)
 	r.URL.Host = r.Host
 
-	// Guard against panics.
+	// Account the bytes transferred to the client, hijacked connections excluded.
 	cw := NewConditionalResponse(w)
+	body := NewCountingBody(r)
+	defer func() {
+		session.BytesIn.Add(uint64(body.Count()))
+		session.BytesOut.Add(uint64(cw.Written))
+	}()
+
+	// Guard against panics.
 	defer func() {
 		if err := recover(); err == http.ErrAbortHandler {
 			panic(err)
@@ -158,11 +205,28 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Error(cw, r, StatusSignatureError)
 		return
 	} else if signed {
-		r.Header["P-Internal"] = []string{"1"}
+		markInternal(r, IdentitySigned)
+	}
+
+	// Hold external traffic until the node is ready, shed it while cordoned. The configured users
+	// still reach the management API, to release the node.
+	if (s.held.Load() || s.cordoned.Load()) && r.Header.Get("P-Internal") != "1" && !isUserAPIRequest(r) {
+		if s.cordoned.Load() {
+			// Close the connection so that the client reconnects through another node.
+			w.Header()["Connection"] = []string{"close"}
+		}
+		w.Header()["Retry-After"] = []string{"5"}
+		Error(cw, r, http.StatusServiceUnavailable)
+		return
 	}
 	s.ServeHTTPSession(cw, r, session)
 }
 
+// Returns true if the request of a configured user is destined to the management API.
+func isUserAPIRequest(r *http.Request) bool {
+	return RequestUser(r) != "" && (r.Host == "pm3" || strings.HasSuffix(r.Host, ".pm3"))
+}
+
 // NewServer returns a new Server.
 func NewServer(ctx context.Context) (s *Server) {
 	logger := xlog.NewDomain("http")
@@ -186,15 +250,20 @@ func NewServer(ctx context.Context) (s *Server) {
 			return s.Context
 		},
 		ErrorLog: log.New(logf, "", 0),
-		TLSConfig: mauth.WrapServer(&tls.Config{
-			GetCertificate:           s.GetCertificate,
-			PreferServerCipherSuites: true,
-			CurvePreferences:         []tls.CurveID{tls.CurveP256, tls.X25519},
-			NextProtos:               []string{"h2", "http/1.1", acme.ALPNProto},
-		}),
 	}
+	base := &tls.Config{
+		GetCertificate:           s.GetCertificate,
+		PreferServerCipherSuites: true,
+		CurvePreferences:         []tls.CurveID{tls.CurveP256, tls.X25519},
+		NextProtos:               []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+	base.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		return s.GetConfigForClient(chi, base)
+	}
+	s.Server.TLSConfig = mauth.WrapServer(base)
 	s.Server.RegisterOnShutdown(func() { logw.Flush() })
 	s.SetIPInfoProvider(netx.NullIPInfoProvider)
+	runServerHooks(s)
 	return
 }
 
@@ -249,11 +318,16 @@ func (s *Server) SetHosts(vhosts ...*VirtualHost) {
 	s.TopLevelMux.SetHosts(vhosts...)
 	s.addLocalhostMappings(s.TopLevelMux.Hostnames()...)
 }
+
+// Listen starts serving on the public ports. Listeners passed by the service manager through socket
+// activation (LISTEN_FDS, named "http" and "https" or otherwise matched by port) are used instead of
+// binding, so that a restart does not refuse connections. So are the listeners taken over from the
+// previous node, see netx.RequestHandoff. Without either, the ports are bound as usual.
 func (s *Server) Listen() (err error) {
 	var http, https net.Listener
 
 	if *config.HttpPort > 0 {
-		http, err = net.Listen("tcp", net.JoinHostPort(*config.BindAddr, strconv.Itoa(*config.HttpPort)))
+		http, err = netx.Listen(netx.ListenerHTTP, *config.BindAddr, *config.HttpPort)
 		if err != nil {
 			return
 		}
@@ -265,7 +339,7 @@ func (s *Server) Listen() (err error) {
 	}
 
 	if *config.HttpsPort > 0 {
-		https, err = net.Listen("tcp", net.JoinHostPort(*config.BindAddr, strconv.Itoa(*config.HttpsPort)))
+		https, err = netx.Listen(netx.ListenerHTTPS, *config.BindAddr, *config.HttpsPort)
 		if err != nil {
 			return
 		}
@@ -280,17 +354,62 @@ func (s *Server) Listen() (err error) {
 		return
 	}
 
+	s.listeners = map[string]net.Listener{}
+	if http != nil {
+		s.listeners[netx.ListenerHTTP] = http
+	}
+	if https != nil {
+		s.listeners[netx.ListenerHTTPS] = https
+	}
 	s.listenerInfo = netx.QueryListener(cmp.Or(http, https))
 	xlog.InfoC(s).Stringer("local", s.listenerInfo.LocalAddr).Stringer("out", s.listenerInfo.OutboundAddr).Msg("Server starting")
 	s.addLocalhostMappings(s.TopLevelMux.Hostnames()...)
 
+	if http != nil {
+		http = netx.GuardListener(http)
+	}
+	if https != nil {
+		https = netx.GuardListener(https)
+	}
 	s.serveHttp(http)
 	s.serveHttps(https)
 	return
 }
+
+// Listeners returns the public listeners by name, for handing them off to the next node.
+func (s *Server) Listeners() map[string]net.Listener {
+	return s.listeners
+}
 func (s *Server) Wait() {
 	s.wg.Wait()
 }
+
+// Hold makes the server respond 503 to external traffic until Release is called,
+// internal requests are still served.
+func (s *Server) Hold() {
+	s.held.Store(true)
+}
+func (s *Server) Release() {
+	if s.held.Swap(false) {
+		s.logger.Info().Msg("Accepting external traffic")
+	}
+}
+func (s *Server) IsHeld() bool {
+	return s.held.Load()
+}
+
+// Cordon makes the server shed new external traffic until Uncordon is called, the requests in
+// flight complete normally and internal requests are still served. Returns false if it already was.
+func (s *Server) Cordon() bool {
+	return !s.cordoned.Swap(true)
+}
+func (s *Server) Uncordon() bool {
+	return s.cordoned.Swap(false)
+}
+func (s *Server) IsCordoned() bool {
+	return s.cordoned.Load()
+}
+
 func (s *Server) Close() error {
 	s.killed.Store(true)
 	return s.Server.Close()
@@ -0,0 +1,90 @@
+package vhttp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// RequestTiming breaks down where the time of a request was spent, it is only attached to the
+// request context when the slow request log is enabled.
+type RequestTiming struct {
+	Route        string        // Route that handled the request.
+	Upstream     string        // Last upstream the request was sent to.
+	UpstreamTime time.Duration // Time spent waiting for the upstream response headers, across attempts.
+	Attempts     int           // Number of upstream attempts.
+}
+
+type requestTimingKey struct{}
+
+func RequestTimingFromContext(ctx context.Context) *RequestTiming {
+	t, _ := ctx.Value(requestTimingKey{}).(*RequestTiming)
+	return t
+}
+
+// Records an attempt to an upstream that took d until the response headers (or the error).
+func (t *RequestTiming) RecordUpstream(upstream string, d time.Duration) {
+	t.Upstream = upstream
+	t.UpstreamTime += d
+	t.Attempts++
+}
+
+var slowLogger = sync.OnceValue(func() *xlog.Logger {
+	return xlog.NewDomain("http.slow")
+})
+
+// SetSlowRequestThreshold logs the requests taking longer than d at warning level, zero disables it.
+func (s *Server) SetSlowRequestThreshold(d time.Duration) {
+	s.slowThreshold.Store(int64(d))
+}
+
+// Returns the request with the timing attached if the slow request log is enabled.
+func (s *Server) startTiming(r *http.Request) (*http.Request, *RequestTiming) {
+	if s.slowThreshold.Load() <= 0 {
+		return r, nil
+	}
+	t := &RequestTiming{}
+	return r.WithContext(context.WithValue(r.Context(), requestTimingKey{}, t)), t
+}
+
+func (s *Server) logSlow(w http.ResponseWriter, r *http.Request, t *RequestTiming, elapsed time.Duration) {
+	threshold := time.Duration(s.slowThreshold.Load())
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+
+	// Long-lived connections and streams are slow by design.
+	status := 0
+	for w != nil {
+		if cr, ok := w.(*ConditionalResponse); ok {
+			status = cr.Status
+			break
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	if status == http.StatusSwitchingProtocols {
+		return
+	}
+	if ct := w.Header().Get("Content-Type"); strings.HasPrefix(ct, "text/event-stream") {
+		return
+	}
+
+	evt := slowLogger().Warn().EmbedObject(xlog.EnhanceResponse(r, status)).
+		Dur("total", elapsed).
+		Str("route", t.Route)
+	if t.Attempts != 0 {
+		evt = evt.Str("upstream", t.Upstream).
+			Int("attempts", t.Attempts).
+			Dur("proxy", t.UpstreamTime).
+			Dur("queue", elapsed-t.UpstreamTime)
+	}
+	evt.Msg("Slow request")
+}
@@ -0,0 +1,68 @@
+package vhttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/netx"
+)
+
+// HdrSteered is set on the requests a node forwards to a peer on behalf of their client. It carries
+// the client address and identity signed with the cluster secret, see SteerRequest.
+var HdrSteered = http.CanonicalHeaderKey("P-Steered")
+
+const steeredTTL = time.Minute
+
+func signSteered(payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.Get().Secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SteerRequest prepares the clone of a client request to be sent to a peer. The peer trusts the
+// connections of the other nodes, so the trust of the client is carried in the signed header instead.
+func SteerRequest(req *http.Request) {
+	ip := ""
+	if cs := ClientSessionFromContext(req.Context()); cs != nil {
+		ip = cs.IP.String()
+	}
+	payload := ip + ";" + strconv.FormatInt(time.Now().Add(steeredTTL).Unix(), 10) + ";" + RequestIdentity(req)
+	req.Header[HdrSteered] = []string{payload + ";" + signSteered(payload)}
+	delete(req.Header, "P-Internal")
+	delete(req.Header, HdrIdentity)
+}
+
+// Returns the client of a request steered by a peer, ok is false if the header is not valid.
+func verifySteered(value string) (ip netx.IP, identity string, ok bool) {
+	i := strings.LastIndexByte(value, ';')
+	if i < 0 {
+		return
+	}
+	payload, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signSteered(payload))) {
+		return
+	}
+	fields := strings.SplitN(payload, ";", 3)
+	if len(fields) != 3 {
+		return
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return
+	}
+	if ip = netx.ParseIP(fields[0]); ip.IsZero() {
+		return
+	}
+	return ip, fields[2], true
+}
+
+// IsSteered returns true if the request was steered to this node by a peer.
+func IsSteered(r *http.Request) bool {
+	return r.Header.Get(HdrSteered) != ""
+}
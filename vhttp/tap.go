@@ -0,0 +1,174 @@
+package vhttp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"get.pme.sh/pmesh/netx"
+	"github.com/samber/lo"
+)
+
+// TapEvent is the metadata of a served request, streamed to taps.
+type TapEvent struct {
+	Time     time.Time `json:"time"`              // Time the request started.
+	Ray      string    `json:"ray,omitempty"`     // Ray ID.
+	Host     string    `json:"host"`              // Host.
+	Method   string    `json:"method"`            // Method.
+	Path     string    `json:"path"`              // Path.
+	Status   int       `json:"status"`            // Response status.
+	Duration float64   `json:"duration"`          // Duration in milliseconds.
+	IP       string    `json:"ip,omitempty"`      // Client IP.
+	Dropped  uint32    `json:"dropped,omitempty"` // Number of events dropped before this one.
+}
+
+// TapOptions selects the requests a tap receives.
+type TapOptions struct {
+	Host   string `json:"host,omitempty"`   // Host to match, empty for any.
+	Prefix string `json:"prefix,omitempty"` // Path prefix to match, empty for any.
+	Rate   int    `json:"rate,omitempty"`   // Maximum number of events per second, defaults to 100.
+}
+
+const TapDefaultRate = 100
+
+// Tap is a subscription to the request stream of a server.
+type Tap struct {
+	TapOptions
+	Events <-chan TapEvent
+
+	ch      chan TapEvent
+	window  atomic.Int64 // Current window (unix seconds).
+	count   atomic.Int32 // Number of events in the current window.
+	dropped atomic.Uint32
+}
+
+func (t *Tap) matches(r *http.Request) bool {
+	if t.Host != "" && !strings.EqualFold(t.Host, r.Host) {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, t.Prefix)
+}
+func (t *Tap) push(ev TapEvent) {
+	now := ev.Time.Unix()
+	if w := t.window.Load(); w != now && t.window.CompareAndSwap(w, now) {
+		t.count.Store(0)
+	}
+	if t.count.Add(1) > int32(t.Rate) {
+		t.dropped.Add(1)
+		return
+	}
+	ev.Dropped = t.dropped.Swap(0)
+	select {
+	case t.ch <- ev:
+	default:
+		t.dropped.Add(ev.Dropped + 1)
+	}
+}
+
+type tapRegistry struct {
+	mu     sync.RWMutex
+	taps   []*Tap
+	active atomic.Int32
+}
+
+// Tap subscribes to the request stream, the returned function must be called to unsubscribe.
+func (s *Server) Tap(opts TapOptions) (*Tap, func()) {
+	if opts.Rate <= 0 {
+		opts.Rate = TapDefaultRate
+	}
+	ch := make(chan TapEvent, opts.Rate)
+	t := &Tap{TapOptions: opts, Events: ch, ch: ch}
+
+	s.taps.mu.Lock()
+	s.taps.taps = append(s.taps.taps, t)
+	s.taps.active.Add(1)
+	s.taps.mu.Unlock()
+
+	once := sync.Once{}
+	return t, func() {
+		once.Do(func() {
+			s.taps.mu.Lock()
+			s.taps.taps = lo.Without(s.taps.taps, t)
+			s.taps.active.Add(-1)
+			s.taps.mu.Unlock()
+		})
+	}
+}
+
+// Serves the request while recording its metadata for the matching taps.
+func (s *Server) serveTapped(w http.ResponseWriter, r *http.Request, session *ClientSession) {
+	s.taps.mu.RLock()
+	var matching []*Tap
+	for _, t := range s.taps.taps {
+		if t.matches(r) {
+			matching = append(matching, t)
+		}
+	}
+	s.taps.mu.RUnlock()
+	if len(matching) == 0 {
+		s.serveHTTPSession(w, r, session)
+		return
+	}
+
+	ev := TapEvent{
+		Time:   time.Now(),
+		Host:   r.Host,
+		Method: r.Method,
+		Path:   r.URL.Path,
+		IP:     session.IP.String(),
+	}
+	if ray := r.Header[netx.HdrRay]; len(ray) != 0 {
+		ev.Ray = ray[0]
+	}
+	tw := &tapResponse{rw: w}
+	defer func() {
+		ev.Status = tw.status
+		if ev.Status == 0 {
+			ev.Status = http.StatusOK
+		}
+		ev.Duration = float64(time.Since(ev.Time).Microseconds()) / 1000
+		for _, t := range matching {
+			t.push(ev)
+		}
+	}()
+	s.serveHTTPSession(tw, r, session)
+}
+
+type tapResponse struct {
+	rw     http.ResponseWriter
+	status int
+}
+
+func (tr *tapResponse) Header() http.Header {
+	return tr.rw.Header()
+}
+func (tr *tapResponse) Write(b []byte) (int, error) {
+	if tr.status == 0 {
+		tr.status = http.StatusOK
+	}
+	return tr.rw.Write(b)
+}
+func (tr *tapResponse) WriteHeader(status int) {
+	// Informational responses (100 Continue, 103 Early Hints) precede the final status.
+	if tr.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		tr.status = status
+	}
+	tr.rw.WriteHeader(status)
+}
+func (tr *tapResponse) Unwrap() http.ResponseWriter {
+	return tr.rw
+}
+func (tr *tapResponse) Flush() {
+	http.NewResponseController(tr.rw).Flush()
+}
+func (tr *tapResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, e := http.NewResponseController(tr.rw).Hijack()
+	if e == nil && tr.status == 0 {
+		tr.status = http.StatusSwitchingProtocols
+	}
+	return c, rw, e
+}
@@ -0,0 +1,71 @@
+package vhttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTapIgnoresInformational(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(upstream.Close)
+	target, _ := url.Parse(upstream.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	recorded := make(chan int, 1)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &tapResponse{rw: w}
+		proxy.ServeHTTP(tw, r)
+		recorded <- tw.status
+	}))
+	t.Cleanup(front.Close)
+
+	conn, err := net.DialTimeout("tcp", front.Listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	const size = 1 << 16
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", size)
+	rd := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusContinue {
+		t.Fatalf("got %d, want 100", res.StatusCode)
+	}
+	if _, err := io.WriteString(conn, strings.Repeat("x", size)); err != nil {
+		t.Fatal(err)
+	}
+	for res.StatusCode == http.StatusContinue {
+		if res, err = http.ReadResponse(rd, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d, want 201", res.StatusCode)
+	}
+	select {
+	case status := <-recorded:
+		if status != http.StatusCreated {
+			t.Errorf("tap recorded %d, want 201", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return")
+	}
+}
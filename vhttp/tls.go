@@ -0,0 +1,122 @@
+package vhttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+
+	"get.pme.sh/pmesh/hosts"
+	"golang.org/x/crypto/acme"
+	"gopkg.in/yaml.v3"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSOptions overrides the TLS settings of the server for a virtual host.
+type TLSOptions struct {
+	MinVersion   string   `yaml:"min_version,omitempty"` // Minimum TLS version, e.g. "1.2".
+	MaxVersion   string   `yaml:"max_version,omitempty"` // Maximum TLS version, e.g. "1.3".
+	CipherSuites []string `yaml:"ciphers,omitempty"`     // Cipher suites for TLS 1.2 and below, by their standard names.
+	ALPN         []string `yaml:"alpn,omitempty"`        // Application protocols, in order of preference.
+
+	minVersion, maxVersion uint16
+	cipherSuites           []uint16
+}
+
+func (o *TLSOptions) UnmarshalYAML(node *yaml.Node) error {
+	type plain TLSOptions
+	if err := node.Decode((*plain)(o)); err != nil {
+		return err
+	}
+	return o.validate()
+}
+
+func (o *TLSOptions) validate() error {
+	var ok bool
+	if o.MinVersion != "" {
+		if o.minVersion, ok = tlsVersions[o.MinVersion]; !ok {
+			return fmt.Errorf("invalid tls min_version: %q", o.MinVersion)
+		}
+	}
+	if o.MaxVersion != "" {
+		if o.maxVersion, ok = tlsVersions[o.MaxVersion]; !ok {
+			return fmt.Errorf("invalid tls max_version: %q", o.MaxVersion)
+		}
+	}
+	if o.minVersion != 0 && o.maxVersion != 0 && o.minVersion > o.maxVersion {
+		return errors.New("tls min_version is greater than max_version")
+	}
+
+	o.cipherSuites = nil
+	if len(o.CipherSuites) != 0 {
+		if o.minVersion == tls.VersionTLS13 {
+			return errors.New("tls cipher suites are not configurable for TLS 1.3")
+		}
+		for _, name := range o.CipherSuites {
+			idx := slices.IndexFunc(tls.CipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name })
+			if idx < 0 {
+				if slices.ContainsFunc(tls.InsecureCipherSuites(), func(cs *tls.CipherSuite) bool { return cs.Name == name }) {
+					return fmt.Errorf("insecure tls cipher suite: %q", name)
+				}
+				return fmt.Errorf("unknown tls cipher suite: %q", name)
+			}
+			o.cipherSuites = append(o.cipherSuites, tls.CipherSuites()[idx].ID)
+		}
+	}
+	for _, proto := range o.ALPN {
+		if proto != "h2" && proto != "http/1.1" {
+			return fmt.Errorf("unsupported alpn protocol: %q", proto)
+		}
+	}
+	return nil
+}
+
+// Returns a copy of the base configuration with the overrides applied.
+func (o *TLSOptions) Apply(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	if o.minVersion != 0 {
+		cfg.MinVersion = o.minVersion
+	}
+	if o.maxVersion != 0 {
+		cfg.MaxVersion = o.maxVersion
+	}
+	if o.cipherSuites != nil {
+		cfg.CipherSuites = o.cipherSuites
+	}
+	if len(o.ALPN) != 0 {
+		// Keep the ACME protocol so that certificates can still be obtained.
+		cfg.NextProtos = append(slices.Clone(o.ALPN), acme.ALPNProto)
+	}
+	return cfg
+}
+
+// GetConfigForClient returns the TLS configuration of the virtual host matching the SNI,
+// or nil if it does not override the base configuration.
+func (mux *TopLevelMux) GetConfigForClient(chi *tls.ClientHelloInfo, base *tls.Config) (*tls.Config, error) {
+	if chi.ServerName == "" {
+		return nil, nil
+	}
+	_, unique := mux.getGroups()
+	_, _, vhg := hosts.MatchMap(unique, chi.ServerName)
+	if vhg == nil {
+		return nil, nil
+	}
+	for _, vh := range vhg.hosts {
+		if vh.TLS == nil {
+			continue
+		}
+		if cfg := vh.tlsConfig.Load(); cfg != nil {
+			return cfg, nil
+		}
+		cfg := vh.TLS.Apply(base)
+		vh.tlsConfig.Store(cfg)
+		return cfg, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,92 @@
+package vhttp
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"get.pme.sh/pmesh/xlog"
+)
+
+// MaxSessionValues is the number of values a client session can hold, storing past it clears them.
+// The handlers store a bounded number of values per route, so crossing it means a leak.
+var MaxSessionValues = 1024
+
+// SessionValues is the state kept by the handlers per client, such as the rate limit counters and
+// the sticky upstreams, accounting for the number of entries.
+type SessionValues struct {
+	m sync.Map
+	n atomic.Int32
+}
+
+// Accounts for a new entry, clearing the values if over the limit.
+func (v *SessionValues) added() {
+	if n := v.n.Add(1); MaxSessionValues > 0 && int(n) > MaxSessionValues {
+		cleared := v.Clear()
+		xlog.Warn().Int("count", cleared).Msg("Client session values over the limit, cleared")
+	}
+}
+
+func (v *SessionValues) Load(key any) (value any, ok bool) {
+	return v.m.Load(key)
+}
+func (v *SessionValues) LoadOrStore(key, value any) (actual any, loaded bool) {
+	actual, loaded = v.m.LoadOrStore(key, value)
+	if !loaded {
+		v.added()
+	}
+	return
+}
+func (v *SessionValues) Store(key, value any) {
+	if _, loaded := v.m.Swap(key, value); !loaded {
+		v.added()
+	}
+}
+func (v *SessionValues) Delete(key any) {
+	if _, loaded := v.m.LoadAndDelete(key); loaded {
+		v.n.Add(-1)
+	}
+}
+
+// CompareAndSwap swaps the value of the key if it is old, nil standing for no value so that
+// entries can be created and removed atomically.
+func (v *SessionValues) CompareAndSwap(key, old, new any) bool {
+	switch {
+	case old == nil && new == nil:
+		_, loaded := v.m.Load(key)
+		return !loaded
+	case old == nil:
+		_, loaded := v.m.LoadOrStore(key, new)
+		if !loaded {
+			v.added()
+		}
+		return !loaded
+	case new == nil:
+		if v.m.CompareAndDelete(key, old) {
+			v.n.Add(-1)
+			return true
+		}
+		return false
+	default:
+		return v.m.CompareAndSwap(key, old, new)
+	}
+}
+func (v *SessionValues) Range(f func(key, value any) bool) {
+	v.m.Range(f)
+}
+
+// Len returns the number of values.
+func (v *SessionValues) Len() int {
+	return int(v.n.Load())
+}
+
+// Clear removes all the values, returning the number removed.
+func (v *SessionValues) Clear() (n int) {
+	v.m.Range(func(key, _ any) bool {
+		if _, loaded := v.m.LoadAndDelete(key); loaded {
+			v.n.Add(-1)
+			n++
+		}
+		return true
+	})
+	return
+}
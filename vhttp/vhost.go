@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -61,11 +62,13 @@ type VirtualHostOptions struct {
 	Hostnames []string                 `yaml:"-"`
 	NoUpgrade bool                     `yaml:"no_upgrade,omitempty"` // Do not upgrade HTTP to HTTPS.
 	Certs     map[string]*CertProvider `yaml:"certs,omitempty"`      // TLS certificates.
+	TLS       *TLSOptions              `yaml:"tls,omitempty"`        // TLS overrides.
 }
 
 type VirtualHost struct {
 	VirtualHostOptions
 	Mux
+	tlsConfig atomic.Pointer[tls.Config]
 }
 
 func NewVirtualHost(opt VirtualHostOptions) (vh *VirtualHost) {
@@ -111,7 +114,7 @@ func (vh *virtualHostGroup) ServeHTTP(w http.ResponseWriter, r *http.Request) Re
 		if hn := host.Hostnames[0]; hn != prevHostname {
 			buffer.Reset()
 			buffer.WriteString(sub)
-			buffer.WriteString(host.Hostnames[0])
+			buffer.WriteString(strings.TrimPrefix(hn, hosts.WildcardPrefix))
 			prevHostname = hn
 		}
 		r.URL.Host = buffer.String()
@@ -150,6 +153,10 @@ func createGroups(vhosts ...*VirtualHost) *groups {
 			group.add(vh)
 		}
 	}
+	// Try the most specific hostnames first so that exact matches take precedence over wildcards.
+	slices.SortStableFunc(ordered, func(a, b *virtualHostGroup) int {
+		return hosts.Compare(a.hostname, b.hostname)
+	})
 	return &groups{ordered: ordered, unique: unique}
 }
 
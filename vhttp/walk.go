@@ -0,0 +1,36 @@
+package vhttp
+
+// Walk calls fn for the handler and every handler nested in it, depth first. The location
+// lists the patterns of the routes leading to the handler.
+func Walk(h Handler, fn func(location []string, h Handler)) {
+	walk(nil, h, fn)
+}
+
+func (mux *Mux) walk(location []string, fn func([]string, Handler)) {
+	for _, route := range mux.Routes {
+		walk(append(location, route.Pattern.String()), route.Handler, fn)
+	}
+}
+
+func walk(location []string, h Handler, fn func([]string, Handler)) {
+	switch h := h.(type) {
+	case nil:
+		return
+	case Subhandler:
+		walk(location, h.Handler, fn)
+		return
+	case *Subhandler:
+		walk(location, h.Handler, fn)
+		return
+	}
+	fn(location, h)
+	switch h := h.(type) {
+	case *HandleMux:
+		h.Mux.walk(location, fn)
+	case *HandleSwitch:
+		h.Mux.walk(location, fn)
+	}
+}
+
+// Name returns the name of the service the handler forwards to.
+func (h HandleService) Name() string { return h.name }
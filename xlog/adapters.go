@@ -4,41 +4,81 @@ import (
 	"bytes"
 	"io"
 	"log/slog"
+	"sync"
 
 	"get.pme.sh/pmesh/textproc"
 	"get.pme.sh/pmesh/util"
 
 	slogzerolog "github.com/samber/slog-zerolog/v2"
+	"github.com/valyala/fastjson"
 )
 
+// Lines longer than this are split into multiple log entries.
+const TextAdapterMaxLine = 64 * 1024
+
 type TextAdapter struct {
 	logger       *Logger
 	defaultLevel Level
+	mu           sync.Mutex
 	buf          bytes.Buffer
 	e            *Event
+
+	structured bool            // If set, lines are buffered until the end to be parsed as JSON.
+	level      Level           // Level of the buffered line, if structured.
+	parser     fastjson.Parser // Parser for the structured lines.
 }
 
 func (w *TextAdapter) Write(p []byte) (n int, err error) {
 	return w.WriteLevel(w.defaultLevel, p)
 }
+
+// WriteLevel buffers the text until the line is flushed, it never fails so that the process output
+// keeps being captured even if the log file can not be written to.
 func (w *TextAdapter) WriteLevel(lv Level, p []byte) (n int, err error) {
-	if w.e == nil {
-		e := w.logger.WithLevel(lv)
-		if !e.Enabled() {
-			return len(p), nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n = len(p)
+	for len(p) != 0 {
+		if w.structured {
+			w.level = lv
+		} else if w.e == nil {
+			e := w.logger.WithLevel(lv)
+			if !e.Enabled() {
+				return
+			}
+			w.e = e
+		}
+		chunk := p[:min(len(p), TextAdapterMaxLine-w.buf.Len())]
+		w.buf.Write(chunk)
+		p = p[len(chunk):]
+		if w.buf.Len() >= TextAdapterMaxLine {
+			w.flushLocked()
 		}
-		w.e = e
 	}
-	return w.buf.Write(p)
+	return
 }
 func (w *TextAdapter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushLocked()
+	return nil
+}
+func (w *TextAdapter) flushLocked() {
+	if w.structured {
+		if w.buf.Len() != 0 {
+			if !w.emitJSON(w.buf.Bytes()) {
+				w.logger.WithLevel(w.level).Msg(util.UnsafeString(w.buf.Bytes()))
+			}
+			w.buf.Reset()
+		}
+		return
+	}
 	if e := w.e; e != nil {
 		w.e = nil
 		buf := w.buf.Bytes()
 		e.Msg(util.UnsafeString(buf))
 		w.buf.Reset()
 	}
-	return nil
 }
 
 var textToLine = textproc.NewEncoding().
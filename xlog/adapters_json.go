@@ -0,0 +1,116 @@
+package xlog
+
+import (
+	"io"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// Fields apps commonly use for the level and the message of structured logs.
+var (
+	jsonLevelFields   = []string{"level", "lvl", "severity", "l"}
+	jsonMessageFields = []string{"msg", "message"}
+)
+
+// Fields we set ourselves, dropped from the app's output to avoid duplicates.
+var jsonReservedFields = map[string]struct{}{
+	"t":             {},
+	"l":             {},
+	DomainFieldName: {},
+}
+
+var jsonLevelNames = map[string]Level{
+	"trace":    LevelTrace,
+	"debug":    LevelDebug,
+	"info":     LevelInfo,
+	"notice":   LevelInfo,
+	"warn":     LevelWarn,
+	"warning":  LevelWarn,
+	"error":    LevelError,
+	"err":      LevelError,
+	"critical": LevelFatal,
+	"fatal":    LevelFatal,
+	"panic":    LevelPanic,
+}
+
+// Parses the level of a structured log line, numeric levels are interpreted as zerolog levels
+// if small and as pino/bunyan levels (10 = trace ... 60 = fatal) otherwise.
+func parseJSONLevel(v *fastjson.Value) (Level, bool) {
+	switch v.Type() {
+	case fastjson.TypeString:
+		lvl, ok := jsonLevelNames[strings.ToLower(string(v.GetStringBytes()))]
+		return lvl, ok
+	case fastjson.TypeNumber:
+		n := v.GetInt()
+		if n >= 10 {
+			return Level(min(n/10-2, int(LevelFatal))), true
+		}
+		if n >= int(LevelTrace) && n <= int(LevelPanic) {
+			return Level(n), true
+		}
+	}
+	return LevelNone, false
+}
+
+// Re-emits the buffered line with the app's own level and fields, returns false if it is not a
+// structured log line.
+func (w *TextAdapter) emitJSON(line []byte) bool {
+	v, err := w.parser.ParseBytes(line)
+	if err != nil {
+		return false
+	}
+	obj, err := v.Object()
+	if err != nil {
+		return false
+	}
+	var (
+		lvl   Level
+		found bool
+	)
+	for _, f := range jsonLevelFields {
+		if lv := obj.Get(f); lv != nil {
+			if lvl, found = parseJSONLevel(lv); found {
+				break
+			}
+		}
+	}
+	if !found {
+		return false
+	}
+
+	e := w.logger.WithLevel(lvl)
+	if !e.Enabled() {
+		return true
+	}
+	var msg string
+	var raw []byte
+	obj.Visit(func(key []byte, v *fastjson.Value) {
+		k := string(key)
+		if _, ok := jsonReservedFields[k]; ok {
+			return
+		}
+		for _, f := range jsonLevelFields {
+			if k == f {
+				return
+			}
+		}
+		for _, f := range jsonMessageFields {
+			if k == f && msg == "" && v.Type() == fastjson.TypeString {
+				msg = string(v.GetStringBytes())
+				return
+			}
+		}
+		raw = v.MarshalTo(raw[:0])
+		e.RawJSON(k, raw)
+	})
+	e.Msg(msg)
+	return true
+}
+
+// Creates a new writer like ToTextWriter, except that lines that are structured JSON logs are
+// re-emitted with their own level and fields.
+func ToStructuredWriter(logger *Logger, level Level) (w io.Writer, te *TextAdapter) {
+	te = &TextAdapter{logger: logger, defaultLevel: level, structured: true}
+	return textToLine.NewEncoder(te), te
+}
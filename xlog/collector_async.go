@@ -0,0 +1,84 @@
+package xlog
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens when the queue of an asynchronous collector is full.
+type OverflowPolicy uint8
+
+const (
+	OverflowDrop  OverflowPolicy = iota // Drop the line.
+	OverflowBlock                       // Block the logger until there is room.
+)
+
+// Lines dropped by all of the asynchronous collectors, removed ones included.
+var droppedLines atomic.Uint64
+
+// DroppedLines returns the number of lines the asynchronous collectors dropped since the start.
+func DroppedLines() uint64 {
+	return droppedLines.Load()
+}
+
+type collectedLine struct {
+	p      []byte
+	level  Level
+	domain string
+}
+
+// asyncCollector dispatches the lines to the inner collector from its own goroutine.
+type asyncCollector struct {
+	inner    Collector
+	policy   OverflowPolicy
+	ch       chan collectedLine
+	done     chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Uint64
+}
+
+func (c *asyncCollector) Write(p []byte, level Level, domain string) {
+	line := collectedLine{bytes.Clone(p), level, domain}
+	if c.policy == OverflowBlock {
+		select {
+		case c.ch <- line:
+		case <-c.done:
+		}
+		return
+	}
+	select {
+	case c.ch <- line:
+	default:
+		c.dropped.Add(1)
+		droppedLines.Add(1)
+	}
+}
+func (c *asyncCollector) run() {
+	for {
+		select {
+		case line := <-c.ch:
+			c.inner.Write(line.p, line.level, line.domain)
+		case <-c.done:
+			return
+		}
+	}
+}
+func (c *asyncCollector) stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// RegisterAsyncCollector registers a collector that is called from its own goroutine with at most
+// queue lines pending, so that a slow collector can not stall logging. The returned function
+// reports the number of lines dropped so far. It is removed with RemoveCollector.
+func RegisterAsyncCollector(c Collector, queue int, policy OverflowPolicy) (dropped func() uint64) {
+	ac := &asyncCollector{
+		inner:  c,
+		policy: policy,
+		ch:     make(chan collectedLine, max(queue, 1)),
+		done:   make(chan struct{}),
+	}
+	go ac.run()
+	RegisterCollector(ac)
+	return ac.dropped.Load
+}
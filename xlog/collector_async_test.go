@@ -0,0 +1,28 @@
+package xlog
+
+import "testing"
+
+func TestAsyncCollectorDrops(t *testing.T) {
+	before := DroppedLines()
+
+	// Not dispatching, the first line fills the queue.
+	c := &asyncCollector{policy: OverflowDrop, ch: make(chan collectedLine, 1), done: make(chan struct{})}
+	for range 10 {
+		c.Write([]byte("line"), LevelInfo, "test")
+	}
+	if n := c.dropped.Load(); n != 9 {
+		t.Errorf("dropped %d lines, want 9", n)
+	}
+	if n := DroppedLines() - before; n != 9 {
+		t.Errorf("total dropped %d, want 9", n)
+	}
+
+	// Blocking collectors do not drop, they are released when stopped.
+	c = &asyncCollector{policy: OverflowBlock, ch: make(chan collectedLine, 1), done: make(chan struct{})}
+	c.Write([]byte("line"), LevelInfo, "test")
+	c.stop()
+	c.Write([]byte("line"), LevelInfo, "test")
+	if n := c.dropped.Load(); n != 0 {
+		t.Errorf("blocking collector dropped %d lines", n)
+	}
+}
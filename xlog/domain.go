@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"slices"
 	"sync"
 
 	"github.com/rs/zerolog"
@@ -27,15 +28,37 @@ type Collector interface {
 var globalCollectors = []Collector{}
 var globalCollectorsMutex = sync.RWMutex{}
 
+// RegisterCollector registers a collector that is called synchronously for each log line,
+// it must not block. See RegisterAsyncCollector for collectors that may be slow.
 func RegisterCollector(c Collector) {
 	globalCollectorsMutex.Lock()
 	defer globalCollectorsMutex.Unlock()
 	globalCollectors = append(globalCollectors, c)
 }
 func RemoveCollector(c Collector) {
+	globalCollectorsMutex.RLock()
+	idx := slices.IndexFunc(globalCollectors, func(e Collector) bool {
+		if ac, ok := e.(*asyncCollector); ok {
+			return ac.inner == c
+		}
+		return e == c
+	})
+	var entry Collector
+	if idx >= 0 {
+		entry = globalCollectors[idx]
+	}
+	globalCollectorsMutex.RUnlock()
+	if entry == nil {
+		return
+	}
+
+	// Stop the dispatcher first so that blocked writers release the lock.
+	if ac, ok := entry.(*asyncCollector); ok {
+		ac.stop()
+	}
 	globalCollectorsMutex.Lock()
 	defer globalCollectorsMutex.Unlock()
-	globalCollectors = lo.Without(globalCollectors, c)
+	globalCollectors = lo.Without(globalCollectors, entry)
 }
 
 // Domain represents a domain.
@@ -86,6 +109,6 @@ func NewDomain(name string, w ...io.Writer) (l *Logger) {
 	dom := &Domain{name: name}
 	w = append(w, dom)
 	dom.encodedName, _ = json.Marshal(name)
-	dom.logger = zerolog.New(zerolog.MultiLevelWriter(w...)).Hook(dom)
+	dom.logger = zerolog.New(zerolog.MultiLevelWriter(w...)).Hook(dom).Sample(domainSampler{name})
 	return &dom.logger
 }
@@ -1,15 +1,185 @@
 package xlog
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
 )
 
 type EventEnhancer interface {
 	MarshalZerologObject(e *Event)
 }
 
+// Request fields that can be logged by EnhanceRequest.
+const (
+	RequestFieldMethod  = "method"  // Request method
+	RequestFieldURL     = "url"     // URL with the sensitive query parameters redacted
+	RequestFieldHost    = "host"    // Host
+	RequestFieldStatus  = "status"  // Response status, if known
+	RequestFieldIP      = "ip"      // Client IP
+	RequestFieldASN     = "asn"     // Client ASN
+	RequestFieldRay     = "ray"     // Ray ID
+	RequestFieldReferer = "referer" // Referer with the sensitive query parameters redacted
+	RequestFieldUA      = "ua"      // User agent
+	RequestFieldAddr    = "adr"     // Remote address
+	RequestFieldH2      = "h2"      // Set if the request is HTTP/2
+)
+
+// All of the request fields.
+var requestFields = []string{
+	RequestFieldMethod,
+	RequestFieldURL,
+	RequestFieldHost,
+	RequestFieldStatus,
+	RequestFieldIP,
+	RequestFieldASN,
+	RequestFieldRay,
+	RequestFieldReferer,
+	RequestFieldUA,
+	RequestFieldAddr,
+	RequestFieldH2,
+}
+
+// Fields logged by default.
+var DefaultRequestFields = []string{
+	RequestFieldMethod,
+	RequestFieldURL,
+	RequestFieldStatus,
+	RequestFieldIP,
+	RequestFieldASN,
+	RequestFieldRay,
+	RequestFieldAddr,
+	RequestFieldH2,
+}
+
+// Query parameters and headers that are always redacted.
+var DefaultRedacted = []string{
+	"token", "access_token", "refresh_token", "id_token", "code",
+	"key", "api_key", "apikey", "password", "secret", "psn",
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+}
+
+const redactedValue = "REDACTED"
+
+// RequestLogOptions controls what request data lands in the logs.
+type RequestLogOptions struct {
+	Fields  []string `yaml:"fields,omitempty"`  // Fields to log, defaults to DefaultRequestFields
+	Headers []string `yaml:"headers,omitempty"` // Additional request headers to log, under "hdr"
+	Redact  []string `yaml:"redact,omitempty"`  // Query parameters and headers to redact in addition to DefaultRedacted
+}
+
+func (o *RequestLogOptions) UnmarshalYAML(node *yaml.Node) error {
+	type plain RequestLogOptions
+	if err := node.Decode((*plain)(o)); err != nil {
+		return err
+	}
+	return o.validate()
+}
+
+func (o *RequestLogOptions) validate() error {
+	for _, f := range o.Fields {
+		if !slices.Contains(requestFields, strings.ToLower(f)) {
+			return fmt.Errorf("unknown request_log field %q, expected one of %s", f, strings.Join(requestFields, ", "))
+		}
+	}
+	for _, h := range o.Headers {
+		if !httpguts.ValidHeaderFieldName(h) {
+			return fmt.Errorf("invalid request_log header %q", h)
+		}
+	}
+	// Query parameters allow more than the header names, only reject what can not be either.
+	for _, k := range o.Redact {
+		if k == "" || strings.ContainsFunc(k, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+			return fmt.Errorf("invalid request_log redacted name %q", k)
+		}
+	}
+	return nil
+}
+
+type requestLogConfig struct {
+	fields  map[string]struct{}
+	headers []string
+	redact  map[string]struct{}
+}
+
+func (o RequestLogOptions) compile() *requestLogConfig {
+	fields := o.Fields
+	if len(fields) == 0 {
+		fields = DefaultRequestFields
+	}
+	c := &requestLogConfig{
+		fields: make(map[string]struct{}, len(fields)),
+		redact: make(map[string]struct{}, len(DefaultRedacted)+len(o.Redact)),
+	}
+	for _, f := range fields {
+		c.fields[strings.ToLower(f)] = struct{}{}
+	}
+	for _, h := range o.Headers {
+		c.headers = append(c.headers, http.CanonicalHeaderKey(h))
+	}
+	for _, k := range DefaultRedacted {
+		c.redact[strings.ToLower(k)] = struct{}{}
+	}
+	for _, k := range o.Redact {
+		c.redact[strings.ToLower(k)] = struct{}{}
+	}
+	return c
+}
+func (c *requestLogConfig) has(field string) bool {
+	_, ok := c.fields[field]
+	return ok
+}
+func (c *requestLogConfig) redacts(key string) bool {
+	_, ok := c.redact[strings.ToLower(key)]
+	return ok
+}
+
+// Returns the URL with the values of redacted query parameters replaced.
+func (c *requestLogConfig) redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	parts := strings.Split(u.RawQuery, "&")
+	changed := false
+	for i, part := range parts {
+		key, _, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		if k, err := url.QueryUnescape(key); err == nil && c.redacts(k) {
+			parts[i] = key + "=" + redactedValue
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+	cpy := *u
+	cpy.RawQuery = strings.Join(parts, "&")
+	return cpy.String()
+}
+
+var requestLogConfigPtr atomic.Pointer[requestLogConfig]
+
+func init() {
+	requestLogConfigPtr.Store(RequestLogOptions{}.compile())
+}
+
+// SetRequestLogOptions changes what EnhanceRequest logs.
+func SetRequestLogOptions(o RequestLogOptions) {
+	requestLogConfigPtr.Store(o.compile())
+}
+
 type httpEnhancer struct {
 	*http.Request
+	status int
 }
 
 func (h httpEnhancer) getHeader(hd string) string {
@@ -33,16 +203,63 @@ func (h httpEnhancer) MarshalZerologObject(e *Event) {
 	if !e.Enabled() {
 		return
 	}
-	e.Str("method", h.Method)
-	e.Stringer("url", h.URL)
-	h.putHeader(e, "P-Asn", "asn")
-	h.putHeader(e, "X-Ray", "ray")
-	e.Str("adr", h.RemoteAddr)
-	if h.ProtoMajor == 2 {
+	c := requestLogConfigPtr.Load()
+	if c.has(RequestFieldMethod) {
+		e.Str("method", h.Method)
+	}
+	if c.has(RequestFieldURL) && h.URL != nil {
+		e.Str("url", c.redactURL(h.URL))
+	}
+	if c.has(RequestFieldHost) {
+		e.Str("host", h.Host)
+	}
+	if c.has(RequestFieldStatus) && h.status != 0 {
+		e.Int("status", h.status)
+	}
+	if c.has(RequestFieldIP) {
+		h.putHeader(e, "P-Ip", "ip")
+	}
+	if c.has(RequestFieldASN) {
+		h.putHeader(e, "P-Asn", "asn")
+	}
+	if c.has(RequestFieldRay) {
+		h.putHeader(e, "X-Ray", "ray")
+	}
+	if c.has(RequestFieldReferer) {
+		if ref := h.getHeader("Referer"); ref != "" {
+			if u, err := url.Parse(ref); err == nil {
+				e.Str("referer", c.redactURL(u))
+			}
+		}
+	}
+	if c.has(RequestFieldUA) {
+		h.putHeader(e, "User-Agent", "ua")
+	}
+	if c.has(RequestFieldAddr) {
+		e.Str("adr", h.RemoteAddr)
+	}
+	if c.has(RequestFieldH2) && h.ProtoMajor == 2 {
 		e.RawJSON("h2", j1)
 	}
+	if len(c.headers) != 0 {
+		dict := zerolog.Dict()
+		for _, hdr := range c.headers {
+			if v := h.getHeader(hdr); v != "" {
+				if c.redacts(hdr) {
+					v = redactedValue
+				}
+				dict.Str(hdr, v)
+			}
+		}
+		e.Dict("hdr", dict)
+	}
 }
 
 func EnhanceRequest(r *http.Request) EventEnhancer {
-	return httpEnhancer{r}
+	return httpEnhancer{Request: r}
+}
+
+// EnhanceResponse is EnhanceRequest with the response status.
+func EnhanceResponse(r *http.Request, status int) EventEnhancer {
+	return httpEnhancer{Request: r, status: status}
 }
@@ -0,0 +1,36 @@
+package xlog
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRequestLogOptionsDecode(t *testing.T) {
+	var o RequestLogOptions
+	doc := "fields: [method, URL, ua]\nheaders: [X-Tenant]\nredact: [session, 'user[token]']\n"
+	if err := yaml.Unmarshal([]byte(doc), &o); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Fields) != 3 || len(o.Headers) != 1 || len(o.Redact) != 2 {
+		t.Errorf("decoded %+v", o)
+	}
+
+	tests := map[string]string{
+		"fields: [method, path]": `unknown request_log field "path"`,
+		"fields: ['']":           `unknown request_log field ""`,
+		"headers: ['X Tenant']":  `invalid request_log header "X Tenant"`,
+		"headers: ['X-Tenant:']": `invalid request_log header "X-Tenant:"`,
+		"headers: ['']":          `invalid request_log header ""`,
+		"redact: ['']":           `invalid request_log redacted name ""`,
+		"redact: ['api key']":    `invalid request_log redacted name "api key"`,
+		"redact: [\"tok\\ten\"]": `invalid request_log redacted name "tok\ten"`,
+	}
+	for doc, want := range tests {
+		var o RequestLogOptions
+		if err := yaml.Unmarshal([]byte(doc), &o); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %s", doc, err, want)
+		}
+	}
+}
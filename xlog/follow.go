@@ -0,0 +1,148 @@
+package xlog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"get.pme.sh/pmesh/config"
+	"get.pme.sh/pmesh/util"
+
+	"github.com/valyala/fastjson"
+)
+
+const followPollInterval = 250 * time.Millisecond
+
+// FollowFile writes the lines appended to the log file matching the filter to out, starting at the
+// offset, such as where reading the history stopped, or at its current end if negative. If the file
+// is shorter than the offset, it was rotated since and is read from the start. When the file is
+// rotated, the remaining lines are read before switching to the new file. If the file does not exist
+// yet, it waits for it to be created.
+func FollowFile(ctx context.Context, path string, start int64, filter Filter, out io.Writer) error {
+	if !filepath.IsAbs(path) {
+		path = config.LogDir.File(path)
+	}
+
+	var (
+		f       *os.File
+		rd      *bufio.Reader
+		offset  int64
+		partial []byte
+		parser  fastjson.Parser
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	// Opens the file, seeking to the given offset, or to the end if negative.
+	open := func(from int64) error {
+		nf, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if offset, err = nf.Seek(0, io.SeekEnd); err == nil && from >= 0 {
+			if from > offset {
+				from = 0 // Rotated since.
+			}
+			offset, err = nf.Seek(from, io.SeekStart)
+		}
+		if err != nil {
+			nf.Close()
+			return err
+		}
+		if f != nil {
+			f.Close()
+		}
+		f, rd, partial = nf, bufio.NewReader(nf), nil
+		return nil
+	}
+	// Returns true if the path now refers to a different file, or the file was truncated.
+	rotated := func() bool {
+		cur, err := f.Stat()
+		if err != nil {
+			return true
+		}
+		next, err := os.Stat(path)
+		if err != nil {
+			return false // Rotation in progress, keep the old file until the new one appears.
+		}
+		return !os.SameFile(cur, next) || next.Size() < offset
+	}
+
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			return nil
+		}
+	}
+
+	if err := open(start); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for {
+		if f == nil {
+			if err := wait(); err != nil {
+				return err
+			}
+			if err := open(0); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+
+		line, err := rd.ReadSlice('\n')
+		offset += int64(len(line))
+		if err == bufio.ErrBufferFull {
+			partial = append(partial, line...)
+			continue
+		} else if err == io.EOF {
+			// Keep the incomplete line until the rest is written.
+			partial = append(partial, line...)
+			if rotated() {
+				err := open(0)
+				if err == nil {
+					continue
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+				// Removed again before it could be opened, retry on the next tick.
+			}
+			if err := wait(); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if len(partial) != 0 {
+			line = append(partial, line...)
+			partial = nil
+		}
+
+		str := util.UnsafeString(line)
+		if !filter.TestRaw(str) {
+			continue
+		}
+		v, err := parser.Parse(str)
+		if err != nil {
+			continue
+		}
+		if inc, _ := filter.Test(Line{v, line}, StreamHead); !inc {
+			continue
+		}
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+	}
+}
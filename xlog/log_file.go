@@ -56,7 +56,14 @@ func (s sharedLogger) Write(p []byte) (n int, e error) {
 	if s.err != nil {
 		return 0, s.err
 	}
-	return s.entry.Value.Write(p)
+	n, e = s.entry.Value.Write(p)
+	if e != nil && n < len(p) {
+		// Retry the remainder once, the file is reopened if it was closed or failed to rotate.
+		var m int
+		m, e = s.entry.Value.Write(p[n:])
+		n += m
+	}
+	return
 }
 
 type noCloser struct {
@@ -76,5 +83,9 @@ func FileWriter(name string) (wc io.WriteCloser) {
 		name = config.LogDir.File(name)
 	}
 	entry, err := loggers.GetEntry(name)
+	if err == nil {
+		// Keep the file open for as long as the writer is in use.
+		entry.Acquire()
+	}
 	return sharedLogger{entry, err}
 }
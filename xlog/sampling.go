@@ -0,0 +1,105 @@
+package xlog
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// SamplingOptions maps a domain to the fraction of events kept at each level, e.g.
+//
+//	http: { trace: 0.01, debug: 0.1 }
+//
+// A domain's rates also apply to its subdomains unless they have their own, "*" applies to all domains.
+// Warnings and errors are never sampled out.
+type SamplingOptions map[string]map[string]float64
+
+type levelRates [LevelInfo - LevelTrace + 1]float64
+
+func (o SamplingOptions) compile() (map[string]*levelRates, error) {
+	if len(o) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]*levelRates, len(o))
+	for domain, levels := range o {
+		rates := &levelRates{}
+		for i := range rates {
+			rates[i] = 1
+		}
+		for name, rate := range levels {
+			lvl, err := zerolog.ParseLevel(name)
+			if err != nil {
+				return nil, err
+			}
+			if lvl < LevelTrace || lvl > LevelInfo {
+				return nil, fmt.Errorf("level %q can not be sampled", name)
+			}
+			if rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sampling rate for %s.%s: %v", domain, name, rate)
+			}
+			rates[lvl-LevelTrace] = rate
+		}
+		res[domain] = rates
+	}
+	return res, nil
+}
+
+// Validate returns an error if the options can not be applied.
+func (o SamplingOptions) Validate() error {
+	_, err := o.compile()
+	return err
+}
+
+var samplingRates atomic.Pointer[map[string]*levelRates]
+
+// SetSampling replaces the sampling configuration of all domains.
+func SetSampling(o SamplingOptions) error {
+	rates, err := o.compile()
+	if err != nil {
+		return err
+	}
+	if rates == nil {
+		samplingRates.Store(nil)
+	} else {
+		samplingRates.Store(&rates)
+	}
+	return nil
+}
+
+// domainSampler implements zerolog.Sampler using the rates configured for the domain.
+type domainSampler struct {
+	name string
+}
+
+func (s domainSampler) Sample(lvl Level) bool {
+	if lvl < LevelTrace || lvl > LevelInfo {
+		return true
+	}
+	ptr := samplingRates.Load()
+	if ptr == nil {
+		return true
+	}
+	rates := *ptr
+	name := s.name
+	for {
+		if r, ok := rates[name]; ok {
+			return keep(r[lvl-LevelTrace])
+		}
+		idx := strings.LastIndexByte(name, '.')
+		if idx < 0 {
+			break
+		}
+		name = name[:idx]
+	}
+	if r, ok := rates["*"]; ok {
+		return keep(r[lvl-LevelTrace])
+	}
+	return true
+}
+
+func keep(rate float64) bool {
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
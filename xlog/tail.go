@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"get.pme.sh/pmesh/ray"
+	"get.pme.sh/pmesh/snowflake"
 
 	"golang.org/x/sync/errgroup"
 )
@@ -29,7 +30,17 @@ func (o TailOptions) WithRay(rayStr string) (opts TailOptions, err error) {
 	// Parse ray
 	rid, err := ray.Parse(rayStr)
 	if err != nil {
-		return
+		// Snowflake rays do not carry the host, so we can only narrow down the time.
+		var sid snowflake.ID
+		if sid.UnmarshalText([]byte(rayStr)) != nil || !sid.Valid() {
+			return
+		}
+		opts = o
+		opts.Before = sid.Timestamp().Add(time.Minute)
+		opts.After = sid.Timestamp().Add(-time.Minute)
+		opts.Search = rayStr
+		opts.Follow = false
+		return opts, nil
 	}
 
 	// Set options
@@ -98,6 +109,9 @@ func TailHistory(ctx context.Context, opts *TailOptions, out io.Writer) error {
 	return e
 }
 
+// Number of lines buffered for a following tail before they are dropped.
+const tailQueueSize = 4096
+
 type tailCollector struct {
 	minLevel Level
 	domain   string
@@ -129,7 +143,8 @@ func tailFollow(ctx context.Context, opts *TailOptions) net.Conn {
 		w:        snd,
 		cancel:   cancel,
 	}
-	RegisterCollector(col)
+	// The reader may be a remote client, do not let it stall logging.
+	RegisterAsyncCollector(col, tailQueueSize, OverflowDrop)
 	go func() {
 		<-ctx.Done()
 		snd.Close()
@@ -0,0 +1,65 @@
+package xpost
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// Special values of an affinity tag.
+const (
+	AffinitySelf    = "@self"    // Matches the value of the local node
+	AffinityCountry = "@country" // Matches the country of the client
+)
+
+// Affinity selects the peers whose user data matches all of the tags, e.g. region=eu tier=gold.
+type Affinity map[string]string
+
+// ParseAffinity parses a list of key=value tags.
+func ParseAffinity(tags []string) (Affinity, error) {
+	a := make(Affinity, len(tags))
+	for _, tag := range tags {
+		k, v, ok := strings.Cut(tag, "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("invalid affinity tag %q, expected key=value", tag)
+		}
+		a[k] = v
+	}
+	return a, nil
+}
+
+func (a Affinity) String() string {
+	keys := lo.Keys(a)
+	slices.Sort(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + a[k]
+	}
+	return strings.Join(keys, " ")
+}
+
+// Matches returns true if the user data of the peer matches all of the tags, the values are compared as
+// strings, case-insensitively.
+func (a Affinity) Matches(p *Peer, self *Peer, country string) bool {
+	for k, want := range a {
+		switch want {
+		case AffinitySelf:
+			v, ok := self.UD[k]
+			if !ok {
+				return false
+			}
+			want = fmt.Sprint(v)
+		case AffinityCountry:
+			if country == "" {
+				return false
+			}
+			want = country
+		}
+		v, ok := p.UD[k]
+		if !ok || !strings.EqualFold(fmt.Sprint(v), want) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package xpost
+
+import "testing"
+
+func TestParseAffinity(t *testing.T) {
+	a, err := ParseAffinity([]string{"tier=gold", "region=@self"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := a.String(); s != "region=@self tier=gold" {
+		t.Errorf("got %q", s)
+	}
+	for _, tag := range []string{"tier", "=gold", "tier="} {
+		if _, err := ParseAffinity([]string{tag}); err == nil {
+			t.Errorf("%q: parsed", tag)
+		}
+	}
+}
+
+func TestAffinityMatches(t *testing.T) {
+	self := &Peer{UD: map[string]any{"region": "eu", "rack": 3}}
+	peer := &Peer{UD: map[string]any{"region": "EU", "tier": "gold", "rack": 3, "country": "de"}}
+	tests := []struct {
+		tags    Affinity
+		country string
+		want    bool
+	}{
+		{Affinity{}, "", true},
+		{Affinity{"tier": "gold"}, "", true},
+		{Affinity{"tier": "Gold", "region": "eu"}, "", true},
+		{Affinity{"tier": "silver"}, "", false},
+		{Affinity{"zone": "a"}, "", false},
+		{Affinity{"region": AffinitySelf, "rack": AffinitySelf}, "", true},
+		{Affinity{"tier": AffinitySelf}, "", false}, // Not set on the local node.
+		{Affinity{"country": AffinityCountry}, "DE", true},
+		{Affinity{"country": AffinityCountry}, "FR", false},
+		{Affinity{"country": AffinityCountry}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.tags.Matches(peer, self, tt.country); got != tt.want {
+			t.Errorf("%v (country %q): got %v, want %v", tt.tags, tt.country, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package xpost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PeerTimeout is the default deadline of a call to a single peer, a peer that does not answer within it is
+// reported as failed rather than holding back the whole fan-out.
+const PeerTimeout = 5 * time.Second
+
+var ErrPeerTimeout = errors.New("peer did not answer in time")
+
+// PeerError is the failure of a call to one of the peers of a fan-out.
+type PeerError struct {
+	MachineID string
+	Host      string
+	Err       error
+}
+
+func (e PeerError) Error() string {
+	return fmt.Sprintf("peer %s (%s): %v", e.Host, e.MachineID, e.Err)
+}
+func (e PeerError) Unwrap() error {
+	return e.Err
+}
+
+// FanOut calls the function for each peer concurrently, each call bounded by the timeout, and returns the
+// results of the peers that answered keyed by machine ID along with the failures of the others, in the order
+// of the peers. It returns once every call completed or timed out, even if the function ignores the context.
+// A zero timeout is PeerTimeout, a negative one leaves the calls bounded by the context only, for streams.
+func FanOut[T any](ctx context.Context, peers []Peer, timeout time.Duration, fn func(ctx context.Context, p *Peer) (T, error)) (results map[string]T, failed []PeerError) {
+	if timeout == 0 {
+		timeout = PeerTimeout
+	}
+	withTimeout := func(ctx context.Context) (context.Context, context.CancelFunc) {
+		if timeout < 0 {
+			return context.WithCancel(ctx)
+		}
+		return context.WithTimeout(ctx, timeout)
+	}
+	type outcome struct {
+		value T
+		err   error
+	}
+	N := len(peers)
+	pending := make([]chan outcome, N)
+	for i := range peers {
+		ch := make(chan outcome, 1)
+		pending[i] = ch
+		go func(p *Peer) {
+			pctx, cancel := withTimeout(ctx)
+			defer cancel()
+			v, err := fn(pctx, p)
+			ch <- outcome{v, err}
+		}(&peers[i])
+	}
+
+	// All the calls share the same deadline, so waiting for them in order takes no longer than the slowest.
+	wctx, cancel := withTimeout(ctx)
+	defer cancel()
+	results = make(map[string]T, N)
+	for i, ch := range pending {
+		var o outcome
+		select {
+		case o = <-ch:
+		case <-wctx.Done():
+			select {
+			case o = <-ch:
+			default:
+				o.err = wctx.Err()
+			}
+		}
+		if errors.Is(o.err, context.DeadlineExceeded) {
+			o.err = ErrPeerTimeout
+		}
+		if o.err != nil {
+			failed = append(failed, PeerError{MachineID: peers[i].MachineID, Host: peers[i].Host, Err: o.err})
+		} else {
+			results[peers[i].MachineID] = o.value
+		}
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package xpost
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFanOut(t *testing.T) {
+	peers := []Peer{{MachineID: "fast"}, {MachineID: "stuck"}, {MachineID: "failing"}}
+	release := make(chan struct{})
+	defer close(release)
+	start := time.Now()
+	results, failed := FanOut(context.Background(), peers, 50*time.Millisecond, func(ctx context.Context, p *Peer) (string, error) {
+		switch p.MachineID {
+		case "stuck":
+			<-release // Ignores the context.
+		case "failing":
+			return "", errors.New("refused")
+		}
+		return p.MachineID, nil
+	})
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("returned after %v", d)
+	}
+	if len(results) != 1 || results["fast"] != "fast" {
+		t.Errorf("results %v", results)
+	}
+	if len(failed) != 2 || failed[0].MachineID != "stuck" || !errors.Is(failed[0], ErrPeerTimeout) || failed[1].MachineID != "failing" {
+		t.Errorf("failed %v", failed)
+	}
+}
+
+func TestFanOutStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	results, failed := FanOut(ctx, []Peer{{MachineID: "a"}, {MachineID: "b"}}, -1, func(ctx context.Context, p *Peer) (int, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return 0, errors.New("deadline set")
+		}
+		if p.MachineID == "a" {
+			return 1, nil
+		}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if results["a"] != 1 || len(failed) != 1 || failed[0].MachineID != "b" || !errors.Is(failed[0], context.Canceled) {
+		t.Errorf("results %v, failed %v", results, failed)
+	}
+}
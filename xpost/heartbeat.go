@@ -0,0 +1,272 @@
+package xpost
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// HeartbeatOptions reduces the size of the heartbeats in large clusters with rich peer data. The peers
+// decode every encoding whatever their own options, but versions predating them do not, so they should
+// only be enabled once all the nodes are upgraded.
+type HeartbeatOptions struct {
+	Compress bool `yaml:"compress,omitempty"`  // Compress the entries with gzip
+	Delta    bool `yaml:"delta,omitempty"`     // Publish only the fields changed since the last full entry
+	FullSync int  `yaml:"full_sync,omitempty"` // Heartbeats between two full entries with delta encoding, default = 10
+}
+
+// With delta encoding, the full entry of a peer is published under this prefix and the entry of the peer
+// only carries the changes since, so that a peer that missed the previous heartbeats can still catch up.
+const fullKeyPrefix = "full."
+
+// Flags of the encoded entries, plain JSON entries start with '{' instead.
+const (
+	hbGzip  byte = 1 << 0
+	hbDelta byte = 1 << 1
+)
+
+// Maximum size of a decompressed entry.
+const hbMaxSize = 16 << 20
+
+var errStaleBase = errors.New("full entry of the peer changed")
+
+// A delta entry, the patch is a JSON merge patch (RFC 7386) to apply to the full entry.
+type peerDelta struct {
+	Base  uint64         `json:"base"`  // Revision of the full entry
+	Patch map[string]any `json:"patch"` // Changes since the full entry
+}
+
+// A full entry and the last document decoded from it.
+type hbBase struct {
+	rev  uint64
+	doc  map[string]any
+	last map[string]any
+}
+
+func encodeHeartbeat(body []byte, compress, delta bool) []byte {
+	if !compress && !delta {
+		return body
+	}
+	var flags byte
+	if delta {
+		flags |= hbDelta
+	}
+	if compress {
+		flags |= hbGzip
+		buf := bytes.NewBuffer([]byte{flags})
+		zw := gzip.NewWriter(buf)
+		zw.Write(body)
+		zw.Close()
+		return buf.Bytes()
+	}
+	return append([]byte{flags}, body...)
+}
+func decodeHeartbeat(data []byte) (body []byte, delta bool, err error) {
+	if len(data) == 0 {
+		return nil, false, errors.New("empty heartbeat")
+	}
+	if data[0] == '{' {
+		return data, false, nil
+	}
+	flags := data[0]
+	if flags&^(hbGzip|hbDelta) != 0 {
+		return nil, false, fmt.Errorf("unknown heartbeat encoding %#x", flags)
+	}
+	body = data[1:]
+	if flags&hbGzip != 0 {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, false, err
+		}
+		body, err = io.ReadAll(io.LimitReader(zr, hbMaxSize))
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return body, flags&hbDelta != 0, nil
+}
+
+// Computes the merge patch turning the base into the current document, null and missing values are not
+// told apart.
+func mergeDiff(base, cur map[string]any) map[string]any {
+	patch := make(map[string]any)
+	for k, v := range cur {
+		bv, ok := base[k]
+		if ok && reflect.DeepEqual(bv, v) {
+			continue
+		}
+		bm, bok := bv.(map[string]any)
+		cm, cok := v.(map[string]any)
+		if bok && cok {
+			patch[k] = mergeDiff(bm, cm)
+		} else {
+			patch[k] = v
+		}
+	}
+	for k := range base {
+		if _, ok := cur[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// Applies the merge patch to a copy of the document.
+func mergeApply(doc, patch map[string]any) map[string]any {
+	out := maps.Clone(doc)
+	if out == nil {
+		out = make(map[string]any, len(patch))
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(out, k)
+		} else if pm, ok := v.(map[string]any); ok {
+			dm, _ := out[k].(map[string]any)
+			out[k] = mergeApply(dm, pm)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Returns the heartbeat options, read on every heartbeat.
+func (m *Peerlist) heartbeatOptions() (o HeartbeatOptions) {
+	if m.Options != nil {
+		o = m.Options()
+	}
+	if o.FullSync <= 0 {
+		o.FullSync = 10
+	}
+	return
+}
+
+// Publishes the entry of the local peer.
+func (m *Peerlist) publish(ctx context.Context, kv jetstream.KeyValue, id string, data []byte) error {
+	opts := m.heartbeatOptions()
+	m.hbmu.Lock()
+	defer m.hbmu.Unlock()
+
+	if !opts.Delta {
+		if m.base != nil {
+			kv.Purge(ctx, fullKeyPrefix+id)
+			m.base = nil
+		}
+		_, err := kv.Put(ctx, id, encodeHeartbeat(data, opts.Compress, false))
+		return err
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if m.base == nil || m.beats >= opts.FullSync {
+		rev, err := kv.Put(ctx, fullKeyPrefix+id, encodeHeartbeat(data, opts.Compress, false))
+		if err != nil {
+			return err
+		}
+		m.base = &hbBase{rev: rev, doc: doc}
+		m.beats = 0
+	}
+	m.beats++
+	body, err := json.Marshal(peerDelta{Base: m.base.rev, Patch: mergeDiff(m.base.doc, doc)})
+	if err != nil {
+		return err
+	}
+	if len(body) >= len(data) {
+		m.beats = opts.FullSync // Drifted too far from the full entry, publish a new one next time.
+	}
+	_, err = kv.Put(ctx, id, encodeHeartbeat(body, opts.Compress, true))
+	return err
+}
+
+// Decodes the entry of a peer, resolving the full entry a delta applies to.
+func (m *Peerlist) decodeEntry(ctx context.Context, kv jetstream.KeyValue, entry jetstream.KeyValueEntry) (p Peer, err error) {
+	body, delta, err := decodeHeartbeat(entry.Value())
+	if err != nil {
+		return
+	}
+	if !delta {
+		err = json.Unmarshal(body, &p)
+		return
+	}
+	var d peerDelta
+	if err = json.Unmarshal(body, &d); err != nil {
+		return
+	}
+
+	key := entry.Key()
+	m.hbmu.Lock()
+	base, ok := m.bases[key]
+	m.hbmu.Unlock()
+	if !ok || base.rev != d.Base {
+		fetched, err := fetchBase(ctx, kv, key, d.Base)
+		if errors.Is(err, errStaleBase) && base.last != nil {
+			// Published a new full entry since, use the last known state until the next heartbeat.
+			return peerFromDoc(base.last)
+		}
+		if err != nil {
+			return p, err
+		}
+		base = fetched
+	}
+	base.last = mergeApply(base.doc, d.Patch)
+	m.hbmu.Lock()
+	m.bases[key] = base
+	m.hbmu.Unlock()
+	return peerFromDoc(base.last)
+}
+
+// Fetches the full entry of the peer, failing with errStaleBase if it is not at the expected revision.
+func fetchBase(ctx context.Context, kv jetstream.KeyValue, key string, rev uint64) (hbBase, error) {
+	full, err := kv.Get(ctx, fullKeyPrefix+key)
+	if err != nil {
+		return hbBase{}, err
+	}
+	if full.Revision() != rev {
+		return hbBase{}, errStaleBase
+	}
+	body, _, err := decodeHeartbeat(full.Value())
+	if err != nil {
+		return hbBase{}, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return hbBase{}, err
+	}
+	return hbBase{rev: rev, doc: doc}, nil
+}
+
+func peerFromDoc(doc map[string]any) (p Peer, err error) {
+	data, err := json.Marshal(doc)
+	if err == nil {
+		err = json.Unmarshal(data, &p)
+	}
+	return
+}
+
+// Forgets the full entries of the peers that are no longer listed.
+func (m *Peerlist) pruneBases(keys []string) {
+	m.hbmu.Lock()
+	defer m.hbmu.Unlock()
+	for key := range m.bases {
+		if !slices.Contains(keys, key) {
+			delete(m.bases, key)
+		}
+	}
+}
+
+// Returns true if the key holds the entry of a peer rather than a full entry.
+func isPeerKey(key string) bool {
+	return !strings.HasPrefix(key, fullKeyPrefix)
+}
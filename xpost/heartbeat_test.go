@@ -0,0 +1,181 @@
+package xpost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestHeartbeatEncoding(t *testing.T) {
+	body := []byte(`{"host":"a","ud":{"k":"` + strings.Repeat("v", 256) + `"}}`)
+	for _, compress := range []bool{false, true} {
+		for _, delta := range []bool{false, true} {
+			data := encodeHeartbeat(body, compress, delta)
+			got, gotDelta, err := decodeHeartbeat(data)
+			if err != nil {
+				t.Fatalf("compress=%v delta=%v: %v", compress, delta, err)
+			}
+			if string(got) != string(body) || gotDelta != delta {
+				t.Errorf("compress=%v delta=%v: got %q delta=%v", compress, delta, got, gotDelta)
+			}
+			if compress && len(data) >= len(body) {
+				t.Errorf("compressed to %d bytes from %d", len(data), len(body))
+			}
+		}
+	}
+	for _, data := range [][]byte{nil, {0x80, '{', '}'}, {hbGzip, 'x'}} {
+		if _, _, err := decodeHeartbeat(data); err == nil {
+			t.Errorf("%q: decoded", data)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	parse := func(s string) (doc map[string]any) {
+		if err := json.Unmarshal([]byte(s), &doc); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	tests := []struct{ base, cur string }{
+		{`{}`, `{}`},
+		{`{}`, `{"a":1,"b":{"c":[1,2]}}`},
+		{`{"a":1,"b":2}`, `{"a":1}`},
+		{`{"a":1}`, `{"a":"x"}`},
+		{`{"a":{"b":1,"c":{"d":2}}}`, `{"a":{"b":1,"c":{"e":3}}}`},
+		{`{"a":{"b":1}}`, `{"a":[1]}`},
+		{`{"a":[1]}`, `{"a":{"b":1}}`},
+		{`{"a":{"b":1}}`, `{"a":{}}`},
+	}
+	for _, tt := range tests {
+		base, cur := parse(tt.base), parse(tt.cur)
+		patch := mergeDiff(base, cur)
+		// Through JSON, as published.
+		data, err := json.Marshal(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := mergeApply(base, parse(string(data)))
+		if !reflect.DeepEqual(got, cur) {
+			t.Errorf("%s -> %s: patch %s gives %v", tt.base, tt.cur, data, got)
+		}
+		if !reflect.DeepEqual(base, parse(tt.base)) {
+			t.Errorf("%s -> %s: base modified to %v", tt.base, tt.cur, base)
+		}
+	}
+}
+
+// In-memory key-value store, only implements what the heartbeats use.
+type memKV struct {
+	jetstream.KeyValue
+	rev     uint64
+	entries map[string]memEntry
+}
+type memEntry struct {
+	jetstream.KeyValueEntry
+	key   string
+	value []byte
+	rev   uint64
+}
+
+func (e memEntry) Key() string      { return e.key }
+func (e memEntry) Value() []byte    { return e.value }
+func (e memEntry) Revision() uint64 { return e.rev }
+
+func (kv *memKV) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	kv.rev++
+	kv.entries[key] = memEntry{key: key, value: value, rev: kv.rev}
+	return kv.rev, nil
+}
+func (kv *memKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	if e, ok := kv.entries[key]; ok {
+		return e, nil
+	}
+	return nil, jetstream.ErrKeyNotFound
+}
+func (kv *memKV) Purge(ctx context.Context, key string, opts ...jetstream.KVDeleteOpt) error {
+	delete(kv.entries, key)
+	return nil
+}
+
+func TestHeartbeatDeltaResync(t *testing.T) {
+	ctx := context.Background()
+	kv := &memKV{entries: make(map[string]memEntry)}
+	pub := NewPeerlist(nil)
+	pub.Options = func() HeartbeatOptions { return HeartbeatOptions{Delta: true, Compress: true, FullSync: 3} }
+
+	beat := func(i int) (jetstream.KeyValueEntry, Peer) {
+		t.Helper()
+		p := Peer{MachineID: "m1", Host: "a", Heartbeat: int64(i), UD: map[string]any{
+			"static": strings.Repeat("x", 512),
+			"beat":   fmt.Sprint(i),
+		}}
+		if i%2 == 0 {
+			p.UD["even"] = true
+		}
+		data, _ := json.Marshal(p)
+		if err := pub.publish(ctx, kv, "m1", data); err != nil {
+			t.Fatal(err)
+		}
+		entry, _ := kv.Get(ctx, "m1")
+		if _, delta, _ := decodeHeartbeat(entry.Value()); !delta {
+			t.Fatalf("beat %d: not a delta", i)
+		}
+		return entry, p
+	}
+	check := func(m *Peerlist, entry jetstream.KeyValueEntry, want Peer) {
+		t.Helper()
+		got, err := m.decodeEntry(ctx, kv, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	sub := NewPeerlist(nil)
+	e1, p1 := beat(1)
+	check(sub, e1, p1)
+	base := sub.bases["m1"].rev
+
+	// Missed heartbeats on the same full entry.
+	beat(2)
+	e3, p3 := beat(3)
+	check(sub, e3, p3)
+	if sub.bases["m1"].rev != base {
+		t.Fatal("full entry fetched again")
+	}
+
+	// Missed the heartbeat publishing a new full entry.
+	beat(4)
+	e5, p5 := beat(5)
+	check(sub, e5, p5)
+	if sub.bases["m1"].rev == base {
+		t.Fatal("new full entry not fetched")
+	}
+
+	// A delayed delta on a replaced full entry keeps the last known state.
+	check(sub, e3, p5)
+	if _, err := NewPeerlist(nil).decodeEntry(ctx, kv, e3); !errors.Is(err, errStaleBase) {
+		t.Errorf("without a known state: got %v, want %v", err, errStaleBase)
+	}
+
+	// Back to full entries.
+	pub.Options = nil
+	data, _ := json.Marshal(p1)
+	if err := pub.publish(ctx, kv, "m1", data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get(ctx, fullKeyPrefix+"m1"); err == nil {
+		t.Error("full entry not purged")
+	}
+	e, _ := kv.Get(ctx, "m1")
+	check(sub, e, p1)
+}
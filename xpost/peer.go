@@ -29,8 +29,10 @@ type Peer struct {
 	Heartbeat int64          `json:"heartbeat"`            // the time of the last heartbeat (ms since epoch)
 	Me        bool           `json:"me,omitempty"`         // if this is the local member
 	Distance  float64        `json:"distance,omitempty"`   // the distance from the local member (meters)
+	Latency   float64        `json:"latency,omitempty"`    // the round-trip time from the local member (ms), -1 if unreachable
 	UD        map[string]any `json:"ud"`                   // user data
 	SD        map[string]any `json:"sd"`                   // system data
+	Conflict  string         `json:"conflict,omitempty"`   // set if another member advertises the same machine ID
 }
 
 func FillPeerForSelf(ctx context.Context) Peer {
@@ -150,6 +152,12 @@ func (p *Peer) Request(ctx context.Context, method string, path string, body any
 	if strings.HasPrefix(path, "/") {
 		path = fmt.Sprintf("%s.pm3%s", p.Host, path)
 	}
+	if _, ok := ctx.Deadline(); !ok {
+		// Never wait on a dead peer indefinitely.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, PeerTimeout)
+		defer cancel()
+	}
 	req, err := http.NewRequestWithContext(ctx, method, "http://"+path, reader)
 	if err != nil {
 		return err
@@ -180,11 +188,17 @@ func (p *Peer) Patch(ctx context.Context, path string, body any, result any) err
 	return p.Request(ctx, http.MethodPatch, path, body, result)
 }
 func (p *Peer) RTT(ctx context.Context) (ms float64) {
+	ms, _ = p.ping(ctx)
+	return
+}
+
+// Measures the round-trip time to the peer in milliseconds, -1 if it could not be reached.
+func (p *Peer) ping(ctx context.Context) (ms float64, err error) {
 	start := time.Now()
-	if p.Get(ctx, "/ping", nil) != nil {
-		return -1
+	if err := p.Get(ctx, "/ping", nil); err != nil {
+		return -1, err
 	}
-	return float64(time.Since(start)) / float64(time.Millisecond)
+	return float64(time.Since(start)) / float64(time.Millisecond), nil
 }
 
 func (p *Peer) Connect() (pmtp.Client, error) {
@@ -203,22 +217,14 @@ func (p *Peer) Call(method string, args any, reply any) error {
 	return cli.Call(method, args, reply)
 }
 
-func MeasureConnectivity(ctx context.Context, peers []Peer) map[string]float64 {
-	N := len(peers)
-	result := make([]float64, N)
-	wg := &sync.WaitGroup{}
-	wg.Add(N)
-	for i := 0; i < N; i++ {
-		go func(i int) {
-			result[i] = peers[i].RTT(ctx)
-			wg.Done()
-		}(i)
+// MeasureConnectivity measures the round-trip time to the peers in milliseconds keyed by machine ID, the
+// peers that could not be reached within the per-peer timeout are set to -1 and reported as failed.
+func MeasureConnectivity(ctx context.Context, peers []Peer) (rtt map[string]float64, failed []PeerError) {
+	rtt, failed = FanOut(ctx, peers, PeerTimeout, func(ctx context.Context, p *Peer) (float64, error) {
+		return p.ping(ctx)
+	})
+	for _, f := range failed {
+		rtt[f.MachineID] = -1
 	}
-	wg.Wait()
-
-	m := make(map[string]float64, N)
-	for i, p := range peers {
-		m[p.MachineID] = result[i]
-	}
-	return m
+	return
 }
@@ -3,7 +3,10 @@ package xpost
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -26,13 +29,31 @@ type Peerlist struct {
 	err    error
 	errn   int
 	self   Peer
+	kick   chan struct{}
+	rtts   map[string]float64 // Round-trip times by machine ID, measured apart from the heartbeats.
 
 	sds  sync.Map // map[int32]SDSource
 	sdsn atomic.Int32
+
+	// Options of the heartbeats, set before opening the list.
+	Options func() HeartbeatOptions
+	hbmu    sync.Mutex
+	base    *hbBase           // last full entry published
+	beats   int               // heartbeats published since
+	bases   map[string]hbBase // full entries of the peers
+
+	lastBeat atomic.Int64               // heartbeat of the last entry written
+	conflict atomic.Pointer[idConflict] // last machine ID conflict detected
+}
+
+// A member found writing the entry of the local peer.
+type idConflict struct {
+	message string
+	at      time.Time
 }
 
 func NewPeerlist(gw *enats.Gateway) *Peerlist {
-	return &Peerlist{gw: gw}
+	return &Peerlist{gw: gw, kick: make(chan struct{}, 1), bases: make(map[string]hbBase)}
 }
 
 func (m *Peerlist) AddSDSource(sds ...SDSource) {
@@ -49,33 +70,41 @@ func (m *Peerlist) update(ctx context.Context, self Peer) ([]Peer, error) {
 		v.(SDSource)(self.SD)
 		return true
 	})
+	if prev, err := kv.Get(ctx, self.MachineID); err == nil {
+		if p, err := m.decodeEntry(ctx, kv, prev); err == nil {
+			m.detectConflict(ctx, self, p)
+		}
+	}
+	self.Conflict = m.Conflict()
 	self.Heartbeat = time.Now().UnixMilli()
 	copyForMarshal := Peer(self)
 	copyForMarshal.MachineID = ""
 	copyForMarshal.Me = false
 	copyForMarshal.Distance = 0
+	copyForMarshal.Latency = 0
 	data, err := json.Marshal(copyForMarshal)
 	if err != nil {
 		return nil, err
 	}
-	_, err = kv.Put(ctx, self.MachineID, data)
-	if err != nil {
+	if err := m.publish(ctx, kv, self.MachineID, data); err != nil {
 		return nil, err
 	}
+	m.lastBeat.Store(self.Heartbeat)
 
 	keys, err := kv.Keys(ctx)
 	if err != nil {
 		return nil, err
 	}
+	keys = slices.DeleteFunc(keys, func(k string) bool { return !isPeerKey(k) })
+	m.pruneBases(keys)
 
 	peers := make([]Peer, 0, len(keys))
 	for _, k := range keys {
-		data, err := kv.Get(ctx, k)
+		entry, err := kv.Get(ctx, k)
 		if err != nil {
 			return nil, err
 		}
-		var p Peer
-		if json.Unmarshal(data.Value(), &p) == nil {
+		if p, err := m.decodeEntry(ctx, kv, entry); err == nil {
 			p.MachineID = k
 			p.Me = p.MachineID == self.MachineID
 			p.Distance = p.DistanceTo(&self)
@@ -129,34 +158,139 @@ func (m *Peerlist) update(ctx context.Context, self Peer) ([]Peer, error) {
 	})
 	return peers, nil
 }
-func (m *Peerlist) tick(ctx context.Context, self Peer) {
-	ticker := time.NewTicker(HeartbeatInterval)
-	defer ticker.Stop()
-	for range ticker.C {
-		if ctx.Err() != nil {
+
+// Checks whether the entry of the local peer was written by another member, which happens when machines
+// cloned from the same image share a machine ID: they overwrite each other's entry and look like a single
+// peer to the rest of the cluster.
+func (m *Peerlist) detectConflict(ctx context.Context, self Peer, prev Peer) {
+	now := time.Now()
+	if last := m.lastBeat.Load(); last != 0 {
+		if prev.Heartbeat == last {
 			return
 		}
-		updatectx, cancel := context.WithTimeout(ctx, HeartbeatInterval)
-		list, err := m.update(updatectx, self)
-		if err != nil {
-			xlog.WarnC(updatectx).Err(err).Msg("Failed to update peer list")
+	} else if prev.IP == self.IP || now.UnixMilli()-prev.Heartbeat > 2*HeartbeatInterval.Milliseconds() {
+		// Left over by a previous run of this node.
+		return
+	}
+
+	c := &idConflict{
+		message: fmt.Sprintf("machine ID %s is also used by %s (%s)", self.MachineID, prev.Host, prev.IP),
+		at:      now,
+	}
+	if old := m.conflict.Swap(c); old == nil || old.message != c.message || now.Sub(old.at) > conflictTTL {
+		xlog.WarnC(ctx).Str("machine_id", self.MachineID).Str("host", prev.Host).Str("ip", prev.IP).
+			Msg("Another node uses the same machine ID, set a new one with `pmesh set machine-id random` and restart")
+	}
+}
+
+// Time a machine ID conflict is reported after it was last detected.
+const conflictTTL = 3 * HeartbeatInterval
+
+// Conflict describes the machine ID conflict detected recently, or is empty if there is none.
+func (m *Peerlist) Conflict() string {
+	if c := m.conflict.Load(); c != nil && time.Since(c.at) < conflictTTL {
+		return c.message
+	}
+	return ""
+}
+
+func (m *Peerlist) refresh(ctx context.Context, self Peer) {
+	updatectx, cancel := context.WithTimeout(ctx, HeartbeatInterval)
+	list, err := m.update(updatectx, self)
+	if err != nil {
+		xlog.WarnC(updatectx).Err(err).Msg("Failed to update peer list")
+	}
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.mu.Lock()
+	if err == nil {
+		applyRTT(list, m.rtts)
+		m.last = list
+		m.errn = 0
+	} else {
+		m.errn++
+		if m.errn > 3 {
+			m.err = err
+		}
+	}
+	m.mu.Unlock()
+}
+
+// Interval between the measurements of the round-trip times.
+const rttInterval = HeartbeatInterval
+
+// Measures the round-trip time to the alive peers, by machine ID.
+func measureRTT(ctx context.Context, peers []Peer) map[string]float64 {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var targets []Peer
+	for _, p := range peers {
+		if !p.Me && p.Alive() {
+			targets = append(targets, p)
+		}
+	}
+	rtts, failed := MeasureConnectivity(ctx, targets)
+	for _, f := range failed {
+		xlog.DebugC(ctx).Err(f.Err).Str("host", f.Host).Msg("Peer unreachable")
+	}
+	return rtts
+}
+func applyRTT(peers []Peer, rtts map[string]float64) {
+	for i := range peers {
+		if rtt, ok := rtts[peers[i].MachineID]; ok {
+			peers[i].Latency = rtt
 		}
-		cancel()
+	}
+}
+
+// Measures the round-trip times periodically, in its own loop so that slow or unreachable peers do
+// not delay the heartbeats.
+func (m *Peerlist) measure(ctx context.Context) {
+	ticker := time.NewTicker(rttInterval)
+	defer ticker.Stop()
+	for {
+		rtts := measureRTT(ctx, m.List(true))
 		if ctx.Err() != nil {
 			return
 		}
-
 		m.mu.Lock()
-		if err == nil {
-			m.last = list
-			m.errn = 0
-		} else {
-			m.errn++
-			if m.errn > 3 {
-				m.err = err
-			}
-		}
+		m.rtts = rtts
+		applyRTT(m.last, rtts)
 		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+func (m *Peerlist) tick(ctx context.Context, self Peer) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.kick:
+		}
+		m.mu.RLock()
+		self.UD = m.self.UD // Picks up the user data set at runtime.
+		m.mu.RUnlock()
+		m.refresh(ctx, self)
+	}
+}
+
+// Refresh publishes the system data without waiting for the next heartbeat, no-op if the list
+// is not open.
+func (m *Peerlist) Refresh() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
 	}
 }
 
@@ -172,19 +306,72 @@ func (m *Peerlist) Open(ctx context.Context) error {
 	if m.err == nil {
 		ctx, m.cancel = context.WithCancel(context.Background())
 		go m.tick(ctx, self)
+		go m.measure(ctx)
 	}
 	return m.err
 }
+
+// Opens the list with only the local peer, used when running without NATS.
+func (m *Peerlist) OpenLocal(ctx context.Context) {
+	self := FillPeerForSelf(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.self = self
+	m.last = []Peer{self}
+}
 func (m *Peerlist) Close(ctx context.Context) error {
 	m.mu.Lock()
-	if m.cancel != nil {
-		m.cancel()
+	if m.cancel == nil {
+		m.mu.Unlock()
+		return nil
 	}
+	m.cancel()
 	mid := m.self.MachineID
 	m.mu.Unlock()
+	m.gw.PeerKV.Purge(ctx, fullKeyPrefix+mid)
 	return m.gw.PeerKV.Purge(ctx, mid)
 }
 
+// Maximum size of the user data of a peer, encoded as JSON.
+const MaxUDSize = 16 << 10
+
+var ErrUDTooLarge = fmt.Errorf("user data exceeds %d bytes", MaxUDSize)
+
+// ValidateUD checks that the user data can be advertised to the peers.
+func ValidateUD(ud map[string]any) error {
+	for k := range ud {
+		if strings.TrimSpace(k) == "" {
+			return errors.New("user data keys must not be empty")
+		}
+	}
+	data, err := json.Marshal(ud)
+	if err != nil {
+		return fmt.Errorf("invalid user data: %w", err)
+	}
+	if len(data) > MaxUDSize {
+		return ErrUDTooLarge
+	}
+	return nil
+}
+
+// SetUD replaces the user data of the local peer, the peers see it after the next heartbeat which is
+// sent without waiting for the interval.
+func (m *Peerlist) SetUD(ud map[string]any) error {
+	if err := ValidateUD(ud); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.self.UD = ud
+	for i := range m.last {
+		if m.last[i].Me {
+			m.last[i].UD = ud
+		}
+	}
+	m.mu.Unlock()
+	m.Refresh()
+	return nil
+}
+
 // Returns the last error encountered
 func (m *Peerlist) Err() error {
 	m.mu.RLock()
@@ -211,18 +398,27 @@ func (m *Peerlist) List(alive bool) (r []Peer) {
 	return
 }
 
-// Finds a peer by machine ID or hostname
-func (m *Peerlist) Find(identifier string) *Peer {
-	if identifier == m.self.Host || identifier == m.self.MachineID {
-		return &m.self
-	}
+// Self returns the local peer.
+func (m *Peerlist) Self() Peer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.self
+}
 
+// Finds a peer by machine ID or hostname, returns a copy as the list is updated in place.
+func (m *Peerlist) Find(identifier string) *Peer {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	for i := range m.last {
-		if m.last[i].MachineID == identifier || m.last[i].Host == identifier {
-			return &m.last[i]
+	for _, p := range m.last {
+		if p.MachineID == identifier || p.Host == identifier {
+			return &p
 		}
 	}
+
+	// Not listed yet, e.g. running without NATS.
+	if identifier == m.self.Host || identifier == m.self.MachineID {
+		self := m.self
+		return &self
+	}
 	return nil
 }
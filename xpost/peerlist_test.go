@@ -0,0 +1,82 @@
+package xpost
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPeerlistFindCopy(t *testing.T) {
+	m := NewPeerlist(nil)
+	m.self = Peer{Host: "a", MachineID: "m1", Me: true, UD: map[string]any{"v": 0}}
+	m.last = []Peer{m.self, {Host: "b", MachineID: "m2"}}
+
+	self, other := m.Find("a"), m.Find("m2")
+	if self == nil || other == nil || other.Host != "b" {
+		t.Fatalf("found %v and %v", self, other)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 100; i++ {
+			if err := m.SetUD(map[string]any{"v": i}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if v := self.UD["v"]; v != 0 {
+			t.Fatalf("found peer changed to %v", v)
+		}
+	}
+	wg.Wait()
+	if v := m.Find("a").UD["v"]; v != 100 {
+		t.Errorf("UD = %v, want 100", v)
+	}
+	if m.Find("c") != nil {
+		t.Error("found an unknown peer")
+	}
+}
+
+func TestDetectConflict(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UnixMilli()
+	self := Peer{MachineID: "m1", Host: "a", IP: "10.0.0.1"}
+	tests := []struct {
+		name     string
+		lastBeat int64 // Heartbeat of the last entry written by this node, zero before the first
+		prev     Peer
+		conflict bool
+	}{
+		{"stale entry at start", 0, Peer{Host: "b", IP: "10.0.0.2", Heartbeat: now - 3*HeartbeatInterval.Milliseconds()}, false},
+		{"previous run at start", 0, Peer{Host: "a", IP: "10.0.0.1", Heartbeat: now}, false},
+		{"clone at start", 0, Peer{Host: "b", IP: "10.0.0.2", Heartbeat: now}, true},
+		{"own entry", now - 1000, Peer{Host: "a", IP: "10.0.0.1", Heartbeat: now - 1000}, false},
+		{"overwritten entry", now - 1000, Peer{Host: "b", IP: "10.0.0.2", Heartbeat: now - 500}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewPeerlist(nil)
+			m.lastBeat.Store(tt.lastBeat)
+			m.detectConflict(ctx, self, tt.prev)
+			got := m.Conflict()
+			if (got != "") != tt.conflict {
+				t.Fatalf("conflict %q, want %v", got, tt.conflict)
+			}
+			if tt.conflict && !strings.Contains(got, "10.0.0.2") {
+				t.Errorf("conflict %q does not name the other node", got)
+			}
+		})
+	}
+
+	// Reported for a while after it was last seen.
+	m := NewPeerlist(nil)
+	m.conflict.Store(&idConflict{message: "old", at: time.Now().Add(-conflictTTL)})
+	if c := m.Conflict(); c != "" {
+		t.Errorf("expired conflict reported: %q", c)
+	}
+}
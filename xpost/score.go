@@ -0,0 +1,80 @@
+package xpost
+
+import (
+	"math"
+	"slices"
+)
+
+// PeerWeights configures how the peers are ranked when steering requests, the score of a peer is the
+// weighted sum of its round-trip time in milliseconds and its reported CPU load in percent. Lower is better.
+type PeerWeights struct {
+	RTT  float64 `yaml:"rtt,omitempty"`  // Weight of a millisecond of round-trip time, defaults to 1
+	Load float64 `yaml:"load,omitempty"` // Weight of a percent of CPU load, defaults to 1
+}
+
+func (w PeerWeights) normalize() PeerWeights {
+	if w.RTT == 0 && w.Load == 0 {
+		w.RTT, w.Load = 1, 1
+	}
+	return w
+}
+
+// Load returns the CPU load in percent advertised by the peer, -1 if unknown.
+func (p *Peer) Load() float64 {
+	if l, ok := p.SD["load"].(float64); ok {
+		return l
+	}
+	return -1
+}
+
+// Cordoned returns true if the peer is taken out of rotation.
+func (p *Peer) Cordoned() bool {
+	c, _ := p.SD["cordoned"].(bool)
+	return c
+}
+
+// Advertises returns true if the peer reports the service as healthy.
+func (p *Peer) Advertises(service string) bool {
+	services, _ := p.SD["services"].([]any)
+	return slices.Contains(services, any(service))
+}
+
+// Round-trip time assumed for the peers not measured yet, ranking them behind the measured nearby
+// peers rather than ahead of every peer.
+const unmeasuredRTT = 250
+
+// Score returns the score of the peer, +Inf if it is unreachable.
+func (w PeerWeights) Score(p *Peer) float64 {
+	w = w.normalize()
+	rtt := p.Latency
+	if p.Me {
+		rtt = 0
+	} else if rtt < 0 {
+		return math.Inf(1)
+	} else if rtt == 0 {
+		rtt = unmeasuredRTT
+	}
+	// Peers not reporting their load yet are assumed half loaded.
+	load := p.Load()
+	if load < 0 {
+		load = 50
+	}
+	return w.RTT*rtt + w.Load*load
+}
+
+// Select returns the alive, reachable and not cordoned peer with the lowest score among the ones
+// accepted by the filter, or nil if there is none.
+func (m *Peerlist) Select(w PeerWeights, filter func(p *Peer) bool) (best *Peer) {
+	peers := m.List(true)
+	score := math.Inf(1)
+	for i := range peers {
+		p := &peers[i]
+		if p.Cordoned() || (filter != nil && !filter(p)) {
+			continue
+		}
+		if s := w.Score(p); s < score {
+			best, score = p, s
+		}
+	}
+	return
+}